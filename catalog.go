@@ -0,0 +1,169 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/JoshuaLim25/db/storage"
+)
+
+// catalogMagic is the header every database file starts its metadata page with
+const catalogMagic = "SIMPLEDB_V1"
+
+// Prefixes of the catalog keys of table and sequence entries
+const (
+	tableKeyPrefix    = "table:"
+	sequenceKeyPrefix = "sequence:"
+)
+
+// catalog records which tables exist and where their trees live. It is a
+// B+Tree of its own whose root page ID is stored in the metadata page, so
+// it is updated in the same batches as the tables it describes.
+type catalog struct {
+	tree *storage.DiskBTree // nil for a read-only file that has no catalog yet
+}
+
+// openCatalog loads the catalog of a database file, creating it if the file
+// is writable and has none
+func openCatalog(pm *storage.PageManager) (*catalog, error) {
+	meta, err := pm.ReadMeta()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata page: %w", err)
+	}
+
+	if !bytes.HasPrefix(meta, []byte(catalogMagic)) {
+		return nil, fmt.Errorf("not a database file")
+	}
+
+	if len(meta) >= len(catalogMagic)+4 {
+		rootID := storage.PageID(binary.LittleEndian.Uint32(meta[len(catalogMagic):]))
+		tree, err := storage.OpenDiskBTree(pm, rootID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open catalog: %w", err)
+		}
+		return &catalog{tree: tree}, nil
+	}
+
+	if pm.ReadOnly() {
+		return &catalog{}, nil
+	}
+
+	// Create the catalog and record its root in the metadata page
+	batch := pm.NewBatch()
+	tree, err := storage.CreateDiskBTree(batch)
+	if err != nil {
+		batch.Abort()
+		return nil, fmt.Errorf("failed to create catalog: %w", err)
+	}
+
+	meta = binary.LittleEndian.AppendUint32([]byte(catalogMagic), uint32(tree.RootID()))
+	if err := batch.WriteMeta(meta); err != nil {
+		batch.Abort()
+		return nil, err
+	}
+
+	if err := batch.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to create catalog: %w", err)
+	}
+	return &catalog{tree: tree}, nil
+}
+
+// tableEntry is what the catalog records about a table
+type tableEntry struct {
+	root   storage.PageID
+	schema []byte // opaque to this package; nil for a plain key/value table
+}
+
+// tables returns the entry of every table in the catalog
+func (c *catalog) tables() (map[string]tableEntry, error) {
+	tables := make(map[string]tableEntry)
+	if c.tree == nil {
+		return tables, nil
+	}
+
+	iter := c.tree.FindLarger([]byte(tableKeyPrefix))
+	for iter.ContainsNext() {
+		key, val := iter.Next()
+		if !bytes.HasPrefix(key, []byte(tableKeyPrefix)) {
+			break
+		}
+		if len(val) < 4 {
+			return nil, fmt.Errorf("corrupt catalog entry for %s", key)
+		}
+
+		name := string(key[len(tableKeyPrefix):])
+		entry := tableEntry{root: storage.PageID(binary.LittleEndian.Uint32(val))}
+		if len(val) > 4 {
+			entry.schema = append([]byte{}, val[4:]...)
+		}
+		tables[name] = entry
+	}
+	return tables, nil
+}
+
+// putTable stages a catalog entry for a table
+func (c *catalog) putTable(batch *storage.Batch, name string, entry tableEntry) error {
+	val := binary.LittleEndian.AppendUint32(nil, uint32(entry.root))
+	val = append(val, entry.schema...)
+	return c.tree.Put(batch, []byte(tableKeyPrefix+name), val)
+}
+
+// removeTable stages removing a table's catalog entry
+func (c *catalog) removeTable(batch *storage.Batch, name string) error {
+	_, err := c.tree.Remove(batch, []byte(tableKeyPrefix+name))
+	return err
+}
+
+// sequenceEntry is what the catalog records about a sequence
+type sequenceEntry struct {
+	next      int64 // the value nextval returns next
+	increment int64
+}
+
+// sequence looks up a sequence's catalog entry. Entries staged in a batch
+// that has not committed yet are visible.
+func (c *catalog) sequence(name string) (sequenceEntry, bool, error) {
+	if c.tree == nil {
+		return sequenceEntry{}, false, nil
+	}
+	val, found := c.tree.Get([]byte(sequenceKeyPrefix + name))
+	if !found {
+		return sequenceEntry{}, false, nil
+	}
+	if len(val) != 16 {
+		return sequenceEntry{}, false, fmt.Errorf("corrupt catalog entry for sequence %s", name)
+	}
+	return sequenceEntry{
+		next:      int64(binary.LittleEndian.Uint64(val)),
+		increment: int64(binary.LittleEndian.Uint64(val[8:])),
+	}, true, nil
+}
+
+// putSequence stages a catalog entry for a sequence
+func (c *catalog) putSequence(batch *storage.Batch, name string, entry sequenceEntry) error {
+	val := binary.LittleEndian.AppendUint64(nil, uint64(entry.next))
+	val = binary.LittleEndian.AppendUint64(val, uint64(entry.increment))
+	return c.tree.Put(batch, []byte(sequenceKeyPrefix+name), val)
+}
+
+// removeSequence stages removing a sequence's catalog entry
+func (c *catalog) removeSequence(batch *storage.Batch, name string) error {
+	_, err := c.tree.Remove(batch, []byte(sequenceKeyPrefix+name))
+	return err
+}
+
+// rollback forgets changes staged in an aborted batch
+func (c *catalog) rollback(batch *storage.Batch) {
+	if c.tree != nil {
+		c.tree.Rollback(batch)
+	}
+}
+
+// close drops the catalog's cached pages
+func (c *catalog) close() error {
+	if c.tree == nil {
+		return nil
+	}
+	return c.tree.Close()
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
 
 	"github.com/JoshuaLim25/db"
 	"github.com/JoshuaLim25/db/query"
@@ -31,6 +36,75 @@ func (dw *DatabaseWrapper) CreateTable(tableName string) (query.Table, error) {
 	return &TableWrapper{table: table}, nil
 }
 
+func (dw *DatabaseWrapper) CreateTableWithSchema(tableName string, schema []byte) (query.Table, error) {
+	table, err := dw.db.CreateTableWithSchema(tableName, schema)
+	if err != nil {
+		return nil, err
+	}
+	return &TableWrapper{table: table}, nil
+}
+
+func (dw *DatabaseWrapper) DropTable(tableName string) error {
+	return dw.db.DropTable(tableName)
+}
+
+func (dw *DatabaseWrapper) SetTableSchema(tableName string, schema []byte) error {
+	return dw.db.SetTableSchema(tableName, schema)
+}
+
+func (dw *DatabaseWrapper) RenameTable(oldName, newName string) error {
+	return dw.db.RenameTable(oldName, newName)
+}
+
+func (dw *DatabaseWrapper) NewBatch() query.Batch {
+	return dw.db.NewWriteBatch()
+}
+
+func (dw *DatabaseWrapper) ListTables() []string {
+	return dw.db.ListTables()
+}
+
+func (dw *DatabaseWrapper) CreateSequence(name string, start, increment int64) error {
+	return sequenceError(name, dw.db.CreateSequence(name, start, increment))
+}
+
+func (dw *DatabaseWrapper) DropSequence(name string) error {
+	return sequenceError(name, dw.db.DropSequence(name))
+}
+
+func (dw *DatabaseWrapper) NextVal(name string) (int64, error) {
+	value, err := dw.db.NextVal(name)
+	return value, sequenceError(name, err)
+}
+
+func (dw *DatabaseWrapper) AdvanceSequence(name string, value int64) error {
+	return sequenceError(name, dw.db.AdvanceSequence(name, value))
+}
+
+// sequenceError translates the database's errors for a duplicate or
+// missing sequence to the query package's, which the executor checks for
+func sequenceError(name string, err error) error {
+	switch {
+	case errors.Is(err, db.ErrSequenceExists):
+		return fmt.Errorf("%w: %s", query.ErrSequenceExists, name)
+	case errors.Is(err, db.ErrSequenceNotFound):
+		return fmt.Errorf("%w: %s", query.ErrSequenceNotFound, name)
+	}
+	return err
+}
+
+func (dw *DatabaseWrapper) TableStats(tableName string) (query.TreeStats, error) {
+	stats, err := dw.db.TableStats(tableName)
+	if err != nil {
+		return query.TreeStats{}, err
+	}
+	return query.TreeStats{
+		RootPage: int64(stats.RootPage),
+		Rows:     int64(stats.Rows),
+		Height:   int64(stats.Height),
+	}, nil
+}
+
 // TableWrapper wraps our table.go Table to implement the query interfaces
 type TableWrapper struct {
 	table *db.Table
@@ -40,6 +114,10 @@ func (tw *TableWrapper) Insert(key, value []byte) error {
 	return tw.table.Insert(key, value)
 }
 
+func (tw *TableWrapper) InsertNew(key, value []byte) (bool, error) {
+	return tw.table.InsertNew(key, value)
+}
+
 func (tw *TableWrapper) Select(key []byte) ([]byte, bool) {
 	return tw.table.Select(key)
 }
@@ -57,10 +135,29 @@ func (tw *TableWrapper) Scan(startKey []byte) query.Iterator {
 	return &IteratorWrapper{iterator: iter}
 }
 
+func (tw *TableWrapper) ScanReverse(endKey []byte) query.Iterator {
+	iter := tw.table.ScanReverse(endKey)
+	return &IteratorWrapper{iterator: iter}
+}
+
+func (tw *TableWrapper) ScanContext(ctx context.Context, startKey []byte) query.Iterator {
+	iter := tw.table.ScanContext(ctx, startKey)
+	return &IteratorWrapper{iterator: iter}
+}
+
+func (tw *TableWrapper) ScanReverseContext(ctx context.Context, endKey []byte) query.Iterator {
+	iter := tw.table.ScanReverseContext(ctx, endKey)
+	return &IteratorWrapper{iterator: iter}
+}
+
 func (tw *TableWrapper) Name() string {
 	return tw.table.Name()
 }
 
+func (tw *TableWrapper) Schema() []byte {
+	return tw.table.Schema()
+}
+
 // IteratorWrapper wraps our storage iterator to implement the query iterator interface
 type IteratorWrapper struct {
 	iterator IteratorImpl
@@ -81,15 +178,21 @@ type IteratorImpl interface {
 }
 
 func main() {
+	dbFile := flag.String("file", "testdb.dat", "database file to open")
+	readOnly := flag.Bool("readonly", false, "open the database read-only")
+	timeout := flag.Duration("timeout", 0, "statement timeout (0 for none)")
+	flag.Parse()
+
 	fmt.Println("🗄️  Simple Database (B+Tree + SQL)")
-	fmt.Println("Commands: CREATE TABLE name, SELECT/INSERT/UPDATE/DELETE, .quit")
-	fmt.Println("Example: CREATE TABLE users")
+	fmt.Println("Commands: CREATE/ALTER/DROP TABLE, CREATE/DROP INDEX, CREATE/DROP SEQUENCE, SELECT/INSERT/UPDATE/DELETE, .quit")
+	fmt.Println("Example: CREATE TABLE users (name TEXT PRIMARY KEY, email TEXT)")
 	fmt.Println("         INSERT INTO users VALUES ('john', 'john@example.com')")
 	fmt.Println("         SELECT * FROM users")
+	fmt.Println("Catalog: db_tables, db_columns, db_indexes, db_stats")
 	fmt.Println()
 
 	// Create database
-	database, err := db.NewDatabase("testdb", "testdb.dat")
+	database, err := db.NewDatabaseWithOptions("testdb", *dbFile, db.Options{ReadOnly: *readOnly})
 	if err != nil {
 		fmt.Printf("Error creating database: %v\n", err)
 		return
@@ -98,6 +201,14 @@ func main() {
 
 	// Wrap for query interface
 	dbWrapper := &DatabaseWrapper{db: database}
+	executor := query.NewExecutor(dbWrapper)
+	executor.SetStatementTimeout(*timeout)
+
+	// Ctrl-C cancels the running statement instead of killing the REPL
+	interrupts := &interruptHandler{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go interrupts.run(signals)
 
 	scanner := bufio.NewScanner(os.Stdin)
 	
@@ -119,33 +230,26 @@ func main() {
 			break
 		}
 		
-		// Handle CREATE TABLE separately since it's not in our SQL parser yet
-		if strings.HasPrefix(strings.ToUpper(input), "CREATE TABLE ") {
-			parts := strings.Fields(input)
-			if len(parts) >= 3 {
-				tableName := parts[2]
-				_, err := dbWrapper.CreateTable(tableName)
-				if err != nil {
-					fmt.Printf("Error: %v\n", err)
-				} else {
-					fmt.Printf("Table '%s' created successfully.\n", tableName)
-				}
-			} else {
-				fmt.Println("Usage: CREATE TABLE tablename")
-			}
+		// Execute SQL
+		stmt, err := query.ParseSQL(input)
+		if err != nil {
+			fmt.Printf("Error: parse error: %v\n", err)
 			continue
 		}
 		
-		// Execute SQL
-		result := query.ExecuteSQL(dbWrapper, input)
-		
-		if result.Success {
+		ctx := interrupts.begin()
+		result := executor.ExecuteContext(ctx, stmt)
+		interrupts.end()
+
+		if errors.Is(result.Error, context.Canceled) {
+			fmt.Println("Statement canceled.")
+		} else if errors.Is(result.Error, context.DeadlineExceeded) {
+			fmt.Println("Statement timed out.")
+		} else if result.Success {
 			fmt.Println(result.Message)
 			if len(result.Rows) > 0 {
 				fmt.Println("\nResults:")
-				for _, row := range result.Rows {
-					fmt.Printf("  %v\n", row)
-				}
+				printRows(result)
 			}
 		} else {
 			fmt.Printf("Error: %v\n", result.Error)
@@ -155,4 +259,64 @@ func main() {
 	if err := scanner.Err(); err != nil {
 		fmt.Printf("Error reading input: %v\n", err)
 	}
-}
\ No newline at end of file
+}
+
+// printRows prints result rows with their columns in select-list order
+func printRows(result *query.QueryResult) {
+	for _, row := range result.Rows {
+		if len(result.Columns) == 0 {
+			fmt.Printf("  %v\n", row)
+			continue
+		}
+
+		fields := make([]string, len(result.Columns))
+		for i, col := range result.Columns {
+			fields[i] = col + "=" + row[col]
+		}
+		fmt.Printf("  %s\n", strings.Join(fields, ", "))
+	}
+}
+
+// interruptHandler routes Ctrl-C to the statement that is currently running
+type interruptHandler struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// begin returns the context for a statement that Ctrl-C should cancel
+func (h *interruptHandler) begin() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	h.mu.Lock()
+	h.cancel = cancel
+	h.mu.Unlock()
+
+	return ctx
+}
+
+// end marks the running statement as finished
+func (h *interruptHandler) end() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+}
+
+// run cancels the running statement on every interrupt. With nothing
+// running it just reminds the user how to leave.
+func (h *interruptHandler) run(signals <-chan os.Signal) {
+	for range signals {
+		h.mu.Lock()
+		cancel := h.cancel
+		h.mu.Unlock()
+
+		if cancel != nil {
+			cancel()
+		} else {
+			fmt.Print("\n(use .quit to exit)\ndb> ")
+		}
+	}
+}
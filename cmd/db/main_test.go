@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/JoshuaLim25/db"
+	"github.com/JoshuaLim25/db/query"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshot returns every key and value of every table in the database,
+// index trees included
+func snapshot(t *testing.T, database *db.Database) map[string]map[string]string {
+	tables := make(map[string]map[string]string)
+	for _, name := range database.ListTables() {
+		table, err := database.GetTable(name)
+		require.NoError(t, err)
+		rows := make(map[string]string)
+		iter := table.Scan([]byte(""))
+		for iter.ContainsNext() {
+			key, value := iter.Next()
+			if key != nil {
+				rows[string(key)] = string(value)
+			}
+		}
+		tables[name] = rows
+	}
+	return tables
+}
+
+// TestAtomicStatements runs statements through the database's WriteBatch,
+// as the shell does, and checks that a statement that fails part-way
+// leaves every table and index as it was
+func TestAtomicStatements(t *testing.T) {
+	tempFile := "test_atomic_statements.dat"
+	defer os.Remove(tempFile)
+
+	database, err := db.NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	wrapper := &DatabaseWrapper{db: database}
+
+	exec := func(sql string) *query.QueryResult {
+		result := query.ExecuteSQL(wrapper, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result
+	}
+	rows := func(sql string) []map[string]string {
+		return exec(sql).Rows
+	}
+
+	exec("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE, name TEXT, age INTEGER CHECK (age >= 0))")
+	exec("CREATE INDEX users_by_name ON users (name)")
+	exec("CREATE TABLE posts (id INTEGER PRIMARY KEY, author INTEGER REFERENCES users ON DELETE CASCADE ON UPDATE CASCADE, title TEXT)")
+	exec("CREATE INDEX posts_by_author ON posts (author)")
+	exec("CREATE TABLE pins (post INTEGER PRIMARY KEY REFERENCES posts ON DELETE RESTRICT)")
+
+	// A multi-row INSERT writes every row and its index entries
+	result := exec("INSERT INTO users VALUES (1, 'ann@x', 'ann', 30), (2, 'ben@x', 'ben', 40), (3, 'cy@x', 'cy', 50)")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	exec("INSERT INTO posts VALUES (10, 1, 'hello'), (11, 2, 'hi'), (12, 2, 'again'), (13, 3, 'pinned')")
+	exec("INSERT INTO pins VALUES (13)")
+	assert.Equal(t, []map[string]string{{"id": "2"}}, rows("SELECT id FROM users WHERE name = 'ben'"))
+	assert.Equal(t, []map[string]string{{"id": "11"}, {"id": "12"}}, rows("SELECT id FROM posts WHERE author = 2 ORDER BY id"))
+
+	before := snapshot(t, database)
+	for _, sql := range []string{
+		// A later row breaks a constraint after earlier rows were staged
+		"INSERT INTO users VALUES (4, 'di@x', 'di', 20), (5, 'ann@x', 'ed', 20)",
+		"INSERT INTO users VALUES (4, 'di@x', 'di', 20), (4, 'ed@x', 'ed', 20)",
+		"INSERT INTO users VALUES (4, 'di@x', 'di', 20), (5, 'ed@x', 'ed', -1)",
+		"INSERT INTO posts VALUES (14, 1, 'ok'), (15, 99, 'no such author')",
+
+		// A set-based UPDATE or DELETE fails on one of the rows it changes
+		"UPDATE users SET email = 'same@x'",
+		"UPDATE users SET age = age - 35",
+		"UPDATE posts SET author = author + 1",
+
+		// A cascade reaches a row another table still refers to
+		"DELETE FROM users WHERE id = 3",
+		"DELETE FROM users",
+		"UPDATE posts SET id = id + 100",
+	} {
+		result := query.ExecuteSQL(wrapper, sql)
+		assert.False(t, result.Success, sql)
+		assert.Equal(t, before, snapshot(t, database), sql)
+	}
+
+	// FK actions change the child rows and their index entries with the parent
+	exec("UPDATE users SET id = 20 WHERE id = 2")
+	assert.Equal(t, []map[string]string{{"id": "11"}, {"id": "12"}}, rows("SELECT id FROM posts WHERE author = 20 ORDER BY id"))
+	assert.Empty(t, rows("SELECT id FROM posts WHERE author = 2"))
+	exec("DELETE FROM users WHERE id = 1")
+	assert.Empty(t, rows("SELECT id FROM posts WHERE author = 1"))
+	assert.Equal(t, []map[string]string{{"id": "11"}, {"id": "12"}, {"id": "13"}}, rows("SELECT id FROM posts ORDER BY id"))
+
+	// Set-based writes keep the indexes in step
+	result = exec("UPDATE users SET name = name || '!'")
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Equal(t, []map[string]string{{"id": "20"}}, rows("SELECT id FROM users WHERE name = 'ben!'"))
+	assert.Empty(t, rows("SELECT id FROM users WHERE name = 'ben'"))
+	exec("DELETE FROM pins")
+	result = exec("DELETE FROM posts")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	tables := snapshot(t, database)
+	assert.Empty(t, tables["posts"])
+	assert.Empty(t, tables["posts_by_author"])
+	require.NoError(t, database.Close())
+
+	// The committed state is what a reopened database reads
+	database, err = db.NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer database.Close()
+	wrapper = &DatabaseWrapper{db: database}
+	assert.Equal(t, []map[string]string{{"id": "3", "name": "cy!"}, {"id": "20", "name": "ben!"}}, rows("SELECT id, name FROM users ORDER BY id"))
+	assert.Equal(t, []map[string]string{{"id": "3"}}, rows("SELECT id FROM users WHERE email = 'cy@x'"))
+}
+
+// TestSequenceExistenceClauses checks that IF NOT EXISTS and IF EXISTS
+// recognize the database's own errors for a duplicate or missing sequence
+func TestSequenceExistenceClauses(t *testing.T) {
+	tempFile := "test_sequence_clauses.dat"
+	defer os.Remove(tempFile)
+
+	database, err := db.NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer database.Close()
+	wrapper := &DatabaseWrapper{db: database}
+
+	for _, sql := range []string{
+		"CREATE SEQUENCE ids",
+		"CREATE SEQUENCE IF NOT EXISTS ids",
+		"DROP SEQUENCE ids",
+		"DROP SEQUENCE IF EXISTS ids",
+	} {
+		result := query.ExecuteSQL(wrapper, sql)
+		assert.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+
+	result := query.ExecuteSQL(wrapper, "DROP SEQUENCE ids")
+	assert.ErrorIs(t, result.Error, query.ErrSequenceNotFound)
+	assert.EqualError(t, result.Error, "sequence does not exist: ids")
+	_, err = wrapper.NextVal("ids")
+	assert.ErrorIs(t, err, query.ErrSequenceNotFound)
+	require.NoError(t, wrapper.CreateSequence("ids", 1, 1))
+	assert.ErrorIs(t, wrapper.CreateSequence("ids", 1, 1), query.ErrSequenceExists)
+}
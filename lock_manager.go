@@ -0,0 +1,333 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDeadlock is returned to the transaction chosen as the victim of a
+	// deadlock. The victim should release its locks and retry from the start.
+	ErrDeadlock = errors.New("deadlock detected: transaction aborted, retry")
+
+	// ErrLockTimeout is returned when a lock could not be acquired within
+	// the lock manager's wait timeout
+	ErrLockTimeout = errors.New("lock wait timeout exceeded")
+)
+
+// TxnID identifies a transaction to the lock manager
+type TxnID uint64
+
+// LockMode is the strength of a lock
+type LockMode int
+
+const (
+	// LockShared allows other shared holders but excludes writers
+	LockShared LockMode = iota
+	// LockExclusive excludes every other holder
+	LockExclusive
+)
+
+func (m LockMode) String() string {
+	if m == LockExclusive {
+		return "X"
+	}
+	return "S"
+}
+
+// keyRange is the half-open interval [start, end) of keys in a table.
+// A nil end means the range is unbounded above.
+type keyRange struct {
+	start []byte
+	end   []byte
+}
+
+// pointRange returns the range covering exactly one key
+func pointRange(key []byte) keyRange {
+	end := make([]byte, len(key)+1)
+	copy(end, key)
+	return keyRange{start: key, end: end}
+}
+
+// overlaps reports whether two ranges share at least one key
+func (r keyRange) overlaps(o keyRange) bool {
+	startsBeforeEnd := func(start, end []byte) bool {
+		return end == nil || bytes.Compare(start, end) < 0
+	}
+	return startsBeforeEnd(r.start, o.end) && startsBeforeEnd(o.start, r.end)
+}
+
+// covers reports whether r contains every key of o
+func (r keyRange) covers(o keyRange) bool {
+	if bytes.Compare(r.start, o.start) > 0 {
+		return false
+	}
+	if r.end == nil {
+		return true
+	}
+	return o.end != nil && bytes.Compare(o.end, r.end) <= 0
+}
+
+// lockGrant is a lock held by a transaction
+type lockGrant struct {
+	txn  TxnID
+	r    keyRange
+	mode LockMode
+}
+
+// LockManager hands out shared and exclusive locks on keys and key ranges
+// of named tables. Transactions that wait on each other in a cycle are
+// detected through a waits-for graph and one of them is aborted with
+// ErrDeadlock.
+type LockManager struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	grants   map[string][]*lockGrant  // table -> granted locks
+	waitsFor map[TxnID]map[TxnID]bool // waiting txn -> txns it waits on
+	aborted  map[TxnID]bool           // deadlock victims not yet woken
+	waiters  map[TxnID]chan struct{}  // wakeup channel per waiting txn
+}
+
+// NewLockManager creates a lock manager. Lock requests that wait longer
+// than timeout fail with ErrLockTimeout; a zero timeout waits forever.
+func NewLockManager(timeout time.Duration) *LockManager {
+	return &LockManager{
+		timeout:  timeout,
+		grants:   make(map[string][]*lockGrant),
+		waitsFor: make(map[TxnID]map[TxnID]bool),
+		aborted:  make(map[TxnID]bool),
+		waiters:  make(map[TxnID]chan struct{}),
+	}
+}
+
+// SetTimeout changes how long lock requests wait before giving up
+func (lm *LockManager) SetTimeout(timeout time.Duration) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	lm.timeout = timeout
+}
+
+// Lock acquires a lock on a single key of a table. A transaction that
+// already holds a shared lock can upgrade it by asking for an exclusive one.
+func (lm *LockManager) Lock(txn TxnID, table string, key []byte, mode LockMode) error {
+	return lm.acquire(txn, table, pointRange(key), mode)
+}
+
+// LockRange acquires a lock on every key in [start, end) of a table. A nil
+// end locks everything from start onwards.
+func (lm *LockManager) LockRange(txn TxnID, table string, start, end []byte, mode LockMode) error {
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return fmt.Errorf("invalid lock range: start must be before end")
+	}
+	return lm.acquire(txn, table, keyRange{start: start, end: end}, mode)
+}
+
+// ReleaseAll releases every lock held by the transaction. Call it when the
+// transaction commits or aborts, including after ErrDeadlock.
+func (lm *LockManager) ReleaseAll(txn TxnID) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for table, grants := range lm.grants {
+		kept := grants[:0]
+		for _, g := range grants {
+			if g.txn != txn {
+				kept = append(kept, g)
+			}
+		}
+		if len(kept) == 0 {
+			delete(lm.grants, table)
+		} else {
+			lm.grants[table] = kept
+		}
+	}
+
+	delete(lm.waitsFor, txn)
+	delete(lm.aborted, txn)
+	lm.wakeAllLocked()
+}
+
+// HeldLocks returns the number of locks currently held by the transaction
+func (lm *LockManager) HeldLocks(txn TxnID) int {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	count := 0
+	for _, grants := range lm.grants {
+		for _, g := range grants {
+			if g.txn == txn {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// waiting reports whether the transaction is blocked waiting for a lock
+func (lm *LockManager) waiting(txn TxnID) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	_, ok := lm.waitsFor[txn]
+	return ok
+}
+
+// acquire blocks until the lock can be granted, the transaction is chosen
+// as a deadlock victim, or the wait times out
+func (lm *LockManager) acquire(txn TxnID, table string, r keyRange, mode LockMode) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	var deadline <-chan time.Time
+	if lm.timeout > 0 {
+		timer := time.NewTimer(lm.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		if lm.aborted[txn] {
+			delete(lm.aborted, txn)
+			lm.stopWaitingLocked(txn)
+			return ErrDeadlock
+		}
+
+		blockers := lm.blockersLocked(txn, table, r, mode)
+		if len(blockers) == 0 {
+			lm.stopWaitingLocked(txn)
+			lm.grantLocked(txn, table, r, mode)
+			return nil
+		}
+
+		lm.waitsFor[txn] = blockers
+		if victim, ok := lm.findDeadlockLocked(txn); ok {
+			if victim == txn {
+				lm.stopWaitingLocked(txn)
+				return ErrDeadlock
+			}
+			lm.aborted[victim] = true
+			lm.wakeAllLocked()
+		}
+
+		wake, ok := lm.waiters[txn]
+		if !ok {
+			wake = make(chan struct{}, 1)
+			lm.waiters[txn] = wake
+		}
+
+		lm.mu.Unlock()
+		var timedOut bool
+		select {
+		case <-wake:
+		case <-deadline:
+			timedOut = true
+		}
+		lm.mu.Lock()
+
+		if timedOut {
+			lm.stopWaitingLocked(txn)
+			return fmt.Errorf("%w: %s lock on table %s", ErrLockTimeout, mode, table)
+		}
+	}
+}
+
+// blockersLocked returns the transactions holding locks that conflict with
+// the request. A transaction never conflicts with its own locks.
+func (lm *LockManager) blockersLocked(txn TxnID, table string, r keyRange, mode LockMode) map[TxnID]bool {
+	var blockers map[TxnID]bool
+	for _, g := range lm.grants[table] {
+		if g.txn == txn || !g.r.overlaps(r) {
+			continue
+		}
+		if mode == LockShared && g.mode == LockShared {
+			continue
+		}
+		if blockers == nil {
+			blockers = make(map[TxnID]bool)
+		}
+		blockers[g.txn] = true
+	}
+	return blockers
+}
+
+// grantLocked records a granted lock, upgrading or skipping it when the
+// transaction already holds a lock that covers the range
+func (lm *LockManager) grantLocked(txn TxnID, table string, r keyRange, mode LockMode) {
+	for _, g := range lm.grants[table] {
+		if g.txn != txn || !g.r.covers(r) {
+			continue
+		}
+		if g.mode >= mode {
+			return
+		}
+		if bytes.Equal(g.r.start, r.start) && bytes.Equal(g.r.end, r.end) {
+			g.mode = mode
+			return
+		}
+	}
+	lm.grants[table] = append(lm.grants[table], &lockGrant{txn: txn, r: r, mode: mode})
+}
+
+// stopWaitingLocked removes the transaction from the waits-for graph
+func (lm *LockManager) stopWaitingLocked(txn TxnID) {
+	delete(lm.waitsFor, txn)
+	delete(lm.waiters, txn)
+}
+
+// wakeAllLocked nudges every waiting transaction to re-check its request
+func (lm *LockManager) wakeAllLocked() {
+	for _, wake := range lm.waiters {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// findDeadlockLocked looks for a cycle in the waits-for graph through txn.
+// If one exists it returns the youngest transaction in the cycle, which has
+// probably done the least work, as the victim.
+func (lm *LockManager) findDeadlockLocked(txn TxnID) (TxnID, bool) {
+	path := []TxnID{txn}
+	onPath := map[TxnID]bool{txn: true}
+	visited := make(map[TxnID]bool)
+
+	var cycle []TxnID
+	var visit func(t TxnID) bool
+	visit = func(t TxnID) bool {
+		for next := range lm.waitsFor[t] {
+			if next == txn {
+				cycle = append([]TxnID(nil), path...)
+				return true
+			}
+			if onPath[next] || visited[next] || lm.aborted[next] {
+				continue
+			}
+			visited[next] = true
+			onPath[next] = true
+			path = append(path, next)
+			if visit(next) {
+				return true
+			}
+			path = path[:len(path)-1]
+			onPath[next] = false
+		}
+		return false
+	}
+
+	if !visit(txn) {
+		return 0, false
+	}
+
+	victim := cycle[0]
+	for _, t := range cycle[1:] {
+		if t > victim {
+			victim = t
+		}
+	}
+	return victim, true
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockManagerSharedLocks(t *testing.T) {
+	lm := NewLockManager(100 * time.Millisecond)
+
+	// Any number of readers can share a key
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockShared))
+	require.NoError(t, lm.Lock(2, "users", []byte("alice"), LockShared))
+
+	// A writer has to wait for them and times out
+	err := lm.Lock(3, "users", []byte("alice"), LockExclusive)
+	assert.ErrorIs(t, err, ErrLockTimeout)
+
+	// Other keys and other tables are unaffected
+	require.NoError(t, lm.Lock(3, "users", []byte("bob"), LockExclusive))
+	require.NoError(t, lm.Lock(3, "orders", []byte("alice"), LockExclusive))
+}
+
+func TestLockManagerExclusiveWaitsForRelease(t *testing.T) {
+	lm := NewLockManager(time.Second)
+
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockExclusive))
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lm.Lock(2, "users", []byte("alice"), LockShared)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("shared lock granted while an exclusive lock was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lm.ReleaseAll(1)
+	require.NoError(t, <-acquired)
+	assert.Equal(t, 1, lm.HeldLocks(2))
+	assert.Equal(t, 0, lm.HeldLocks(1))
+}
+
+func TestLockManagerRanges(t *testing.T) {
+	lm := NewLockManager(50 * time.Millisecond)
+
+	// Lock [b, d) for reading
+	require.NoError(t, lm.LockRange(1, "users", []byte("b"), []byte("d"), LockShared))
+
+	// Writes inside the range conflict, writes outside do not
+	assert.ErrorIs(t, lm.Lock(2, "users", []byte("c"), LockExclusive), ErrLockTimeout)
+	assert.NoError(t, lm.Lock(2, "users", []byte("d"), LockExclusive))
+	assert.NoError(t, lm.Lock(2, "users", []byte("a"), LockExclusive))
+
+	// An unbounded range overlaps everything past its start
+	assert.ErrorIs(t, lm.LockRange(3, "users", []byte("c"), nil, LockExclusive), ErrLockTimeout)
+
+	// Empty or inverted ranges are rejected
+	assert.Error(t, lm.LockRange(3, "users", []byte("d"), []byte("b"), LockShared))
+}
+
+func TestLockManagerUpgrade(t *testing.T) {
+	lm := NewLockManager(50 * time.Millisecond)
+
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockShared))
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockExclusive))
+	assert.Equal(t, 1, lm.HeldLocks(1), "upgrade should replace the shared lock")
+
+	// Re-requesting a weaker lock is a no-op
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockShared))
+	assert.Equal(t, 1, lm.HeldLocks(1))
+
+	assert.ErrorIs(t, lm.Lock(2, "users", []byte("alice"), LockShared), ErrLockTimeout)
+}
+
+func TestLockManagerUpgradeDeadlock(t *testing.T) {
+	lm := NewLockManager(time.Second)
+
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockShared))
+	require.NoError(t, lm.Lock(2, "users", []byte("alice"), LockShared))
+
+	upgraded := make(chan error, 1)
+	go func() {
+		upgraded <- lm.Lock(1, "users", []byte("alice"), LockExclusive)
+	}()
+	require.Eventually(t, func() bool { return lm.waiting(1) }, time.Second, time.Millisecond)
+
+	// Both readers now want to write: the younger one is the victim
+	err := lm.Lock(2, "users", []byte("alice"), LockExclusive)
+	assert.ErrorIs(t, err, ErrDeadlock)
+
+	lm.ReleaseAll(2)
+	require.NoError(t, <-upgraded)
+}
+
+func TestLockManagerDeadlockCycle(t *testing.T) {
+	lm := NewLockManager(time.Second)
+
+	require.NoError(t, lm.Lock(1, "users", []byte("a"), LockExclusive))
+	require.NoError(t, lm.Lock(2, "users", []byte("b"), LockExclusive))
+	require.NoError(t, lm.Lock(3, "users", []byte("c"), LockExclusive))
+
+	results := make(chan error, 3)
+	run := func(txn TxnID, key string) {
+		err := lm.Lock(txn, "users", []byte(key), LockExclusive)
+		if err != nil {
+			lm.ReleaseAll(txn)
+		}
+		results <- err
+	}
+
+	// 1 -> 2 -> 3 -> 1
+	go run(1, "b")
+	go run(2, "c")
+	require.Eventually(t, func() bool { return lm.waiting(1) && lm.waiting(2) }, time.Second, time.Millisecond)
+	go run(3, "a")
+
+	var deadlocks, granted int
+	for i := 0; i < 2; i++ {
+		err := <-results
+		switch {
+		case errors.Is(err, ErrDeadlock):
+			deadlocks++
+		case err == nil:
+			granted++
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 1, deadlocks, "exactly one victim should be aborted")
+	assert.Equal(t, 1, granted)
+
+	// Transaction 3 is the youngest and the victim; once 2 is done the
+	// last waiter goes through
+	lm.ReleaseAll(2)
+	require.NoError(t, <-results)
+}
+
+func TestLockManagerTimeoutIsConfigurable(t *testing.T) {
+	lm := NewLockManager(0)
+	lm.SetTimeout(10 * time.Millisecond)
+
+	require.NoError(t, lm.Lock(1, "users", []byte("alice"), LockExclusive))
+
+	start := time.Now()
+	err := lm.Lock(2, "users", []byte("alice"), LockExclusive)
+	assert.ErrorIs(t, err, ErrLockTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+}
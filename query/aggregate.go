@@ -0,0 +1,428 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// aggregateFunctions are the functions that reduce the rows of a group to
+// one value
+var aggregateFunctions = map[string]bool{
+	"count": true,
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+}
+
+// errAggregate stops the walk of hasAggregate
+var errAggregate = errors.New("aggregate")
+
+// hasAggregate reports whether an expression calls an aggregate function
+func hasAggregate(expr Expression) bool {
+	return walkExpression(expr, func(e Expression) error {
+		if f, ok := e.(*FunctionCall); ok && aggregateFunctions[f.Name] {
+			return errAggregate
+		}
+		return nil
+	}) != nil
+}
+
+// aggregates reports whether a SELECT reduces its rows to groups: it has a
+// GROUP BY or HAVING clause, or its select list or ORDER BY calls an
+// aggregate function
+func aggregates(stmt *SelectStatement, exprs []Expression, orderBy []OrderByItem) bool {
+	if len(stmt.GroupBy) > 0 || stmt.Having != nil {
+		return true
+	}
+	for _, expr := range exprs {
+		if hasAggregate(expr) {
+			return true
+		}
+	}
+	for _, item := range orderBy {
+		if hasAggregate(item.Expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// grouping describes how a SELECT reduces rows to groups. Each group becomes
+// one row of the group schema, holding the values of the GROUP BY
+// expressions followed by the results of the aggregate calls. Expressions
+// evaluated on groups are rewritten to read those columns.
+type grouping struct {
+	schema  *Schema // of the rows being grouped
+	groupBy []Expression
+	calls   []*FunctionCall
+}
+
+// groupColumn names the column of the group schema holding the value of
+// the i-th GROUP BY expression. Names start with # so that they cannot
+// clash with a column a query names.
+func groupColumn(i int) string {
+	return fmt.Sprintf("#group%d", i+1)
+}
+
+// aggregateColumn names the column of the group schema holding the result
+// of the i-th aggregate call
+func aggregateColumn(i int) string {
+	return fmt.Sprintf("#aggregate%d", i+1)
+}
+
+// groupSchema returns the schema of the rows of groups
+func (g *grouping) groupSchema() *Schema {
+	schema := &Schema{}
+	for i := range g.groupBy {
+		schema.Columns = append(schema.Columns, Column{Name: groupColumn(i)})
+	}
+	for i := range g.calls {
+		schema.Columns = append(schema.Columns, Column{Name: aggregateColumn(i)})
+	}
+	return schema
+}
+
+// rewrite returns an expression to evaluate on the rows of groups in place
+// of one evaluated on the rows being grouped. Outside aggregate calls it may
+// only read columns through the GROUP BY expressions.
+func (g *grouping) rewrite(expr Expression) (Expression, error) {
+	return rewriteExpression(expr, func(e Expression) (Expression, error) {
+		for i, key := range g.groupBy {
+			if g.sameExpression(e, key) {
+				return &ColumnRef{Name: groupColumn(i)}, nil
+			}
+		}
+		switch x := e.(type) {
+		case *FunctionCall:
+			if aggregateFunctions[x.Name] {
+				return g.aggregate(x)
+			}
+		case *ColumnRef:
+			return nil, fmt.Errorf("column %s must appear in GROUP BY or be used in an aggregate function", x.Name)
+		}
+		return nil, nil
+	})
+}
+
+// sameExpression reports whether two expressions compute the same value
+func (g *grouping) sameExpression(a, b Expression) bool {
+	ra, ok := a.(*ColumnRef)
+	rb, ok2 := b.(*ColumnRef)
+	if ok && ok2 {
+		i, err := g.schema.resolveColumn(ra)
+		j, err2 := g.schema.resolveColumn(rb)
+		return err == nil && err2 == nil && i == j
+	}
+	return a.String() == b.String()
+}
+
+// aggregate checks an aggregate call and returns the column of the group
+// schema holding its result. Identical calls share a column.
+func (g *grouping) aggregate(call *FunctionCall) (Expression, error) {
+	switch {
+	case call.Star && call.Name != "count":
+		return nil, fmt.Errorf("%s(*) is not supported; only count takes *", call.Name)
+	case call.Star && call.Distinct:
+		return nil, fmt.Errorf("count(DISTINCT *) is not supported")
+	case !call.Star && len(call.Args) != 1:
+		return nil, fmt.Errorf("%s takes one argument", call.Name)
+	case !call.Star && hasAggregate(call.Args[0]):
+		return nil, fmt.Errorf("aggregate function calls cannot be nested: %s", call)
+	}
+
+	for i, c := range g.calls {
+		if c.String() == call.String() {
+			return &ColumnRef{Name: aggregateColumn(i)}, nil
+		}
+	}
+	g.calls = append(g.calls, call)
+	return &ColumnRef{Name: aggregateColumn(len(g.calls) - 1)}, nil
+}
+
+// group is one group of rows being aggregated
+type group struct {
+	keys []Value // values of the GROUP BY expressions
+	accs []*accumulator
+}
+
+// newGroup starts a group with no rows
+func (g *grouping) newGroup(keys []Value) *group {
+	grp := &group{keys: keys, accs: make([]*accumulator, len(g.calls))}
+	for i, call := range g.calls {
+		grp.accs[i] = &accumulator{call: call}
+	}
+	return grp
+}
+
+// row returns the row of the group schema describing a group
+func (grp *group) row() Row {
+	row := append(Row(nil), grp.keys...)
+	for _, acc := range grp.accs {
+		row = append(row, acc.result())
+	}
+	return row
+}
+
+// groupRows reduces the rows of a relation that satisfy where, the WHERE
+// clause of a SELECT, to one row per group, hashing rows into groups by the values of
+// the GROUP BY expressions. It returns the rows of the groups HAVING keeps,
+// sorted and cut to size as the statement asks, along with their schema and
+// the select list rewritten to be evaluated on them. Without GROUP BY every
+// row belongs to a single group, which exists even when there are no rows.
+func (e *Executor) groupRows(ctx context.Context, r *relation, stmt *SelectStatement, where Expression, items []SelectColumn, orderBy []OrderByItem, limit, offset int64) (*Schema, []Expression, []Row, error) {
+	schema := r.schema
+	g := &grouping{schema: schema}
+	for _, expr := range stmt.GroupBy {
+		expr, err := resolveSelectRef(schema, items, "GROUP BY", expr)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if hasAggregate(expr) {
+			return nil, nil, nil, fmt.Errorf("aggregate functions are not allowed in GROUP BY")
+		}
+		g.groupBy = append(g.groupBy, expr)
+	}
+
+	// Rewrite everything evaluated on groups, which finds the aggregate calls
+	exprs := make([]Expression, len(items))
+	for i, item := range items {
+		var err error
+		if exprs[i], err = g.rewrite(item.Expr); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	var having Expression
+	if stmt.Having != nil {
+		if err := checkColumnRefs(schema, stmt.Having); err != nil {
+			return nil, nil, nil, err
+		}
+		var err error
+		if having, err = g.rewrite(stmt.Having); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	groupOrder := make([]OrderByItem, len(orderBy))
+	for i, item := range orderBy {
+		var err error
+		if item.Expr, err = g.rewrite(item.Expr); err != nil {
+			return nil, nil, nil, err
+		}
+		groupOrder[i] = item
+	}
+
+	groups, err := e.scanGroups(ctx, r, where, g)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	groupSchema := g.groupSchema()
+	var rows []Row
+	for _, grp := range groups {
+		row := grp.row()
+		if having != nil {
+			v, err := (&evalContext{ctx: ctx, exec: e, schema: groupSchema, row: row}).condition(having)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("HAVING: %w", err)
+			}
+			if v != true {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if len(groupOrder) > 0 {
+		sorter := &rowSorter{exec: e, schema: groupSchema, orderBy: groupOrder, keep: -1}
+		if limit >= 0 {
+			sorter.keep = offset + limit
+		}
+		for _, row := range rows {
+			if err := sorter.add(row); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if rows, err = sorter.sorted(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if offset >= int64(len(rows)) {
+		rows = nil
+	} else {
+		rows = rows[offset:]
+	}
+	if limit >= 0 && limit < int64(len(rows)) {
+		rows = rows[:limit]
+	}
+	return groupSchema, exprs, rows, nil
+}
+
+// scanGroups aggregates the rows of a relation that satisfy a WHERE clause
+// into groups, returned in the order their first rows were read. A bare
+// count(*) of a whole table is taken from the row count the database keeps,
+// when it keeps one, without reading any rows.
+func (e *Executor) scanGroups(ctx context.Context, r *relation, where Expression, g *grouping) ([]*group, error) {
+	if where == nil && len(g.groupBy) == 0 && len(r.tables) == 1 && !r.tables[0].derived && countsRowsOnly(g.calls) {
+		count, ok, err := e.tableRowCount(r.tables[0].table)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			grp := g.newGroup(nil)
+			for _, acc := range grp.accs {
+				acc.count = count
+			}
+			return []*group{grp}, nil
+		}
+	}
+
+	var groups []*group
+	byKey := make(map[string]*group)
+	_, err := e.scanRelation(ctx, r, where, nil, func(row Row) error {
+		c := &evalContext{ctx: ctx, exec: e, schema: g.schema, row: row}
+		keys := make([]Value, len(g.groupBy))
+		for i, expr := range g.groupBy {
+			var err error
+			if keys[i], err = c.eval(expr); err != nil {
+				return err
+			}
+		}
+		hash, err := hashValues(keys)
+		if err != nil {
+			return err
+		}
+
+		grp := byKey[hash]
+		if grp == nil {
+			grp = g.newGroup(keys)
+			byKey[hash] = grp
+			groups = append(groups, grp)
+		}
+		for _, acc := range grp.accs {
+			if err := acc.add(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 && len(g.groupBy) == 0 {
+		groups = append(groups, g.newGroup(nil))
+	}
+	return groups, nil
+}
+
+// countsRowsOnly reports whether every aggregate call is count(*)
+func countsRowsOnly(calls []*FunctionCall) bool {
+	for _, call := range calls {
+		if !call.Star {
+			return false
+		}
+	}
+	return true
+}
+
+// tableRowCount returns the number of rows of a table if the database keeps
+// count of them
+func (e *Executor) tableRowCount(table Table) (int64, bool, error) {
+	sd, ok := e.db.(StatsDatabase)
+	if _, virtual := table.(*memTable); !ok || virtual {
+		return 0, false, nil
+	}
+	stats, err := sd.TableStats(table.Name())
+	if err != nil {
+		return 0, false, err
+	}
+	return stats.Rows, true, nil
+}
+
+// appendHashValue appends an encoding of a value that is equal for equal
+// values of the same type and never a prefix of the encoding of another
+// value, so that a group's values can be concatenated into a hash key
+func appendHashValue(buf []byte, v Value) ([]byte, error) {
+	if v == nil {
+		return append(buf, 0), nil // column types start at 1
+	}
+	t := valueType(v)
+	return appendKeyValue(append(buf, byte(t)), Column{Type: t}, v)
+}
+
+// accumulator computes the result of one aggregate call over the rows of a
+// group. NULL arguments are left out; SUM, AVG, MIN and MAX of no values
+// are NULL and COUNT of none is 0.
+type accumulator struct {
+	call  *FunctionCall
+	count int64
+	value Value           // the running SUM, MIN or MAX
+	seen  map[string]bool // values already aggregated, for DISTINCT
+}
+
+// add adds a row to the aggregate
+func (a *accumulator) add(c *evalContext) error {
+	if a.call.Star {
+		a.count++
+		return nil
+	}
+	v, err := c.eval(a.call.Args[0])
+	if err != nil || v == nil {
+		return err
+	}
+	if a.call.Distinct {
+		hash, err := appendHashValue(nil, v)
+		if err != nil {
+			return err
+		}
+		if a.seen[string(hash)] {
+			return nil
+		}
+		if a.seen == nil {
+			a.seen = make(map[string]bool)
+		}
+		a.seen[string(hash)] = true
+	}
+	a.count++
+
+	switch a.call.Name {
+	case "sum", "avg":
+		if a.value == nil {
+			a.value, err = numeric(v)
+		} else {
+			a.value, err = arithmetic("+", a.value, v)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.call, err)
+		}
+	case "min", "max":
+		if a.value == nil {
+			a.value = v
+			return nil
+		}
+		cmp, err := compareMixed(v, a.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.call, err)
+		}
+		if cmp < 0 && a.call.Name == "min" || cmp > 0 && a.call.Name == "max" {
+			a.value = v
+		}
+	}
+	return nil
+}
+
+// result returns the value of the aggregate over the rows added
+func (a *accumulator) result() Value {
+	switch a.call.Name {
+	case "count":
+		return a.count
+	case "avg":
+		if a.count == 0 {
+			return nil
+		}
+		return toFloat(a.value) / float64(a.count)
+	default:
+		return a.value
+	}
+}
@@ -1,5 +1,10 @@
 package query
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Statement represents a SQL statement
 type Statement interface {
 	String() string
@@ -7,20 +12,154 @@ type Statement interface {
 
 // SelectStatement represents a SELECT query
 type SelectStatement struct {
-	Columns   []string // columns to select, "*" for all
+	Columns   []SelectColumn
 	TableName string
-	Where     Expression // optional WHERE clause
+	Subquery  *SelectStatement // a SELECT read in place of a table, named by Alias
+	Alias     string           // optional alias of the table
+	Joins     []Join           // tables joined to the first, in order
+	Where     Expression       // optional WHERE clause
+	GroupBy   []Expression     // optional GROUP BY clause
+	Having    Expression       // optional HAVING clause
+	OrderBy   []OrderByItem    // optional ORDER BY clause
+	Limit     Expression       // optional LIMIT count
+	Offset    Expression       // optional OFFSET count
 }
 
 func (s *SelectStatement) String() string {
 	return "SELECT"
 }
 
+// sql renders a SELECT as SQL text, as it appears in the expressions it is
+// a subquery of
+func (s *SelectStatement) sql() string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	for i, col := range s.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch {
+		case col.Expr != nil:
+			b.WriteString(col.Expr.String())
+			if col.Alias != "" {
+				b.WriteString(" AS " + col.Alias)
+			}
+		case col.Table != "":
+			b.WriteString(col.Table + ".*")
+		default:
+			b.WriteString("*")
+		}
+	}
+	b.WriteString(" FROM " + tableRefSQL(s.TableName, s.Subquery, s.Alias))
+	for _, join := range s.Joins {
+		b.WriteString(joinKeywords[join.Kind] + tableRefSQL(join.TableName, join.Subquery, join.Alias))
+		if join.On != nil {
+			b.WriteString(" ON " + join.On.String())
+		}
+	}
+	if s.Where != nil {
+		b.WriteString(" WHERE " + s.Where.String())
+	}
+	if len(s.GroupBy) > 0 {
+		b.WriteString(" GROUP BY " + joinExpressions(s.GroupBy))
+	}
+	if s.Having != nil {
+		b.WriteString(" HAVING " + s.Having.String())
+	}
+	for i, item := range s.OrderBy {
+		if i == 0 {
+			b.WriteString(" ORDER BY ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(item.Expr.String())
+		if item.Desc {
+			b.WriteString(" DESC")
+		}
+		switch item.Nulls {
+		case NullsFirst:
+			b.WriteString(" NULLS FIRST")
+		case NullsLast:
+			b.WriteString(" NULLS LAST")
+		}
+	}
+	if s.Limit != nil {
+		b.WriteString(" LIMIT " + s.Limit.String())
+	}
+	if s.Offset != nil {
+		b.WriteString(" OFFSET " + s.Offset.String())
+	}
+	return b.String()
+}
+
+// joinKeywords are the keywords that join a table of each kind
+var joinKeywords = map[JoinKind]string{
+	InnerJoin: " JOIN ",
+	LeftJoin:  " LEFT JOIN ",
+	CrossJoin: " CROSS JOIN ",
+}
+
+// tableRefSQL renders a table of a FROM clause: a name or a subquery, and
+// its alias
+func tableRefSQL(name string, subquery *SelectStatement, alias string) string {
+	if subquery != nil {
+		name = "(" + subquery.sql() + ")"
+	}
+	if alias != "" {
+		name += " AS " + alias
+	}
+	return name
+}
+
+// SelectColumn is one entry of a SELECT list: an expression with an
+// optional alias, or * for every column when Expr is nil. With Table set,
+// * only stands for the columns of that table, as in t.*.
+type SelectColumn struct {
+	Expr  Expression
+	Alias string
+	Table string
+}
+
+// JoinKind is the kind of a join
+type JoinKind int
+
+const (
+	InnerJoin JoinKind = iota // [INNER] JOIN ... ON
+	LeftJoin                  // LEFT [OUTER] JOIN ... ON
+	CrossJoin                 // CROSS JOIN, or a comma
+)
+
+// Join is a table joined to those before it in a FROM clause
+type Join struct {
+	Kind      JoinKind
+	TableName string
+	Subquery  *SelectStatement // a SELECT read in place of a table, named by Alias
+	Alias     string
+	On        Expression // nil for a cross join
+}
+
+// OrderByItem is one sort key of an ORDER BY clause
+type OrderByItem struct {
+	Expr  Expression
+	Desc  bool
+	Nulls NullsOrder
+}
+
+// NullsOrder says where an ORDER BY puts NULLs
+type NullsOrder int
+
+const (
+	NullsDefault NullsOrder = iota // last when ascending, first when descending
+	NullsFirst
+	NullsLast
+)
+
 // InsertStatement represents an INSERT query
 type InsertStatement struct {
 	TableName string
 	Columns   []string
-	Values    [][]string // each inner slice is a row of values
+	Values    [][]Expression   // each inner slice is a row of values
+	Select    *SelectStatement // a SELECT whose rows to insert, in place of Values
 }
 
 func (i *InsertStatement) String() string {
@@ -30,8 +169,14 @@ func (i *InsertStatement) String() string {
 // UpdateStatement represents an UPDATE query
 type UpdateStatement struct {
 	TableName string
-	Set       map[string]string // column -> value mapping
-	Where     Expression        // optional WHERE clause
+	Set       []Assignment // in the order written, each evaluated on the old row
+	Where     Expression   // optional WHERE clause
+}
+
+// Assignment is one column = value of an UPDATE's SET clause
+type Assignment struct {
+	Column string
+	Value  Expression
 }
 
 func (u *UpdateStatement) String() string {
@@ -48,23 +193,308 @@ func (d *DeleteStatement) String() string {
 	return "DELETE"
 }
 
+// CreateTableStatement represents a CREATE TABLE statement. A statement
+// without a column list creates a plain key/value table.
+type CreateTableStatement struct {
+	TableName   string
+	IfNotExists bool
+	Columns     []ColumnDefinition
+	PrimaryKey  []string               // table constraint PRIMARY KEY (a, b), if given
+	Constraints []ConstraintDefinition // table constraints other than the primary key
+}
+
+func (c *CreateTableStatement) String() string {
+	return "CREATE TABLE"
+}
+
+// DropTableStatement represents a DROP TABLE statement
+type DropTableStatement struct {
+	TableName string
+	IfExists  bool
+}
+
+func (d *DropTableStatement) String() string {
+	return "DROP TABLE"
+}
+
+// CreateSequenceStatement represents a CREATE SEQUENCE statement
+type CreateSequenceStatement struct {
+	SequenceName string
+	IfNotExists  bool
+	Start        int64
+	Increment    int64
+}
+
+func (c *CreateSequenceStatement) String() string {
+	return "CREATE SEQUENCE"
+}
+
+// DropSequenceStatement represents a DROP SEQUENCE statement
+type DropSequenceStatement struct {
+	SequenceName string
+	IfExists     bool
+}
+
+func (d *DropSequenceStatement) String() string {
+	return "DROP SEQUENCE"
+}
+
+// CreateIndexStatement represents a CREATE [UNIQUE] INDEX statement
+type CreateIndexStatement struct {
+	IndexName   string
+	TableName   string
+	Columns     []string
+	Unique      bool
+	IfNotExists bool
+}
+
+func (c *CreateIndexStatement) String() string {
+	return "CREATE INDEX"
+}
+
+// DropIndexStatement represents a DROP INDEX statement
+type DropIndexStatement struct {
+	IndexName string
+	IfExists  bool
+}
+
+func (d *DropIndexStatement) String() string {
+	return "DROP INDEX"
+}
+
+// AlterTableAction is the change an ALTER TABLE statement makes
+type AlterTableAction int
+
+const (
+	AlterAddColumn AlterTableAction = iota + 1
+	AlterDropColumn
+	AlterRenameColumn
+	AlterRenameTable
+)
+
+// AlterTableStatement represents an ALTER TABLE statement
+type AlterTableStatement struct {
+	TableName  string
+	Action     AlterTableAction
+	Column     ColumnDefinition // ADD COLUMN
+	ColumnName string           // DROP COLUMN and RENAME COLUMN
+	NewName    string           // RENAME COLUMN and RENAME TO
+}
+
+func (a *AlterTableStatement) String() string {
+	return "ALTER TABLE"
+}
+
+// ColumnDefinition is one column in a CREATE TABLE or ALTER TABLE statement
+type ColumnDefinition struct {
+	Name          string
+	Type          ColumnType
+	PrimaryKey    bool
+	NotNull       bool
+	AutoIncrement bool
+	Default       string                 // SQL text of the DEFAULT value, empty if none
+	Constraints   []ConstraintDefinition // UNIQUE, CHECK and REFERENCES constraints of the column
+}
+
+// ConstraintDefinition is a UNIQUE, CHECK or FOREIGN KEY constraint in a
+// CREATE TABLE statement
+type ConstraintDefinition struct {
+	Name    string // from CONSTRAINT name, empty to generate one
+	Kind    ConstraintKind
+	Columns []string // UNIQUE or FOREIGN KEY columns, or the column a column constraint belongs to
+	Check   string   // SQL text of the CHECK expression
+
+	// REFERENCES clause of a FOREIGN KEY. Without RefColumns the key refers
+	// to the primary key of RefTable.
+	RefTable   string
+	RefColumns []string
+	OnDelete   ForeignKeyAction
+	OnUpdate   ForeignKeyAction
+}
+
 // Expression represents a SQL expression
 type Expression interface {
 	String() string
 }
 
-// ComparisonExpression represents a comparison (e.g., col = 'value')
-type ComparisonExpression struct {
-	Left     string
+// Literal is a constant value: a number, a string, TRUE, FALSE or NULL
+type Literal struct {
+	Value Value
+}
+
+func (l *Literal) String() string {
+	switch v := l.Value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return quoteString(v)
+	case bool:
+		return strings.ToUpper(FormatValue(v))
+	case float64:
+		// Keep a decimal point, so 7.0 does not read back as an INTEGER
+		s := FormatValue(v)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	default:
+		return FormatValue(v)
+	}
+}
+
+// Parameter is a placeholder, written ? or $n, for a value given when a
+// prepared statement runs. Index counts from 1; each ? takes the next one.
+type Parameter struct {
+	Index int
+}
+
+func (p *Parameter) String() string {
+	return "$" + strconv.Itoa(p.Index)
+}
+
+// ColumnRef refers to a column of the row an expression is evaluated on,
+// optionally qualified by the name or alias of its table (e.g., u.name)
+type ColumnRef struct {
+	Table string
+	Name  string
+}
+
+func (c *ColumnRef) String() string {
+	if c.Table != "" {
+		return c.Table + "." + c.Name
+	}
+	return c.Name
+}
+
+// UnaryExpression applies a prefix operator: NOT, - or +
+type UnaryExpression struct {
 	Operator string
-	Right    string
+	Operand  Expression
 }
 
-func (c *ComparisonExpression) String() string {
-	return c.Left + " " + c.Operator + " " + c.Right
+func (u *UnaryExpression) String() string {
+	if u.Operator == "NOT" {
+		return "NOT " + u.Operand.String()
+	}
+	return u.Operator + u.Operand.String()
 }
 
-// BinaryExpression represents a binary operation (AND/OR)
+// FunctionCall calls a function by name, as in nextval('seq'). Aggregate
+// functions may take DISTINCT before their argument, and count takes * to
+// count rows.
+type FunctionCall struct {
+	Name     string
+	Args     []Expression
+	Distinct bool
+	Star     bool
+}
+
+func (f *FunctionCall) String() string {
+	args := joinExpressions(f.Args)
+	if f.Star {
+		args = "*"
+	}
+	if f.Distinct {
+		args = "DISTINCT " + args
+	}
+	return f.Name + "(" + args + ")"
+}
+
+// LikeExpression matches a string against a pattern in which % stands for
+// any run of characters and _ for any single one (e.g., name LIKE 'a%')
+type LikeExpression struct {
+	Left    Expression
+	Pattern Expression
+	Not     bool
+}
+
+func (l *LikeExpression) String() string {
+	return l.Left.String() + negation(l.Not) + " LIKE " + l.Pattern.String()
+}
+
+// InExpression tests whether a value equals one of a list of values
+// (e.g., id IN (1, 2, 3)), or one of the values a subquery returns
+// (e.g., id IN (SELECT user_id FROM orders))
+type InExpression struct {
+	Left     Expression
+	Values   []Expression
+	Subquery *SelectStatement // replaces Values when set
+	Not      bool
+}
+
+func (i *InExpression) String() string {
+	list := joinExpressions(i.Values)
+	if i.Subquery != nil {
+		list = i.Subquery.sql()
+	}
+	return i.Left.String() + negation(i.Not) + " IN (" + list + ")"
+}
+
+// SubqueryExpression is a SELECT used as a value: the one column of the one
+// row it returns, or NULL when it returns no rows
+type SubqueryExpression struct {
+	Select *SelectStatement
+}
+
+func (s *SubqueryExpression) String() string {
+	return "(" + s.Select.sql() + ")"
+}
+
+// ExistsExpression tests whether a SELECT returns any rows
+// (e.g., EXISTS (SELECT 1 FROM orders WHERE orders.user_id = users.id))
+type ExistsExpression struct {
+	Select *SelectStatement
+}
+
+func (e *ExistsExpression) String() string {
+	return "EXISTS (" + e.Select.sql() + ")"
+}
+
+// BetweenExpression tests whether a value lies between two others,
+// inclusive (e.g., age BETWEEN 18 AND 65)
+type BetweenExpression struct {
+	Left Expression
+	Low  Expression
+	High Expression
+	Not  bool
+}
+
+func (b *BetweenExpression) String() string {
+	return b.Left.String() + negation(b.Not) + " BETWEEN " + b.Low.String() + " AND " + b.High.String()
+}
+
+// IsNullExpression tests whether a value is NULL (e.g., email IS NOT NULL)
+type IsNullExpression struct {
+	Left Expression
+	Not  bool
+}
+
+func (i *IsNullExpression) String() string {
+	if i.Not {
+		return i.Left.String() + " IS NOT NULL"
+	}
+	return i.Left.String() + " IS NULL"
+}
+
+// negation renders the NOT of a negated condition
+func negation(not bool) string {
+	if not {
+		return " NOT"
+	}
+	return ""
+}
+
+// joinExpressions renders a comma-separated list of expressions
+func joinExpressions(exprs []Expression) string {
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		parts[i] = expr.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// BinaryExpression applies an infix operator: OR, AND, a comparison (=, !=,
+// <>, <, <=, >, >=), arithmetic (+, -, *, /, %) or concatenation (||)
 type BinaryExpression struct {
 	Left     Expression
 	Operator string
@@ -73,4 +503,95 @@ type BinaryExpression struct {
 
 func (b *BinaryExpression) String() string {
 	return "(" + b.Left.String() + " " + b.Operator + " " + b.Right.String() + ")"
-}
\ No newline at end of file
+}
+
+// walkExpression calls fn with an expression and then with each expression
+// nested in it, depth first, stopping at the first error
+func walkExpression(expr Expression, fn func(Expression) error) error {
+	if err := fn(expr); err != nil {
+		return err
+	}
+
+	var children []Expression
+	switch e := expr.(type) {
+	case *UnaryExpression:
+		children = []Expression{e.Operand}
+	case *BinaryExpression:
+		children = []Expression{e.Left, e.Right}
+	case *FunctionCall:
+		children = e.Args
+	case *LikeExpression:
+		children = []Expression{e.Left, e.Pattern}
+	case *InExpression:
+		children = append([]Expression{e.Left}, e.Values...)
+	case *BetweenExpression:
+		children = []Expression{e.Left, e.Low, e.High}
+	case *IsNullExpression:
+		children = []Expression{e.Left}
+	}
+	for _, child := range children {
+		if err := walkExpression(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteExpression returns a copy of an expression in which fn has replaced
+// subexpressions. fn returns the replacement of an expression, or nil to keep
+// it and rewrite the expressions nested in it instead.
+func rewriteExpression(expr Expression, fn func(Expression) (Expression, error)) (Expression, error) {
+	replaced, err := fn(expr)
+	if err != nil || replaced != nil {
+		return replaced, err
+	}
+
+	rewrite := func(exprs ...*Expression) error {
+		for _, e := range exprs {
+			var err error
+			if *e, err = rewriteExpression(*e, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rewriteList := func(list []Expression) ([]Expression, error) {
+		out := make([]Expression, len(list))
+		for i, e := range list {
+			var err error
+			if out[i], err = rewriteExpression(e, fn); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	switch e := expr.(type) {
+	case *UnaryExpression:
+		c := *e
+		return &c, rewrite(&c.Operand)
+	case *BinaryExpression:
+		c := *e
+		return &c, rewrite(&c.Left, &c.Right)
+	case *FunctionCall:
+		c := *e
+		c.Args, err = rewriteList(e.Args)
+		return &c, err
+	case *LikeExpression:
+		c := *e
+		return &c, rewrite(&c.Left, &c.Pattern)
+	case *InExpression:
+		c := *e
+		if c.Values, err = rewriteList(e.Values); err != nil {
+			return nil, err
+		}
+		return &c, rewrite(&c.Left)
+	case *BetweenExpression:
+		c := *e
+		return &c, rewrite(&c.Left, &c.Low, &c.High)
+	case *IsNullExpression:
+		c := *e
+		return &c, rewrite(&c.Left)
+	}
+	return expr, nil
+}
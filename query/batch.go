@@ -0,0 +1,290 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Batch stages writes to several tables, along with table and sequence
+// creation, drops and schema changes, and applies them in one commit. A
+// statement that touches a table and its indexes writes through a batch, so
+// either all of its changes are applied or none are.
+type Batch interface {
+	Put(tableName string, key, value []byte)
+	Delete(tableName string, key []byte)
+
+	// RequireAbsent makes the commit fail with err, applying nothing, if a
+	// key starting with prefix exists at that point in the batch
+	RequireAbsent(tableName string, prefix []byte, err error)
+
+	// RequirePresent makes the commit fail with err, applying nothing,
+	// unless a key starting with prefix exists at that point in the batch
+	RequirePresent(tableName string, prefix []byte, err error)
+
+	CreateTable(tableName string, schema []byte)
+	DropTable(tableName string)
+	SetTableSchema(tableName string, schema []byte)
+
+	// Truncate removes every key of a table, keeping the table
+	Truncate(tableName string)
+
+	CreateSequence(name string, start, increment int64)
+	DropSequence(name string)
+
+	// AdvanceSequence moves a sequence past value unless it already has,
+	// so nextval never returns a value written explicitly
+	AdvanceSequence(name string, value int64)
+
+	Commit() error
+}
+
+// BatchDatabase is implemented by databases that can commit a Batch
+// atomically
+type BatchDatabase interface {
+	NewBatch() Batch
+}
+
+// TableLister is implemented by databases that can list their tables
+type TableLister interface {
+	ListTables() []string
+}
+
+// newBatch starts a batch for one statement. Only a BatchDatabase makes
+// the statement atomic; other databases get a tableBatch, which is not.
+func (e *Executor) newBatch() Batch {
+	if bd, ok := e.db.(BatchDatabase); ok {
+		return bd.NewBatch()
+	}
+	return &tableBatch{db: e.db}
+}
+
+// tableBatch is the Batch of a database that cannot commit atomically. It
+// applies the operations one at a time through the Table interface, so it
+// is not atomic: an error from the database part-way through a commit
+// leaves the earlier operations applied. Every RequireAbsent and
+// RequirePresent check is evaluated before anything is applied, so a failed
+// check at least leaves the database untouched.
+type tableBatch struct {
+	db  Database
+	ops []tableBatchOp
+}
+
+// batchOpKind identifies the operation a tableBatchOp performs
+type batchOpKind int
+
+const (
+	opPut batchOpKind = iota
+	opDelete
+	opRequireAbsent
+	opRequirePresent
+	opCreateTable
+	opDropTable
+	opSetSchema
+	opTruncate
+	opCreateSequence
+	opDropSequence
+	opAdvanceSequence
+)
+
+// tableBatchOp is one operation of a tableBatch
+type tableBatchOp struct {
+	kind  batchOpKind
+	table string // the table, or the sequence of a sequence operation
+	key   []byte
+	value []byte
+	err   error
+	n     int64 // the start of a new sequence, or the value to advance past
+	step  int64 // the increment of a new sequence
+}
+
+func (b *tableBatch) Put(tableName string, key, value []byte) {
+	b.ops = append(b.ops, tableBatchOp{kind: opPut, table: tableName, key: key, value: value})
+}
+
+func (b *tableBatch) Delete(tableName string, key []byte) {
+	b.ops = append(b.ops, tableBatchOp{kind: opDelete, table: tableName, key: key})
+}
+
+func (b *tableBatch) RequireAbsent(tableName string, prefix []byte, err error) {
+	b.ops = append(b.ops, tableBatchOp{kind: opRequireAbsent, table: tableName, key: prefix, err: err})
+}
+
+func (b *tableBatch) RequirePresent(tableName string, prefix []byte, err error) {
+	b.ops = append(b.ops, tableBatchOp{kind: opRequirePresent, table: tableName, key: prefix, err: err})
+}
+
+func (b *tableBatch) CreateTable(tableName string, schema []byte) {
+	b.ops = append(b.ops, tableBatchOp{kind: opCreateTable, table: tableName, value: schema})
+}
+
+func (b *tableBatch) DropTable(tableName string) {
+	b.ops = append(b.ops, tableBatchOp{kind: opDropTable, table: tableName})
+}
+
+func (b *tableBatch) SetTableSchema(tableName string, schema []byte) {
+	b.ops = append(b.ops, tableBatchOp{kind: opSetSchema, table: tableName, value: schema})
+}
+
+func (b *tableBatch) Truncate(tableName string) {
+	b.ops = append(b.ops, tableBatchOp{kind: opTruncate, table: tableName})
+}
+
+func (b *tableBatch) CreateSequence(name string, start, increment int64) {
+	b.ops = append(b.ops, tableBatchOp{kind: opCreateSequence, table: name, n: start, step: increment})
+}
+
+func (b *tableBatch) DropSequence(name string) {
+	b.ops = append(b.ops, tableBatchOp{kind: opDropSequence, table: name})
+}
+
+func (b *tableBatch) AdvanceSequence(name string, value int64) {
+	b.ops = append(b.ops, tableBatchOp{kind: opAdvanceSequence, table: name, n: value})
+}
+
+func (b *tableBatch) Commit() error {
+	if err := b.check(); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		if err := b.apply(op); err != nil {
+			return err
+		}
+	}
+	b.ops = nil
+	return nil
+}
+
+// check evaluates the RequireAbsent and RequirePresent operations against
+// the tables as the operations before each of them would leave them
+func (b *tableBatch) check() error {
+	written := make(map[string]map[string]bool) // table -> key -> present
+	fresh := make(map[string]bool)              // tables created or dropped by the batch
+
+	for _, op := range b.ops {
+		switch op.kind {
+		case opPut, opDelete:
+			if written[op.table] == nil {
+				written[op.table] = make(map[string]bool)
+			}
+			written[op.table][string(op.key)] = op.kind == opPut
+		case opCreateTable, opDropTable, opTruncate:
+			written[op.table] = nil
+			fresh[op.table] = true
+		case opRequireAbsent, opRequirePresent:
+			found, err := b.hasPrefix(op.table, op.key, written[op.table], fresh[op.table])
+			if err != nil {
+				return err
+			}
+			if found == (op.kind == opRequireAbsent) {
+				return op.err
+			}
+		}
+	}
+	return nil
+}
+
+// hasPrefix reports whether a table has a key starting with prefix once the
+// written keys are applied to it. A fresh table starts out empty.
+func (b *tableBatch) hasPrefix(tableName string, prefix []byte, written map[string]bool, fresh bool) (bool, error) {
+	for key, present := range written {
+		if present && bytes.HasPrefix([]byte(key), prefix) {
+			return true, nil
+		}
+	}
+	if fresh {
+		return false, nil
+	}
+
+	table, err := b.db.GetTable(tableName)
+	if err != nil {
+		return false, err
+	}
+	overwritten := func(key []byte) bool {
+		_, ok := written[string(key)]
+		return ok
+	}
+	if _, exists := table.Select(prefix); exists && !overwritten(prefix) {
+		return true, nil
+	}
+	iter := table.Scan(prefix)
+	for iter.ContainsNext() {
+		key, _ := iter.Next()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		if !overwritten(key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply performs one operation
+func (b *tableBatch) apply(op tableBatchOp) error {
+	switch op.kind {
+	case opPut, opDelete:
+		table, err := b.db.GetTable(op.table)
+		if err != nil {
+			return err
+		}
+		if op.kind == opPut {
+			return table.Insert(op.key, op.value)
+		}
+		if _, exists := table.Select(op.key); !exists {
+			return nil
+		}
+		return table.Delete(op.key)
+	case opCreateTable:
+		schemaDB, ok := b.db.(SchemaDatabase)
+		if !ok {
+			return fmt.Errorf("database does not support typed tables")
+		}
+		_, err := schemaDB.CreateTableWithSchema(op.table, op.value)
+		return err
+	case opDropTable:
+		dropper, ok := b.db.(TableDropper)
+		if !ok {
+			return fmt.Errorf("database does not support dropping tables")
+		}
+		return dropper.DropTable(op.table)
+	case opTruncate:
+		table, err := b.db.GetTable(op.table)
+		if err != nil {
+			return err
+		}
+		// Collect the keys before deleting any, so the deletes cannot
+		// disturb the scan
+		var keys [][]byte
+		iter := table.Scan([]byte(""))
+		for iter.ContainsNext() {
+			key, _ := iter.Next()
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := table.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opSetSchema:
+		alterer, ok := b.db.(TableAlterer)
+		if !ok {
+			return fmt.Errorf("database does not support ALTER TABLE")
+		}
+		return alterer.SetTableSchema(op.table, op.value)
+	case opCreateSequence, opDropSequence, opAdvanceSequence:
+		sequences, ok := b.db.(SequenceDatabase)
+		if !ok {
+			return fmt.Errorf("database does not support sequences")
+		}
+		switch op.kind {
+		case opCreateSequence:
+			return sequences.CreateSequence(op.table, op.n, op.step)
+		case opDropSequence:
+			return sequences.DropSequence(op.table)
+		default:
+			return sequences.AdvanceSequence(op.table, op.n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConstraintKind identifies the kind of a table constraint
+type ConstraintKind int
+
+const (
+	ConstraintNotNull ConstraintKind = iota + 1
+	ConstraintUnique
+	ConstraintCheck
+	ConstraintPrimaryKey
+	ConstraintForeignKey
+)
+
+// String returns the SQL name of the constraint kind
+func (k ConstraintKind) String() string {
+	switch k {
+	case ConstraintNotNull:
+		return "NOT NULL"
+	case ConstraintUnique:
+		return "UNIQUE"
+	case ConstraintCheck:
+		return "CHECK"
+	case ConstraintPrimaryKey:
+		return "PRIMARY KEY"
+	case ConstraintForeignKey:
+		return "FOREIGN KEY"
+	default:
+		return fmt.Sprintf("ConstraintKind(%d)", int(k))
+	}
+}
+
+// ConstraintError is returned when a write would violate a constraint of a
+// table. Nothing of the statement that caused it has been written.
+type ConstraintError struct {
+	Kind       ConstraintKind
+	Constraint string // name of the constraint, or of the unique index
+	Table      string
+	Columns    []string
+	Check      string // SQL text of a violated CHECK expression
+	RefTable   string // table a violated foreign key refers to
+	Detail     string // the values that violated a foreign key
+}
+
+func (e *ConstraintError) Error() string {
+	columns := strings.Join(e.Columns, ", ")
+	switch e.Kind {
+	case ConstraintNotNull:
+		return fmt.Sprintf("table %s: column %s cannot be NULL (constraint %s)", e.Table, columns, e.Constraint)
+	case ConstraintUnique:
+		return fmt.Sprintf("table %s: duplicate value for unique index %s (%s)", e.Table, e.Constraint, columns)
+	case ConstraintPrimaryKey:
+		return fmt.Sprintf("table %s: duplicate primary key %s (constraint %s)", e.Table, columns, e.Constraint)
+	case ConstraintCheck:
+		return fmt.Sprintf("table %s: CHECK constraint %s failed: %s", e.Table, e.Constraint, e.Check)
+	case ConstraintForeignKey:
+		return fmt.Sprintf("table %s: foreign key %s (%s) violated: %s", e.Table, e.Constraint, columns, e.Detail)
+	default:
+		return fmt.Sprintf("table %s: %s constraint %s failed", e.Table, e.Kind, e.Constraint)
+	}
+}
+
+// Unwrap lets errors.Is match primary key violations with ErrDuplicateKey
+func (e *ConstraintError) Unwrap() error {
+	if e.Kind == ConstraintPrimaryKey {
+		return ErrDuplicateKey
+	}
+	return nil
+}
+
+// Check is a CHECK constraint of a table
+type Check struct {
+	Name    string   `json:"name"`
+	Expr    string   `json:"expr"`    // SQL text of the expression
+	Columns []string `json:"columns"` // columns the expression refers to
+}
+
+// AddConstraint records a UNIQUE, CHECK or FOREIGN KEY constraint of a new
+// table. A UNIQUE constraint is kept as a unique index, which the caller
+// creates. The caller also resolves the referenced columns of a foreign key.
+func (s *Schema) AddConstraint(tableName string, def ConstraintDefinition) error {
+	switch def.Kind {
+	case ConstraintUnique:
+		name := def.Name
+		if name == "" {
+			name = s.constraintName(tableName + "_" + strings.Join(def.Columns, "_") + "_key")
+		}
+		return s.AddIndex(Index{Name: name, Columns: def.Columns, Unique: true, Constraint: true})
+
+	case ConstraintCheck:
+		expr, err := ParseCheck(def.Check)
+		if err != nil {
+			return err
+		}
+		columns, err := s.checkColumns(expr)
+		if err != nil {
+			return fmt.Errorf("CHECK (%s): %w", def.Check, err)
+		}
+		if len(def.Columns) > 0 {
+			columns = def.Columns
+		}
+
+		name := def.Name
+		if name == "" {
+			base := tableName + "_check"
+			if len(columns) == 1 {
+				base = tableName + "_" + columns[0] + "_check"
+			}
+			name = s.constraintName(base)
+		} else if s.hasConstraint(name) {
+			return fmt.Errorf("constraint %s already exists", name)
+		}
+
+		s.Checks = append(s.Checks, Check{Name: name, Expr: def.Check, Columns: columns})
+		s.checks = nil
+		return nil
+
+	case ConstraintForeignKey:
+		return s.addForeignKey(tableName, def)
+
+	default:
+		return fmt.Errorf("unsupported constraint: %s", def.Kind)
+	}
+}
+
+// hasConstraint reports whether a check, foreign key or index of the table
+// has the name
+func (s *Schema) hasConstraint(name string) bool {
+	for _, c := range s.Checks {
+		if strings.EqualFold(c.Name, name) {
+			return true
+		}
+	}
+	if s.ForeignKey(name) != nil {
+		return true
+	}
+	return s.Index(name) != nil
+}
+
+// constraintName returns base, or base with a number appended if the name
+// is already taken
+func (s *Schema) constraintName(base string) string {
+	name := base
+	for n := 1; s.hasConstraint(name); n++ {
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+	return name
+}
+
+// checkColumns validates a CHECK expression against the schema and returns
+// the columns it refers to. A column compared with a literal must accept the
+// literal's value.
+func (s *Schema) checkColumns(expr Expression) ([]string, error) {
+	var columns []string
+	err := walkExpression(expr, func(e Expression) error {
+		switch e := e.(type) {
+		case *FunctionCall:
+			return fmt.Errorf("function %s cannot be used in CHECK", e.Name)
+		case *LikeExpression:
+			if ref, ok := e.Left.(*ColumnRef); ok {
+				if col := s.Column(ref.Name); col != nil && col.Type != TypeText {
+					return fmt.Errorf("LIKE needs a TEXT column, %s is %s", col.Name, col.Type)
+				}
+			}
+		case *BinaryExpression:
+			if !isComparison(e.Operator) {
+				return nil
+			}
+			for _, pair := range [][2]Expression{{e.Left, e.Right}, {e.Right, e.Left}} {
+				ref, ok := pair[0].(*ColumnRef)
+				lit, isLit := pair[1].(*Literal)
+				if !ok || !isLit {
+					continue
+				}
+				if col := s.Column(ref.Name); col != nil {
+					if _, err := coerceValue(lit.Value, col.Type); err != nil {
+						return fmt.Errorf("column %s: %w", col.Name, err)
+					}
+				}
+			}
+		case *ColumnRef:
+			col := s.Column(e.Name)
+			if col == nil {
+				return fmt.Errorf("unknown column: %s", e.Name)
+			}
+			for _, name := range columns {
+				if name == col.Name {
+					return nil
+				}
+			}
+			columns = append(columns, col.Name)
+		}
+		return nil
+	})
+	return columns, err
+}
+
+// checkExpr returns the parsed expression of the i-th CHECK constraint
+func (s *Schema) checkExpr(i int) (Expression, error) {
+	if s.checks == nil {
+		s.checks = make([]Expression, len(s.Checks))
+		for j, c := range s.Checks {
+			expr, err := ParseCheck(c.Expr)
+			if err != nil {
+				s.checks = nil
+				return nil, fmt.Errorf("constraint %s: %w", c.Name, err)
+			}
+			s.checks[j] = expr
+		}
+	}
+	return s.checks[i], nil
+}
+
+// ParseCheck parses the SQL text of a CHECK expression
+func ParseCheck(text string) (Expression, error) {
+	p := NewParser(NewLexer("CHECK (" + text + ")"))
+	expr, _, err := p.parseCheck()
+	if err != nil {
+		return nil, fmt.Errorf("CHECK (%s): %w", text, err)
+	}
+	if p.peekToken.Type != EOF {
+		return nil, fmt.Errorf("CHECK (%s): unexpected %q", text, p.peekToken.Literal)
+	}
+	return expr, nil
+}
+
+// renameCheckColumn rewrites references to a renamed column in the text of
+// CHECK expressions
+func (s *Schema) renameCheckColumn(oldName, newName string) {
+	for i := range s.Checks {
+		c := &s.Checks[i]
+
+		var sb strings.Builder
+		last := 0
+		l := NewLexer(c.Expr)
+		for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+			if (tok.Type == IDENTIFIER || nonReserved[tok.Type]) && strings.EqualFold(tok.Literal, oldName) {
+				sb.WriteString(c.Expr[last:tok.Pos])
+				sb.WriteString(newName)
+				last = tok.Pos + len(tok.Literal)
+			}
+		}
+		sb.WriteString(c.Expr[last:])
+		c.Expr = sb.String()
+
+		for j, name := range c.Columns {
+			if strings.EqualFold(name, oldName) {
+				c.Columns[j] = newName
+			}
+		}
+	}
+	s.checks = nil
+}
+
+// checkRow enforces the NOT NULL and CHECK constraints of a table on a row
+// about to be written. A CHECK constraint only fails when its expression is
+// false; NULL passes, as in standard SQL.
+func checkRow(tableName string, schema *Schema, row Row) error {
+	for i, col := range schema.Columns {
+		if col.NotNull && row[i] == nil {
+			return &ConstraintError{
+				Kind:       ConstraintNotNull,
+				Constraint: tableName + "_" + col.Name + "_not_null",
+				Table:      tableName,
+				Columns:    []string{col.Name},
+			}
+		}
+	}
+
+	for i, c := range schema.Checks {
+		expr, err := schema.checkExpr(i)
+		if err != nil {
+			return err
+		}
+		v, err := evalCondition(schema, row, expr)
+		if err != nil {
+			return fmt.Errorf("constraint %s: %w", c.Name, err)
+		}
+		if v == false {
+			return &ConstraintError{
+				Kind:       ConstraintCheck,
+				Constraint: c.Name,
+				Table:      tableName,
+				Columns:    c.Columns,
+				Check:      c.Expr,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,516 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// evalContext is what an expression is evaluated against: a row of a
+// table, if any, and the executor that runs functions such as nextval and
+// subqueries, with the context of the statement they run for
+type evalContext struct {
+	ctx    context.Context
+	exec   *Executor
+	schema *Schema
+	row    Row
+}
+
+// eval evaluates an expression. Conditions follow three-valued logic: they
+// are true, false, or nil when a NULL makes them unknown.
+func (c *evalContext) eval(expr Expression) (Value, error) {
+	switch e := expr.(type) {
+	case *Literal:
+		return e.Value, nil
+
+	case *Parameter:
+		return nil, fmt.Errorf("no value bound to parameter %s; run the statement through Prepare", e)
+
+	case *ColumnRef:
+		if c.schema == nil {
+			return nil, fmt.Errorf("column %s cannot be used here", e.Name)
+		}
+		i, err := c.schema.resolveColumn(e)
+		if err != nil {
+			return nil, err
+		}
+		return c.row[i], nil
+
+	case *UnaryExpression:
+		v, err := c.eval(e.Operand)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		switch e.Operator {
+		case "NOT":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("NOT needs a boolean, got %s", FormatValue(v))
+			}
+			return !b, nil
+		case "-":
+			return arithmetic("-", int64(0), v)
+		case "+":
+			return arithmetic("+", int64(0), v)
+		}
+		return nil, fmt.Errorf("unsupported operator: %s", e.Operator)
+
+	case *BinaryExpression:
+		if e.Operator == "AND" || e.Operator == "OR" {
+			return c.evalLogical(e)
+		}
+		left, err := c.eval(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := c.eval(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		if e.Operator == "||" {
+			return FormatValue(left) + FormatValue(right), nil
+		}
+		if isComparison(e.Operator) {
+			cmp, err := compareMixed(left, right)
+			if err != nil {
+				return nil, err
+			}
+			return compareResult(e.Operator, cmp), nil
+		}
+		return arithmetic(e.Operator, left, right)
+
+	case *FunctionCall:
+		return c.call(e)
+
+	case *LikeExpression:
+		left, err := c.eval(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := c.eval(e.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil || pattern == nil {
+			return nil, nil
+		}
+		s, ok := left.(string)
+		p, pok := pattern.(string)
+		if !ok || !pok {
+			return nil, fmt.Errorf("LIKE needs TEXT operands: %s", e)
+		}
+		return matchLike(s, p) != e.Not, nil
+
+	case *InExpression:
+		if e.Subquery != nil {
+			result, err := c.subquery(e.Subquery, -1)
+			if err != nil {
+				return nil, err
+			}
+			list, err := result.list(e)
+			if err != nil {
+				return nil, err
+			}
+			return c.eval(list)
+		}
+		left, err := c.eval(e.Left)
+		if err != nil || left == nil {
+			return nil, err
+		}
+		var result Value = false
+		for _, item := range e.Values {
+			v, err := c.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				result = nil // unknown unless another value matches
+				continue
+			}
+			cmp, err := compareMixed(left, v)
+			if err != nil {
+				return nil, err
+			}
+			if cmp == 0 {
+				result = true
+				break
+			}
+		}
+		if result == nil {
+			return nil, nil
+		}
+		return result != e.Not, nil
+
+	case *BetweenExpression:
+		low := &BinaryExpression{Left: e.Left, Operator: ">=", Right: e.Low}
+		high := &BinaryExpression{Left: e.Left, Operator: "<=", Right: e.High}
+		v, err := c.eval(&BinaryExpression{Left: low, Operator: "AND", Right: high})
+		if err != nil || v == nil {
+			return nil, err
+		}
+		return v != e.Not, nil
+
+	case *IsNullExpression:
+		v, err := c.eval(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		return (v == nil) != e.Not, nil
+
+	case *SubqueryExpression:
+		result, err := c.subquery(e.Select, 2)
+		if err != nil {
+			return nil, err
+		}
+		return result.value()
+
+	case *ExistsExpression:
+		result, err := c.subquery(e.Select, 1)
+		if err != nil {
+			return nil, err
+		}
+		return len(result.rows) > 0, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported expression: %s", expr)
+	}
+}
+
+// evalLogical evaluates AND and OR with three-valued logic
+func (c *evalContext) evalLogical(e *BinaryExpression) (Value, error) {
+	operand := func(expr Expression) (Value, error) {
+		v, err := c.eval(expr)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		if _, ok := v.(bool); !ok {
+			return nil, fmt.Errorf("%s needs boolean operands, got %s", e.Operator, FormatValue(v))
+		}
+		return v, nil
+	}
+	left, err := operand(e.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := operand(e.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Operator {
+	case "AND":
+		if left == false || right == false {
+			return false, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return true, nil
+	default:
+		if left == true || right == true {
+			return true, nil
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return false, nil
+	}
+}
+
+// call evaluates a function call
+func (c *evalContext) call(f *FunctionCall) (Value, error) {
+	switch f.Name {
+	case "nextval":
+		if len(f.Args) != 1 {
+			return nil, fmt.Errorf("nextval takes one argument")
+		}
+		if c.exec == nil {
+			return nil, fmt.Errorf("nextval cannot be used here")
+		}
+		name, err := c.eval(f.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		sequence, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("nextval needs a sequence name")
+		}
+		return c.exec.nextVal(sequence)
+	default:
+		if aggregateFunctions[f.Name] {
+			return nil, fmt.Errorf("aggregate function %s cannot be used here", f.Name)
+		}
+		return nil, fmt.Errorf("unknown function: %s", f.Name)
+	}
+}
+
+// evalCondition evaluates a condition on a row with three-valued logic: the
+// result is true, false or nil when it is unknown because of a NULL
+func evalCondition(schema *Schema, row Row, expr Expression) (Value, error) {
+	return (&evalContext{schema: schema, row: row}).condition(expr)
+}
+
+// condition evaluates a condition as evalCondition does
+func (c *evalContext) condition(expr Expression) (Value, error) {
+	v, err := c.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(bool); !ok && v != nil {
+		return nil, fmt.Errorf("%s is not a condition", expr)
+	}
+	return v, nil
+}
+
+// isComparison reports whether a binary operator compares its operands
+func isComparison(operator string) bool {
+	switch operator {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// compareResult applies a comparison operator to the result of comparing
+// its operands
+func compareResult(operator string, c int) bool {
+	switch operator {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// valueType returns the column type a non-NULL value belongs to
+func valueType(v Value) ColumnType {
+	switch v.(type) {
+	case int64:
+		return TypeInteger
+	case float64:
+		return TypeReal
+	case []byte:
+		return TypeBlob
+	case bool:
+		return TypeBoolean
+	default:
+		return TypeText
+	}
+}
+
+// coerceValue converts a value to a column's type: strings convert as
+// literals do, integers widen to REAL and become booleans as 0 and 1, and
+// anything becomes TEXT
+func coerceValue(v Value, t ColumnType) (Value, error) {
+	if v == nil || checkValueType(v, t) == nil {
+		return v, nil
+	}
+	switch x := v.(type) {
+	case string:
+		return ConvertLiteral(x, t)
+	case []byte:
+		return ConvertLiteral(string(x), t)
+	case int64:
+		switch t {
+		case TypeReal:
+			return float64(x), nil
+		case TypeBoolean:
+			if x == 0 || x == 1 {
+				return x == 1, nil
+			}
+		}
+	}
+	if t == TypeText {
+		return FormatValue(v), nil
+	}
+	return nil, checkValueType(v, t)
+}
+
+// compareMixed orders two non-NULL values, converting one to the other's
+// type when they differ, as when a column is compared with a string
+func compareMixed(a, b Value) (int, error) {
+	c, err := compareValues(a, b)
+	if err == nil {
+		return c, nil
+	}
+	if cb, cerr := coerceValue(b, valueType(a)); cerr == nil {
+		return compareValues(a, cb)
+	}
+	if ca, cerr := coerceValue(a, valueType(b)); cerr == nil {
+		return compareValues(ca, b)
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", FormatValue(a), FormatValue(b))
+}
+
+// arithmetic applies +, -, *, / or % to two non-NULL numbers. Two INTEGERs
+// give an INTEGER, failing on overflow; anything involving a REAL gives a
+// REAL. Strings holding numbers are converted first.
+func arithmetic(operator string, a, b Value) (Value, error) {
+	x, err := numeric(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := numeric(b)
+	if err != nil {
+		return nil, err
+	}
+
+	xi, xint := x.(int64)
+	yi, yint := y.(int64)
+	if xint && yint {
+		return integerArithmetic(operator, xi, yi)
+	}
+
+	xf, yf := toFloat(x), toFloat(y)
+	switch operator {
+	case "+":
+		return xf + yf, nil
+	case "-":
+		return xf - yf, nil
+	case "*":
+		return xf * yf, nil
+	case "/":
+		if yf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return xf / yf, nil
+	case "%":
+		if yf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(xf, yf), nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", operator)
+}
+
+// integerArithmetic applies an arithmetic operator to two INTEGERs
+func integerArithmetic(operator string, x, y int64) (Value, error) {
+	var r int64
+	overflow := false
+	switch operator {
+	case "+":
+		r = x + y
+		overflow = (x > 0 && y > 0 && r < 0) || (x < 0 && y < 0 && r >= 0)
+	case "-":
+		r = x - y
+		overflow = (x >= 0 && y < 0 && r < 0) || (x < 0 && y > 0 && r >= 0)
+	case "*":
+		r = x * y
+		overflow = x != 0 && (r/x != y || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64))
+	case "/", "%":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if x == math.MinInt64 && y == -1 {
+			if operator == "%" {
+				return int64(0), nil
+			}
+			overflow = true
+			break
+		}
+		if operator == "/" {
+			r = x / y
+		} else {
+			r = x % y
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", operator)
+	}
+	if overflow {
+		return nil, fmt.Errorf("integer overflow in %d %s %d", x, operator, y)
+	}
+	return r, nil
+}
+
+// numeric returns a value as an int64 or float64 for arithmetic
+func numeric(v Value) (Value, error) {
+	switch x := v.(type) {
+	case int64, float64:
+		return x, nil
+	case string:
+		if n, err := parseNumber(strings.TrimSpace(x)); err == nil {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a number", FormatValue(v))
+}
+
+// toFloat widens a number to float64
+func toFloat(v Value) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// isConstant reports whether an expression has the same value for every
+// row: it refers to no column and calls no function or subquery
+func isConstant(expr Expression) bool {
+	return walkExpression(expr, func(e Expression) error {
+		switch e := e.(type) {
+		case *ColumnRef, *FunctionCall, *SubqueryExpression, *ExistsExpression:
+			return errNotConstant
+		case *InExpression:
+			if e.Subquery != nil {
+				return errNotConstant
+			}
+		}
+		return nil
+	}) == nil
+}
+
+// errNotConstant stops the walk of isConstant
+var errNotConstant = fmt.Errorf("not constant")
+
+// checkColumnRefs reports the first column an expression refers to that
+// the schema lacks
+func checkColumnRefs(schema *Schema, expr Expression) error {
+	return walkExpression(expr, func(e Expression) error {
+		if ref, ok := e.(*ColumnRef); ok {
+			_, err := schema.resolveColumn(ref)
+			return err
+		}
+		return nil
+	})
+}
+
+// matchLike reports whether s matches a LIKE pattern, in which % matches
+// any run of characters and _ any single character. Matching is case
+// sensitive.
+func matchLike(s, pattern string) bool {
+	str, pat := []rune(s), []rune(pattern)
+	si, pi := 0, 0
+	star, mark := -1, 0 // the last % seen and where its match started
+	for si < len(str) {
+		switch {
+		case pi < len(pat) && pat[pi] == '%':
+			star, mark = pi, si
+			pi++
+		case pi < len(pat) && (pat[pi] == '_' || pat[pi] == str[si]):
+			si++
+			pi++
+		case star >= 0:
+			// Let the last % match one more character
+			mark++
+			pi, si = star+1, mark
+		default:
+			return false
+		}
+	}
+	for pi < len(pat) && pat[pi] == '%' {
+		pi++
+	}
+	return pi == len(pat)
+}
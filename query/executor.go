@@ -1,7 +1,12 @@
 package query
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // Database interface for executing queries against
@@ -20,6 +25,69 @@ type Table interface {
 	Name() string
 }
 
+// SchemaDatabase is implemented by databases that can persist a schema with
+// each table. Creating a typed table requires it.
+type SchemaDatabase interface {
+	CreateTableWithSchema(tableName string, schema []byte) (Table, error)
+}
+
+// TableDropper is implemented by databases that can drop tables
+type TableDropper interface {
+	DropTable(tableName string) error
+}
+
+// TableAlterer is implemented by databases that can change a table's schema
+// and name after it was created
+type TableAlterer interface {
+	SetTableSchema(tableName string, schema []byte) error
+	RenameTable(oldName, newName string) error
+}
+
+// SequenceDatabase is implemented by databases that keep sequences. Values
+// handed out by NextVal are never handed out again.
+type SequenceDatabase interface {
+	CreateSequence(name string, start, increment int64) error
+	DropSequence(name string) error
+	NextVal(name string) (int64, error)
+	AdvanceSequence(name string, value int64) error
+}
+
+// ErrDuplicateKey is returned when a row would reuse the primary key of an
+// existing row
+var ErrDuplicateKey = errors.New("duplicate primary key")
+
+var (
+	// ErrSequenceExists is wrapped by the error a SequenceDatabase returns
+	// when creating a sequence whose name is already taken
+	ErrSequenceExists = errors.New("sequence already exists")
+
+	// ErrSequenceNotFound is wrapped by the error a SequenceDatabase
+	// returns for a sequence that does not exist
+	ErrSequenceNotFound = errors.New("sequence does not exist")
+)
+
+// SchemaTable is implemented by tables that carry a schema. A table without
+// one, or with a nil schema, is a plain key/value table.
+type SchemaTable interface {
+	Schema() []byte
+}
+
+// ReverseScanner is implemented by tables that can scan their keys from the
+// largest down. ScanReverse returns the keys smaller than endKey, or every
+// key when endKey is nil.
+type ReverseScanner interface {
+	ScanReverse(endKey []byte) Iterator
+}
+
+// ContextScanner is implemented by tables whose scans check a context as
+// they read pages, so a canceled statement stops even while a scan skips
+// pages with no keys. A scan stopped that way just ends; the caller checks
+// the context to tell. ScanReverseContext is only used on a ReverseScanner.
+type ContextScanner interface {
+	ScanContext(ctx context.Context, startKey []byte) Iterator
+	ScanReverseContext(ctx context.Context, endKey []byte) Iterator
+}
+
 // Iterator interface for scanning results
 type Iterator interface {
 	Next() (key, val []byte)
@@ -30,13 +98,19 @@ type Iterator interface {
 type QueryResult struct {
 	Success bool
 	Message string
+	Columns []string            // For SELECT queries, in select-list order
 	Rows    []map[string]string // For SELECT queries
 	Error   error
+
+	// RowsAffected is the number of rows an INSERT, UPDATE or DELETE
+	// wrote, not counting those its foreign key actions reached
+	RowsAffected int64
 }
 
 // Executor executes parsed SQL statements
 type Executor struct {
-	db Database
+	db               Database
+	statementTimeout time.Duration
 }
 
 // NewExecutor creates a new query executor
@@ -44,17 +118,59 @@ func NewExecutor(db Database) *Executor {
 	return &Executor{db: db}
 }
 
+// SetStatementTimeout limits how long a single statement may run. A
+// statement that runs longer fails with context.DeadlineExceeded. Zero
+// means no limit.
+func (e *Executor) SetStatementTimeout(timeout time.Duration) {
+	e.statementTimeout = timeout
+}
+
 // Execute executes a parsed SQL statement and returns the result
 func (e *Executor) Execute(stmt Statement) *QueryResult {
+	return e.ExecuteContext(context.Background(), stmt)
+}
+
+// ExecuteContext executes a parsed SQL statement, giving up with the
+// context's error if it is canceled or its deadline passes. Scans check for
+// cancellation between rows.
+func (e *Executor) ExecuteContext(ctx context.Context, stmt Statement) *QueryResult {
+	if e.statementTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.statementTimeout)
+		defer cancel()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if err := checkNotSystemTable(stmt); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	ctx = withSubqueryCache(ctx)
+
 	switch s := stmt.(type) {
 	case *SelectStatement:
-		return e.executeSelect(s)
+		return e.executeSelect(ctx, s)
 	case *InsertStatement:
-		return e.executeInsert(s)
+		return e.executeInsert(ctx, s)
 	case *UpdateStatement:
-		return e.executeUpdate(s)
+		return e.executeUpdate(ctx, s)
 	case *DeleteStatement:
-		return e.executeDelete(s)
+		return e.executeDelete(ctx, s)
+	case *CreateTableStatement:
+		return e.executeCreateTable(ctx, s)
+	case *DropTableStatement:
+		return e.executeDropTable(ctx, s)
+	case *AlterTableStatement:
+		return e.executeAlterTable(ctx, s)
+	case *CreateIndexStatement:
+		return e.executeCreateIndex(ctx, s)
+	case *DropIndexStatement:
+		return e.executeDropIndex(ctx, s)
+	case *CreateSequenceStatement:
+		return e.executeCreateSequence(ctx, s)
+	case *DropSequenceStatement:
+		return e.executeDropSequence(ctx, s)
 	default:
 		return &QueryResult{
 			Success: false,
@@ -64,184 +180,1259 @@ func (e *Executor) Execute(stmt Statement) *QueryResult {
 }
 
 // executeSelect executes a SELECT statement
-func (e *Executor) executeSelect(stmt *SelectStatement) *QueryResult {
+func (e *Executor) executeSelect(ctx context.Context, stmt *SelectStatement) *QueryResult {
+	r, err := e.openRelation(ctx, stmt)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	columns, rows, err := e.selectFrom(ctx, r, stmt)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	result := &QueryResult{Success: true, Columns: columns}
+	for _, row := range rows {
+		out := make(map[string]string, len(row))
+		for i, v := range row {
+			out[columns[i]] = FormatValue(v)
+		}
+		result.Rows = append(result.Rows, out)
+	}
+	result.Message = fmt.Sprintf("Selected %d rows", len(result.Rows))
+	return result
+}
+
+// executeInsert executes an INSERT statement
+func (e *Executor) executeInsert(ctx context.Context, stmt *InsertStatement) *QueryResult {
 	table, err := e.db.GetTable(stmt.TableName)
 	if err != nil {
 		return &QueryResult{Success: false, Error: err}
 	}
 
-	var rows []map[string]string
+	rows, err := e.insertValues(ctx, stmt)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
 
-	if stmt.Where != nil {
-		// Handle WHERE clause - simplified to only handle single key lookups
-		if comp, ok := stmt.Where.(*ComparisonExpression); ok && comp.Operator == "=" {
-			key := []byte(comp.Right)
-			if value, found := table.Select(key); found {
-				if e.matchesColumns(stmt.Columns, comp.Left, string(key), string(value)) {
-					row := make(map[string]string)
-					row[comp.Left] = string(key)
-					row["value"] = string(value) // Simplified - in real DB this would parse structured data
-					rows = append(rows, row)
-				}
+	schema, err := tableSchema(table)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if schema != nil {
+		return e.insertRows(ctx, table, schema, stmt.TableName, stmt.Columns, rows)
+	}
+
+	// A key/value table stores each row's key and value, all in one batch
+	batch := e.newBatch()
+	for _, values := range rows {
+		key, value, err := e.keyValuePair(ctx, stmt.TableName, stmt.Columns, values)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		batch.Put(stmt.TableName, []byte(key), []byte(value))
+	}
+	if err := batch.Commit(); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	return &QueryResult{
+		Success:      true,
+		Message:      insertedMessage(len(rows), stmt.TableName),
+		RowsAffected: int64(len(rows)),
+	}
+}
+
+// insertValues returns the rows of values an INSERT stores: those of its
+// VALUES list, or the rows its SELECT returns, read in full before any is
+// written
+func (e *Executor) insertValues(ctx context.Context, stmt *InsertStatement) ([][]Expression, error) {
+	if stmt.Select == nil {
+		if len(stmt.Values) == 0 {
+			return nil, fmt.Errorf("no values provided")
+		}
+		return stmt.Values, nil
+	}
+
+	r, err := e.openRelation(ctx, stmt.Select)
+	if err != nil {
+		return nil, err
+	}
+	_, rows, err := e.selectFrom(ctx, r, stmt.Select)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]Expression, len(rows))
+	for i, row := range rows {
+		values[i] = make([]Expression, len(row))
+		for j, v := range row {
+			values[i][j] = &Literal{Value: v}
+		}
+	}
+	return values, nil
+}
+
+// keyValuePair evaluates a row of an INSERT into a key/value table. A column
+// list names the key and value columns the values go to; without one the
+// first value is the key and the last the value, so a single value is
+// stored as both.
+func (e *Executor) keyValuePair(ctx context.Context, tableName string, columns []string, values []Expression) (string, string, error) {
+	if len(columns) > 0 {
+		row, err := e.buildRow(ctx, keyValueSchema(), columns, values)
+		if err != nil {
+			return "", "", err
+		}
+		if row[0] == nil || row[1] == nil {
+			return "", "", fmt.Errorf("key/value tables cannot store NULL")
+		}
+		return FormatValue(row[0]), FormatValue(row[1]), nil
+	}
+
+	if len(values) == 0 || len(values) > 2 {
+		return "", "", fmt.Errorf("key/value table %s takes a key and a value; create it with columns to store more", tableName)
+	}
+	c := &evalContext{ctx: ctx, exec: e}
+	key, err := keyValueText(c, values[0])
+	if err != nil {
+		return "", "", err
+	}
+	value, err := keyValueText(c, values[len(values)-1])
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// insertedMessage reports the number of rows an INSERT stored
+func insertedMessage(n int, tableName string) string {
+	if n == 1 {
+		return fmt.Sprintf("Inserted 1 row into %s", tableName)
+	}
+	return fmt.Sprintf("Inserted %d rows into %s", n, tableName)
+}
+
+// executeUpdate executes an UPDATE statement
+func (e *Executor) executeUpdate(ctx context.Context, stmt *UpdateStatement) *QueryResult {
+	table, err := e.db.GetTable(stmt.TableName)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	schema, err := tableSchema(table)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if schema != nil {
+		return e.updateRow(ctx, table, schema.qualified(stmt.TableName), stmt)
+	}
+
+	// A key/value table only has its value to update
+	if len(stmt.Set) != 1 || !strings.EqualFold(stmt.Set[0].Column, "value") {
+		return &QueryResult{
+			Success: false,
+			Error:   fmt.Errorf("key/value table %s only has a value column to update", stmt.TableName),
+		}
+	}
+
+	newExpr := stmt.Set[0].Value
+	kvSchema := keyValueSchema().qualified(stmt.TableName)
+	if err := checkColumnRefs(kvSchema, newExpr); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	// Work out every new value before writing any, so the writes cannot
+	// disturb the scan, then write them all in one batch
+	batch := e.newBatch()
+	updatedRows := 0
+	err = e.whereRows(ctx, table, kvSchema, stmt.Where, func(key []byte, row Row) error {
+		newValue, err := keyValueText(&evalContext{ctx: ctx, exec: e, schema: kvSchema, row: row}, newExpr)
+		if err != nil {
+			return err
+		}
+		batch.Put(stmt.TableName, key, []byte(newValue))
+		updatedRows++
+		return nil
+	})
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if err := batch.Commit(); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	return &QueryResult{
+		Success:      true,
+		Message:      fmt.Sprintf("Updated %d rows in %s", updatedRows, stmt.TableName),
+		RowsAffected: int64(updatedRows),
+	}
+}
+
+// executeDelete executes a DELETE statement
+func (e *Executor) executeDelete(ctx context.Context, stmt *DeleteStatement) *QueryResult {
+	table, err := e.db.GetTable(stmt.TableName)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	schema, err := tableSchema(table)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if schema != nil {
+		return e.deleteRow(ctx, table, schema.qualified(stmt.TableName), stmt)
+	}
+
+	var deletedRows int64
+	if stmt.Where == nil {
+		deletedRows, _, err = e.truncateTable(ctx, table, nil, stmt.TableName)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+	} else {
+		keys, err := e.matchingKeys(ctx, table, keyValueSchema().qualified(stmt.TableName), stmt.Where)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+
+		batch := e.newBatch()
+		for _, key := range keys {
+			batch.Delete(stmt.TableName, key)
+		}
+		if err := batch.Commit(); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		deletedRows = int64(len(keys))
+	}
+
+	return &QueryResult{
+		Success:      true,
+		Message:      fmt.Sprintf("Deleted %d rows from %s", deletedRows, stmt.TableName),
+		RowsAffected: deletedRows,
+	}
+}
+
+// executeCreateTable executes a CREATE TABLE statement
+func (e *Executor) executeCreateTable(ctx context.Context, stmt *CreateTableStatement) *QueryResult {
+	if stmt.IfNotExists {
+		if _, err := e.db.GetTable(stmt.TableName); err == nil {
+			return &QueryResult{
+				Success: true,
+				Message: fmt.Sprintf("Table %s already exists", stmt.TableName),
 			}
 		}
+	}
+
+	if len(stmt.Columns) == 0 {
+		if _, err := e.db.CreateTable(stmt.TableName); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
 	} else {
-		// No WHERE clause - scan all records
-		iter := table.Scan([]byte(""))
-		for iter.ContainsNext() {
-			key, value := iter.Next()
-			if key != nil {
-				row := make(map[string]string)
-				row["key"] = string(key)
-				row["value"] = string(value)
-				rows = append(rows, row)
+		schemaDB, ok := e.db.(SchemaDatabase)
+		if !ok {
+			return &QueryResult{Success: false, Error: fmt.Errorf("database does not support typed tables")}
+		}
+
+		schema, err := NewSchema(stmt.Columns, stmt.PrimaryKey)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		constraints := stmt.Constraints
+		for _, col := range stmt.Columns {
+			constraints = append(constraints, col.Constraints...)
+		}
+		for _, c := range constraints {
+			if err := schema.AddConstraint(stmt.TableName, c); err != nil {
+				return &QueryResult{Success: false, Error: err}
+			}
+		}
+		if err := e.resolveForeignKeys(stmt.TableName, schema); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		for _, col := range stmt.Columns {
+			if col.AutoIncrement {
+				schema.setAutoIncrement(col.Name, stmt.TableName+"_"+col.Name+"_seq")
+			}
+		}
+		data, err := schema.Encode()
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+
+		if len(schema.Indexes) == 0 && len(schema.sequences()) == 0 {
+			if _, err := schemaDB.CreateTableWithSchema(stmt.TableName, data); err != nil {
+				return &QueryResult{Success: false, Error: err}
+			}
+		} else {
+			// Create the table with the indexes backing its UNIQUE
+			// constraints and the sequences of its AUTOINCREMENT columns
+			// in one batch
+			batch := e.newBatch()
+			batch.CreateTable(stmt.TableName, data)
+			for _, idx := range schema.Indexes {
+				batch.CreateTable(idx.Name, indexTreeSchema())
+			}
+			for _, seq := range schema.sequences() {
+				batch.CreateSequence(seq, 1, 1)
+			}
+			if err := batch.Commit(); err != nil {
+				return &QueryResult{Success: false, Error: err}
 			}
 		}
 	}
 
 	return &QueryResult{
 		Success: true,
-		Message: fmt.Sprintf("Selected %d rows", len(rows)),
-		Rows:    rows,
+		Message: fmt.Sprintf("Created table %s", stmt.TableName),
 	}
 }
 
-// executeInsert executes an INSERT statement
-func (e *Executor) executeInsert(stmt *InsertStatement) *QueryResult {
+// executeDropTable executes a DROP TABLE statement
+func (e *Executor) executeDropTable(ctx context.Context, stmt *DropTableStatement) *QueryResult {
+	dropper, ok := e.db.(TableDropper)
+	if !ok {
+		return &QueryResult{Success: false, Error: fmt.Errorf("database does not support dropping tables")}
+	}
+
 	table, err := e.db.GetTable(stmt.TableName)
 	if err != nil {
+		if stmt.IfExists {
+			return &QueryResult{
+				Success: true,
+				Message: fmt.Sprintf("Table %s does not exist", stmt.TableName),
+			}
+		}
 		return &QueryResult{Success: false, Error: err}
 	}
 
-	// For simplicity, use the first value as key and concatenate others as value
-	// In a real implementation, we'd have proper column mapping
-	if len(stmt.Values) == 0 || len(stmt.Values[0]) == 0 {
-		return &QueryResult{Success: false, Error: fmt.Errorf("no values provided")}
+	schema, err := tableSchema(table)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
 	}
 
-	values := stmt.Values[0]
-	key := []byte(values[0])
-	
-	// Concatenate remaining values as the stored value
-	var value string
-	if len(values) > 1 {
-		for i, v := range values[1:] {
-			if i > 0 {
-				value += "|"
+	refs, err := e.referencesTo(stmt.TableName)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	for _, ref := range refs {
+		if ref.table != stmt.TableName {
+			return &QueryResult{
+				Success: false,
+				Error:   fmt.Errorf("cannot drop table %s: foreign key %s of table %s refers to it", stmt.TableName, ref.fk.Name, ref.table),
 			}
-			value += v
 		}
+	}
+
+	if schema != nil && (len(schema.Indexes) > 0 || len(schema.sequences()) > 0) {
+		// The table's indexes and sequences go with it
+		batch := e.newBatch()
+		for _, idx := range schema.Indexes {
+			batch.DropTable(idx.Name)
+		}
+		for _, seq := range schema.sequences() {
+			batch.DropSequence(seq)
+		}
+		batch.DropTable(stmt.TableName)
+		err = batch.Commit()
 	} else {
-		value = values[0] // If only one value, use it as both key and value
+		err = dropper.DropTable(stmt.TableName)
+	}
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
 	}
 
-	if err := table.Insert(key, []byte(value)); err != nil {
+	return &QueryResult{
+		Success: true,
+		Message: fmt.Sprintf("Dropped table %s", stmt.TableName),
+	}
+}
+
+// executeCreateSequence executes a CREATE SEQUENCE statement
+func (e *Executor) executeCreateSequence(ctx context.Context, stmt *CreateSequenceStatement) *QueryResult {
+	sequences, ok := e.db.(SequenceDatabase)
+	if !ok {
+		return &QueryResult{Success: false, Error: fmt.Errorf("database does not support sequences")}
+	}
+
+	if err := sequences.CreateSequence(stmt.SequenceName, stmt.Start, stmt.Increment); err != nil {
+		if stmt.IfNotExists && errors.Is(err, ErrSequenceExists) {
+			return &QueryResult{
+				Success: true,
+				Message: fmt.Sprintf("Sequence %s already exists", stmt.SequenceName),
+			}
+		}
 		return &QueryResult{Success: false, Error: err}
 	}
 
 	return &QueryResult{
 		Success: true,
-		Message: fmt.Sprintf("Inserted 1 row into %s", stmt.TableName),
+		Message: fmt.Sprintf("Created sequence %s", stmt.SequenceName),
 	}
 }
 
-// executeUpdate executes an UPDATE statement
-func (e *Executor) executeUpdate(stmt *UpdateStatement) *QueryResult {
+// executeDropSequence executes a DROP SEQUENCE statement. A sequence owned
+// by an AUTOINCREMENT column is dropped with its table instead.
+func (e *Executor) executeDropSequence(ctx context.Context, stmt *DropSequenceStatement) *QueryResult {
+	sequences, ok := e.db.(SequenceDatabase)
+	if !ok {
+		return &QueryResult{Success: false, Error: fmt.Errorf("database does not support sequences")}
+	}
+
+	if lister, ok := e.db.(TableLister); ok {
+		for _, name := range lister.ListTables() {
+			table, err := e.db.GetTable(name)
+			if err != nil {
+				continue
+			}
+			schema, err := tableSchema(table)
+			if err != nil || schema == nil {
+				continue
+			}
+			for _, col := range schema.Columns {
+				if col.Sequence == stmt.SequenceName {
+					return &QueryResult{
+						Success: false,
+						Error:   fmt.Errorf("cannot drop sequence %s: it numbers column %s of table %s", stmt.SequenceName, col.Name, name),
+					}
+				}
+			}
+		}
+	}
+
+	if err := sequences.DropSequence(stmt.SequenceName); err != nil {
+		if stmt.IfExists && errors.Is(err, ErrSequenceNotFound) {
+			return &QueryResult{
+				Success: true,
+				Message: fmt.Sprintf("Sequence %s does not exist", stmt.SequenceName),
+			}
+		}
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	return &QueryResult{
+		Success: true,
+		Message: fmt.Sprintf("Dropped sequence %s", stmt.SequenceName),
+	}
+}
+
+// executeAlterTable executes an ALTER TABLE statement. Column changes only
+// rewrite the schema; rows written earlier are adapted when they are read.
+func (e *Executor) executeAlterTable(ctx context.Context, stmt *AlterTableStatement) *QueryResult {
+	alterer, ok := e.db.(TableAlterer)
+	if !ok {
+		return &QueryResult{Success: false, Error: fmt.Errorf("database does not support ALTER TABLE")}
+	}
+
 	table, err := e.db.GetTable(stmt.TableName)
 	if err != nil {
 		return &QueryResult{Success: false, Error: err}
 	}
 
-	updatedRows := 0
+	refs, err := e.referencesTo(stmt.TableName)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
 
-	if stmt.Where != nil {
-		// Handle WHERE clause - simplified to only handle single key lookups
-		if comp, ok := stmt.Where.(*ComparisonExpression); ok && comp.Operator == "=" {
-			key := []byte(comp.Right)
-			
-			// Build new value from SET assignments
-			var newValue string
-			for col, val := range stmt.Set {
-				if len(newValue) > 0 {
-					newValue += "|"
-				}
-				newValue += col + ":" + val
+	if stmt.Action == AlterRenameTable {
+		// Foreign keys name the table they refer to
+		if len(refs) > 0 {
+			return &QueryResult{
+				Success: false,
+				Error:   fmt.Errorf("cannot rename table %s: foreign key %s of table %s refers to it", stmt.TableName, refs[0].fk.Name, refs[0].table),
 			}
-			
-			if err := table.Update(key, []byte(newValue)); err != nil {
-				return &QueryResult{Success: false, Error: err}
+		}
+		if err := alterer.RenameTable(stmt.TableName, stmt.NewName); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		return &QueryResult{
+			Success: true,
+			Message: fmt.Sprintf("Renamed table %s to %s", stmt.TableName, stmt.NewName),
+		}
+	}
+
+	schema, err := tableSchema(table)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if schema == nil {
+		return &QueryResult{Success: false, Error: fmt.Errorf("table %s has no columns to alter", stmt.TableName)}
+	}
+
+	// Tables whose foreign keys refer to a renamed column change with it
+	var children []foreignKeyRef
+	switch stmt.Action {
+	case AlterAddColumn:
+		err = schema.AddColumn(stmt.Column)
+	case AlterDropColumn:
+		err = schema.DropColumn(stmt.ColumnName)
+	case AlterRenameColumn:
+		var oldName string
+		if col := schema.Column(stmt.ColumnName); col != nil {
+			oldName = col.Name
+		}
+		if err = schema.RenameColumn(stmt.ColumnName, stmt.NewName); err != nil {
+			break
+		}
+		schema.renameReferencedColumn(stmt.TableName, oldName, stmt.NewName)
+		for _, ref := range refs {
+			if ref.table != stmt.TableName && ref.schema.renameReferencedColumn(stmt.TableName, oldName, stmt.NewName) {
+				children = append(children, ref)
 			}
-			updatedRows = 1
 		}
+	default:
+		err = fmt.Errorf("unsupported ALTER TABLE action")
+	}
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	data, err := schema.Encode()
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if len(children) == 0 {
+		err = alterer.SetTableSchema(stmt.TableName, data)
 	} else {
-		// No WHERE clause - update all records (dangerous, but simplified)
-		return &QueryResult{
-			Success: false,
-			Error:   fmt.Errorf("UPDATE without WHERE clause not supported in this implementation"),
+		batch := e.newBatch()
+		batch.SetTableSchema(stmt.TableName, data)
+		for _, ref := range children {
+			childData, err := ref.schema.Encode()
+			if err != nil {
+				return &QueryResult{Success: false, Error: err}
+			}
+			batch.SetTableSchema(ref.table, childData)
 		}
+		err = batch.Commit()
+	}
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
 	}
 
 	return &QueryResult{
 		Success: true,
-		Message: fmt.Sprintf("Updated %d rows in %s", updatedRows, stmt.TableName),
+		Message: fmt.Sprintf("Altered table %s", stmt.TableName),
 	}
 }
 
-// executeDelete executes a DELETE statement
-func (e *Executor) executeDelete(stmt *DeleteStatement) *QueryResult {
+// executeCreateIndex executes a CREATE INDEX statement. The index is
+// created, filled from the existing rows and recorded in the table's schema
+// in one batch.
+func (e *Executor) executeCreateIndex(ctx context.Context, stmt *CreateIndexStatement) *QueryResult {
 	table, err := e.db.GetTable(stmt.TableName)
 	if err != nil {
 		return &QueryResult{Success: false, Error: err}
 	}
 
-	deletedRows := 0
+	schema, err := tableSchema(table)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if schema == nil {
+		return &QueryResult{Success: false, Error: fmt.Errorf("table %s has no columns to index", stmt.TableName)}
+	}
+
+	// Indexes and tables share one namespace
+	if _, err := e.db.GetTable(stmt.IndexName); err == nil {
+		if stmt.IfNotExists {
+			return &QueryResult{
+				Success: true,
+				Message: fmt.Sprintf("Index %s already exists", stmt.IndexName),
+			}
+		}
+		return &QueryResult{Success: false, Error: fmt.Errorf("index or table %s already exists", stmt.IndexName)}
+	}
 
-	if stmt.Where != nil {
-		// Handle WHERE clause - simplified to only handle single key lookups
-		if comp, ok := stmt.Where.(*ComparisonExpression); ok && comp.Operator == "=" {
-			key := []byte(comp.Right)
-			
-			if err := table.Delete(key); err != nil {
-				return &QueryResult{Success: false, Error: err}
+	if err := schema.AddIndex(Index{Name: stmt.IndexName, Columns: stmt.Columns, Unique: stmt.Unique}); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	idx := schema.Index(stmt.IndexName)
+	data, err := schema.Encode()
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	batch := e.newBatch()
+	batch.CreateTable(idx.Name, indexTreeSchema())
+	err = scanRows(ctx, table, schema, func(key []byte, row Row) error {
+		return stageIndexEntry(batch, stmt.TableName, schema, idx, row, key)
+	})
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	batch.SetTableSchema(stmt.TableName, data)
+	if err := batch.Commit(); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	return &QueryResult{
+		Success: true,
+		Message: fmt.Sprintf("Created index %s on %s", stmt.IndexName, stmt.TableName),
+	}
+}
+
+// executeDropIndex executes a DROP INDEX statement
+func (e *Executor) executeDropIndex(ctx context.Context, stmt *DropIndexStatement) *QueryResult {
+	lister, ok := e.db.(TableLister)
+	if !ok {
+		return &QueryResult{Success: false, Error: fmt.Errorf("database does not support indexes")}
+	}
+
+	// Find the table the index belongs to
+	for _, name := range lister.ListTables() {
+		table, err := e.db.GetTable(name)
+		if err != nil {
+			continue
+		}
+		schema, err := tableSchema(table)
+		if err != nil || schema == nil || schema.Index(stmt.IndexName) == nil {
+			continue
+		}
+
+		idx := schema.Index(stmt.IndexName)
+		indexName := idx.Name
+		if idx.Constraint {
+			return &QueryResult{
+				Success: false,
+				Error:   fmt.Errorf("cannot drop index %s: it backs a UNIQUE constraint of table %s", indexName, name),
 			}
-			deletedRows = 1
 		}
-	} else {
-		// No WHERE clause - delete all records (dangerous, but simplified)
+		refs, err := e.referencesTo(name)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		for _, ref := range refs {
+			if key, _ := schema.uniqueKey(ref.fk.RefColumns); key != nil && key.Name == indexName {
+				return &QueryResult{
+					Success: false,
+					Error:   fmt.Errorf("cannot drop index %s: foreign key %s of table %s refers to its columns", indexName, ref.fk.Name, ref.table),
+				}
+			}
+		}
+		if err := schema.DropIndex(indexName); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		data, err := schema.Encode()
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+
+		batch := e.newBatch()
+		batch.DropTable(indexName)
+		batch.SetTableSchema(name, data)
+		if err := batch.Commit(); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
 		return &QueryResult{
-			Success: false,
-			Error:   fmt.Errorf("DELETE without WHERE clause not supported in this implementation"),
+			Success: true,
+			Message: fmt.Sprintf("Dropped index %s", indexName),
+		}
+	}
+
+	if stmt.IfExists {
+		return &QueryResult{
+			Success: true,
+			Message: fmt.Sprintf("Index %s does not exist", stmt.IndexName),
+		}
+	}
+	return &QueryResult{Success: false, Error: fmt.Errorf("index %s does not exist", stmt.IndexName)}
+}
+
+// tableSchema returns the schema of a typed table, or nil for a key/value
+// table
+func tableSchema(table Table) (*Schema, error) {
+	st, ok := table.(SchemaTable)
+	if !ok {
+		return nil, nil
+	}
+
+	data := st.Schema()
+	if data == nil {
+		return nil, nil
+	}
+
+	schema, err := DecodeSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	if schema.IndexTree {
+		return nil, fmt.Errorf("%s is an index, not a table", table.Name())
+	}
+	return schema, nil
+}
+
+// keyFromEqualities returns the key of the only row the equalities can
+// match, if they constrain every key column
+func keyFromEqualities(schema *Schema, equal map[int]Value) ([]byte, bool, error) {
+	row := make(Row, len(schema.Columns))
+	for _, i := range schema.keyColumns() {
+		v, ok := equal[i]
+		if !ok {
+			return nil, false, nil
+		}
+		row[i] = v
+	}
+
+	key, err := EncodeKey(schema, row)
+	if err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// buildRow converts the values of an INSERT into a row. Without a column
+// list, values fill the columns in order; columns without a value get their
+// DEFAULT, or NULL. A DEFAULT from a sequence takes the sequence's next
+// value.
+func (e *Executor) buildRow(ctx context.Context, schema *Schema, columns []string, values []Expression) (Row, error) {
+	targets := make([]int, len(values))
+	if len(columns) == 0 {
+		if len(values) > len(schema.Columns) {
+			return nil, fmt.Errorf("%d values for %d columns", len(values), len(schema.Columns))
+		}
+		for i := range targets {
+			targets[i] = i
 		}
+	} else {
+		if len(columns) != len(values) {
+			return nil, fmt.Errorf("%d columns but %d values", len(columns), len(values))
+		}
+		listed := make([]bool, len(schema.Columns))
+		for i, name := range columns {
+			targets[i] = schema.ColumnIndex(name)
+			if targets[i] < 0 {
+				return nil, fmt.Errorf("unknown column: %s", name)
+			}
+			if listed[targets[i]] {
+				return nil, fmt.Errorf("column %s is listed more than once", name)
+			}
+			listed[targets[i]] = true
+		}
+	}
+
+	row := make(Row, len(schema.Columns))
+	given := make([]bool, len(schema.Columns))
+	for i, expr := range values {
+		v, err := e.columnValue(&evalContext{ctx: ctx, exec: e}, schema, targets[i], expr)
+		if err != nil {
+			return nil, err
+		}
+		row[targets[i]] = v
+		given[targets[i]] = true
+	}
+
+	for i := range row {
+		if given[i] {
+			continue
+		}
+		v, err := e.defaultFor(schema, i)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// columnValue evaluates an expression to store in the column at index i,
+// converting the result to the column's type
+func (e *Executor) columnValue(c *evalContext, schema *Schema, i int, expr Expression) (Value, error) {
+	col := schema.Columns[i]
+	v, err := c.eval(expr)
+	if err == nil {
+		v, err = coerceValue(v, col.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("column %s: %w", col.Name, err)
+	}
+	return v, nil
+}
+
+// defaultFor returns the value a new row gets in the column at index i when
+// none is given
+func (e *Executor) defaultFor(schema *Schema, i int) (Value, error) {
+	d, err := schema.columnDefault(i)
+	if err != nil {
+		return nil, err
+	}
+	if d.expr == nil {
+		return d.value, nil
+	}
+	return e.columnValue(&evalContext{exec: e}, schema, i, d.expr)
+}
+
+// nextVal takes the next value of a sequence
+func (e *Executor) nextVal(sequence string) (Value, error) {
+	sequences, ok := e.db.(SequenceDatabase)
+	if !ok {
+		return nil, fmt.Errorf("database does not support sequences")
+	}
+	v, err := sequences.NextVal(sequence)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// equalValues compares two values of the same column
+func equalValues(a, b Value) bool {
+	if a == nil || b == nil {
+		return false // NULL is never equal to anything
+	}
+	if ab, ok := a.([]byte); ok {
+		bb, ok := b.([]byte)
+		return ok && bytes.Equal(ab, bb)
+	}
+	return a == b
+}
+
+// scanRows decodes every row of a typed table in key order
+func scanRows(ctx context.Context, table Table, schema *Schema, fn func(key []byte, row Row) error) error {
+	iter := scanTable(ctx, table, []byte(""))
+	for iter.ContainsNext() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key, data := iter.Next()
+		if key == nil {
+			continue
+		}
+		row, err := DecodeRow(schema, data)
+		if err != nil {
+			return fmt.Errorf("row %q: %w", key, err)
+		}
+		if err := fn(key, row); err != nil {
+			return err
+		}
+	}
+	return ctx.Err() // the scan may have stopped early for it
+}
+
+// findRows calls fn with every row that has the given values. It looks the
+// row up by key if every key column has a value, scans the index with the
+// most leading columns that do, and scans the whole table otherwise.
+func (e *Executor) findRows(ctx context.Context, table Table, schema *Schema, equal map[int]Value, fn func(key []byte, row Row) error) error {
+	fetch := fetchRow(table, schema, equal, fn)
+
+	key, isKey, err := keyFromEqualities(schema, equal)
+	if err != nil {
+		return err
+	}
+	if isKey {
+		return fetch(key)
+	}
+
+	idx, r, err := chooseIndex(schema, equal, nil)
+	if err != nil {
+		return err
+	}
+	if idx != nil {
+		return scanIndex(ctx, e.db, schema, idx, r, fetch)
+	}
+
+	return scanRows(ctx, table, schema, func(key []byte, row Row) error {
+		if !rowMatches(row, equal) {
+			return nil
+		}
+		return fn(key, row)
+	})
+}
+
+// fetchRow returns a function that looks a row up by key and calls fn with
+// it if the row exists and has the given values
+func fetchRow(table Table, schema *Schema, equal map[int]Value, fn func(key []byte, row Row) error) func(key []byte) error {
+	return func(key []byte) error {
+		data, found := table.Select(key)
+		if !found {
+			return nil
+		}
+		row, err := DecodeRow(schema, data)
+		if err != nil {
+			return fmt.Errorf("row %q: %w", key, err)
+		}
+		if !rowMatches(row, equal) {
+			return nil
+		}
+		return fn(key, row)
+	}
+}
+
+// rowMatches reports whether a row has the given values, keyed by column
+// position
+func rowMatches(row Row, equal map[int]Value) bool {
+	for i, v := range equal {
+		if !equalValues(row[i], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectFrom runs a SELECT against the relation of the tables it reads,
+// and returns the names of the columns of its result and its rows
+func (e *Executor) selectFrom(ctx context.Context, r *relation, stmt *SelectStatement) ([]string, []Row, error) {
+	schema := r.schema
+
+	// Resolve the select list, expanding * to every column and t.* to
+	// those of table t
+	var items []SelectColumn
+	for _, col := range stmt.Columns {
+		if col.Expr == nil {
+			n := len(items)
+			for _, def := range schema.Columns {
+				if col.Table == "" || strings.EqualFold(def.table, col.Table) {
+					items = append(items, SelectColumn{Expr: &ColumnRef{Table: def.table, Name: def.Name}})
+				}
+			}
+			if len(items) == n {
+				return nil, nil, fmt.Errorf("unknown table: %s", col.Table)
+			}
+			continue
+		}
+		if err := checkColumnRefs(schema, col.Expr); err != nil {
+			return nil, nil, err
+		}
+		items = append(items, col)
+	}
+	orderBy, err := resolveOrderBy(schema, items, stmt.OrderBy)
+	if err != nil {
+		return nil, nil, err
+	}
+	limit, offset := int64(-1), int64(0)
+	if stmt.Limit != nil {
+		if limit, err = e.rowCount("LIMIT", stmt.Limit); err != nil {
+			return nil, nil, err
+		}
+	}
+	if stmt.Offset != nil {
+		if offset, err = e.rowCount("OFFSET", stmt.Offset); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Subqueries of the WHERE clause that do not depend on the row run now,
+	// so that their values can choose the rows to read
+	where, err := e.bindSubqueries(ctx, schema, stmt.Where)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exprs := make([]Expression, len(items))
+	for i, item := range items {
+		exprs[i] = item.Expr
+	}
+	var rows []Row
+	emit := func(schema *Schema, row Row) error {
+		c := &evalContext{ctx: ctx, exec: e, schema: schema, row: row}
+		out := make(Row, len(exprs))
+		for i, expr := range exprs {
+			var err error
+			if out[i], err = c.eval(expr); err != nil {
+				return err
+			}
+		}
+		rows = append(rows, out)
+		return nil
+	}
+
+	if aggregates(stmt, exprs, orderBy) {
+		// The select list is evaluated on the rows of groups instead
+		var groupSchema *Schema
+		var groups []Row
+		groupSchema, exprs, groups, err = e.groupRows(ctx, r, stmt, where, items, orderBy, limit, offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, row := range groups {
+			if err = emit(groupSchema, row); err != nil {
+				break
+			}
+		}
+	} else {
+		err = e.orderedRows(ctx, r, where, orderBy, limit, offset, func(row Row) error {
+			return emit(schema, row)
+		})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return selectColumnNames(items), rows, nil
+}
+
+// selectColumnNames returns the names of the columns of a SELECT's result.
+// Columns of different tables that share a name are told apart by their
+// table, as in u.id and o.id.
+func selectColumnNames(items []SelectColumn) []string {
+	names := make([]string, len(items))
+	uses := make(map[string]int)
+	for i, item := range items {
+		names[i] = selectColumnName(item)
+		uses[names[i]]++
+	}
+	for i, item := range items {
+		if ref, ok := item.Expr.(*ColumnRef); ok && item.Alias == "" && ref.Table != "" && uses[names[i]] > 1 {
+			names[i] = ref.String()
+		}
+	}
+	return names
+}
+
+// selectColumnName returns the name a SELECT list entry has in the result:
+// its alias, the column it reads, or else the text of its expression
+func selectColumnName(col SelectColumn) string {
+	if col.Alias != "" {
+		return col.Alias
+	}
+	if ref, ok := col.Expr.(*ColumnRef); ok {
+		return ref.Name
+	}
+	name := col.Expr.String()
+	if _, ok := col.Expr.(*BinaryExpression); ok {
+		name = name[1 : len(name)-1]
+	}
+	return name
+}
+
+// insertRows executes an INSERT of rows of values into a typed table. The
+// rows are written in one batch, so either all of them are inserted or,
+// when one fails, none are.
+func (e *Executor) insertRows(ctx context.Context, table Table, schema *Schema, tableName string, columns []string, rows [][]Expression) *QueryResult {
+	w := e.newWriteSet(ctx)
+	t := w.addTable(tableName, table, schema)
+	for i, values := range rows {
+		row, err := e.buildRow(ctx, schema, columns, values)
+		if err == nil {
+			err = w.insert(t, row)
+		}
+		if err != nil {
+			if len(rows) > 1 {
+				err = fmt.Errorf("row %d: %w", i+1, err)
+			}
+			return &QueryResult{Success: false, Error: err}
+		}
+	}
+	if err := w.commit(); err != nil {
+		return &QueryResult{Success: false, Error: err}
 	}
 
 	return &QueryResult{
-		Success: true,
-		Message: fmt.Sprintf("Deleted %d rows from %s", deletedRows, stmt.TableName),
+		Success:      true,
+		Message:      insertedMessage(len(rows), tableName),
+		RowsAffected: int64(len(rows)),
 	}
 }
 
-// matchesColumns checks if the returned data matches the requested columns
-func (e *Executor) matchesColumns(requestedColumns []string, keyColumn, keyValue, storedValue string) bool {
-	if len(requestedColumns) == 1 && requestedColumns[0] == "*" {
-		return true
+// updateRow executes an UPDATE of the rows of a typed table that satisfy
+// its WHERE clause, or of every row without one
+func (e *Executor) updateRow(ctx context.Context, table Table, schema *Schema, stmt *UpdateStatement) *QueryResult {
+	for _, a := range stmt.Set {
+		if schema.ColumnIndex(a.Column) < 0 {
+			return &QueryResult{Success: false, Error: fmt.Errorf("unknown column: %s", a.Column)}
+		}
+		if err := checkColumnRefs(schema, a.Value); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+	}
+
+	keys, err := e.matchingKeys(ctx, table, schema, stmt.Where)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
 	}
-	
-	// Simplified column matching
-	for _, col := range requestedColumns {
-		if col == keyColumn {
-			return true
+
+	// Work out every new row from the rows as they were before the
+	// statement, then replace them all, with their index entries, as one
+	// set, along with the rows their foreign key actions reach
+	w := e.newWriteSet(ctx)
+	t := w.addTable(stmt.TableName, table, schema)
+	changes := make([]rowChange, 0, len(keys))
+	for _, key := range keys {
+		old, err := w.get(t, key)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		if old == nil {
+			continue // deleted since the scan
 		}
+
+		// Every SET expression sees the old row
+		c := &evalContext{ctx: ctx, exec: e, schema: schema, row: old}
+		row := append(Row(nil), old...)
+		for _, a := range stmt.Set {
+			i := schema.ColumnIndex(a.Column)
+			if row[i], err = e.columnValue(c, schema, i, a.Value); err != nil {
+				return &QueryResult{Success: false, Error: err}
+			}
+		}
+		changes = append(changes, rowChange{key: key, old: old, row: row})
+	}
+	if err := w.updateAll(t, changes); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	if err := w.commit(); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	return &QueryResult{
+		Success:      true,
+		Message:      fmt.Sprintf("Updated %d rows in %s", len(changes), stmt.TableName),
+		RowsAffected: int64(len(changes)),
 	}
-	return false
+}
+
+// deleteRow executes a DELETE of the rows of a typed table that satisfy its
+// WHERE clause, or of every row without one
+func (e *Executor) deleteRow(ctx context.Context, table Table, schema *Schema, stmt *DeleteStatement) *QueryResult {
+	if stmt.Where == nil {
+		deletedRows, ok, err := e.truncateTable(ctx, table, schema, stmt.TableName)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		if ok {
+			return &QueryResult{
+				Success:      true,
+				Message:      fmt.Sprintf("Deleted %d rows from %s", deletedRows, stmt.TableName),
+				RowsAffected: deletedRows,
+			}
+		}
+	}
+
+	keys, err := e.matchingKeys(ctx, table, schema, stmt.Where)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	w := e.newWriteSet(ctx)
+	t := w.addTable(stmt.TableName, table, schema)
+	var deletedRows int64
+	for _, key := range keys {
+		row, err := w.get(t, key)
+		if err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		if row == nil {
+			continue // deleted by an earlier row's foreign key actions
+		}
+		if err := w.delete(t, key, row); err != nil {
+			return &QueryResult{Success: false, Error: err}
+		}
+		deletedRows++
+	}
+	if err := w.commit(); err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+
+	return &QueryResult{
+		Success:      true,
+		Message:      fmt.Sprintf("Deleted %d rows from %s", deletedRows, stmt.TableName),
+		RowsAffected: deletedRows,
+	}
+}
+
+// truncateTable runs a DELETE without a WHERE clause by emptying the table,
+// and the indexes of its schema, in one batch instead of row by row. It
+// returns the number of rows removed. When a foreign key of another table
+// refers to the table it does nothing and reports false, since the key's
+// actions and checks need each row deleted in turn.
+func (e *Executor) truncateTable(ctx context.Context, table Table, schema *Schema, tableName string) (int64, bool, error) {
+	refs, err := e.referencesTo(tableName)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, ref := range refs {
+		if ref.table != tableName {
+			return 0, false, nil
+		}
+	}
+
+	n, counted, err := e.tableRowCount(table)
+	if err != nil {
+		return 0, false, err
+	}
+	if !counted {
+		err := visitKeys(ctx, scanTable(ctx, table, []byte("")), func(key, data []byte) (bool, error) {
+			n++
+			return true, nil
+		})
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	batch := e.newBatch()
+	batch.Truncate(tableName)
+	if schema != nil {
+		for _, idx := range schema.Indexes {
+			batch.Truncate(idx.Name)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return 0, false, err
+	}
+	return n, true, nil
+}
+
+// matchingKeys returns the keys of the rows that satisfy a WHERE clause. It
+// finds them all before a statement changes any, so its writes cannot
+// disturb the scan.
+func (e *Executor) matchingKeys(ctx context.Context, table Table, schema *Schema, where Expression) ([][]byte, error) {
+	where, err := e.bindSubqueries(ctx, schema, where)
+	if err != nil {
+		return nil, err
+	}
+	var keys [][]byte
+	err = e.whereRows(ctx, table, schema, where, func(key []byte, row Row) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// keyValueSchema returns the schema queries see a key/value table through:
+// a key and the value stored under it, both read as text
+func keyValueSchema() *Schema {
+	return &Schema{
+		Version: 1,
+		Columns: []Column{
+			{ID: 1, Name: "key", Type: TypeText, NotNull: true},
+			{ID: 2, Name: "value", Type: TypeText},
+		},
+		PrimaryKey:   []string{"key"},
+		NextColumnID: 3,
+		keyValue:     true,
+	}
+}
+
+// keyValueText evaluates a value written to a key/value table, which stores
+// text
+func keyValueText(c *evalContext, expr Expression) (string, error) {
+	v, err := c.eval(expr)
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", fmt.Errorf("key/value tables cannot store NULL")
+	}
+	return FormatValue(v), nil
 }
 
 // ExecuteSQL is a convenience function that parses and executes a SQL string
 func ExecuteSQL(db Database, sql string) *QueryResult {
-	stmt, err := ParseSQL(sql)
+	return ExecuteSQLContext(context.Background(), db, sql)
+}
+
+// ExecuteSQLContext parses and executes a SQL string, stopping early if the
+// context is canceled or times out. SQL text run before is not parsed
+// again. A statement with parameters has to be run through Prepare instead.
+func ExecuteSQLContext(ctx context.Context, db Database, sql string) *QueryResult {
+	parsed, err := parseCached(sql)
 	if err != nil {
 		return &QueryResult{Success: false, Error: fmt.Errorf("parse error: %w", err)}
 	}
-	
+	if parsed.params > 0 {
+		return &QueryResult{
+			Success: false,
+			Error:   fmt.Errorf("statement takes %d parameters; run it through Prepare", parsed.params),
+		}
+	}
+
 	executor := NewExecutor(db)
-	return executor.Execute(stmt)
-}
\ No newline at end of file
+	return executor.ExecuteContext(ctx, parsed.stmt)
+}
@@ -1,21 +1,29 @@
 package query
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Mock implementations for testing
 
 type MockDatabase struct {
-	tables map[string]*MockTable
+	tables    map[string]*MockTable
+	sequences map[string][2]int64 // next value and increment
 }
 
 func NewMockDatabase() *MockDatabase {
 	return &MockDatabase{
-		tables: make(map[string]*MockTable),
+		tables:    make(map[string]*MockTable),
+		sequences: make(map[string][2]int64),
 	}
 }
 
@@ -39,9 +47,144 @@ func (m *MockDatabase) CreateTable(tableName string) (Table, error) {
 	return table, nil
 }
 
+func (m *MockDatabase) CreateTableWithSchema(tableName string, schema []byte) (Table, error) {
+	table, err := m.CreateTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	table.(*MockTable).schema = schema
+	return table, nil
+}
+
+func (m *MockDatabase) DropTable(tableName string) error {
+	if _, exists := m.tables[tableName]; !exists {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	delete(m.tables, tableName)
+	return nil
+}
+
+func (m *MockDatabase) ListTables() []string {
+	names := make([]string, 0, len(m.tables))
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m *MockDatabase) SetTableSchema(tableName string, schema []byte) error {
+	table, exists := m.tables[tableName]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	table.schema = schema
+	return nil
+}
+
+func (m *MockDatabase) RenameTable(oldName, newName string) error {
+	table, exists := m.tables[oldName]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", oldName)
+	}
+	if _, exists := m.tables[newName]; exists {
+		return fmt.Errorf("table %s already exists", newName)
+	}
+	delete(m.tables, oldName)
+	table.name = newName
+	m.tables[newName] = table
+	return nil
+}
+
+func (m *MockDatabase) CreateSequence(name string, start, increment int64) error {
+	if _, exists := m.sequences[name]; exists {
+		return fmt.Errorf("%w: %s", ErrSequenceExists, name)
+	}
+	m.sequences[name] = [2]int64{start, increment}
+	return nil
+}
+
+func (m *MockDatabase) DropSequence(name string) error {
+	if _, exists := m.sequences[name]; !exists {
+		return fmt.Errorf("%w: %s", ErrSequenceNotFound, name)
+	}
+	delete(m.sequences, name)
+	return nil
+}
+
+func (m *MockDatabase) NextVal(name string) (int64, error) {
+	seq, exists := m.sequences[name]
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrSequenceNotFound, name)
+	}
+	m.sequences[name] = [2]int64{seq[0] + seq[1], seq[1]}
+	return seq[0], nil
+}
+
+func (m *MockDatabase) AdvanceSequence(name string, value int64) error {
+	seq, exists := m.sequences[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrSequenceNotFound, name)
+	}
+	if value >= seq[0] {
+		m.sequences[name] = [2]int64{value + seq[1], seq[1]}
+	}
+	return nil
+}
+
+// NewBatch returns a batch that commits all or nothing, as a real
+// database's does: it applies the operations as the fallback batch does and
+// puts the database back as it was if one of them fails
+func (m *MockDatabase) NewBatch() Batch {
+	return &mockBatch{tableBatch: tableBatch{db: m}, db: m}
+}
+
+type mockBatch struct {
+	tableBatch
+	db *MockDatabase
+}
+
+func (b *mockBatch) Commit() error {
+	saved := b.db.save()
+	if err := b.tableBatch.Commit(); err != nil {
+		b.db.restore(saved)
+		return err
+	}
+	return nil
+}
+
+// mockState is a copy of the contents of a MockDatabase
+type mockState struct {
+	tables    map[string]*MockTable
+	contents  map[*MockTable]MockTable
+	sequences map[string][2]int64
+}
+
+func (m *MockDatabase) save() mockState {
+	s := mockState{
+		tables:    maps.Clone(m.tables),
+		contents:  make(map[*MockTable]MockTable),
+		sequences: maps.Clone(m.sequences),
+	}
+	for _, table := range m.tables {
+		s.contents[table] = MockTable{name: table.name, data: maps.Clone(table.data), schema: table.schema}
+	}
+	return s
+}
+
+// restore puts back saved contents, in the same MockTables, so tables
+// looked up before the save see them too
+func (m *MockDatabase) restore(s mockState) {
+	m.tables = s.tables
+	m.sequences = s.sequences
+	for table, contents := range s.contents {
+		*table = contents
+	}
+}
+
 type MockTable struct {
-	name string
-	data map[string]string
+	name   string
+	data   map[string]string
+	schema []byte
 }
 
 func (m *MockTable) Insert(key, value []byte) error {
@@ -74,20 +217,37 @@ func (m *MockTable) Delete(key []byte) error {
 
 func (m *MockTable) Scan(startKey []byte) Iterator {
 	return &MockIterator{
-		data:    m.data,
-		started: false,
+		data:     m.data,
+		started:  false,
+		startKey: string(startKey),
 	}
 }
 
+func (m *MockTable) ScanReverse(endKey []byte) Iterator {
+	var keys []string
+	for k := range m.data {
+		if endKey == nil || k < string(endKey) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	return &MockIterator{data: m.data, started: true, keys: keys}
+}
+
 func (m *MockTable) Name() string {
 	return m.name
 }
 
+func (m *MockTable) Schema() []byte {
+	return m.schema
+}
+
 type MockIterator struct {
-	data    map[string]string
-	started bool
-	keys    []string
-	index   int
+	data     map[string]string
+	started  bool
+	startKey string
+	keys     []string
+	index    int
 }
 
 func (m *MockIterator) Next() (key, val []byte) {
@@ -95,8 +255,11 @@ func (m *MockIterator) Next() (key, val []byte) {
 		m.started = true
 		m.keys = make([]string, 0, len(m.data))
 		for k := range m.data {
-			m.keys = append(m.keys, k)
+			if k > m.startKey {
+				m.keys = append(m.keys, k)
+			}
 		}
+		sort.Strings(m.keys) // keys larger than the start key, in order
 		m.index = 0
 	}
 	
@@ -113,7 +276,12 @@ func (m *MockIterator) Next() (key, val []byte) {
 
 func (m *MockIterator) ContainsNext() bool {
 	if !m.started {
-		return len(m.data) > 0
+		for k := range m.data {
+			if k > m.startKey {
+				return true
+			}
+		}
+		return false
 	}
 	return m.index < len(m.keys)
 }
@@ -138,6 +306,134 @@ func TestExecutorInsert(t *testing.T) {
 	assert.Equal(t, "john@example.com", string(value))
 }
 
+func TestExecutorInsertRows(t *testing.T) {
+	db := NewMockDatabase()
+	for _, sql := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, role TEXT DEFAULT 'member')",
+		"CREATE TABLE archive (id INTEGER PRIMARY KEY, name TEXT, note TEXT)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	query := func(sql string) []map[string]string {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result.Rows
+	}
+	
+	// Every row is inserted, with values going to the columns listed and
+	// the others taking their defaults
+	result := ExecuteSQL(db, "INSERT INTO users (name, id) VALUES ('al', 1), ('bo', 2), ('cy', 3)")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "Inserted 3 rows into users", result.Message)
+	assert.Equal(t, []map[string]string{
+		{"id": "1", "name": "al", "role": "member"},
+		{"id": "2", "name": "bo", "role": "member"},
+		{"id": "3", "name": "cy", "role": "member"},
+	}, query("SELECT * FROM users"))
+	
+	// A failing row inserts none of them, even one that came before it
+	for _, sql := range []string{
+		"INSERT INTO users VALUES (4, 'di', 'admin'), (5, NULL, 'admin')",
+		"INSERT INTO users VALUES (4, 'di', 'admin'), (4, 'ed', 'admin')",
+		"INSERT INTO users VALUES (4, 'di', 'admin'), (1, 'ed', 'admin')",
+		"INSERT INTO users (id, name) VALUES (4, 'di'), (5)",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+	assert.Len(t, query("SELECT * FROM users"), 3)
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (4, 'di'), (5, NULL)")
+	require.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "row 2")
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (4, 'di'), (4, 'ed')")
+	require.False(t, result.Success)
+	var constraintErr *ConstraintError
+	assert.ErrorAs(t, result.Error, &constraintErr)
+	
+	// INSERT ... SELECT reads every row before writing, so it can read the
+	// table it inserts into
+	result = ExecuteSQL(db, "INSERT INTO archive (note, id, name) SELECT role, id * 10, name FROM users WHERE id > 1")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "Inserted 2 rows into archive", result.Message)
+	assert.Equal(t, []map[string]string{
+		{"id": "20", "name": "bo", "note": "member"},
+		{"id": "30", "name": "cy", "note": "member"},
+	}, query("SELECT * FROM archive"))
+	result = ExecuteSQL(db, "INSERT INTO users SELECT id + 3, name || '2', 'copy' FROM users")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "Inserted 3 rows into users", result.Message)
+	assert.Equal(t, []map[string]string{{"n": "6"}}, query("SELECT count(*) AS n FROM users"))
+	result = ExecuteSQL(db, "INSERT INTO archive SELECT id, name, role FROM users WHERE id > 100")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "Inserted 0 rows into archive", result.Message)
+	assert.False(t, ExecuteSQL(db, "INSERT INTO archive (id) SELECT id, name FROM users").Success)
+	result = ExecuteSQL(db, "INSERT INTO archive (id, name, ID) VALUES (1, 'x', 2)")
+	assert.EqualError(t, result.Error, "column ID is listed more than once")
+	assert.False(t, ExecuteSQL(db, "INSERT INTO archive SELECT id * 10, name, role FROM users").Success)
+	assert.Len(t, query("SELECT * FROM archive"), 2)
+	
+	// Key/value tables take several rows too, and a column list
+	_, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	result = ExecuteSQL(db, "INSERT INTO kv VALUES ('a', '1'), ('b', '2'), ('c')")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "Inserted 3 rows into kv", result.Message)
+	result = ExecuteSQL(db, "INSERT INTO kv (value, key) VALUES ('4', 'd')")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{
+		{"key": "a", "value": "1"},
+		{"key": "b", "value": "2"},
+		{"key": "c", "value": "c"},
+		{"key": "d", "value": "4"},
+	}, query("SELECT * FROM kv"))
+	assert.False(t, ExecuteSQL(db, "INSERT INTO kv (key) VALUES ('e')").Success)
+	assert.False(t, ExecuteSQL(db, "INSERT INTO kv (key, key) VALUES ('e', 'f')").Success)
+	assert.False(t, ExecuteSQL(db, "INSERT INTO kv VALUES ('e', '5'), ('f', '6', '7')").Success)
+	assert.Len(t, query("SELECT * FROM kv"), 4)
+}
+
+// plainDatabase is a MockDatabase without NewBatch, so statements run on it
+// write through the fallback tableBatch
+type plainDatabase struct {
+	*MockDatabase
+	NewBatch struct{} // hides MockDatabase.NewBatch
+}
+
+func TestExecutorAtomicStatements(t *testing.T) {
+	db := NewMockDatabase()
+	for _, sql := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+		"INSERT INTO users VALUES (1, 'a'), (2, 'b')",
+		"CREATE SEQUENCE items_id_seq",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	before := db.save()
+	
+	// The commit creates the table before it finds that the sequence the
+	// table would own already exists, and then takes the table back out
+	result := ExecuteSQL(db, "CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT)")
+	assert.EqualError(t, result.Error, "sequence already exists: items_id_seq")
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (3, 'c'), (4, 'a')")
+	assert.False(t, result.Success)
+	assert.Equal(t, before, db.save())
+	
+	// The fallback batch is not atomic, but it checks every constraint
+	// before it writes anything
+	plain := &plainDatabase{MockDatabase: db}
+	_, ok := Database(plain).(BatchDatabase)
+	require.False(t, ok)
+	result = ExecuteSQL(plain, "INSERT INTO users VALUES (3, 'c'), (4, 'a')")
+	assert.False(t, result.Success)
+	assert.Equal(t, before, db.save())
+	result = ExecuteSQL(plain, "INSERT INTO users VALUES (3, 'c'), (4, 'd')")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, int64(2), ExecuteSQL(plain, "DELETE FROM users WHERE id > 2").RowsAffected)
+	assert.Equal(t, before, db.save())
+}
+
 func TestExecutorSelect(t *testing.T) {
 	db := NewMockDatabase()
 	table, err := db.CreateTable("users")
@@ -150,7 +446,7 @@ func TestExecutorSelect(t *testing.T) {
 	assert.NoError(t, err)
 	
 	// Test SELECT with WHERE clause
-	result := ExecuteSQL(db, "SELECT * FROM users WHERE id = 'john'")
+	result := ExecuteSQL(db, "SELECT * FROM users WHERE key = 'john'")
 	assert.True(t, result.Success)
 	assert.NoError(t, result.Error)
 	assert.Len(t, result.Rows, 1)
@@ -164,23 +460,37 @@ func TestExecutorSelect(t *testing.T) {
 
 func TestExecutorUpdate(t *testing.T) {
 	db := NewMockDatabase()
-	table, err := db.CreateTable("users")
-	assert.NoError(t, err)
+	result := ExecuteSQL(db, "CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT)")
+	assert.True(t, result.Success)
 	
 	// Insert test data
-	err = table.Insert([]byte("john"), []byte("john@example.com"))
-	assert.NoError(t, err)
+	result = ExecuteSQL(db, "INSERT INTO users VALUES ('john', 'john@example.com')")
+	assert.True(t, result.Success)
 	
 	// Test UPDATE
-	result := ExecuteSQL(db, "UPDATE users SET email = 'newemail@example.com' WHERE id = 'john'")
+	result = ExecuteSQL(db, "UPDATE users SET email = 'newemail@example.com' WHERE id = 'john'")
 	assert.True(t, result.Success)
 	assert.NoError(t, result.Error)
 	assert.Contains(t, result.Message, "Updated 1 rows")
 	
 	// Verify the data was updated
-	value, found := table.Select([]byte("john"))
+	result = ExecuteSQL(db, "SELECT email FROM users WHERE id = 'john'")
+	assert.True(t, result.Success)
+	assert.Equal(t, []map[string]string{{"email": "newemail@example.com"}}, result.Rows)
+	
+	// Key/value tables only have a value to update
+	kv, err := db.CreateTable("kv")
+	assert.NoError(t, err)
+	assert.NoError(t, kv.Insert([]byte("k"), []byte("old")))
+	
+	result = ExecuteSQL(db, "UPDATE kv SET value = 'new' WHERE key = 'k'")
+	assert.True(t, result.Success)
+	value, found := kv.Select([]byte("k"))
 	assert.True(t, found)
-	assert.Contains(t, string(value), "newemail@example.com")
+	assert.Equal(t, "new", string(value))
+	
+	result = ExecuteSQL(db, "UPDATE kv SET email = 'new' WHERE key = 'k'")
+	assert.False(t, result.Success)
 }
 
 func TestExecutorDelete(t *testing.T) {
@@ -193,7 +503,7 @@ func TestExecutorDelete(t *testing.T) {
 	assert.NoError(t, err)
 	
 	// Test DELETE
-	result := ExecuteSQL(db, "DELETE FROM users WHERE id = 'john'")
+	result := ExecuteSQL(db, "DELETE FROM users WHERE key = 'john'")
 	assert.True(t, result.Success)
 	assert.NoError(t, result.Error)
 	assert.Contains(t, result.Message, "Deleted 1 rows")
@@ -203,6 +513,80 @@ func TestExecutorDelete(t *testing.T) {
 	assert.False(t, found)
 }
 
+func TestExecutorSetBasedWrites(t *testing.T) {
+	db := NewMockDatabase()
+	exec := func(sql string) *QueryResult {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result
+	}
+	ids := func(sql string) []string {
+		ids := []string{}
+		for _, row := range exec(sql).Rows {
+			ids = append(ids, row["id"])
+		}
+		return ids
+	}
+	
+	exec("CREATE TABLE users (id INTEGER PRIMARY KEY, seat INTEGER UNIQUE, age INTEGER)")
+	result := exec("INSERT INTO users VALUES (1, 1, 20), (2, 2, 30), (3, 3, 40)")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	
+	// UPDATE without WHERE changes every row
+	result = exec("UPDATE users SET age = age + 1")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	assert.Equal(t, "Updated 3 rows in users", result.Message)
+	
+	// Rows may take the keys and unique values others of the set give up
+	result = exec("UPDATE users SET id = id + 1")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	assert.Equal(t, []string{"2", "3", "4"}, ids("SELECT id FROM users"))
+	exec("UPDATE users SET seat = seat + 1")
+	assert.Equal(t, []string{"3"}, ids("SELECT id FROM users WHERE seat = 3"))
+	
+	// A collision the set does not resolve still fails, changing nothing
+	result = ExecuteSQL(db, "UPDATE users SET id = 4 WHERE id < 4")
+	require.False(t, result.Success)
+	assert.Equal(t, []string{"2", "3", "4"}, ids("SELECT id FROM users"))
+	
+	result = exec("UPDATE users SET age = 0 WHERE age > 100")
+	assert.Equal(t, int64(0), result.RowsAffected)
+	assert.Equal(t, "Updated 0 rows in users", result.Message)
+	result = exec("DELETE FROM users WHERE id = 3")
+	assert.Equal(t, int64(1), result.RowsAffected)
+	
+	// DELETE without WHERE empties the table and its indexes
+	result = exec("DELETE FROM users")
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Equal(t, "Deleted 2 rows from users", result.Message)
+	assert.Equal(t, []string{}, ids("SELECT id FROM users"))
+	exec("INSERT INTO users VALUES (1, 2, 20)")
+	assert.Equal(t, []string{"1"}, ids("SELECT id FROM users WHERE seat = 2"))
+	
+	// A table other tables refer to is emptied row by row, so the foreign
+	// key actions run
+	exec("CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id) ON DELETE CASCADE)")
+	exec("INSERT INTO users VALUES (2, 3, 30)")
+	exec("INSERT INTO orders VALUES (10, 1), (11, 2), (12, 2)")
+	result = exec("DELETE FROM users")
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Equal(t, []string{}, ids("SELECT id FROM orders"))
+	
+	// Key/value tables too
+	kv, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, kv.Insert([]byte(k), []byte("v"+k)))
+	}
+	result = exec("UPDATE kv SET value = value || '!'")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	value, _ := kv.Select([]byte("b"))
+	assert.Equal(t, "vb!", string(value))
+	result = exec("DELETE FROM kv")
+	assert.Equal(t, int64(3), result.RowsAffected)
+	assert.Empty(t, exec("SELECT * FROM kv").Rows)
+}
+
 func TestExecutorErrors(t *testing.T) {
 	db := NewMockDatabase()
 	
@@ -217,4 +601,1460 @@ func TestExecutorErrors(t *testing.T) {
 	assert.False(t, result.Success)
 	assert.Error(t, result.Error)
 	assert.Contains(t, result.Error.Error(), "parse error")
-}
\ No newline at end of file
+}
+
+func TestExecutorTypedRows(t *testing.T) {
+	db := NewMockDatabase()
+	result := ExecuteSQL(db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, score REAL, active BOOLEAN)")
+	assert.True(t, result.Success)
+	
+	// Values are converted to the column types; missing values are NULL
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (1, 'a|b:c', '2.5', 'true')")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO users (name, id) VALUES ('jane', '2')")
+	assert.True(t, result.Success, "%v", result.Error)
+	
+	result = ExecuteSQL(db, "SELECT * FROM users WHERE id = 1")
+	assert.True(t, result.Success)
+	assert.Equal(t, []string{"id", "name", "score", "active"}, result.Columns)
+	assert.Equal(t, []map[string]string{
+		{"id": "1", "name": "a|b:c", "score": "2.5", "active": "true"},
+	}, result.Rows)
+	
+	// Lookups on other columns scan the table
+	result = ExecuteSQL(db, "SELECT id, score FROM users WHERE name = 'jane'")
+	assert.True(t, result.Success)
+	assert.Equal(t, []string{"id", "score"}, result.Columns)
+	assert.Equal(t, []map[string]string{{"id": "2", "score": "NULL"}}, result.Rows)
+	
+	// Updating one column keeps the others
+	result = ExecuteSQL(db, "UPDATE users SET score = '9' WHERE id = '2'")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "SELECT * FROM users WHERE id = 2")
+	assert.Equal(t, []map[string]string{
+		{"id": "2", "name": "jane", "score": "9", "active": "NULL"},
+	}, result.Rows)
+	
+	// Nothing matched
+	result = ExecuteSQL(db, "UPDATE users SET score = '1' WHERE id = 3")
+	assert.True(t, result.Success)
+	assert.Contains(t, result.Message, "Updated 0 rows")
+	
+	// Bad values and missing NOT NULL columns are rejected
+	result = ExecuteSQL(db, "INSERT INTO users VALUES ('x', 'bob')")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "invalid INTEGER value")
+	result = ExecuteSQL(db, "INSERT INTO users (id) VALUES (3)")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "name cannot be NULL")
+	result = ExecuteSQL(db, "SELECT missing FROM users")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "unknown column")
+	
+	result = ExecuteSQL(db, "DELETE FROM users WHERE id = 1")
+	assert.True(t, result.Success)
+	assert.Contains(t, result.Message, "Deleted 1 rows")
+	result = ExecuteSQL(db, "SELECT * FROM users")
+	assert.Len(t, result.Rows, 1)
+}
+
+func TestExecutorDropTable(t *testing.T) {
+	db := NewMockDatabase()
+	_, err := db.CreateTable("users")
+	assert.NoError(t, err)
+	
+	result := ExecuteSQL(db, "DROP TABLE users")
+	assert.True(t, result.Success)
+	assert.Contains(t, result.Message, "Dropped table users")
+	_, err = db.GetTable("users")
+	assert.Error(t, err)
+	
+	// Dropping a missing table fails unless IF EXISTS is given
+	result = ExecuteSQL(db, "DROP TABLE users")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "does not exist")
+	
+	result = ExecuteSQL(db, "DROP TABLE IF EXISTS users")
+	assert.True(t, result.Success)
+	
+	result = ExecuteSQL(db, "DROP users")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "parse error")
+}
+
+func TestExecutorCreateTable(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, score REAL, avatar BLOB, active BOOLEAN)")
+	assert.True(t, result.Success)
+	assert.NoError(t, result.Error)
+	assert.Contains(t, result.Message, "Created table users")
+	
+	table, err := db.GetTable("users")
+	assert.NoError(t, err)
+	schema, err := DecodeSchema(table.(SchemaTable).Schema())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "name", "score", "avatar", "active"}, schema.ColumnNames())
+	assert.Equal(t, []string{"id"}, schema.PrimaryKey)
+	assert.Equal(t, TypeInteger, schema.Column("id").Type)
+	assert.True(t, schema.Column("id").NotNull, "primary key columns are NOT NULL")
+	assert.True(t, schema.Column("name").NotNull)
+	assert.Equal(t, TypeBoolean, schema.Column("active").Type)
+	
+	// Creating it again fails unless IF NOT EXISTS is given
+	result = ExecuteSQL(db, "CREATE TABLE users (id INTEGER PRIMARY KEY)")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "already exists")
+	
+	result = ExecuteSQL(db, "CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY)")
+	assert.True(t, result.Success)
+	
+	// Without a column list the table is a plain key/value table
+	result = ExecuteSQL(db, "CREATE TABLE kv")
+	assert.True(t, result.Success)
+	table, err = db.GetTable("kv")
+	assert.NoError(t, err)
+	assert.Nil(t, table.(SchemaTable).Schema())
+	
+	// Invalid definitions are rejected
+	result = ExecuteSQL(db, "CREATE TABLE bad (a INTEGER, a TEXT)")
+	assert.Contains(t, result.Error.Error(), "duplicate column")
+	result = ExecuteSQL(db, "CREATE TABLE bad (a INTEGER PRIMARY KEY, b TEXT PRIMARY KEY)")
+	assert.Contains(t, result.Error.Error(), "more than one primary key")
+	result = ExecuteSQL(db, "CREATE TABLE bad (a INTEGER, b TEXT)")
+	assert.EqualError(t, result.Error, "a table needs a PRIMARY KEY")
+	_, err = db.GetTable("bad")
+	assert.Error(t, err)
+	
+	// Databases that cannot store schemas only get key/value tables
+	slow := &slowDatabase{}
+	result = ExecuteSQL(slow, "CREATE TABLE users (id INTEGER)")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "does not support typed tables")
+}
+
+func TestExecutorPrimaryKey(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE orders (region TEXT, id INTEGER, total REAL, PRIMARY KEY (region, id))")
+	assert.True(t, result.Success, "%v", result.Error)
+	
+	result = ExecuteSQL(db, "INSERT INTO orders VALUES ('eu', 1, 9.5)")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO orders VALUES ('us', 1, 20)")
+	assert.True(t, result.Success, "%v", result.Error)
+	
+	// The same key twice is rejected and the first row is kept
+	result = ExecuteSQL(db, "INSERT INTO orders VALUES ('eu', 1, 0)")
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Error, ErrDuplicateKey)
+	result = ExecuteSQL(db, "INSERT INTO orders (id, total) VALUES (2, 1)")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "region cannot be NULL")
+	
+	// A lookup needs every key column
+	result = ExecuteSQL(db, "SELECT total FROM orders WHERE id = 1 AND region = 'eu'")
+	assert.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"total": "9.5"}}, result.Rows)
+	
+	// Any WHERE clause picks the rows to change
+	result = ExecuteSQL(db, "UPDATE orders SET total = 1 WHERE region = 'eu'")
+	assert.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Updated 1 rows")
+	
+	// Moving a row onto an existing key fails without losing either row
+	result = ExecuteSQL(db, "UPDATE orders SET region = 'us' WHERE region = 'eu' AND id = 1")
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Error, ErrDuplicateKey)
+	result = ExecuteSQL(db, "SELECT * FROM orders")
+	assert.Len(t, result.Rows, 2)
+	
+	result = ExecuteSQL(db, "DELETE FROM orders WHERE region = 'us' AND id = 1")
+	assert.True(t, result.Success)
+	assert.Contains(t, result.Message, "Deleted 1 rows")
+	
+	// Key columns cannot be dropped
+	result = ExecuteSQL(db, "ALTER TABLE orders DROP COLUMN id")
+	assert.Contains(t, result.Error.Error(), "cannot drop key column")
+	
+	result = ExecuteSQL(db, "CREATE TABLE bad (a INTEGER, PRIMARY KEY (b))")
+	assert.Contains(t, result.Error.Error(), "unknown primary key column")
+	result = ExecuteSQL(db, "CREATE TABLE bad (a INTEGER PRIMARY KEY, PRIMARY KEY (a))")
+	assert.Contains(t, result.Error.Error(), "more than one primary key")
+}
+
+func TestExecutorIndexes(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, city TEXT, age INTEGER)")
+	assert.True(t, result.Success)
+	for _, sql := range []string{
+		"INSERT INTO users VALUES (1, 'a@x', 'paris', 30)",
+		"INSERT INTO users VALUES (2, 'b@x', 'oslo', 30)",
+		"INSERT INTO users VALUES (3, 'c@x', 'paris', 41)",
+	} {
+		result = ExecuteSQL(db, sql)
+		assert.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	
+	// Existing rows are indexed when the index is created
+	result = ExecuteSQL(db, "CREATE INDEX by_city ON users (city, age)")
+	assert.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Created index by_city on users")
+	assert.Len(t, db.tables["by_city"].data, 3)
+	
+	result = ExecuteSQL(db, "SELECT id FROM users WHERE city = 'paris'")
+	assert.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"id": "1"}, {"id": "3"}}, result.Rows)
+	result = ExecuteSQL(db, "SELECT id FROM users WHERE age = 41 AND city = 'paris'")
+	assert.Equal(t, []map[string]string{{"id": "3"}}, result.Rows)
+	
+	// Writes keep the index up to date
+	result = ExecuteSQL(db, "UPDATE users SET city = 'oslo' WHERE id = 1")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "DELETE FROM users WHERE id = 2")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO users (id, email) VALUES (4, 'd@x')")
+	assert.True(t, result.Success, "%v", result.Error)
+	assert.Len(t, db.tables["by_city"].data, 3)
+	result = ExecuteSQL(db, "SELECT id FROM users WHERE city = 'oslo'")
+	assert.Equal(t, []map[string]string{{"id": "1"}}, result.Rows)
+	
+	// Lookups go through the index: an entry removed behind the executor's
+	// back hides its row
+	for k := range db.tables["by_city"].data {
+		delete(db.tables["by_city"].data, k)
+	}
+	result = ExecuteSQL(db, "SELECT id FROM users WHERE city = 'paris'")
+	assert.Empty(t, result.Rows)
+	result = ExecuteSQL(db, "SELECT id FROM users WHERE email = 'c@x'")
+	assert.Equal(t, []map[string]string{{"id": "3"}}, result.Rows, "unindexed columns are scanned")
+	
+	result = ExecuteSQL(db, "DROP INDEX by_city")
+	assert.True(t, result.Success, "%v", result.Error)
+	_, err := db.GetTable("by_city")
+	assert.Error(t, err)
+	result = ExecuteSQL(db, "SELECT id FROM users WHERE city = 'paris'")
+	assert.Equal(t, []map[string]string{{"id": "3"}}, result.Rows)
+	result = ExecuteSQL(db, "DROP INDEX by_city")
+	assert.Contains(t, result.Error.Error(), "does not exist")
+	result = ExecuteSQL(db, "DROP INDEX IF EXISTS by_city")
+	assert.True(t, result.Success)
+	
+	// Index names cannot clash with tables, and index tables are not tables
+	result = ExecuteSQL(db, "CREATE INDEX users ON users (city)")
+	assert.Contains(t, result.Error.Error(), "already exists")
+	result = ExecuteSQL(db, "CREATE INDEX by_email ON users (email)")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "CREATE INDEX IF NOT EXISTS by_email ON users (email)")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "SELECT * FROM by_email")
+	assert.Contains(t, result.Error.Error(), "by_email is an index")
+	
+	// Dropping the table drops its indexes
+	result = ExecuteSQL(db, "DROP TABLE users")
+	assert.True(t, result.Success, "%v", result.Error)
+	assert.Empty(t, db.tables)
+}
+
+func TestExecutorUniqueIndex(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (1, 'a@x')")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (2, 'a@x')")
+	assert.True(t, result.Success)
+	
+	// Existing duplicates prevent the index, and nothing is created
+	result = ExecuteSQL(db, "CREATE UNIQUE INDEX by_email ON users (email)")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "duplicate value for unique index by_email")
+	_, err := db.GetTable("by_email")
+	assert.Error(t, err)
+	
+	result = ExecuteSQL(db, "UPDATE users SET email = 'b@x' WHERE id = 2")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "CREATE UNIQUE INDEX by_email ON users (email)")
+	assert.True(t, result.Success, "%v", result.Error)
+	
+	// A duplicate is rejected without writing the row
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (3, 'a@x')")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "duplicate value for unique index by_email (email)")
+	result = ExecuteSQL(db, "SELECT * FROM users WHERE id = 3")
+	assert.Empty(t, result.Rows)
+	result = ExecuteSQL(db, "UPDATE users SET email = 'a@x' WHERE id = 2")
+	assert.False(t, result.Success)
+	result = ExecuteSQL(db, "SELECT email FROM users WHERE id = 2")
+	assert.Equal(t, []map[string]string{{"email": "b@x"}}, result.Rows)
+	
+	// A row may keep its own value, and NULLs never conflict
+	result = ExecuteSQL(db, "UPDATE users SET email = 'a@x' WHERE id = 1")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO users (id) VALUES (4)")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO users (id) VALUES (5)")
+	assert.True(t, result.Success, "%v", result.Error)
+}
+
+func TestExecutorAlterTable(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (1, 'alice')")
+	assert.True(t, result.Success)
+	
+	// Rows written before a column was added read its default
+	result = ExecuteSQL(db, "ALTER TABLE users ADD COLUMN score INTEGER NOT NULL DEFAULT 10")
+	assert.True(t, result.Success)
+	assert.NoError(t, result.Error)
+	result = ExecuteSQL(db, "INSERT INTO users (id, name) VALUES (2, 'bob')")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "INSERT INTO users VALUES (3, 'carol', 7)")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "SELECT * FROM users")
+	assert.True(t, result.Success)
+	assert.ElementsMatch(t, []map[string]string{
+		{"id": "1", "name": "alice", "score": "10"},
+		{"id": "2", "name": "bob", "score": "10"},
+		{"id": "3", "name": "carol", "score": "7"},
+	}, result.Rows)
+	
+	result = ExecuteSQL(db, "ALTER TABLE users RENAME COLUMN name TO nick")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "SELECT nick FROM users WHERE id = 1")
+	assert.Equal(t, []map[string]string{{"nick": "alice"}}, result.Rows)
+	
+	result = ExecuteSQL(db, "ALTER TABLE users DROP COLUMN score")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "SELECT * FROM users WHERE id = 3")
+	assert.Equal(t, []map[string]string{{"id": "3", "nick": "carol"}}, result.Rows)
+	
+	// A column added again under an old name does not see the old values
+	result = ExecuteSQL(db, "ALTER TABLE users ADD score INTEGER")
+	assert.True(t, result.Success)
+	result = ExecuteSQL(db, "SELECT score FROM users WHERE id = 3")
+	assert.Equal(t, []map[string]string{{"score": "NULL"}}, result.Rows)
+	
+	result = ExecuteSQL(db, "ALTER TABLE users RENAME TO people")
+	assert.True(t, result.Success)
+	assert.Contains(t, result.Message, "Renamed table users to people")
+	result = ExecuteSQL(db, "SELECT * FROM people")
+	assert.Len(t, result.Rows, 3)
+	_, err := db.GetTable("users")
+	assert.Error(t, err)
+	
+	// Invalid changes leave the schema alone
+	for sql, msg := range map[string]string{
+		"ALTER TABLE people ADD COLUMN nick TEXT":               "duplicate column",
+		"ALTER TABLE people ADD COLUMN age INTEGER NOT NULL":    "needs a DEFAULT",
+		"ALTER TABLE people ADD COLUMN age INTEGER DEFAULT 'x'": "invalid INTEGER value",
+		"ALTER TABLE people DROP COLUMN id":                     "cannot drop key column",
+		"ALTER TABLE people DROP COLUMN missing":                "unknown column",
+		"ALTER TABLE people RENAME COLUMN nick TO id":           "duplicate column",
+		"ALTER TABLE missing RENAME TO other":                   "does not exist",
+	} {
+		result = ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+		assert.Contains(t, result.Error.Error(), msg, sql)
+	}
+	result = ExecuteSQL(db, "SELECT * FROM people WHERE id = 1")
+	assert.Equal(t, []map[string]string{{"id": "1", "nick": "alice", "score": "NULL"}}, result.Rows)
+}
+
+// cancelingIterator cancels its context after a number of rows, simulating
+// a user interrupting a long scan
+type cancelingIterator struct {
+	Iterator
+	cancel context.CancelFunc
+	after  int
+	seen   int
+}
+
+func (c *cancelingIterator) Next() (key, val []byte) {
+	c.seen++
+	if c.seen == c.after {
+		c.cancel()
+	}
+	return c.Iterator.Next()
+}
+
+type cancelingTable struct {
+	*MockTable
+	iter *cancelingIterator
+}
+
+func (c *cancelingTable) Scan(startKey []byte) Iterator {
+	c.iter.Iterator = c.MockTable.Scan(startKey)
+	return c.iter
+}
+
+type cancelingDatabase struct {
+	table Table
+}
+
+func (c *cancelingDatabase) GetTable(tableName string) (Table, error) {
+	return c.table, nil
+}
+
+func (c *cancelingDatabase) CreateTable(tableName string) (Table, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestExecutorCancelsScan(t *testing.T) {
+	mock := &MockTable{name: "big", data: make(map[string]string)}
+	for i := 0; i < 1000; i++ {
+		mock.data[fmt.Sprintf("key%04d", i)] = "value"
+	}
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter := &cancelingIterator{cancel: cancel, after: 10}
+	db := &cancelingDatabase{table: &cancelingTable{MockTable: mock, iter: iter}}
+	
+	stmt, err := ParseSQL("SELECT * FROM big")
+	assert.NoError(t, err)
+	
+	result := NewExecutor(db).ExecuteContext(ctx, stmt)
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Error, context.Canceled)
+	assert.Less(t, iter.seen, 1000, "scan should stop soon after cancellation")
+}
+
+// contextIterator ends quietly once its context is done, as a scan of a
+// disk table does between pages
+type contextIterator struct {
+	Iterator
+	ctx context.Context
+}
+
+func (c *contextIterator) ContainsNext() bool {
+	return c.ctx.Err() == nil && c.Iterator.ContainsNext()
+}
+
+type contextTable struct {
+	*cancelingTable
+}
+
+func (c *contextTable) ScanContext(ctx context.Context, startKey []byte) Iterator {
+	return &contextIterator{Iterator: c.Scan(startKey), ctx: ctx}
+}
+
+func (c *contextTable) ScanReverseContext(ctx context.Context, endKey []byte) Iterator {
+	return nil // not a ReverseScanner
+}
+
+func TestExecutorCancelsContextScan(t *testing.T) {
+	mock := &MockTable{name: "big", data: make(map[string]string)}
+	for i := 0; i < 1000; i++ {
+		mock.data[fmt.Sprintf("key%04d", i)] = "value"
+	}
+
+	// A scan that ends because the statement was canceled fails it rather
+	// than returning the rows read so far
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter := &cancelingIterator{cancel: cancel, after: 10}
+	db := &cancelingDatabase{table: &contextTable{&cancelingTable{MockTable: mock, iter: iter}}}
+
+	result := ExecuteSQLContext(ctx, db, "SELECT * FROM big")
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Error, context.Canceled)
+	assert.Equal(t, 10, iter.seen)
+}
+
+func TestExecutorContextErrors(t *testing.T) {
+	db := NewMockDatabase()
+	_, err := db.CreateTable("users")
+	assert.NoError(t, err)
+	
+	// An already-canceled context never runs the statement
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := ExecuteSQLContext(ctx, db, "INSERT INTO users VALUES ('john', 'john@example.com')")
+	assert.False(t, result.Success)
+	assert.ErrorIs(t, result.Error, context.Canceled)
+	
+	table, _ := db.GetTable("users")
+	_, found := table.Select([]byte("john"))
+	assert.False(t, found)
+	
+	// An expired deadline reports DeadlineExceeded
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	result = ExecuteSQLContext(ctx, db, "SELECT * FROM users")
+	assert.ErrorIs(t, result.Error, context.DeadlineExceeded)
+}
+
+// slowIterator sleeps before every row to simulate a slow scan
+type slowIterator struct {
+	Iterator
+	delay time.Duration
+}
+
+func (s *slowIterator) Next() (key, val []byte) {
+	time.Sleep(s.delay)
+	return s.Iterator.Next()
+}
+
+type slowTable struct {
+	*MockTable
+}
+
+func (s *slowTable) Scan(startKey []byte) Iterator {
+	return &slowIterator{Iterator: s.MockTable.Scan(startKey), delay: time.Millisecond}
+}
+
+type slowDatabase struct {
+	table *slowTable
+}
+
+func (s *slowDatabase) GetTable(tableName string) (Table, error) {
+	return s.table, nil
+}
+
+func (s *slowDatabase) CreateTable(tableName string) (Table, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func TestExecutorStatementTimeout(t *testing.T) {
+	mock := &MockTable{name: "big", data: make(map[string]string)}
+	for i := 0; i < 1000; i++ {
+		mock.data[fmt.Sprintf("key%04d", i)] = "value"
+	}
+	db := &slowDatabase{table: &slowTable{MockTable: mock}}
+	
+	stmt, err := ParseSQL("SELECT * FROM big")
+	assert.NoError(t, err)
+	
+	executor := NewExecutor(db)
+	executor.SetStatementTimeout(20 * time.Millisecond)
+	start := time.Now()
+	result := executor.Execute(stmt)
+	assert.ErrorIs(t, result.Error, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	
+	// Without a timeout the same statement runs to completion
+	delete(mock.data, "key0000")
+	for i := 10; i < 1000; i++ {
+		delete(mock.data, fmt.Sprintf("key%04d", i))
+	}
+	executor.SetStatementTimeout(0)
+	result = executor.Execute(stmt)
+	assert.True(t, result.Success)
+	assert.Len(t, result.Rows, 9)
+}
+
+func TestExecutorConstraints(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE products (id INTEGER PRIMARY KEY, sku TEXT NOT NULL UNIQUE, "+
+		"price REAL CHECK (price > 0), qty INTEGER, CONSTRAINT stock CHECK (qty >= 0 AND qty <= 100))")
+	require.True(t, result.Success, "%v", result.Error)
+	_, err := db.GetTable("products_sku_key")
+	assert.NoError(t, err, "UNIQUE is backed by an index")
+	
+	result = ExecuteSQL(db, "INSERT INTO products VALUES (1, 'a', 9.5, 10)")
+	require.True(t, result.Success, "%v", result.Error)
+	
+	violations := []struct {
+		sql        string
+		kind       ConstraintKind
+		constraint string
+		columns    []string
+	}{
+		{"INSERT INTO products (id, price) VALUES (2, 1)", ConstraintNotNull, "products_sku_not_null", []string{"sku"}},
+		{"INSERT INTO products VALUES (2, 'a', 1, 1)", ConstraintUnique, "products_sku_key", []string{"sku"}},
+		{"INSERT INTO products VALUES (2, 'b', 0, 1)", ConstraintCheck, "products_price_check", []string{"price"}},
+		{"INSERT INTO products VALUES (2, 'b', 1, 101)", ConstraintCheck, "stock", []string{"qty"}},
+		{"INSERT INTO products VALUES (1, 'b', 1, 1)", ConstraintPrimaryKey, "products_pkey", []string{"id"}},
+		{"UPDATE products SET price = 0 WHERE id = 1", ConstraintCheck, "products_price_check", []string{"price"}},
+	}
+	for _, v := range violations {
+		result = ExecuteSQL(db, v.sql)
+		require.False(t, result.Success, v.sql)
+		
+		var cerr *ConstraintError
+		require.ErrorAs(t, result.Error, &cerr, v.sql)
+		assert.Equal(t, v.kind, cerr.Kind, v.sql)
+		assert.Equal(t, v.constraint, cerr.Constraint, v.sql)
+		assert.Equal(t, "products", cerr.Table, v.sql)
+		assert.Equal(t, v.columns, cerr.Columns, v.sql)
+		assert.Contains(t, cerr.Error(), v.constraint, v.sql)
+		assert.Equal(t, v.kind == ConstraintPrimaryKey, errors.Is(result.Error, ErrDuplicateKey), v.sql)
+	}
+	
+	// Nothing was written by the rejected statements
+	result = ExecuteSQL(db, "SELECT * FROM products")
+	assert.Equal(t, []map[string]string{{"id": "1", "sku": "a", "price": "9.5", "qty": "10"}}, result.Rows)
+	
+	// A NULL makes a CHECK unknown, which passes
+	result = ExecuteSQL(db, "INSERT INTO products (id, sku) VALUES (2, 'b')")
+	assert.True(t, result.Success, "%v", result.Error)
+	
+	// Renaming a column keeps its constraints; dropping it is refused
+	result = ExecuteSQL(db, "ALTER TABLE products RENAME COLUMN qty TO stock_qty")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "UPDATE products SET stock_qty = 500 WHERE id = 1")
+	assert.False(t, result.Success)
+	assert.Contains(t, result.Error.Error(), "stock_qty >= 0 AND stock_qty <= 100")
+	result = ExecuteSQL(db, "ALTER TABLE products DROP COLUMN stock_qty")
+	assert.False(t, result.Success)
+	result = ExecuteSQL(db, "DROP INDEX products_sku_key")
+	assert.False(t, result.Success)
+	
+	// Constraints must refer to existing columns
+	result = ExecuteSQL(db, "CREATE TABLE bad (id INTEGER, CHECK (missing > 0))")
+	assert.False(t, result.Success)
+	result = ExecuteSQL(db, "CREATE TABLE bad (id INTEGER, UNIQUE (missing))")
+	assert.False(t, result.Success)
+	_, err = db.GetTable("bad")
+	assert.Error(t, err)
+}
+
+func TestExecutorForeignKeys(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+		"CREATE TABLE posts (id INTEGER PRIMARY KEY, author INTEGER REFERENCES users ON DELETE CASCADE ON UPDATE CASCADE, " +
+			"editor TEXT REFERENCES users (email) ON DELETE SET NULL ON UPDATE CASCADE)",
+		"CREATE TABLE comments (id INTEGER PRIMARY KEY, post INTEGER NOT NULL REFERENCES posts ON DELETE RESTRICT)",
+		"CREATE TABLE drafts (id INTEGER PRIMARY KEY, owner INTEGER DEFAULT 0 REFERENCES users ON DELETE SET DEFAULT)",
+		"INSERT INTO users VALUES (0, 'nobody')",
+		"INSERT INTO users VALUES (1, 'a')",
+		"INSERT INTO users VALUES (2, 'b')",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	exec := func(sql string) {
+		t.Helper()
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	rows := func(sql string) []map[string]string {
+		t.Helper()
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result.Rows
+	}
+	violation := func(sql, constraint, table string) {
+		t.Helper()
+		result := ExecuteSQL(db, sql)
+		require.False(t, result.Success, sql)
+		var cerr *ConstraintError
+		require.ErrorAs(t, result.Error, &cerr, sql)
+		assert.Equal(t, ConstraintForeignKey, cerr.Kind, sql)
+		assert.Equal(t, constraint, cerr.Constraint, sql)
+		assert.Equal(t, table, cerr.Table, sql)
+	}
+	
+	// Rows of the child must refer to existing rows; NULL refers to nothing
+	violation("INSERT INTO posts VALUES (10, 3, 'a')", "posts_author_fkey", "posts")
+	violation("INSERT INTO posts VALUES (10, 1, 'x')", "posts_editor_fkey", "posts")
+	exec("INSERT INTO posts VALUES (10, 1, 'b')")
+	exec("INSERT INTO posts (id, author) VALUES (11, 2)")
+	violation("UPDATE posts SET author = 7 WHERE id = 11", "posts_author_fkey", "posts")
+	exec("UPDATE posts SET editor = 'b' WHERE id = 11")
+	exec("INSERT INTO comments VALUES (100, 10)")
+	violation("INSERT INTO comments VALUES (101, 99)", "comments_post_fkey", "comments")
+	
+	// The cascade from users to posts is stopped by the comment, and the
+	// whole statement is undone
+	violation("DELETE FROM users WHERE id = 1", "comments_post_fkey", "comments")
+	assert.Len(t, rows("SELECT * FROM users WHERE id = 1"), 1)
+	assert.Len(t, rows("SELECT * FROM posts WHERE id = 10"), 1)
+	
+	exec("DELETE FROM comments WHERE id = 100")
+	exec("DELETE FROM users WHERE id = 1")
+	assert.Empty(t, rows("SELECT * FROM posts WHERE id = 10"))
+	
+	// ON UPDATE CASCADE follows changes to the primary key and to a unique
+	// column
+	exec("UPDATE users SET email = 'bee' WHERE id = 2")
+	exec("UPDATE users SET id = 5 WHERE id = 2")
+	assert.Equal(t, []map[string]string{{"id": "11", "author": "5", "editor": "bee"}}, rows("SELECT * FROM posts"))
+	
+	// SET NULL and SET DEFAULT
+	exec("INSERT INTO users VALUES (3, 'c')")
+	exec("INSERT INTO posts VALUES (12, 5, 'c')")
+	exec("DELETE FROM users WHERE id = 3")
+	assert.Equal(t, []map[string]string{{"id": "12", "author": "5", "editor": "NULL"}}, rows("SELECT * FROM posts WHERE id = 12"))
+	exec("INSERT INTO drafts VALUES (1, 5)")
+	exec("DELETE FROM users WHERE id = 5")
+	assert.Empty(t, rows("SELECT * FROM posts"))
+	assert.Equal(t, []map[string]string{{"id": "1", "owner": "0"}}, rows("SELECT * FROM drafts"))
+	violation("DELETE FROM users WHERE id = 0", "drafts_owner_fkey", "drafts")
+	
+	// Tables that are referenced cannot be dropped or renamed; renaming a
+	// referenced column carries over to the referring tables
+	assert.False(t, ExecuteSQL(db, "DROP TABLE users").Success)
+	assert.False(t, ExecuteSQL(db, "ALTER TABLE users RENAME TO people").Success)
+	exec("ALTER TABLE users RENAME COLUMN email TO mail")
+	exec("INSERT INTO posts VALUES (13, 0, 'nobody')")
+	violation("INSERT INTO posts VALUES (14, 0, 'a')", "posts_editor_fkey", "posts")
+	assert.False(t, ExecuteSQL(db, "ALTER TABLE posts DROP COLUMN editor").Success)
+	
+	// A unique index referred to by a foreign key cannot be dropped
+	exec("CREATE TABLE products (id INTEGER PRIMARY KEY, sku TEXT)")
+	exec("CREATE UNIQUE INDEX products_sku ON products (sku)")
+	exec("CREATE TABLE lines (id INTEGER PRIMARY KEY, sku TEXT REFERENCES products (sku))")
+	assert.False(t, ExecuteSQL(db, "DROP INDEX products_sku").Success)
+	
+	// Foreign keys must refer to a unique key of an existing table, with
+	// columns of the same types
+	for _, sql := range []string{
+		"CREATE TABLE bad (id INTEGER, x INTEGER REFERENCES missing)",
+		"CREATE TABLE bad (id INTEGER, x TEXT REFERENCES users)",
+		"CREATE TABLE bad (id INTEGER, x INTEGER REFERENCES products (id, sku))",
+		"CREATE TABLE bad (id INTEGER, x INTEGER REFERENCES drafts (owner))",
+		"CREATE TABLE bad (id INTEGER, x INTEGER NOT NULL REFERENCES users ON DELETE SET NULL)",
+	} {
+		assert.False(t, ExecuteSQL(db, sql).Success, sql)
+	}
+	_, err := db.GetTable("bad")
+	assert.Error(t, err)
+}
+
+func TestExecutorSelfReferencingForeignKey(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE categories (id INTEGER PRIMARY KEY, parent INTEGER REFERENCES categories ON DELETE CASCADE)")
+	require.True(t, result.Success, "%v", result.Error)
+	for _, sql := range []string{
+		"INSERT INTO categories (id) VALUES (1)",
+		"INSERT INTO categories VALUES (2, 1)",
+		"INSERT INTO categories VALUES (3, 2)",
+		"INSERT INTO categories VALUES (4, 4)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	assert.False(t, ExecuteSQL(db, "INSERT INTO categories VALUES (5, 6)").Success)
+	
+	// Deleting the root deletes the whole tree below it
+	result = ExecuteSQL(db, "DELETE FROM categories WHERE id = 1")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "SELECT * FROM categories")
+	assert.Equal(t, []map[string]string{{"id": "4", "parent": "4"}}, result.Rows)
+	
+	// A table that only refers to itself can be dropped
+	result = ExecuteSQL(db, "DROP TABLE categories")
+	assert.True(t, result.Success, "%v", result.Error)
+}
+
+func TestSystemTables(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL, active BOOLEAN DEFAULT true)",
+		"CREATE UNIQUE INDEX by_email ON users (email)",
+		"INSERT INTO users (id, email) VALUES (1, 'a@x')",
+		"INSERT INTO users (id, email) VALUES (2, 'b@x')",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	_, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	
+	result := ExecuteSQL(db, "SELECT name, type, column_count, primary_key FROM db_tables")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{
+		{"name": "kv", "type": "key/value", "column_count": "2", "primary_key": "key"},
+		{"name": "users", "type": "table", "column_count": "3", "primary_key": "id"},
+	}, result.Rows)
+	
+	result = ExecuteSQL(db, "SELECT name, type, not_null, default_value, primary_key FROM db_columns WHERE table_name = 'users'")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{
+		{"name": "id", "type": "INTEGER", "not_null": "true", "default_value": "NULL", "primary_key": "true"},
+		{"name": "email", "type": "TEXT", "not_null": "true", "default_value": "NULL", "primary_key": "false"},
+		{"name": "active", "type": "BOOLEAN", "not_null": "false", "default_value": "true", "primary_key": "false"},
+	}, result.Rows)
+	
+	result = ExecuteSQL(db, "SELECT * FROM db_indexes")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{
+		{"name": "by_email", "table_name": "users", "columns": "email", "is_unique": "true", "root_page": "NULL"},
+	}, result.Rows)
+	
+	// Without tree statistics from the database, rows are counted
+	result = ExecuteSQL(db, "SELECT name, type, row_count FROM db_stats")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{
+		{"name": "by_email", "type": "index", "row_count": "2"},
+		{"name": "kv", "type": "table", "row_count": "0"},
+		{"name": "users", "type": "table", "row_count": "2"},
+	}, result.Rows)
+	
+	// System tables are read-only and their names are reserved
+	for _, sql := range []string{
+		"INSERT INTO db_tables (name) VALUES ('x')",
+		"UPDATE db_tables SET type = 'x' WHERE name = 'users'",
+		"DELETE FROM db_stats WHERE name = 'users'",
+		"DROP TABLE db_columns",
+		"CREATE TABLE db_indexes (id INTEGER PRIMARY KEY)",
+		"ALTER TABLE users RENAME TO db_stats",
+		"CREATE INDEX db_tables ON users (email)",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+		assert.Contains(t, result.Error.Error(), "read-only system table", sql)
+	}
+}
+
+func TestExecutorWhereOperators(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, age INTEGER, "+
+		"status TEXT CHECK (status IN ('new', 'active') AND status NOT LIKE '% %'))")
+	require.True(t, result.Success, "%v", result.Error)
+	for _, sql := range []string{
+		"INSERT INTO people VALUES (1, 'alice', 30, 'new')",
+		"INSERT INTO people VALUES (2, 'bob', 17, 'active')",
+		"INSERT INTO people VALUES (3, 'carol', 45, 'active')",
+		"INSERT INTO people (id, name) VALUES (4, 'dave')",
+		"INSERT INTO people VALUES (5, 'Alan', 0, 'new')",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	assert.False(t, ExecuteSQL(db, "INSERT INTO people VALUES (6, 'eve', 20, 'gone')").Success)
+	
+	ids := func(where string) []string {
+		result := ExecuteSQL(db, "SELECT id FROM people WHERE "+where)
+		require.True(t, result.Success, "%s: %v", where, result.Error)
+		ids := []string{}
+		for _, row := range result.Rows {
+			ids = append(ids, row["id"])
+		}
+		return ids
+	}
+	
+	assert.Equal(t, []string{"1", "3"}, ids("age > 17"))
+	assert.Equal(t, []string{"2", "5"}, ids("age <= 17"))
+	assert.Equal(t, []string{"1", "3", "5"}, ids("age != 17"), "NULL is neither equal nor unequal")
+	assert.Equal(t, []string{"1", "3", "5"}, ids("age <> 17"))
+	assert.Equal(t, []string{"1", "2", "3", "5"}, ids("age > -1"))
+	assert.Equal(t, []string{"1", "4"}, ids("name LIKE '%a%e'"))
+	assert.Equal(t, []string{"2", "3", "5"}, ids("name NOT LIKE '%a%e'"))
+	assert.Equal(t, []string{"1"}, ids("name LIKE 'a_i%'"))
+	assert.Equal(t, []string{"2", "4"}, ids("id IN (2, 4, 9)"))
+	assert.Equal(t, []string{"1", "3", "5"}, ids("id NOT IN (2, 4)"))
+	assert.Equal(t, []string{"2", "3"}, ids("status IN ('active')"))
+	assert.Equal(t, []string{"1", "3"}, ids("age BETWEEN 18 AND 45"))
+	assert.Equal(t, []string{"2", "5"}, ids("age NOT BETWEEN 18 AND 45"))
+	assert.Equal(t, []string{"4"}, ids("age IS NULL"))
+	assert.Equal(t, []string{"1", "2", "3", "5"}, ids("status IS NOT NULL"))
+	assert.Equal(t, []string{"2", "3"}, ids("id >= 2 AND id < 4"))
+	assert.Equal(t, []string{"3", "4"}, ids("id > 2 AND age IS NULL OR age > 40"))
+	assert.Equal(t, []string{}, ids("id BETWEEN 4 AND 2"))
+	
+	for _, where := range []string{
+		"age LIKE '1%'",
+		"age > 'old'",
+		"missing IS NULL",
+	} {
+		result := ExecuteSQL(db, "SELECT id FROM people WHERE "+where)
+		assert.False(t, result.Success, where)
+	}
+}
+
+func TestExecutorExpressions(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE SEQUENCE item_ids START WITH 10",
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT, price REAL, qty INTEGER, note TEXT)",
+		"INSERT INTO items VALUES (nextval('item_ids'), 'pen', 1.5, 4, NULL)",
+		"INSERT INTO items VALUES (nextval('item_ids'), 'ink', 2 * 3, -2, 'x' || 'y')",
+		"INSERT INTO items (id, name, qty) VALUES (5 % 3, 'cap', 7 / 2)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	
+	result := ExecuteSQL(db, "SELECT id, price * qty AS total, name || '!' shout, qty + 1 FROM items")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []string{"id", "total", "shout", "qty + 1"}, result.Columns)
+	assert.Equal(t, []map[string]string{
+		{"id": "2", "total": "NULL", "shout": "cap!", "qty + 1": "4"},
+		{"id": "10", "total": "6", "shout": "pen!", "qty + 1": "5"},
+		{"id": "11", "total": "-12", "shout": "ink!", "qty + 1": "-1"},
+	}, result.Rows)
+	
+	result = ExecuteSQL(db, "SELECT note FROM items WHERE id = 11")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "xy", result.Rows[0]["note"])
+	
+	// SET expressions see the row as it was before the update
+	result = ExecuteSQL(db, "UPDATE items SET qty = qty * 10, price = qty WHERE id = 10")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "SELECT qty, price FROM items WHERE id = 10")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"qty": "40", "price": "4"}}, result.Rows)
+	
+	result = ExecuteSQL(db, "SELECT name FROM items WHERE qty * 2 > 10 OR NOT (id <> 2)")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"name": "cap"}, {"name": "pen"}}, result.Rows)
+	
+	for _, sql := range []string{
+		"SELECT qty / 0 FROM items",
+		"SELECT missing + 1 FROM items",
+		"SELECT name - 1 FROM items",
+		"INSERT INTO items VALUES (3, id, 1.0, 1, NULL)",
+		"INSERT INTO items VALUES (9223372036854775807 + 1, 'big', 1.0, 1, NULL)",
+		"INSERT INTO items VALUES (nextval('missing'), 'x', 1.0, 1, NULL)",
+		"UPDATE items SET qty = 'many' WHERE id = 10",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+	
+	// The smallest INTEGER stays an INTEGER, and a REAL literal keeps its
+	// decimal point in the column name
+	result = ExecuteSQL(db, "SELECT -9223372036854775808, 7.0, 7 FROM items WHERE id = 2")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []string{"-9223372036854775808", "7.0", "7"}, result.Columns)
+	assert.Equal(t, "-9223372036854775808", result.Rows[0]["-9223372036854775808"])
+	
+	result = ExecuteSQL(db, "INSERT INTO items (id, qty) VALUES (20, 1.5)")
+	assert.EqualError(t, result.Error, "column qty: cannot store a REAL value in an INTEGER column")
+}
+
+func TestExecutorWhereClauses(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE staff (id INTEGER PRIMARY KEY, name TEXT, dept TEXT, salary INTEGER)",
+		"CREATE INDEX staff_dept ON staff (dept)",
+		"INSERT INTO staff VALUES (1, 'ann', 'eng', 100)",
+		"INSERT INTO staff VALUES (2, 'ben', 'eng', 80)",
+		"INSERT INTO staff VALUES (3, 'cat', 'ops', 70)",
+		"INSERT INTO staff VALUES (4, 'dan', 'ops', 90)",
+		"INSERT INTO staff VALUES (5, 'eve', 'hr', NULL)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	names := func(where string) []string {
+		result := ExecuteSQL(db, "SELECT name FROM staff WHERE "+where)
+		require.True(t, result.Success, "%s: %v", where, result.Error)
+		names := []string{}
+		for _, row := range result.Rows {
+			names = append(names, row["name"])
+		}
+		return names
+	}
+	
+	// Any predicate selects rows, through the index, the key or a full scan
+	assert.Equal(t, []string{"ann"}, names("dept = 'eng' AND salary > 90"))
+	assert.Equal(t, []string{"ben", "cat"}, names("dept = 'eng' AND salary < 90 OR dept = 'ops' AND salary < 90"))
+	assert.Equal(t, []string{"cat", "dan"}, names("NOT (dept = 'eng' OR dept = 'hr')"))
+	assert.Equal(t, []string{"ann", "dan"}, names("salary + id * 10 > 105"))
+	assert.Equal(t, []string{}, names("id = 1 AND id = 2"))
+	
+	result := ExecuteSQL(db, "UPDATE staff SET salary = salary + 5 WHERE dept = 'ops' OR salary IS NULL")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Updated 3 rows")
+	assert.Equal(t, []string{"cat", "dan"}, names("salary IN (75, 95)"))
+	assert.Equal(t, []string{"eve"}, names("salary IS NULL"))
+	
+	result = ExecuteSQL(db, "UPDATE staff SET dept = 'ops' WHERE dept = 'eng' AND name LIKE 'b%'")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Updated 1 rows")
+	assert.Equal(t, []string{"ben", "cat", "dan"}, names("dept = 'ops'"))
+	
+	result = ExecuteSQL(db, "DELETE FROM staff WHERE dept = 'ops' AND salary < 90")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Deleted 2 rows")
+	assert.Equal(t, []string{"ann", "dan", "eve"}, names("id > 0"))
+	assert.Equal(t, []string{"dan"}, names("dept = 'ops'"))
+	
+	result = ExecuteSQL(db, "DELETE FROM staff WHERE salary > 1000")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Deleted 0 rows")
+	
+	// Key/value tables are read as a key and a value column
+	kv, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, kv.Insert([]byte(k), []byte("v"+k)))
+	}
+	result = ExecuteSQL(db, "SELECT key FROM kv WHERE key > 'a' AND value <> 'vc'")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"key": "b"}, {"key": "d"}}, result.Rows)
+	
+	result = ExecuteSQL(db, "UPDATE kv SET value = value || '!' WHERE key IN ('a', 'c')")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Updated 2 rows")
+	value, _ := kv.Select([]byte("c"))
+	assert.Equal(t, "vc!", string(value))
+	
+	result = ExecuteSQL(db, "DELETE FROM kv WHERE value LIKE '%!' OR key = 'd'")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Contains(t, result.Message, "Deleted 3 rows")
+	result = ExecuteSQL(db, "SELECT * FROM kv")
+	assert.Equal(t, []map[string]string{{"key": "b", "value": "vb"}}, result.Rows)
+	
+	for _, sql := range []string{
+		"SELECT * FROM kv WHERE id = 'b'",
+		"DELETE FROM kv WHERE id = 'b'",
+		"UPDATE kv SET value = id WHERE key = 'b'",
+		"SELECT * FROM staff WHERE missing = 1",
+		"UPDATE staff SET salary = bonus WHERE id = 1",
+		"DELETE FROM staff WHERE name > 1 + 'x'",
+		"DELETE FROM staff WHERE bonus LIKE 'x' AND id = 99",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+}
+
+func TestExecutorOrderByLimit(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, age INTEGER)",
+		"INSERT INTO people VALUES (1, 'dee', 40)",
+		"INSERT INTO people VALUES (2, 'al', NULL)",
+		"INSERT INTO people VALUES (3, 'cy', 25)",
+		"INSERT INTO people VALUES (4, 'bo', 40)",
+		"INSERT INTO people VALUES (5, 'ed', 31)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	names := func(sql string) []string {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		names := []string{}
+		for _, row := range result.Rows {
+			names = append(names, row["name"])
+		}
+		return names
+	}
+	
+	// Key order, forwards and backwards
+	assert.Equal(t, []string{"dee", "al", "cy"}, names("SELECT name FROM people ORDER BY id LIMIT 3"))
+	assert.Equal(t, []string{"ed", "bo"}, names("SELECT name FROM people ORDER BY id DESC LIMIT 2"))
+	assert.Equal(t, []string{"bo", "cy"}, names("SELECT name FROM people WHERE id < 5 ORDER BY id DESC LIMIT 2"))
+	assert.Equal(t, []string{"cy", "bo"}, names("SELECT name FROM people ORDER BY id LIMIT 2 OFFSET 2"))
+	
+	// Other sort keys, with NULLs last ascending and first descending
+	assert.Equal(t, []string{"cy", "ed", "dee", "bo", "al"}, names("SELECT name FROM people ORDER BY age"))
+	assert.Equal(t, []string{"al", "dee", "bo", "ed", "cy"}, names("SELECT name FROM people ORDER BY age DESC"))
+	assert.Equal(t, []string{"dee", "bo", "ed", "cy", "al"}, names("SELECT name FROM people ORDER BY age DESC NULLS LAST"))
+	assert.Equal(t, []string{"al", "cy"}, names("SELECT name FROM people ORDER BY age NULLS FIRST LIMIT 2"))
+	assert.Equal(t, []string{"bo", "dee", "ed"}, names("SELECT name FROM people ORDER BY age DESC, name LIMIT 3 OFFSET 1"))
+	assert.Equal(t, []string{"ed", "dee", "bo"}, names("SELECT name, age * 2 AS twice FROM people WHERE age > 30 ORDER BY twice, 1 DESC"))
+	assert.Equal(t, []string{"bo", "cy"}, names("SELECT name FROM people ORDER BY name LIMIT 2 OFFSET 1"))
+	assert.Equal(t, []string{}, names("SELECT name FROM people ORDER BY age LIMIT 0"))
+	assert.Equal(t, []string{}, names("SELECT name FROM people ORDER BY age OFFSET 9"))
+	assert.Equal(t, []string{"al", "cy"}, names("SELECT name FROM people LIMIT 2 OFFSET 1"))
+	
+	// Key columns after those an equality fixes are in key order too
+	result := ExecuteSQL(db, "CREATE TABLE visits (day INTEGER, seq INTEGER, name TEXT, PRIMARY KEY (day, seq))")
+	require.True(t, result.Success, "%v", result.Error)
+	for _, sql := range []string{
+		"INSERT INTO visits VALUES (1, 1, 'a')",
+		"INSERT INTO visits VALUES (1, 2, 'b')",
+		"INSERT INTO visits VALUES (2, 1, 'c')",
+		"INSERT INTO visits VALUES (2, 2, 'd')",
+		"INSERT INTO visits VALUES (2, 3, 'e')",
+	} {
+		require.True(t, ExecuteSQL(db, sql).Success, sql)
+	}
+	assert.Equal(t, []string{"e", "d"}, names("SELECT name FROM visits WHERE day = 2 ORDER BY seq DESC LIMIT 2"))
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, names("SELECT name FROM visits ORDER BY day DESC, seq DESC"))
+	assert.Equal(t, []string{"c", "d", "e", "a", "b"}, names("SELECT name FROM visits ORDER BY day DESC, seq"))
+	
+	// Key/value tables and system tables
+	kv, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	for _, k := range []string{"b", "a", "c"} {
+		require.NoError(t, kv.Insert([]byte(k), []byte("v"+k)))
+	}
+	result = ExecuteSQL(db, "SELECT key FROM kv WHERE key <= 'b' ORDER BY key DESC")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"key": "b"}, {"key": "a"}}, result.Rows)
+	result = ExecuteSQL(db, "SELECT name FROM db_tables ORDER BY name DESC LIMIT 1")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"name": "visits"}}, result.Rows)
+	
+	for _, sql := range []string{
+		"SELECT name FROM people ORDER BY missing",
+		"SELECT name FROM people ORDER BY 3",
+		"SELECT name FROM people LIMIT -1",
+		"SELECT name FROM people LIMIT 'x'",
+		"SELECT name FROM people LIMIT id",
+		"SELECT name FROM people ORDER BY name - 1",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+}
+
+func TestExecutorAggregates(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE staff (id INTEGER PRIMARY KEY, name TEXT, dept TEXT, salary INTEGER, rating REAL)",
+		"INSERT INTO staff VALUES (1, 'al', 'eng', 100, 4.5)",
+		"INSERT INTO staff VALUES (2, 'bo', 'eng', 120, NULL)",
+		"INSERT INTO staff VALUES (3, 'cy', 'ops', 90, 3.0)",
+		"INSERT INTO staff VALUES (4, 'dee', 'eng', 100, 4.0)",
+		"INSERT INTO staff VALUES (5, 'ed', NULL, NULL, 2.0)",
+		"CREATE TABLE empty (id INTEGER PRIMARY KEY, n INTEGER)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	query := func(sql string) []map[string]string {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result.Rows
+	}
+	
+	// Without GROUP BY every row is one group, NULLs left out
+	result := ExecuteSQL(db, "SELECT count(*), count(salary), COUNT(DISTINCT salary), sum(salary), avg(salary), min(name), max(rating) FROM staff")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []string{"count(*)", "count(salary)", "count(DISTINCT salary)", "sum(salary)", "avg(salary)", "min(name)", "max(rating)"}, result.Columns)
+	assert.Equal(t, []map[string]string{{
+		"count(*)": "5", "count(salary)": "4", "count(DISTINCT salary)": "3", "sum(salary)": "410",
+		"avg(salary)": "102.5", "min(name)": "al", "max(rating)": "4.5",
+	}}, result.Rows)
+	assert.Equal(t, []map[string]string{{"n": "3", "total": "320"}}, query("SELECT count(*) AS n, sum(salary) AS total FROM staff WHERE dept = 'eng'"))
+	assert.Equal(t, []map[string]string{{"count(*)": "0", "sum(n)": "NULL", "avg(n)": "NULL", "max(n)": "NULL"}}, query("SELECT count(*), sum(n), avg(n), max(n) FROM empty"))
+	assert.Equal(t, []map[string]string{{"twice": "820"}}, query("SELECT sum(salary) * 2 AS twice FROM staff"))
+	
+	// Groups come out in the order they are first seen, NULL as a group
+	assert.Equal(t, []map[string]string{
+		{"dept": "eng", "n": "3", "top": "120"},
+		{"dept": "ops", "n": "1", "top": "90"},
+		{"dept": "NULL", "n": "1", "top": "NULL"},
+	}, query("SELECT dept, count(*) AS n, max(salary) AS top FROM staff GROUP BY dept"))
+	assert.Equal(t, []map[string]string{
+		{"dept": "eng", "n": "3"},
+	}, query("SELECT dept, count(*) AS n FROM staff GROUP BY dept HAVING count(*) > 1"))
+	assert.Equal(t, []map[string]string{
+		{"dept": "ops", "avg(rating)": "3"},
+		{"dept": "eng", "avg(rating)": "4.25"},
+	}, query("SELECT dept, avg(rating) FROM staff WHERE dept IS NOT NULL GROUP BY 1 ORDER BY avg(rating) LIMIT 2"))
+	assert.Equal(t, []map[string]string{
+		{"d": "eng", "c": "2"},
+	}, query("SELECT dept AS d, count(DISTINCT salary) AS c FROM staff GROUP BY d ORDER BY c DESC LIMIT 1"))
+	assert.Equal(t, []map[string]string{
+		{"tagged": "eng!", "count(*)": "3"},
+	}, query("SELECT dept || '!' AS tagged, count(*) FROM staff WHERE dept = 'eng' GROUP BY dept || '!'"))
+	assert.Equal(t, []map[string]string{
+		{"salary": "100", "count(*)": "2"},
+		{"salary": "120", "count(*)": "1"},
+	}, query("SELECT salary, count(*) FROM staff WHERE dept = 'eng' GROUP BY salary ORDER BY salary OFFSET 0"))
+	assert.Empty(t, query("SELECT dept FROM staff GROUP BY dept HAVING sum(salary) > 1000"))
+	assert.Empty(t, query("SELECT n, count(*) FROM empty GROUP BY n"))
+	
+	// Key/value tables aggregate too
+	kv, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	require.NoError(t, kv.Insert([]byte("a"), []byte("1")))
+	require.NoError(t, kv.Insert([]byte("b"), []byte("1")))
+	assert.Equal(t, []map[string]string{{"count(*)": "2", "count(DISTINCT value)": "1"}}, query("SELECT count(*), count(DISTINCT value) FROM kv"))
+	
+	for _, sql := range []string{
+		"SELECT name, count(*) FROM staff",
+		"SELECT dept, name FROM staff GROUP BY dept",
+		"SELECT dept FROM staff GROUP BY dept ORDER BY name",
+		"SELECT dept FROM staff GROUP BY dept HAVING name = 'al'",
+		"SELECT count(*) FROM staff GROUP BY count(*)",
+		"SELECT sum(count(*)) FROM staff",
+		"SELECT sum(*) FROM staff",
+		"SELECT sum(salary, id) FROM staff",
+		"SELECT count(nope) FROM staff",
+		"SELECT sum(name) FROM staff",
+		"SELECT * FROM staff WHERE count(*) > 1",
+		"SELECT dept FROM staff GROUP BY 9",
+		"UPDATE staff SET salary = max(salary) WHERE id = 1",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+}
+
+func TestExecutorJoins(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'al')",
+		"INSERT INTO users VALUES (2, 'bo')",
+		"INSERT INTO users VALUES (3, 'cy')",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER, total INTEGER)",
+		"INSERT INTO orders VALUES (10, 1, 5)",
+		"INSERT INTO orders VALUES (11, 2, 7)",
+		"INSERT INTO orders VALUES (12, 1, 9)",
+		"INSERT INTO orders VALUES (13, NULL, 1)",
+		"CREATE TABLE sizes (name TEXT PRIMARY KEY)",
+		"INSERT INTO sizes VALUES ('S')",
+		"INSERT INTO sizes VALUES ('L')",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	query := func(sql string) []map[string]string {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result.Rows
+	}
+	
+	// Inner joins, through a hash join on orders.user_id and a key lookup
+	// on users.id
+	expected := []map[string]string{
+		{"name": "al", "id": "10", "total": "5"},
+		{"name": "al", "id": "12", "total": "9"},
+		{"name": "bo", "id": "11", "total": "7"},
+	}
+	assert.Equal(t, expected, query("SELECT u.name, o.id, total FROM users u JOIN orders o ON o.user_id = u.id"))
+	assert.Equal(t, expected, query("SELECT u.name, o.id, total FROM orders AS o INNER JOIN users AS u ON u.id = o.user_id ORDER BY name, o.id"))
+	assert.Equal(t, []map[string]string{{"name": "al", "total": "9"}}, query("SELECT name, total FROM users JOIN orders ON users.id = orders.user_id AND total > 6 WHERE users.name < 'b'"))
+	assert.Equal(t, []map[string]string{{"users.id": "2", "orders.id": "11"}}, query("SELECT users.id, orders.id FROM users JOIN orders ON orders.user_id = users.id WHERE orders.total = 7"))
+	
+	// LEFT JOIN keeps unmatched rows with NULLs, and WHERE applies after it
+	assert.Equal(t, []map[string]string{
+		{"name": "al", "total": "5"},
+		{"name": "al", "total": "9"},
+		{"name": "bo", "total": "7"},
+		{"name": "cy", "total": "NULL"},
+	}, query("SELECT name, total FROM users LEFT OUTER JOIN orders ON orders.user_id = users.id"))
+	assert.Equal(t, []map[string]string{{"name": "cy"}}, query("SELECT name FROM users u LEFT JOIN orders o ON o.user_id = u.id WHERE o.id IS NULL"))
+	assert.Equal(t, []map[string]string{
+		{"name": "al", "total": "9"},
+		{"name": "bo", "total": "NULL"},
+		{"name": "cy", "total": "NULL"},
+	}, query("SELECT name, total FROM users u LEFT JOIN orders o ON o.user_id = u.id AND o.total > 8"))
+	
+	// Cross joins, with a comma or CROSS JOIN, and * over every table
+	assert.Len(t, query("SELECT * FROM users, sizes"), 6)
+	result := ExecuteSQL(db, "SELECT * FROM users CROSS JOIN sizes s WHERE users.id = 2")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []string{"id", "users.name", "s.name"}, result.Columns)
+	assert.Equal(t, []map[string]string{
+		{"id": "2", "users.name": "bo", "s.name": "L"},
+		{"id": "2", "users.name": "bo", "s.name": "S"},
+	}, result.Rows)
+	result = ExecuteSQL(db, "SELECT s.*, u.name AS who FROM sizes s JOIN users u ON u.id < 2")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []string{"name", "who"}, result.Columns)
+	assert.Len(t, result.Rows, 2)
+	
+	// Joins with a non-equality condition, ordering, limits and aggregates
+	assert.Equal(t, []map[string]string{
+		{"name": "cy", "id": "13"},
+		{"name": "cy", "id": "11"},
+	}, query("SELECT u.name, o.id FROM users u JOIN orders o ON o.total < u.id * 3 ORDER BY u.id DESC, o.id DESC LIMIT 2"))
+	assert.Equal(t, []map[string]string{
+		{"name": "al", "n": "2", "spent": "14"},
+		{"name": "bo", "n": "1", "spent": "7"},
+		{"name": "cy", "n": "0", "spent": "NULL"},
+	}, query("SELECT u.name, count(o.id) AS n, sum(o.total) AS spent FROM users u LEFT JOIN orders o ON o.user_id = u.id GROUP BY u.name ORDER BY n DESC"))
+	assert.Equal(t, []map[string]string{{"name": "al"}}, query("SELECT name FROM users WHERE users.id = 1"))
+	
+	for _, sql := range []string{
+		"SELECT id FROM users JOIN orders ON orders.user_id = users.id",
+		"SELECT * FROM users JOIN users ON users.id = users.id",
+		"SELECT * FROM users u JOIN orders o ON o.user_id = x.id",
+		"SELECT * FROM users u JOIN orders o ON o.user_id = s.id JOIN sizes s ON s.name = u.name",
+		"SELECT nope.* FROM users",
+		"SELECT u.name FROM users",
+		"SELECT * FROM users JOIN missing ON missing.id = users.id",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+}
+
+func TestExecutorSubqueries(t *testing.T) {
+	db := NewMockDatabase()
+	
+	for _, sql := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"INSERT INTO users VALUES (1, 'al')",
+		"INSERT INTO users VALUES (2, 'bo')",
+		"INSERT INTO users VALUES (3, 'cy')",
+		"CREATE TABLE orders (id INTEGER PRIMARY KEY, user_id INTEGER, total INTEGER)",
+		"INSERT INTO orders VALUES (10, 1, 5)",
+		"INSERT INTO orders VALUES (11, 2, 7)",
+		"INSERT INTO orders VALUES (12, 1, 9)",
+		"INSERT INTO orders VALUES (13, NULL, 1)",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+	query := func(sql string) []map[string]string {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		return result.Rows
+	}
+	names := func(names ...string) []map[string]string {
+		rows := make([]map[string]string, len(names))
+		for i, name := range names {
+			rows[i] = map[string]string{"name": name}
+		}
+		return rows
+	}
+	
+	// Scalar subqueries, correlated to the outer row or not
+	assert.Equal(t, []map[string]string{
+		{"name": "al", "top": "9"},
+		{"name": "bo", "top": "7"},
+		{"name": "cy", "top": "NULL"},
+	}, query("SELECT name, (SELECT max(total) FROM orders WHERE orders.user_id = users.id) AS top FROM users"))
+	assert.Equal(t, []map[string]string{{"id": "12"}}, query("SELECT id FROM orders WHERE total = (SELECT max(total) FROM orders)"))
+	assert.Equal(t, []map[string]string{{"id": "11"}, {"id": "12"}}, query("SELECT id FROM orders WHERE total > (SELECT avg(total) FROM orders)"))
+	
+	// IN and NOT IN, where a NULL among the values makes a miss unknown
+	assert.Equal(t, names("al", "bo"), query("SELECT name FROM users WHERE id IN (SELECT user_id FROM orders WHERE total > 6)"))
+	assert.Equal(t, names("cy"), query("SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders WHERE total > 6)"))
+	assert.Empty(t, query("SELECT name FROM users WHERE id NOT IN (SELECT user_id FROM orders)"))
+	
+	// EXISTS, in which the subquery's own columns hide the outer ones
+	assert.Equal(t, names("al"), query("SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id AND o.total > 8)"))
+	assert.Equal(t, names("cy"), query("SELECT name FROM users WHERE NOT EXISTS (SELECT * FROM orders WHERE user_id = users.id)"))
+	assert.Len(t, query("SELECT name FROM users WHERE EXISTS (SELECT 1 FROM orders WHERE id = 11)"), 3)
+	
+	// Nested subqueries may refer to any query around them
+	assert.Equal(t, names("al"), query("SELECT name FROM users WHERE id IN (SELECT user_id FROM orders WHERE total < 6 AND total = (SELECT min(total) FROM orders o WHERE o.user_id = orders.user_id))"))
+	assert.Equal(t, names("al", "bo"), query("SELECT name FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.total > 6 AND EXISTS (SELECT 1 FROM users x WHERE x.id = o.user_id AND x.id = u.id))"))
+	
+	// Subqueries in FROM, alone or joined
+	assert.Equal(t, []map[string]string{{"user_id": "1", "n": "2"}}, query("SELECT t.user_id, n FROM (SELECT user_id, count(*) AS n FROM orders GROUP BY user_id) AS t WHERE t.n > 1"))
+	assert.Equal(t, []map[string]string{
+		{"name": "al", "spent": "14"},
+		{"name": "bo", "spent": "7"},
+	}, query("SELECT u.name, t.spent FROM users u JOIN (SELECT user_id, sum(total) AS spent FROM orders GROUP BY user_id) t ON t.user_id = u.id ORDER BY t.spent DESC"))
+	assert.Equal(t, []map[string]string{{"m": "2"}}, query("SELECT max(x.n) AS m FROM (SELECT count(*) AS n FROM orders GROUP BY user_id) x"))
+	
+	// UPDATE and DELETE
+	result := ExecuteSQL(db, "UPDATE orders SET total = (SELECT count(*) FROM users) WHERE user_id IN (SELECT id FROM users WHERE name = 'bo')")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"total": "3"}}, query("SELECT total FROM orders WHERE id = 11"))
+	result = ExecuteSQL(db, "DELETE FROM orders WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = orders.user_id)")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, "Deleted 1 rows from orders", result.Message)
+	
+	for _, sql := range []string{
+		"SELECT (SELECT id FROM users) FROM orders",
+		"SELECT name FROM users WHERE id IN (SELECT id, name FROM users)",
+		"SELECT * FROM (SELECT * FROM missing) AS m",
+		"SELECT * FROM users WHERE EXISTS (SELECT 1 FROM orders WHERE nope = 1)",
+		"SELECT * FROM users u, (SELECT id FROM orders WHERE orders.user_id = u.id) AS t",
+	} {
+		result := ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+}
+
+func TestExecutorSequences(t *testing.T) {
+	db := NewMockDatabase()
+	
+	result := ExecuteSQL(db, "CREATE SEQUENCE order_no START WITH 100 INCREMENT BY 10")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "CREATE SEQUENCE order_no")
+	assert.False(t, result.Success)
+	result = ExecuteSQL(db, "CREATE SEQUENCE IF NOT EXISTS order_no")
+	assert.True(t, result.Success)
+	
+	// AUTOINCREMENT numbers rows from a sequence owned by the table, and
+	// DEFAULT can draw from a standalone sequence
+	result = ExecuteSQL(db, "CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, "+
+		"no INTEGER DEFAULT nextval('order_no'), qty INTEGER DEFAULT -1, note TEXT)")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO orders (note) VALUES ('a')")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO orders (note) VALUES ('b')")
+	require.True(t, result.Success, "%v", result.Error)
+	
+	// An explicit id moves the sequence past it
+	result = ExecuteSQL(db, "INSERT INTO orders (id, no, note) VALUES (10, 5, 'c')")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO orders (note) VALUES ('d')")
+	require.True(t, result.Success, "%v", result.Error)
+	
+	result = ExecuteSQL(db, "SELECT * FROM orders")
+	assert.Equal(t, []map[string]string{
+		{"id": "1", "no": "100", "qty": "-1", "note": "a"},
+		{"id": "2", "no": "110", "qty": "-1", "note": "b"},
+		{"id": "10", "no": "5", "qty": "-1", "note": "c"},
+		{"id": "11", "no": "120", "qty": "-1", "note": "d"},
+	}, result.Rows)
+	
+	// The table's own sequence lives and dies with it
+	result = ExecuteSQL(db, "DROP SEQUENCE orders_id_seq")
+	assert.False(t, result.Success)
+	result = ExecuteSQL(db, "DROP TABLE orders")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.NotContains(t, db.sequences, "orders_id_seq")
+	result = ExecuteSQL(db, "DROP SEQUENCE order_no")
+	assert.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "DROP SEQUENCE IF EXISTS order_no")
+	assert.True(t, result.Success)
+	
+	for _, sql := range []string{
+		"CREATE TABLE bad (id TEXT PRIMARY KEY AUTOINCREMENT)",
+		"CREATE TABLE bad (id INTEGER PRIMARY KEY, n INTEGER AUTOINCREMENT)",
+		"CREATE TABLE bad (id INTEGER PRIMARY KEY, name TEXT DEFAULT nextval('s'))",
+	} {
+		result = ExecuteSQL(db, sql)
+		assert.False(t, result.Success, sql)
+	}
+}
+
+func TestExecutorExpressionDefaults(t *testing.T) {
+	db := NewMockDatabase()
+	for _, sql := range []string{
+		"CREATE SEQUENCE ticket_no",
+		"CREATE TABLE tickets (id INTEGER PRIMARY KEY, no INTEGER DEFAULT nextval('ticket_no') * 10, " +
+			"label TEXT DEFAULT 'T-' || (40 + 2), weight REAL DEFAULT (1 + 2) / 2.0, open BOOLEAN DEFAULT (1 < 2))",
+	} {
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+	}
+
+	// A default is evaluated for each row that does not give a value
+	result := ExecuteSQL(db, "INSERT INTO tickets (id) VALUES (1), (2)")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO tickets (id, no, label) VALUES (3, 5, 'mine')")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "INSERT INTO tickets (id) VALUES (4)")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "SELECT * FROM tickets")
+	assert.Equal(t, []map[string]string{
+		{"id": "1", "no": "10", "label": "T-42", "weight": "1.5", "open": "true"},
+		{"id": "2", "no": "20", "label": "T-42", "weight": "1.5", "open": "true"},
+		{"id": "3", "no": "5", "label": "mine", "weight": "1.5", "open": "true"},
+		{"id": "4", "no": "30", "label": "T-42", "weight": "1.5", "open": "true"},
+	}, result.Rows)
+
+	// Rows written before a column was added read its default
+	result = ExecuteSQL(db, "ALTER TABLE tickets ADD COLUMN priority INTEGER DEFAULT 2 * 3 - 1")
+	require.True(t, result.Success, "%v", result.Error)
+	result = ExecuteSQL(db, "SELECT priority FROM tickets WHERE id = 1")
+	assert.Equal(t, []map[string]string{{"priority": "5"}}, result.Rows)
+
+	for sql, msg := range map[string]string{
+		"CREATE TABLE bad (id INTEGER PRIMARY KEY, n INTEGER DEFAULT 'a' || 'b')":     "invalid INTEGER value",
+		"CREATE TABLE bad (id INTEGER PRIMARY KEY, n INTEGER DEFAULT id + 1)":         "cannot refer to column id",
+		"CREATE TABLE bad (id INTEGER PRIMARY KEY, n INTEGER DEFAULT count(1))":       "cannot be used in DEFAULT",
+		"CREATE TABLE bad (id INTEGER PRIMARY KEY, n INTEGER DEFAULT 1 / 0)":          "division by zero",
+		"ALTER TABLE tickets ADD COLUMN seq INTEGER DEFAULT nextval('ticket_no') + 1": "cannot be added to an existing table",
+	} {
+		result = ExecuteSQL(db, sql)
+		require.False(t, result.Success, sql)
+		assert.Contains(t, result.Error.Error(), msg, sql)
+	}
+}
@@ -0,0 +1,357 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ForeignKeyAction is what happens to the rows referring to a row when that
+// row is deleted or its referenced columns change
+type ForeignKeyAction string
+
+const (
+	// ActionNoAction fails the statement if rows still refer to the old
+	// values once all of its writes are done
+	ActionNoAction ForeignKeyAction = "NO ACTION"
+
+	// ActionRestrict fails the statement as soon as a referring row is found
+	ActionRestrict ForeignKeyAction = "RESTRICT"
+
+	// ActionCascade deletes the referring rows, or updates them to the new
+	// values
+	ActionCascade ForeignKeyAction = "CASCADE"
+
+	// ActionSetNull and ActionSetDefault set the foreign key columns of the
+	// referring rows to NULL or to their defaults
+	ActionSetNull    ForeignKeyAction = "SET NULL"
+	ActionSetDefault ForeignKeyAction = "SET DEFAULT"
+)
+
+// ForeignKey is a FOREIGN KEY constraint of a table. A row whose Columns
+// are all non-NULL must match a row of RefTable on RefColumns, which are
+// the primary key or the columns of a unique index of RefTable.
+type ForeignKey struct {
+	Name       string           `json:"name"`
+	Columns    []string         `json:"columns"`
+	RefTable   string           `json:"ref_table"`
+	RefColumns []string         `json:"ref_columns"`
+	OnDelete   ForeignKeyAction `json:"on_delete"`
+	OnUpdate   ForeignKeyAction `json:"on_update"`
+}
+
+// ForeignKey returns the foreign key with the given name, or nil
+func (s *Schema) ForeignKey(name string) *ForeignKey {
+	for i := range s.ForeignKeys {
+		if strings.EqualFold(s.ForeignKeys[i].Name, name) {
+			return &s.ForeignKeys[i]
+		}
+	}
+	return nil
+}
+
+// addForeignKey records a FOREIGN KEY constraint of a new table
+func (s *Schema) addForeignKey(tableName string, def ConstraintDefinition) error {
+	seen := make(map[int]bool)
+	for _, name := range def.Columns {
+		i := s.ColumnIndex(name)
+		if i < 0 {
+			return fmt.Errorf("unknown column: %s", name)
+		}
+		if seen[i] {
+			return fmt.Errorf("column %s appears twice in a foreign key", name)
+		}
+		seen[i] = true
+	}
+	if len(def.RefColumns) > 0 && len(def.RefColumns) != len(def.Columns) {
+		return fmt.Errorf("foreign key (%s) has %d columns but refers to %d", strings.Join(def.Columns, ", "), len(def.Columns), len(def.RefColumns))
+	}
+
+	name := def.Name
+	if name == "" {
+		name = s.constraintName(tableName + "_" + strings.Join(def.Columns, "_") + "_fkey")
+	} else if s.hasConstraint(name) {
+		return fmt.Errorf("constraint %s already exists", name)
+	}
+
+	fk := ForeignKey{
+		Name:       name,
+		Columns:    def.Columns,
+		RefTable:   def.RefTable,
+		RefColumns: def.RefColumns,
+		OnDelete:   def.OnDelete,
+		OnUpdate:   def.OnUpdate,
+	}
+	for _, action := range []*ForeignKeyAction{&fk.OnDelete, &fk.OnUpdate} {
+		if *action == "" {
+			*action = ActionNoAction
+		}
+		if *action != ActionSetNull {
+			continue
+		}
+		for _, col := range fk.Columns {
+			if s.Column(col).NotNull {
+				return fmt.Errorf("foreign key %s: SET NULL on NOT NULL column %s", name, col)
+			}
+		}
+	}
+
+	s.ForeignKeys = append(s.ForeignKeys, fk)
+	return nil
+}
+
+// renameReferencedColumn rewrites the references of the table's foreign keys
+// to a renamed column of the table refTable
+func (s *Schema) renameReferencedColumn(refTable, oldName, newName string) bool {
+	changed := false
+	for _, fk := range s.ForeignKeys {
+		if fk.RefTable != refTable {
+			continue
+		}
+		for i, name := range fk.RefColumns {
+			if strings.EqualFold(name, oldName) {
+				fk.RefColumns[i] = newName
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// sameColumns reports whether two lists name the same columns in the same
+// order
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueKey returns the unique index whose columns are exactly the given
+// ones, or nil if they are the primary key. It reports false if neither
+// makes the columns unique.
+func (s *Schema) uniqueKey(columns []string) (*Index, bool) {
+	if sameColumns(columns, s.keyColumnNames()) {
+		return nil, true
+	}
+	for i := range s.Indexes {
+		idx := &s.Indexes[i]
+		if idx.Unique && sameColumns(columns, idx.Columns) {
+			return idx, true
+		}
+	}
+	return nil, false
+}
+
+// resolveForeignKeys checks the foreign keys of a new table against the
+// tables they refer to, which must exist unless a key refers to the new
+// table itself, and fills in the referenced columns: the primary key when
+// none were given, with their names spelled as in the referenced table.
+func (e *Executor) resolveForeignKeys(tableName string, schema *Schema) error {
+	for i := range schema.ForeignKeys {
+		fk := &schema.ForeignKeys[i]
+
+		refSchema := schema
+		if fk.RefTable != tableName {
+			table, err := e.db.GetTable(fk.RefTable)
+			if err != nil {
+				return fmt.Errorf("foreign key %s: %w", fk.Name, err)
+			}
+			if refSchema, err = tableSchema(table); err != nil {
+				return fmt.Errorf("foreign key %s: %w", fk.Name, err)
+			}
+			if refSchema == nil {
+				return fmt.Errorf("foreign key %s: key/value table %s cannot be referenced", fk.Name, fk.RefTable)
+			}
+		}
+
+		if len(fk.RefColumns) == 0 {
+			fk.RefColumns = refSchema.keyColumnNames()
+		}
+		if len(fk.RefColumns) != len(fk.Columns) {
+			return fmt.Errorf("foreign key %s has %d columns but the primary key of %s has %d", fk.Name, len(fk.Columns), fk.RefTable, len(fk.RefColumns))
+		}
+		for k, name := range fk.RefColumns {
+			refCol := refSchema.Column(name)
+			if refCol == nil {
+				return fmt.Errorf("foreign key %s: unknown column %s in table %s", fk.Name, name, fk.RefTable)
+			}
+			fk.RefColumns[k] = refCol.Name
+
+			col := schema.Column(fk.Columns[k])
+			if col.Type != refCol.Type {
+				return fmt.Errorf("foreign key %s: column %s is %s but %s.%s is %s", fk.Name, col.Name, col.Type, fk.RefTable, refCol.Name, refCol.Type)
+			}
+		}
+		if _, ok := refSchema.uniqueKey(fk.RefColumns); !ok {
+			return fmt.Errorf("foreign key %s: (%s) is neither the primary key of %s nor covered by one of its unique indexes", fk.Name, strings.Join(fk.RefColumns, ", "), fk.RefTable)
+		}
+	}
+	return nil
+}
+
+// foreignKeyRef is a foreign key of one table, found when looking for the
+// keys that refer to another table
+type foreignKeyRef struct {
+	table  string // the table the foreign key belongs to
+	schema *Schema
+	fk     *ForeignKey
+}
+
+// referencesTo returns the foreign keys that refer to a table, including
+// its own. A database that cannot list its tables has none.
+func (e *Executor) referencesTo(tableName string) ([]foreignKeyRef, error) {
+	var refs []foreignKeyRef
+	err := e.typedTables(func(name string, table Table, schema *Schema) error {
+		for i := range schema.ForeignKeys {
+			if schema.ForeignKeys[i].RefTable == tableName {
+				refs = append(refs, foreignKeyRef{table: name, schema: schema, fk: &schema.ForeignKeys[i]})
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// typedTables calls fn with every table of the database that has a schema,
+// if the database can list its tables
+func (e *Executor) typedTables(fn func(name string, table Table, schema *Schema) error) error {
+	lister, ok := e.db.(TableLister)
+	if !ok {
+		return nil
+	}
+	for _, name := range lister.ListTables() {
+		table, err := e.db.GetTable(name)
+		if err != nil {
+			continue
+		}
+		st, ok := table.(SchemaTable)
+		if !ok || st.Schema() == nil {
+			continue
+		}
+		schema, err := DecodeSchema(st.Schema())
+		if err != nil {
+			return fmt.Errorf("table %s: %w", name, err)
+		}
+		if schema.IndexTree {
+			continue
+		}
+		if err := fn(name, table, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnValues returns the values a row has for the named columns
+func columnValues(schema *Schema, columns []string, row Row) []Value {
+	values := make([]Value, len(columns))
+	for i, name := range columns {
+		values[i] = row[schema.ColumnIndex(name)]
+	}
+	return values
+}
+
+// columnEqualities maps the positions of the named columns to values, as
+// findRows takes them
+func columnEqualities(schema *Schema, columns []string, values []Value) map[int]Value {
+	equal := make(map[int]Value, len(columns))
+	for i, name := range columns {
+		equal[schema.ColumnIndex(name)] = values[i]
+	}
+	return equal
+}
+
+// sameValues reports whether two lists of values are equal, treating NULLs
+// as equal to each other
+func sameValues(a, b []Value) bool {
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || a[i] != nil && !equalValues(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// referencedPrefix returns the tree and the key prefix that hold the rows of
+// a referenced table with the given values in its referenced columns: the
+// table itself when the columns are its primary key, or the unique index on
+// them
+func referencedPrefix(tableName string, schema *Schema, columns []string, values []Value) (string, []byte, error) {
+	idx, ok := schema.uniqueKey(columns)
+	if !ok {
+		return "", nil, fmt.Errorf("table %s has no unique key on (%s)", tableName, strings.Join(columns, ", "))
+	}
+	if idx != nil {
+		prefix, err := indexPrefix(schema, idx, values)
+		return idx.Name, prefix, err
+	}
+
+	key, _, err := keyFromEqualities(schema, columnEqualities(schema, columns, values))
+	return tableName, key, err
+}
+
+// referringPrefix returns the tree and key prefix that hold exactly the
+// rows of a table with the given values in the columns of a foreign key, if
+// the key columns or an index start with those columns
+func referringPrefix(tableName string, schema *Schema, fk *ForeignKey, values []Value) (string, []byte, bool, error) {
+	keyColumns := schema.keyColumnNames()
+	if len(keyColumns) >= len(fk.Columns) && sameColumns(keyColumns[:len(fk.Columns)], fk.Columns) {
+		var prefix []byte
+		for i, name := range fk.Columns {
+			var err error
+			if prefix, err = appendKeyValue(prefix, *schema.Column(name), values[i]); err != nil {
+				return "", nil, false, err
+			}
+		}
+		return tableName, prefix, true, nil
+	}
+
+	for i := range schema.Indexes {
+		idx := &schema.Indexes[i]
+		if len(idx.Columns) >= len(fk.Columns) && sameColumns(idx.Columns[:len(fk.Columns)], fk.Columns) {
+			prefix, err := indexPrefix(schema, idx, values)
+			return idx.Name, prefix, true, err
+		}
+	}
+	return "", nil, false, nil
+}
+
+// formatValues renders values for an error message
+func formatValues(values []Value) string {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		formatted[i] = FormatValue(v)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// missingReference is the error of a row whose foreign key matches no row
+// of the referenced table
+func missingReference(tableName string, fk *ForeignKey, values []Value) error {
+	return &ConstraintError{
+		Kind:       ConstraintForeignKey,
+		Constraint: fk.Name,
+		Table:      tableName,
+		Columns:    fk.Columns,
+		RefTable:   fk.RefTable,
+		Detail:     fmt.Sprintf("no row of %s has (%s) = (%s)", fk.RefTable, strings.Join(fk.RefColumns, ", "), formatValues(values)),
+	}
+}
+
+// stillReferenced is the error of a delete or update that would leave rows
+// referring to values that no longer exist
+func stillReferenced(tableName string, fk *ForeignKey, values []Value) error {
+	return &ConstraintError{
+		Kind:       ConstraintForeignKey,
+		Constraint: fk.Name,
+		Table:      tableName,
+		Columns:    fk.Columns,
+		RefTable:   fk.RefTable,
+		Detail:     fmt.Sprintf("row of %s with (%s) = (%s) is still referenced", fk.RefTable, strings.Join(fk.RefColumns, ", "), formatValues(values)),
+	}
+}
@@ -0,0 +1,389 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Index describes a secondary index of a typed table. Its entries live in a
+// table of their own, named after the index, whose keys are the encoded
+// index columns followed by the primary key of the row and whose values are
+// empty.
+type Index struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+
+	// Constraint marks the index backing a UNIQUE constraint of the table,
+	// which lives as long as the table
+	Constraint bool `json:"constraint,omitempty"`
+}
+
+// hasColumn reports whether the index covers the named column
+func (idx *Index) hasColumn(name string) bool {
+	for _, col := range idx.Columns {
+		if strings.EqualFold(col, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Index returns the index with the given name, or nil
+func (s *Schema) Index(name string) *Index {
+	for i := range s.Indexes {
+		if strings.EqualFold(s.Indexes[i].Name, name) {
+			return &s.Indexes[i]
+		}
+	}
+	return nil
+}
+
+// AddIndex records a new index of the table
+func (s *Schema) AddIndex(idx Index) error {
+	if s.Index(idx.Name) != nil {
+		return fmt.Errorf("index %s already exists", idx.Name)
+	}
+	if len(idx.Columns) == 0 {
+		return fmt.Errorf("index %s has no columns", idx.Name)
+	}
+
+	seen := make(map[int]bool)
+	for _, name := range idx.Columns {
+		i := s.ColumnIndex(name)
+		if i < 0 {
+			return fmt.Errorf("unknown column: %s", name)
+		}
+		if seen[i] {
+			return fmt.Errorf("column %s appears twice in index %s", name, idx.Name)
+		}
+		seen[i] = true
+	}
+
+	s.Indexes = append(s.Indexes, idx)
+	return nil
+}
+
+// DropIndex removes an index from the table
+func (s *Schema) DropIndex(name string) error {
+	for i := range s.Indexes {
+		if strings.EqualFold(s.Indexes[i].Name, name) {
+			s.Indexes = append(s.Indexes[:i], s.Indexes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("index %s does not exist", name)
+}
+
+// indexTreeSchema is the schema stored with the table holding an index's
+// entries, so it is never mistaken for a table of rows
+func indexTreeSchema() []byte {
+	data, _ := (&Schema{Version: 1, IndexTree: true}).Encode()
+	return data
+}
+
+// appendIndexValue appends the encoding of one indexed value. Unlike a key
+// value it may be NULL, which sorts before every other value.
+func appendIndexValue(buf []byte, col Column, v Value) ([]byte, error) {
+	if v == nil {
+		return append(buf, 0), nil
+	}
+	return appendKeyValue(append(buf, 1), col, v)
+}
+
+// indexPrefix encodes values of the leading columns of an index. Entries of
+// rows with those values are exactly the keys that start with the result.
+func indexPrefix(schema *Schema, idx *Index, values []Value) ([]byte, error) {
+	var prefix []byte
+	for i, v := range values {
+		col := schema.Column(idx.Columns[i])
+		if col == nil {
+			return nil, fmt.Errorf("index %s: unknown column %s", idx.Name, idx.Columns[i])
+		}
+
+		var err error
+		if prefix, err = appendIndexValue(prefix, *col, v); err != nil {
+			return nil, err
+		}
+	}
+	return prefix, nil
+}
+
+// indexValues returns the values a row has for the columns of an index
+func indexValues(schema *Schema, idx *Index, row Row) []Value {
+	values := make([]Value, len(idx.Columns))
+	for i, name := range idx.Columns {
+		values[i] = row[schema.ColumnIndex(name)]
+	}
+	return values
+}
+
+// indexEntry returns the key of a row's entry in an index
+func indexEntry(schema *Schema, idx *Index, row Row, key []byte) ([]byte, error) {
+	prefix, err := indexPrefix(schema, idx, indexValues(schema, idx, row))
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, key...), nil
+}
+
+// indexEntryKey returns the primary key stored at the end of an index entry
+func indexEntryKey(schema *Schema, idx *Index, entry []byte) ([]byte, error) {
+	rest := entry
+	for _, name := range idx.Columns {
+		col := schema.Column(name)
+		if col == nil || len(rest) == 0 {
+			return nil, fmt.Errorf("index %s: corrupt entry", idx.Name)
+		}
+
+		null := rest[0] == 0
+		rest = rest[1:]
+		if null {
+			continue
+		}
+
+		n, ok := keyValueLen(rest, col.Type)
+		if !ok {
+			return nil, fmt.Errorf("index %s: corrupt entry", idx.Name)
+		}
+		rest = rest[n:]
+	}
+	return rest, nil
+}
+
+// keyValueLen returns the length of the key encoding of a value of the
+// given type at the start of b
+func keyValueLen(b []byte, t ColumnType) (int, bool) {
+	switch t {
+	case TypeInteger, TypeReal:
+		return 8, len(b) >= 8
+	case TypeBoolean:
+		return 1, len(b) >= 1
+	case TypeText, TypeBlob:
+		for i := 0; i+1 < len(b); i++ {
+			if b[i] != keyEscape {
+				continue
+			}
+			if b[i+1] == keyTerminator {
+				return i + 2, true
+			}
+			i++ // skip the escaped zero byte
+		}
+	}
+	return 0, false
+}
+
+// uniqueViolation is the error of a write that would duplicate a value of a
+// unique index
+func uniqueViolation(tableName string, idx *Index) error {
+	return &ConstraintError{Kind: ConstraintUnique, Constraint: idx.Name, Table: tableName, Columns: idx.Columns}
+}
+
+// stageIndexEntry stages adding a row's entry to an index. For a unique
+// index, the batch fails if another row already has the same values; rows
+// with a NULL in the indexed columns never conflict.
+func stageIndexEntry(batch Batch, tableName string, schema *Schema, idx *Index, row Row, key []byte) error {
+	values := indexValues(schema, idx, row)
+	prefix, err := indexPrefix(schema, idx, values)
+	if err != nil {
+		return err
+	}
+
+	if idx.Unique && !containsNull(values) {
+		batch.RequireAbsent(idx.Name, prefix, uniqueViolation(tableName, idx))
+	}
+	batch.Put(idx.Name, append(prefix, key...), nil)
+	return nil
+}
+
+// stageInsert stages inserting a new row and its index entries. The batch
+// fails with a PRIMARY KEY ConstraintError, which matches ErrDuplicateKey,
+// if the key is already in use.
+func stageInsert(batch Batch, tableName string, schema *Schema, row Row) error {
+	key, err := EncodeKey(schema, row)
+	if err != nil {
+		return err
+	}
+	data, err := EncodeRow(schema, row)
+	if err != nil {
+		return err
+	}
+
+	batch.RequireAbsent(tableName, key, &ConstraintError{
+		Kind:       ConstraintPrimaryKey,
+		Constraint: tableName + "_pkey",
+		Table:      tableName,
+		Columns:    schema.keyColumnNames(),
+	})
+	batch.Put(tableName, key, data)
+	for i := range schema.Indexes {
+		if err := stageIndexEntry(batch, tableName, schema, &schema.Indexes[i], row, key); err != nil {
+			return err
+		}
+	}
+
+	// An explicit value in an AUTOINCREMENT column moves its sequence on,
+	// so the sequence never hands it out later
+	for i, col := range schema.Columns {
+		if v, ok := row[i].(int64); ok && col.Sequence != "" {
+			batch.AdvanceSequence(col.Sequence, v)
+		}
+	}
+	return nil
+}
+
+// stageDelete stages removing a row and its index entries
+func stageDelete(batch Batch, tableName string, schema *Schema, key []byte, row Row) error {
+	batch.Delete(tableName, key)
+	for i := range schema.Indexes {
+		idx := &schema.Indexes[i]
+		entry, err := indexEntry(schema, idx, row, key)
+		if err != nil {
+			return err
+		}
+		batch.Delete(idx.Name, entry)
+	}
+	return nil
+}
+
+// indexRange is a run of consecutive entries of an index: those that start
+// with prefix, the encoded values of the leading index columns fixed by
+// equalities, and, if column is set, whose value in the index column after
+// them lies in values
+type indexRange struct {
+	prefix []byte
+	column *Column
+	values *valueRange
+}
+
+// start returns the entry a scan of the range starts after
+func (r indexRange) start() ([]byte, error) {
+	start := append([]byte(nil), r.prefix...)
+	if r.column == nil {
+		return start, nil
+	}
+	if r.values.low == nil {
+		// Skip the NULLs, which sort first and are in no range
+		return append(start, 1), nil
+	}
+	start, err := appendIndexValue(start, *r.column, r.values.low.value)
+	if err != nil {
+		return nil, err
+	}
+	if !r.values.low.inclusive {
+		// Skip the entries that have the bound itself in the column
+		start = prefixEnd(start)
+	}
+	return start, nil
+}
+
+// end returns the first entry past the range's upper bound, or nil if it
+// has none and the range ends with the entries that start with its prefix
+func (r indexRange) end() ([]byte, error) {
+	if r.column == nil || r.values.high == nil {
+		return nil, nil
+	}
+	end, err := appendIndexValue(append([]byte(nil), r.prefix...), *r.column, r.values.high.value)
+	if err != nil {
+		return nil, err
+	}
+	if r.values.high.inclusive {
+		// Take in the entries that have the bound itself in the column
+		end = prefixEnd(end)
+	}
+	return end, nil
+}
+
+// scanIndex calls fn with the primary key of every index entry in a range,
+// in index order
+func scanIndex(ctx context.Context, db Database, schema *Schema, idx *Index, r indexRange, fn func(key []byte) error) error {
+	tree, err := db.GetTable(idx.Name)
+	if err != nil {
+		return fmt.Errorf("index %s: %w", idx.Name, err)
+	}
+	start, err := r.start()
+	if err != nil {
+		return err
+	}
+	end, err := r.end()
+	if err != nil {
+		return err
+	}
+
+	iter := scanTable(ctx, tree, start)
+	for iter.ContainsNext() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry, _ := iter.Next()
+		if entry == nil {
+			continue
+		}
+		if !bytes.HasPrefix(entry, r.prefix) || end != nil && bytes.Compare(entry, end) >= 0 {
+			break
+		}
+
+		key, err := indexEntryKey(schema, idx, entry)
+		if err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return ctx.Err() // the scan may have stopped early for it
+}
+
+// chooseIndex picks the index whose leading columns are covered by the most
+// equality predicates, preferring one whose next column a range predicate
+// also bounds, and returns the range of its entries they allow. It returns
+// nil if no index starts with a constrained column.
+func chooseIndex(schema *Schema, equal map[int]Value, ranges map[int]*valueRange) (*Index, indexRange, error) {
+	var best *Index
+	var bestValues []Value
+	var bestRange *valueRange
+	for i := range schema.Indexes {
+		idx := &schema.Indexes[i]
+
+		var values []Value
+		var bounded *valueRange
+		for _, name := range idx.Columns {
+			col := schema.ColumnIndex(name)
+			if v, ok := equal[col]; ok {
+				values = append(values, v)
+				continue
+			}
+			bounded = ranges[col]
+			break
+		}
+		if len(values) > len(bestValues) || len(values) == len(bestValues) && bounded != nil && bestRange == nil {
+			best, bestValues, bestRange = idx, values, bounded
+		}
+	}
+	if best == nil {
+		return nil, indexRange{}, nil
+	}
+
+	prefix, err := indexPrefix(schema, best, bestValues)
+	if err != nil {
+		return nil, indexRange{}, err
+	}
+	r := indexRange{prefix: prefix, values: bestRange}
+	if bestRange != nil {
+		r.column = schema.Column(best.Columns[len(bestValues)])
+	}
+	return best, r, nil
+}
+
+// containsNull reports whether any of the values is NULL
+func containsNull(values []Value) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexEntryKey(t *testing.T) {
+	schema, err := NewSchema([]ColumnDefinition{
+		{Name: "id", Type: TypeInteger, PrimaryKey: true},
+		{Name: "name", Type: TypeText},
+		{Name: "score", Type: TypeReal},
+		{Name: "active", Type: TypeBoolean},
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, schema.AddIndex(Index{Name: "idx", Columns: []string{"name", "score", "active"}}))
+	idx := schema.Index("IDX")
+	require.NotNil(t, idx)
+
+	for _, row := range []Row{
+		{int64(1), "plain", 1.5, true},
+		{int64(-2), "with\x00zero\x00", nil, false},
+		{int64(3), nil, nil, nil},
+		{int64(4), "", -0.0, true},
+	} {
+		key, err := EncodeKey(schema, row)
+		require.NoError(t, err)
+		entry, err := indexEntry(schema, idx, row, key)
+		require.NoError(t, err)
+
+		got, err := indexEntryKey(schema, idx, entry)
+		require.NoError(t, err)
+		assert.Equal(t, key, got, "%v", row)
+
+		prefix, err := indexPrefix(schema, idx, []Value{row[1]})
+		require.NoError(t, err)
+		assert.Equal(t, prefix, entry[:len(prefix)])
+	}
+
+	_, err = indexEntryKey(schema, idx, []byte{1, 'a', 'b'})
+	assert.Error(t, err, "an unterminated string is corrupt")
+}
+
+func TestSchemaIndexes(t *testing.T) {
+	schema, err := NewSchema([]ColumnDefinition{
+		{Name: "id", Type: TypeInteger, PrimaryKey: true},
+		{Name: "email", Type: TypeText},
+	}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, schema.AddIndex(Index{Name: "by_email", Columns: []string{"email"}, Unique: true}))
+	assert.ErrorContains(t, schema.AddIndex(Index{Name: "by_email", Columns: []string{"id"}}), "already exists")
+	assert.ErrorContains(t, schema.AddIndex(Index{Name: "bad", Columns: []string{"missing"}}), "unknown column")
+	assert.ErrorContains(t, schema.AddIndex(Index{Name: "bad", Columns: []string{"id", "ID"}}), "appears twice")
+
+	// Indexed columns follow renames and cannot be dropped
+	require.NoError(t, schema.RenameColumn("email", "mail"))
+	assert.Equal(t, []string{"mail"}, schema.Index("by_email").Columns)
+	assert.ErrorContains(t, schema.DropColumn("mail"), "used by index by_email")
+
+	data, err := schema.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodeSchema(data)
+	require.NoError(t, err)
+	assert.Equal(t, schema.Indexes, decoded.Indexes)
+
+	require.NoError(t, schema.DropIndex("by_email"))
+	assert.Error(t, schema.DropIndex("by_email"))
+	require.NoError(t, schema.DropColumn("mail"))
+}
+
+func TestIndexRangeScans(t *testing.T) {
+	db := NewMockDatabase()
+	for _, sql := range []string{
+		"CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, age INTEGER, city TEXT)",
+		"CREATE INDEX people_by_age ON people (age)",
+		"CREATE INDEX people_by_city_age ON people (city, age)",
+	} {
+		require.True(t, ExecuteSQL(db, sql).Success, sql)
+	}
+	for i := 1; i <= 100; i++ {
+		age, city := fmt.Sprint(i), "rome"
+		if i%10 == 0 {
+			age = "NULL"
+		}
+		if i%2 == 0 {
+			city = "oslo"
+		}
+		sql := fmt.Sprintf("INSERT INTO people VALUES (%d, 'p%d', %s, '%s')", i, i, age, city)
+		require.True(t, ExecuteSQL(db, sql).Success, sql)
+	}
+	tables := map[string]*countingTable{}
+	for _, name := range []string{"people", "people_by_age", "people_by_city_age"} {
+		tables[name] = &countingTable{MockTable: db.tables[name]}
+	}
+	counting := &countingDatabase{MockDatabase: db, tables: tables}
+
+	tests := []struct {
+		where string
+		rows  int
+		index string
+		read  int // index entries, at most
+	}{
+		{"age > 95", 4, "people_by_age", 4},
+		{"age < 5", 4, "people_by_age", 5},
+		{"age BETWEEN 20 AND 29", 9, "people_by_age", 10},
+		{"30 < age AND age <= 32", 2, "people_by_age", 3},
+		{"age >= 51 AND age < 54 AND name <> 'p52'", 2, "people_by_age", 4},
+		{"city = 'oslo' AND age > 90", 4, "people_by_city_age", 5},
+		{"city = 'rome' AND age BETWEEN 11 AND 15", 3, "people_by_city_age", 4},
+	}
+	for _, tt := range tests {
+		for _, table := range tables {
+			table.read = 0
+		}
+		result := ExecuteSQL(counting, "SELECT id FROM people WHERE "+tt.where+" ORDER BY id")
+		require.True(t, result.Success, "%s: %v", tt.where, result.Error)
+		assert.Len(t, result.Rows, tt.rows, tt.where)
+		assert.LessOrEqual(t, tables[tt.index].read, tt.read, tt.where)
+		assert.Zero(t, tables["people"].read, "%s: the table is scanned", tt.where)
+
+		// An OR hides the conditions, so the same rows come from a full scan
+		full := ExecuteSQL(db, "SELECT id FROM people WHERE ("+tt.where+") OR 1 = 0 ORDER BY id")
+		require.True(t, full.Success, "%v", full.Error)
+		assert.Equal(t, full.Rows, result.Rows, tt.where)
+	}
+
+	// UPDATE and DELETE find their rows through the index as well
+	for _, table := range tables {
+		table.read = 0
+	}
+	result := ExecuteSQL(counting, "DELETE FROM people WHERE age < 3")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.LessOrEqual(t, tables["people_by_age"].read, 3)
+	assert.Zero(t, tables["people"].read)
+	result = ExecuteSQL(counting, "UPDATE people SET age = age + 100 WHERE age >= 98")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, int64(2), result.RowsAffected)
+	assert.Zero(t, tables["people"].read)
+	assert.Len(t, ExecuteSQL(db, "SELECT id FROM people WHERE age > 100").Rows, 2)
+	assert.Len(t, ExecuteSQL(db, "SELECT id FROM people WHERE age < 3").Rows, 0)
+}
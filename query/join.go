@@ -0,0 +1,487 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// relation is what a SELECT reads: one table, or tables joined together.
+// Its rows hold the columns of each table in turn, qualified by the table's
+// alias or name.
+type relation struct {
+	schema *Schema
+	tables []*relationTable
+}
+
+// relationTable is one table of a relation
+type relationTable struct {
+	name   string // alias or table name, which qualifies the table's columns
+	table  Table
+	schema *Schema // the table's own schema, qualified by name
+	join   Join    // how the table joins those before it; unused for the first
+	offset int     // position of the table's first column in the relation's rows
+
+	derived bool  // the table is a subquery in FROM, whose rows are ...
+	rows    []Row // ... these, held in memory in place of a table
+}
+
+// openRelation opens the tables a SELECT reads, running the subqueries it
+// reads in place of tables
+func (e *Executor) openRelation(ctx context.Context, stmt *SelectStatement) (*relation, error) {
+	r := &relation{schema: &Schema{}}
+	if err := e.addToRelation(ctx, r, stmt.TableName, stmt.Subquery, stmt.Alias, Join{}); err != nil {
+		return nil, err
+	}
+	for _, join := range stmt.Joins {
+		if err := e.addToRelation(ctx, r, join.TableName, join.Subquery, join.Alias, join); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// addToRelation opens a table, or runs a subquery, and joins it to a
+// relation
+func (e *Executor) addToRelation(ctx context.Context, r *relation, name string, subquery *SelectStatement, alias string, join Join) error {
+	if alias == "" {
+		alias = name
+	}
+	for _, t := range r.tables {
+		if strings.EqualFold(t.name, alias) {
+			return fmt.Errorf("table name %s specified more than once", alias)
+		}
+	}
+
+	if subquery != nil {
+		result, err := e.runSubquery(ctx, subquery, nil, -1)
+		if err != nil {
+			return fmt.Errorf("subquery %s: %w", alias, err)
+		}
+		t := r.add(alias, nil, derivedSchema(result), join)
+		t.derived, t.rows = true, result.rows
+		return nil
+	}
+
+	table, err := e.getTable(name)
+	if err != nil {
+		return err
+	}
+	schema, err := tableSchema(table)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		schema = keyValueSchema()
+	}
+	r.add(alias, table, schema, join)
+	return nil
+}
+
+// add joins a table to a relation under a name. A relation of one table
+// has that table's schema, so that scans can use its key and indexes.
+func (r *relation) add(name string, table Table, schema *Schema, join Join) *relationTable {
+	t := &relationTable{
+		name:   name,
+		table:  table,
+		schema: schema.qualified(name),
+		join:   join,
+		offset: len(r.schema.Columns),
+	}
+	r.tables = append(r.tables, t)
+	if len(r.tables) == 1 {
+		r.schema = t.schema
+		return t
+	}
+	columns := append([]Column(nil), r.schema.Columns...)
+	r.schema = &Schema{Columns: append(columns, t.schema.Columns...)}
+	return t
+}
+
+// tableRange returns the first and last of the relation's tables whose
+// columns an expression refers to, or -1 and -1 if it refers to none
+func (r *relation) tableRange(expr Expression) (int, int) {
+	first, last := -1, -1
+	walkExpression(expr, func(e Expression) error {
+		ref, ok := e.(*ColumnRef)
+		if !ok {
+			return nil
+		}
+		i, err := r.schema.resolveColumn(ref)
+		if err != nil {
+			return nil
+		}
+		t := len(r.tables) - 1
+		for r.tables[t].offset > i {
+			t--
+		}
+		if first < 0 || t < first {
+			first = t
+		}
+		if t > last {
+			last = t
+		}
+		return nil
+	})
+	return first, last
+}
+
+// scanRelation calls fn with every row of a relation that satisfies a WHERE
+// clause, reading a single table as scanWhere does. The rows of a join come
+// in the order the first table is scanned in, so when scanning it can sort
+// them as orderBy asks, so can the join; otherwise it reports false without
+// reading any rows.
+func (e *Executor) scanRelation(ctx context.Context, r *relation, where Expression, orderBy []OrderByItem, fn func(row Row) error) (bool, error) {
+	first := r.tables[0]
+	if len(r.tables) == 1 {
+		return e.scanTable(ctx, first, where, orderBy, fn)
+	}
+
+	if where != nil {
+		if err := checkColumnRefs(r.schema, where); err != nil {
+			return false, err
+		}
+	}
+	for _, item := range orderBy {
+		if _, last := r.tableRange(item.Expr); last > 0 {
+			return false, nil
+		}
+	}
+	p, err := e.planJoin(r, where)
+	if err != nil {
+		return false, err
+	}
+	return e.scanTable(ctx, first, p.where, orderBy, func(row Row) error {
+		return p.join(ctx, 0, row, fn)
+	})
+}
+
+// scanTable calls fn with every row of a relation's table that satisfies a
+// WHERE clause, as scanWhere does. The rows of a subquery come in no
+// particular order.
+func (e *Executor) scanTable(ctx context.Context, t *relationTable, where Expression, orderBy []OrderByItem, fn func(row Row) error) (bool, error) {
+	if !t.derived {
+		return e.scanWhere(ctx, t.table, t.schema, where, orderBy, func(key []byte, row Row) error {
+			return fn(row)
+		})
+	}
+
+	if len(orderBy) > 0 {
+		return false, nil
+	}
+	if where != nil {
+		if err := checkColumnRefs(t.schema, where); err != nil {
+			return false, err
+		}
+	}
+	for _, row := range t.rows {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if where != nil {
+			v, err := (&evalContext{ctx: ctx, exec: e, schema: t.schema, row: row}).condition(where)
+			if err != nil {
+				return false, err
+			}
+			if v != true {
+				continue
+			}
+		}
+		if err := fn(row); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// joinPlan joins the rows of a relation's first table to its other tables
+// in turn
+type joinPlan struct {
+	exec  *Executor
+	where Expression // the WHERE conditions on the first table alone
+	steps []*joinStep
+}
+
+// joinStep joins a table to the rows of the tables before it. It looks up
+// the rows that match each row through the table's key or an index when the
+// ON conditions compare the columns of either with the tables before; it
+// reads the table once into a hash table keyed by the columns ON compares
+// when there is no such key or index; and it reads the table once and tries
+// every row against every row before it when ON compares no columns.
+type joinStep struct {
+	*relationTable
+	before *Schema      // the columns of the tables before this one
+	prefix *Schema      // the columns up to and including this table's
+	where  Expression   // conditions on this table alone, used to read it
+	keys   []int        // columns of this table ON compares for equality ...
+	values []Expression // ... with these expressions on the rows before it
+	lookup bool         // the keys cover the table's key or an index
+	filter Expression   // WHERE conditions to apply once this table is joined
+
+	loaded bool
+	rows   []Row            // the table's rows, for a nested-loop join
+	hashed map[string][]Row // the table's rows by their keys, for a hash join
+}
+
+// planJoin decides how to join the tables of a relation, and moves each
+// WHERE condition to the earliest table whose rows can decide it
+func (e *Executor) planJoin(r *relation, where Expression) (*joinPlan, error) {
+	p := &joinPlan{exec: e}
+	for i, t := range r.tables[1:] {
+		s := &joinStep{
+			relationTable: t,
+			before:        &Schema{Columns: r.schema.Columns[:t.offset]},
+			prefix:        &Schema{Columns: r.schema.Columns[:t.offset+len(t.schema.Columns)]},
+		}
+		if t.join.On != nil {
+			if err := checkColumnRefs(s.prefix, t.join.On); err != nil {
+				return nil, fmt.Errorf("JOIN %s: %w", t.name, err)
+			}
+			for _, cond := range conjuncts(t.join.On) {
+				s.planCondition(r, i+1, cond)
+			}
+		}
+		p.steps = append(p.steps, s)
+	}
+
+	for _, cond := range conjuncts(where) {
+		first, last := r.tableRange(cond)
+		if last <= 0 {
+			p.where = and(p.where, cond)
+			continue
+		}
+		s := p.steps[last-1]
+		if first == last && s.join.Kind != LeftJoin {
+			// An inner join only keeps rows that satisfy it anyway
+			s.where = and(s.where, cond)
+		} else {
+			s.filter = and(s.filter, cond)
+		}
+	}
+
+	for _, s := range p.steps {
+		if len(s.keys) == 0 || s.derived {
+			continue
+		}
+		equal := make(map[int]Value, len(s.keys))
+		for _, i := range s.keys {
+			equal[i] = nil
+		}
+		s.lookup = true
+		for _, i := range s.schema.keyColumns() {
+			if _, ok := equal[i]; !ok {
+				s.lookup = false
+			}
+		}
+		// The values are placeholders, so only the choice of index matters
+		if idx, _, _ := chooseIndex(s.schema, equal, nil); idx != nil {
+			s.lookup = true
+		}
+	}
+	return p, nil
+}
+
+// planCondition notes an ON condition that can choose the rows of the
+// step's table, the me-th of the relation: one on that table alone, or an
+// equality between one of its columns and an expression on the tables
+// before it. The whole ON clause is still tested on every row joined.
+func (s *joinStep) planCondition(r *relation, me int, cond Expression) {
+	if first, last := r.tableRange(cond); first == me && last == me {
+		s.where = and(s.where, cond)
+		return
+	}
+
+	b, ok := cond.(*BinaryExpression)
+	if !ok || b.Operator != "=" {
+		return
+	}
+	for _, sides := range [][2]Expression{{b.Left, b.Right}, {b.Right, b.Left}} {
+		ref, ok := sides[0].(*ColumnRef)
+		if !ok {
+			continue
+		}
+		i, err := r.schema.resolveColumn(ref)
+		if err != nil || i < s.offset {
+			continue
+		}
+		if first, last := r.tableRange(sides[1]); first < 0 || last >= me {
+			continue
+		}
+		s.keys = append(s.keys, i-s.offset)
+		s.values = append(s.values, sides[1])
+		return
+	}
+}
+
+// join joins a row of the tables before the i-th join step to the rest of
+// the relation, calling fn with every row that results
+func (p *joinPlan) join(ctx context.Context, i int, row Row, fn func(row Row) error) error {
+	if i == len(p.steps) {
+		return fn(row)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := p.steps[i]
+	matched := false
+	err := s.matches(ctx, p.exec, row, func(inner Row) error {
+		joined := append(row[:len(row):len(row)], inner...)
+		if s.join.On != nil {
+			v, err := (&evalContext{ctx: ctx, exec: p.exec, schema: s.prefix, row: joined}).condition(s.join.On)
+			if err != nil || v != true {
+				return err
+			}
+		}
+		matched = true
+		return p.next(ctx, i, joined, fn)
+	})
+	if err != nil || matched || s.join.Kind != LeftJoin {
+		return err
+	}
+	// A LEFT JOIN keeps a row that matches nothing, with NULLs for the table
+	return p.next(ctx, i, append(row[:len(row):len(row)], make(Row, len(s.schema.Columns))...), fn)
+}
+
+// next applies the WHERE conditions the i-th join step decides to a row it
+// joined, and joins the rows that satisfy them to the next table
+func (p *joinPlan) next(ctx context.Context, i int, row Row, fn func(row Row) error) error {
+	s := p.steps[i]
+	if s.filter != nil {
+		v, err := (&evalContext{ctx: ctx, exec: p.exec, schema: s.prefix, row: row}).condition(s.filter)
+		if err != nil || v != true {
+			return err
+		}
+	}
+	return p.join(ctx, i+1, row, fn)
+}
+
+// matches calls fn with the rows of the step's table that may join a row of
+// the tables before it
+func (s *joinStep) matches(ctx context.Context, e *Executor, row Row, fn func(inner Row) error) error {
+	var values []Value
+	if len(s.keys) > 0 {
+		var ok bool
+		var err error
+		if values, ok, err = s.keyValues(ctx, e, row); err != nil || !ok {
+			return err
+		}
+	}
+
+	if s.lookup {
+		where := s.where
+		for k, i := range s.keys {
+			ref := &ColumnRef{Table: s.name, Name: s.schema.Columns[i].Name}
+			where = and(where, &BinaryExpression{Left: ref, Operator: "=", Right: &Literal{Value: values[k]}})
+		}
+		return e.whereRows(ctx, s.table, s.schema, where, func(key []byte, inner Row) error {
+			return fn(inner)
+		})
+	}
+
+	if err := s.load(ctx, e); err != nil {
+		return err
+	}
+	rows := s.rows
+	if s.hashed != nil {
+		hash, err := hashValues(values)
+		if err != nil {
+			return err
+		}
+		rows = s.hashed[hash]
+	}
+	for _, inner := range rows {
+		if err := fn(inner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyValues evaluates the expressions ON compares the step's key columns
+// with on a row of the tables before it, converted to the columns' types.
+// It reports false when a value is NULL or cannot be converted, since then
+// no row can be equal.
+func (s *joinStep) keyValues(ctx context.Context, e *Executor, row Row) ([]Value, bool, error) {
+	c := &evalContext{ctx: ctx, exec: e, schema: s.before, row: row}
+	values := make([]Value, len(s.keys))
+	for k, expr := range s.values {
+		v, err := c.eval(expr)
+		if err != nil || v == nil {
+			return nil, false, err
+		}
+		if values[k], err = coerceValue(v, s.schema.Columns[s.keys[k]].Type); err != nil {
+			return nil, false, nil
+		}
+	}
+	return values, true, nil
+}
+
+// load reads the rows of the step's table that satisfy its own conditions,
+// the first time they are needed, hashing them by their key columns if ON
+// compares any
+func (s *joinStep) load(ctx context.Context, e *Executor) error {
+	if s.loaded {
+		return nil
+	}
+	if len(s.keys) > 0 {
+		s.hashed = make(map[string][]Row)
+	}
+	_, err := e.scanTable(ctx, s.relationTable, s.where, nil, func(row Row) error {
+		if s.hashed == nil {
+			s.rows = append(s.rows, row)
+			return nil
+		}
+		values := make([]Value, len(s.keys))
+		for k, i := range s.keys {
+			if values[k] = row[i]; values[k] == nil {
+				return nil // never equal to anything
+			}
+		}
+		hash, err := hashValues(values)
+		if err != nil {
+			return err
+		}
+		s.hashed[hash] = append(s.hashed[hash], row)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// hashValues returns a hash key for a list of values
+func hashValues(values []Value) (string, error) {
+	var hash []byte
+	for _, v := range values {
+		var err error
+		if hash, err = appendHashValue(hash, v); err != nil {
+			return "", err
+		}
+	}
+	return string(hash), nil
+}
+
+// conjuncts splits a condition into the conditions AND joins
+func conjuncts(expr Expression) []Expression {
+	if b, ok := expr.(*BinaryExpression); ok && b.Operator == "AND" {
+		return append(conjuncts(b.Left), conjuncts(b.Right)...)
+	}
+	if expr == nil {
+		return nil
+	}
+	return []Expression{expr}
+}
+
+// and joins two conditions with AND, either of which may be missing
+func and(a, b Expression) Expression {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return &BinaryExpression{Left: a, Operator: "AND", Right: b}
+}
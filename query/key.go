@@ -0,0 +1,87 @@
+package query
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Escapes used by the key encoding of TEXT and BLOB values. A zero byte in
+// the value becomes 0x00 0xFF and the value ends with 0x00 0x01, so shorter
+// values sort before longer ones that share their prefix.
+const (
+	keyEscape     = 0x00
+	keyEscapedNul = 0xFF
+	keyTerminator = 0x01
+)
+
+// EncodeKey encodes the key columns of a row so that bytes.Compare on the
+// result orders rows the same way comparing their key values would. Each
+// value is encoded by its column type:
+//
+//   - INTEGER: 8 bytes big-endian with the sign bit flipped
+//   - REAL: 8 bytes big-endian, sign bit flipped for positive numbers and
+//     every bit flipped for negative ones
+//   - TEXT and BLOB: escaped bytes followed by a terminator
+//   - BOOLEAN: one byte, 0 or 1
+//
+// Key values cannot be NULL.
+func EncodeKey(schema *Schema, row Row) ([]byte, error) {
+	var key []byte
+	for _, i := range schema.keyColumns() {
+		var err error
+		if key, err = appendKeyValue(key, schema.Columns[i], row[i]); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// appendKeyValue appends the key encoding of one value of a column
+func appendKeyValue(buf []byte, col Column, v Value) ([]byte, error) {
+	if v == nil {
+		return nil, fmt.Errorf("column %s: key cannot be NULL", col.Name)
+	}
+	if err := checkValueType(v, col.Type); err != nil {
+		return nil, fmt.Errorf("column %s: %w", col.Name, err)
+	}
+
+	switch v := v.(type) {
+	case int64:
+		return binary.BigEndian.AppendUint64(buf, uint64(v)^(1<<63)), nil
+	case float64:
+		if v == 0 {
+			v = 0 // -0 and +0 are the same key
+		}
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return binary.BigEndian.AppendUint64(buf, bits), nil
+	case string:
+		return appendKeyBytes(buf, []byte(v)), nil
+	case []byte:
+		return appendKeyBytes(buf, v), nil
+	case bool:
+		if v {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	default:
+		return nil, fmt.Errorf("column %s: cannot use %T in a key", col.Name, v)
+	}
+}
+
+// appendKeyBytes appends an escaped, terminated byte string
+func appendKeyBytes(buf, b []byte) []byte {
+	for _, c := range b {
+		if c == keyEscape {
+			buf = append(buf, keyEscape, keyEscapedNul)
+		} else {
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, keyEscape, keyTerminator)
+}
@@ -0,0 +1,75 @@
+package query
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeKeyPreservesOrder(t *testing.T) {
+	tests := []struct {
+		typ    ColumnType
+		sorted []Value
+	}{
+		{TypeInteger, []Value{int64(math.MinInt64), int64(-10), int64(-9), int64(-1), int64(0), int64(1), int64(9), int64(10), int64(math.MaxInt64)}},
+		{TypeReal, []Value{math.Inf(-1), -1e10, -2.5, -0.5, 0.0, 0.25, 1.0, 9.0, 10.0, math.Inf(1)}},
+		{TypeText, []Value{"", "\x00", "\x00a", "a", "a\x00", "a\x00b", "ab", "b", "ba"}},
+		{TypeBlob, []Value{[]byte{}, []byte{0}, []byte{0, 0}, []byte{0, 1}, []byte{1}, []byte{0xFF}}},
+		{TypeBoolean, []Value{false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ.String(), func(t *testing.T) {
+			schema, err := NewSchema([]ColumnDefinition{{Name: "k", Type: tt.typ, PrimaryKey: true}}, nil)
+			require.NoError(t, err)
+
+			var keys [][]byte
+			for _, v := range tt.sorted {
+				key, err := EncodeKey(schema, Row{v})
+				require.NoError(t, err)
+				keys = append(keys, key)
+			}
+			assert.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+				return bytes.Compare(keys[i], keys[j]) < 0
+			}), "keys are not in value order")
+			for i := 1; i < len(keys); i++ {
+				assert.NotEqual(t, keys[i-1], keys[i])
+			}
+		})
+	}
+}
+
+func TestEncodeCompositeKey(t *testing.T) {
+	schema, err := NewSchema([]ColumnDefinition{
+		{Name: "name", Type: TypeText},
+		{Name: "region", Type: TypeText},
+		{Name: "id", Type: TypeInteger},
+	}, []string{"region", "id"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, schema.keyColumns())
+	assert.True(t, schema.Column("region").NotNull)
+
+	// Rows sort by region first, then by id; a shorter region never sorts
+	// after a longer one that starts with it
+	rows := []Row{
+		{"x", "eu", int64(-3)},
+		{"x", "eu", int64(2)},
+		{"x", "eu", int64(10)},
+		{"x", "eu-west", int64(1)},
+		{"x", "us", int64(-100)},
+	}
+	var prev []byte
+	for _, row := range rows {
+		key, err := EncodeKey(schema, row)
+		require.NoError(t, err)
+		assert.Equal(t, -1, bytes.Compare(prev, key), "%v", row)
+		prev = key
+	}
+
+	_, err = EncodeKey(schema, Row{"x", nil, int64(1)})
+	assert.ErrorContains(t, err, "region: key cannot be NULL")
+}
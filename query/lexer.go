@@ -1,5 +1,7 @@
 package query
 
+import "strings"
+
 // Lexer tokenizes SQL strings
 type Lexer struct {
 	input        string
@@ -43,8 +45,35 @@ func (l *Lexer) NextToken() Token {
 	switch l.ch {
 	case '=':
 		tok = Token{Type: EQUAL, Literal: string(l.ch), Pos: l.position}
+	case '<':
+		switch l.peekChar() {
+		case '=':
+			tok = Token{Type: LESS_EQ, Literal: "<=", Pos: l.position}
+			l.readChar()
+		case '>':
+			tok = Token{Type: NOT_EQUAL, Literal: "<>", Pos: l.position}
+			l.readChar()
+		default:
+			tok = Token{Type: LESS, Literal: string(l.ch), Pos: l.position}
+		}
+	case '>':
+		if l.peekChar() == '=' {
+			tok = Token{Type: GREATER_EQ, Literal: ">=", Pos: l.position}
+			l.readChar()
+		} else {
+			tok = Token{Type: GREATER, Literal: string(l.ch), Pos: l.position}
+		}
+	case '!':
+		if l.peekChar() == '=' {
+			tok = Token{Type: NOT_EQUAL, Literal: "!=", Pos: l.position}
+			l.readChar()
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: string(l.ch), Pos: l.position}
+		}
 	case ',':
 		tok = Token{Type: COMMA, Literal: string(l.ch), Pos: l.position}
+	case '.':
+		tok = Token{Type: DOT, Literal: string(l.ch), Pos: l.position}
 	case ';':
 		tok = Token{Type: SEMICOLON, Literal: string(l.ch), Pos: l.position}
 	case '(':
@@ -53,11 +82,41 @@ func (l *Lexer) NextToken() Token {
 		tok = Token{Type: RPAREN, Literal: string(l.ch), Pos: l.position}
 	case '*':
 		tok = Token{Type: ASTERISK, Literal: string(l.ch), Pos: l.position}
+	case '-':
+		tok = Token{Type: MINUS, Literal: string(l.ch), Pos: l.position}
+	case '+':
+		tok = Token{Type: PLUS, Literal: string(l.ch), Pos: l.position}
+	case '/':
+		tok = Token{Type: SLASH, Literal: string(l.ch), Pos: l.position}
+	case '%':
+		tok = Token{Type: PERCENT, Literal: string(l.ch), Pos: l.position}
+	case '?':
+		tok = Token{Type: PARAMETER, Literal: string(l.ch), Pos: l.position}
+	case '$':
+		if !isDigit(l.peekChar()) {
+			tok = Token{Type: ILLEGAL, Literal: string(l.ch), Pos: l.position}
+			break
+		}
+		tok.Type = PARAMETER
+		tok.Pos = l.position
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+		tok.Literal = l.input[tok.Pos:l.position]
+		return tok
+	case '|':
+		if l.peekChar() == '|' {
+			tok = Token{Type: CONCAT, Literal: "||", Pos: l.position}
+			l.readChar()
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: string(l.ch), Pos: l.position}
+		}
 	case '\'':
 		tok.Type = STRING
-		tok.Literal = l.readString()
 		tok.Pos = l.position
-		// Note: readString positions us at the closing quote, 
+		tok.Literal = l.readString()
+		// Note: readString positions us at the closing quote,
 		// we need to advance past it for the next token
 		l.readChar()
 		return tok
@@ -94,25 +153,38 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// readNumber reads a numeric literal
+// readNumber reads a numeric literal, with an optional fractional part
 func (l *Lexer) readNumber() string {
 	position := l.position
 	for isDigit(l.ch) {
 		l.readChar()
 	}
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
 	return l.input[position:l.position]
 }
 
-// readString reads a string literal enclosed in single quotes
+// readString reads a string literal enclosed in single quotes. A quote
+// inside the literal is written as two quotes.
 func (l *Lexer) readString() string {
-	position := l.position + 1
+	var sb strings.Builder
 	for {
 		l.readChar()
+		if l.ch == '\'' && l.peekChar() == '\'' {
+			sb.WriteByte('\'')
+			l.readChar()
+			continue
+		}
 		if l.ch == '\'' || l.ch == 0 {
 			break
 		}
+		sb.WriteByte(l.ch)
 	}
-	return l.input[position:l.position]
+	return sb.String()
 }
 
 // skipWhitespace skips whitespace characters
@@ -130,4 +202,4 @@ func isLetter(ch byte) bool {
 // isDigit checks if the character is a digit
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
-}
\ No newline at end of file
+}
@@ -0,0 +1,240 @@
+package query
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// errLimitReached stops a scan once a LIMIT has been met
+var errLimitReached = errors.New("limit reached")
+
+// resolveOrderBy returns the sort keys of an ORDER BY with references to
+// the select list replaced by the expressions they name
+func resolveOrderBy(schema *Schema, items []SelectColumn, orderBy []OrderByItem) ([]OrderByItem, error) {
+	resolved := make([]OrderByItem, len(orderBy))
+	for i, item := range orderBy {
+		var err error
+		if item.Expr, err = resolveSelectRef(schema, items, "ORDER BY", item.Expr); err != nil {
+			return nil, err
+		}
+		resolved[i] = item
+	}
+	return resolved, nil
+}
+
+// resolveSelectRef replaces an ORDER BY or GROUP BY expression that refers
+// to the select list with the expression it names: a position, as in
+// ORDER BY 2, or an alias that is not also a column
+func resolveSelectRef(schema *Schema, items []SelectColumn, clause string, expr Expression) (Expression, error) {
+	switch x := expr.(type) {
+	case *Literal:
+		n, ok := x.Value.(int64)
+		if !ok {
+			break
+		}
+		if n < 1 || n > int64(len(items)) {
+			return nil, fmt.Errorf("%s position %d is not in the select list", clause, n)
+		}
+		expr = items[n-1].Expr
+	case *ColumnRef:
+		if x.Table != "" || schema.ColumnIndex(x.Name) >= 0 {
+			break
+		}
+		for _, col := range items {
+			if strings.EqualFold(col.Alias, x.Name) {
+				expr = col.Expr
+				break
+			}
+		}
+	}
+	if err := checkColumnRefs(schema, expr); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// rowCount evaluates the count of a LIMIT or OFFSET clause
+func (e *Executor) rowCount(clause string, expr Expression) (int64, error) {
+	v, err := (&evalContext{exec: e}).eval(expr)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", clause, err)
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%s needs a non-negative integer, not %s", clause, FormatValue(v))
+	}
+	return n, nil
+}
+
+// orderedRows calls fn with the rows of a relation that satisfy a WHERE
+// clause, sorted as an ORDER BY asks, leaving out the first offset rows and
+// stopping after limit rows when limit is not negative. Rows come straight
+// from the scan when key order sorts them; otherwise a heap keeps the first
+// offset+limit rows, or every row is sorted when there is no limit.
+func (e *Executor) orderedRows(ctx context.Context, r *relation, where Expression, orderBy []OrderByItem, limit, offset int64, fn func(row Row) error) error {
+	if limit == 0 {
+		return nil
+	}
+
+	// emit passes on the rows after the offset until the limit is reached
+	var seen int64
+	emit := func(row Row) error {
+		seen++
+		if seen <= offset {
+			return nil
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+		if limit >= 0 && seen-offset >= limit {
+			return errLimitReached
+		}
+		return nil
+	}
+
+	ordered, err := e.scanRelation(ctx, r, where, orderBy, emit)
+	if ordered || err != nil {
+		if errors.Is(err, errLimitReached) {
+			return nil
+		}
+		return err
+	}
+
+	sorter := &rowSorter{exec: e, schema: r.schema, orderBy: orderBy, keep: -1}
+	if limit >= 0 {
+		sorter.keep = offset + limit
+	}
+	_, err = e.scanRelation(ctx, r, where, nil, sorter.add)
+	if err != nil {
+		return err
+	}
+	rows, err := sorter.sorted()
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := emit(row); err != nil {
+			if errors.Is(err, errLimitReached) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sortRow is a row with the values of its sort keys
+type sortRow struct {
+	row  Row
+	keys []Value
+	seq  int // position in the scan, which breaks ties
+}
+
+// rowSorter sorts rows by the keys of an ORDER BY. With keep set to zero or
+// more it only holds on to that many of the first rows, in a heap with the
+// last of them on top.
+type rowSorter struct {
+	exec    *Executor
+	schema  *Schema
+	orderBy []OrderByItem
+	keep    int64
+	rows    []sortRow
+	added   int   // rows added so far
+	err     error // the first error comparing rows
+}
+
+// add evaluates the sort keys of a row and keeps it if it may be among the
+// first rows
+func (s *rowSorter) add(row Row) error {
+	c := &evalContext{exec: s.exec, schema: s.schema, row: row}
+	r := sortRow{row: row, keys: make([]Value, len(s.orderBy)), seq: s.added}
+	s.added++
+	for i, item := range s.orderBy {
+		v, err := c.eval(item.Expr)
+		if err != nil {
+			return err
+		}
+		r.keys[i] = v
+	}
+
+	switch {
+	case s.keep < 0:
+		s.rows = append(s.rows, r)
+	case int64(len(s.rows)) < s.keep:
+		heap.Push(s, r)
+	case s.keep > 0 && s.before(r, s.rows[0]):
+		s.rows[0] = r
+		heap.Fix(s, 0)
+	}
+	return s.err
+}
+
+// sorted returns the rows kept, in order
+func (s *rowSorter) sorted() ([]Row, error) {
+	sort.Slice(s.rows, func(i, j int) bool { return s.before(s.rows[i], s.rows[j]) })
+	if s.err != nil {
+		return nil, s.err
+	}
+	rows := make([]Row, len(s.rows))
+	for i, r := range s.rows {
+		rows[i] = r.row
+	}
+	return rows, nil
+}
+
+// before reports whether a sorts before b
+func (s *rowSorter) before(a, b sortRow) bool {
+	for i, item := range s.orderBy {
+		c, err := compareSortKeys(a.keys[i], b.keys[i], item)
+		if err != nil {
+			if s.err == nil {
+				s.err = err
+			}
+			return false
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return a.seq < b.seq
+}
+
+// heap.Interface, ordered so the row that sorts last is on top
+func (s *rowSorter) Len() int           { return len(s.rows) }
+func (s *rowSorter) Less(i, j int) bool { return s.before(s.rows[j], s.rows[i]) }
+func (s *rowSorter) Swap(i, j int)      { s.rows[i], s.rows[j] = s.rows[j], s.rows[i] }
+func (s *rowSorter) Push(x any)         { s.rows = append(s.rows, x.(sortRow)) }
+func (s *rowSorter) Pop() any {
+	last := s.rows[len(s.rows)-1]
+	s.rows = s.rows[:len(s.rows)-1]
+	return last
+}
+
+// compareSortKeys orders two values of one ORDER BY sort key. NULLs sort
+// after every other value unless the item says otherwise, and a descending
+// sort key also reverses where they go.
+func compareSortKeys(a, b Value, item OrderByItem) (int, error) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, nil
+		}
+		nullsFirst := item.Nulls == NullsFirst || item.Nulls == NullsDefault && item.Desc
+		if (a == nil) == nullsFirst {
+			return -1, nil
+		}
+		return 1, nil
+	}
+
+	c, err := compareMixed(a, b)
+	if err != nil {
+		return 0, err
+	}
+	if item.Desc {
+		c = -c
+	}
+	return c, nil
+}
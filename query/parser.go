@@ -2,6 +2,7 @@ package query
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -11,6 +12,10 @@ type Parser struct {
 	
 	curToken  Token
 	peekToken Token
+	
+	params     int          // the highest parameter number seen
+	paramStyle byte         // '?' or '$', once a parameter is seen
+	paramsSeen map[int]bool // the numbers of the $n parameters seen
 }
 
 // New creates a new parser instance
@@ -32,6 +37,45 @@ func (p *Parser) nextToken() {
 
 // Parse parses a SQL statement and returns the AST
 func (p *Parser) Parse() (Statement, error) {
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+	
+	// The statement must use up the input, apart from a closing semicolon
+	if p.curToken.Type != EOF {
+		p.nextToken()
+	}
+	if p.curToken.Type == SEMICOLON {
+		p.nextToken()
+	}
+	if p.curToken.Type != EOF {
+		return nil, fmt.Errorf("unexpected %q after statement", p.curToken.Literal)
+	}
+	
+	// Only statements that read and write rows take parameters; the others
+	// keep their expressions in the schema
+	if p.params > 0 {
+		switch stmt.(type) {
+		case *SelectStatement, *InsertStatement, *UpdateStatement, *DeleteStatement:
+		default:
+			return nil, fmt.Errorf("parameters cannot be used in %s", stmt)
+		}
+	}
+	
+	// Every argument has to be used, so $n parameters leave no gaps
+	if p.paramStyle == '$' {
+		for n := 1; n < p.params; n++ {
+			if !p.paramsSeen[n] {
+				return nil, fmt.Errorf("parameter $%d is missing; parameters are numbered from $1 without gaps", n)
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// parseStatement parses the statement the current token starts
+func (p *Parser) parseStatement() (Statement, error) {
 	switch p.curToken.Type {
 	case SELECT:
 		return p.parseSelectStatement()
@@ -41,6 +85,24 @@ func (p *Parser) Parse() (Statement, error) {
 		return p.parseUpdateStatement()
 	case DELETE:
 		return p.parseDeleteStatement()
+	case CREATE:
+		if p.peekToken.Type == UNIQUE || p.peekToken.Type == INDEX {
+			return p.parseCreateIndexStatement()
+		}
+		if p.peekToken.Type == SEQUENCE {
+			return p.parseCreateSequenceStatement()
+		}
+		return p.parseCreateStatement()
+	case DROP:
+		if p.peekToken.Type == INDEX {
+			return p.parseDropIndexStatement()
+		}
+		if p.peekToken.Type == SEQUENCE {
+			return p.parseDropSequenceStatement()
+		}
+		return p.parseDropStatement()
+	case ALTER:
+		return p.parseAlterStatement()
 	default:
 		return nil, fmt.Errorf("unexpected token: %s", p.curToken.Literal)
 	}
@@ -55,16 +117,16 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		return nil, fmt.Errorf("expected SELECT")
 	}
 	
-	// Parse columns
-	if p.peekToken.Type == ASTERISK {
-		p.nextToken()
-		stmt.Columns = []string{"*"}
-	} else {
-		columns, err := p.parseColumnList()
+	// Parse the select list
+	for {
+		column, err := p.parseSelectColumn()
 		if err != nil {
 			return nil, err
 		}
-		stmt.Columns = columns
+		stmt.Columns = append(stmt.Columns, column)
+		if !p.expectPeek(COMMA) {
+			break
+		}
 	}
 	
 	// Expect FROM
@@ -72,11 +134,21 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		return nil, fmt.Errorf("expected FROM")
 	}
 	
-	// Parse table name
-	if !p.expectPeek(IDENTIFIER) {
-		return nil, fmt.Errorf("expected table name")
+	// Parse the table and those joined to it
+	var err error
+	if stmt.TableName, stmt.Subquery, stmt.Alias, err = p.parseTableRef(); err != nil {
+		return nil, err
+	}
+	for {
+		join, ok, err := p.parseJoin()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		stmt.Joins = append(stmt.Joins, join)
 	}
-	stmt.TableName = p.curToken.Literal
 	
 	// Optional WHERE clause
 	if p.peekToken.Type == WHERE {
@@ -88,9 +160,197 @@ func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
 		stmt.Where = where
 	}
 	
+	// Optional GROUP BY and HAVING clauses
+	if p.expectPeek(GROUP) {
+		if !p.expectPeek(BY) {
+			return nil, fmt.Errorf("expected BY after GROUP")
+		}
+		groupBy, err := p.parseExpressionList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.GroupBy = groupBy
+	}
+	if p.expectPeek(HAVING) {
+		having, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Having = having
+	}
+	
+	// Optional ORDER BY clause
+	if p.expectPeek(ORDER) {
+		if !p.expectPeek(BY) {
+			return nil, fmt.Errorf("expected BY after ORDER")
+		}
+		for {
+			item, err := p.parseOrderByItem()
+			if err != nil {
+				return nil, err
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if !p.expectPeek(COMMA) {
+				break
+			}
+		}
+	}
+	
+	// Optional LIMIT and OFFSET
+	if p.expectPeek(LIMIT) {
+		if stmt.Limit, err = p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
+	if p.expectPeek(OFFSET) {
+		if stmt.Offset, err = p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
+	
 	return stmt, nil
 }
 
+// parseTableRef parses a table in a FROM clause and its alias: name
+// [[AS] alias], or a subquery, which must have one: (SELECT ...) [AS] alias
+func (p *Parser) parseTableRef() (string, *SelectStatement, string, error) {
+	var name string
+	var subquery *SelectStatement
+	switch {
+	case p.expectPeek(LPAREN):
+		var err error
+		if subquery, err = p.parseSubquery(); err != nil {
+			return "", nil, "", err
+		}
+	case p.expectIdentifier():
+		name = p.curToken.Literal
+	default:
+		return "", nil, "", fmt.Errorf("expected table name")
+	}
+	
+	alias := ""
+	if p.expectPeek(AS) {
+		if !p.expectIdentifier() {
+			return "", nil, "", fmt.Errorf("expected alias after AS")
+		}
+		alias = p.curToken.Literal
+	} else if p.expectIdentifier() {
+		alias = p.curToken.Literal
+	}
+	if subquery != nil && alias == "" {
+		return "", nil, "", fmt.Errorf("a subquery in FROM needs an alias")
+	}
+	return name, subquery, alias, nil
+}
+
+// parseSubquery parses a SELECT in parentheses and its closing parenthesis.
+// The current token is the opening parenthesis.
+func (p *Parser) parseSubquery() (*SelectStatement, error) {
+	if !p.expectPeek(SELECT) {
+		return nil, fmt.Errorf("expected SELECT after (")
+	}
+	stmt, err := p.parseSelectStatement()
+	if err != nil {
+		return nil, err
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil, fmt.Errorf("expected ) after subquery")
+	}
+	return stmt, nil
+}
+
+// parseJoin parses the next table joined in a FROM clause, if there is one:
+// , t | CROSS JOIN t | [INNER] JOIN t ON cond | LEFT [OUTER] JOIN t ON cond
+func (p *Parser) parseJoin() (Join, bool, error) {
+	join := Join{Kind: InnerJoin}
+	switch {
+	case p.expectPeek(COMMA):
+		join.Kind = CrossJoin
+	case p.expectPeek(CROSS):
+		join.Kind = CrossJoin
+		if !p.expectPeek(JOIN) {
+			return Join{}, false, fmt.Errorf("expected JOIN after CROSS")
+		}
+	case p.expectPeek(INNER):
+		if !p.expectPeek(JOIN) {
+			return Join{}, false, fmt.Errorf("expected JOIN after INNER")
+		}
+	case p.expectPeek(LEFT):
+		join.Kind = LeftJoin
+		p.expectPeek(OUTER)
+		if !p.expectPeek(JOIN) {
+			return Join{}, false, fmt.Errorf("expected JOIN after LEFT")
+		}
+	case p.expectPeek(JOIN):
+	default:
+		return Join{}, false, nil
+	}
+	
+	var err error
+	if join.TableName, join.Subquery, join.Alias, err = p.parseTableRef(); err != nil {
+		return Join{}, false, err
+	}
+	if join.Kind != CrossJoin {
+		if !p.expectPeek(ON) {
+			return Join{}, false, fmt.Errorf("expected ON after JOIN %s", tableRefSQL(join.TableName, join.Subquery, join.Alias))
+		}
+		if join.On, err = p.parseExpression(); err != nil {
+			return Join{}, false, err
+		}
+	}
+	return join, true, nil
+}
+
+// parseOrderByItem parses one sort key of an ORDER BY clause:
+// expr [ASC|DESC] [NULLS FIRST|LAST]
+func (p *Parser) parseOrderByItem() (OrderByItem, error) {
+	expr, err := p.parseExpression()
+	if err != nil {
+		return OrderByItem{}, err
+	}
+	item := OrderByItem{Expr: expr}
+	if !p.expectPeek(ASC) && p.expectPeek(DESC) {
+		item.Desc = true
+	}
+	if p.expectPeek(NULLS) {
+		switch {
+		case p.expectPeek(FIRST):
+			item.Nulls = NullsFirst
+		case p.expectPeek(LAST):
+			item.Nulls = NullsLast
+		default:
+			return OrderByItem{}, fmt.Errorf("expected FIRST or LAST after NULLS")
+		}
+	}
+	return item, nil
+}
+
+// parseSelectColumn parses one entry of a SELECT list: *, table.* or an
+// expression, optionally followed by [AS] alias
+func (p *Parser) parseSelectColumn() (SelectColumn, error) {
+	if p.expectPeek(ASTERISK) {
+		return SelectColumn{}, nil
+	}
+	if table, ok := p.expectTableStar(); ok {
+		return SelectColumn{Table: table}, nil
+	}
+	
+	expr, err := p.parseExpression()
+	if err != nil {
+		return SelectColumn{}, err
+	}
+	column := SelectColumn{Expr: expr}
+	if p.expectPeek(AS) {
+		if !p.expectIdentifier() {
+			return SelectColumn{}, fmt.Errorf("expected alias after AS")
+		}
+		column.Alias = p.curToken.Literal
+	} else if p.expectIdentifier() {
+		column.Alias = p.curToken.Literal
+	}
+	return column, nil
+}
+
 // parseInsertStatement parses an INSERT statement
 func (p *Parser) parseInsertStatement() (*InsertStatement, error) {
 	stmt := &InsertStatement{}
@@ -106,7 +366,7 @@ func (p *Parser) parseInsertStatement() (*InsertStatement, error) {
 	}
 	
 	// Parse table name
-	if !p.expectPeek(IDENTIFIER) {
+	if !p.expectIdentifier() {
 		return nil, fmt.Errorf("expected table name")
 	}
 	stmt.TableName = p.curToken.Literal
@@ -125,24 +385,35 @@ func (p *Parser) parseInsertStatement() (*InsertStatement, error) {
 		}
 	}
 	
-	// Expect VALUES
-	if !p.expectPeek(VALUES) {
-		return nil, fmt.Errorf("expected VALUES")
-	}
-	
-	// Parse values
-	if !p.expectPeek(LPAREN) {
-		return nil, fmt.Errorf("expected (")
+	// The rows come from a SELECT or a VALUES list
+	if p.expectPeek(SELECT) {
+		sel, err := p.parseSelectStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Select = sel
+		return stmt, nil
 	}
-	
-	values, err := p.parseValueList()
-	if err != nil {
-		return nil, err
+	if !p.expectPeek(VALUES) {
+		return nil, fmt.Errorf("expected VALUES or SELECT")
 	}
-	stmt.Values = [][]string{values}
 	
-	if !p.expectPeek(RPAREN) {
-		return nil, fmt.Errorf("expected )")
+	// Parse each row of values
+	for {
+		if !p.expectPeek(LPAREN) {
+			return nil, fmt.Errorf("expected (")
+		}
+		values, err := p.parseExpressionList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Values = append(stmt.Values, values)
+		if !p.expectPeek(RPAREN) {
+			return nil, fmt.Errorf("expected )")
+		}
+		if !p.expectPeek(COMMA) {
+			break
+		}
 	}
 	
 	return stmt, nil
@@ -150,7 +421,7 @@ func (p *Parser) parseInsertStatement() (*InsertStatement, error) {
 
 // parseUpdateStatement parses an UPDATE statement
 func (p *Parser) parseUpdateStatement() (*UpdateStatement, error) {
-	stmt := &UpdateStatement{Set: make(map[string]string)}
+	stmt := &UpdateStatement{}
 	
 	// We're already on UPDATE token, no need to expect it
 	if p.curToken.Type != UPDATE {
@@ -158,7 +429,7 @@ func (p *Parser) parseUpdateStatement() (*UpdateStatement, error) {
 	}
 	
 	// Parse table name
-	if !p.expectPeek(IDENTIFIER) {
+	if !p.expectIdentifier() {
 		return nil, fmt.Errorf("expected table name")
 	}
 	stmt.TableName = p.curToken.Literal
@@ -170,21 +441,26 @@ func (p *Parser) parseUpdateStatement() (*UpdateStatement, error) {
 	
 	// Parse SET assignments
 	for {
-		if !p.expectPeek(IDENTIFIER) {
+		if !p.expectIdentifier() {
 			return nil, fmt.Errorf("expected column name")
 		}
 		column := p.curToken.Literal
+		for _, a := range stmt.Set {
+			if strings.EqualFold(a.Column, column) {
+				return nil, fmt.Errorf("column %s is assigned more than once", column)
+			}
+		}
 		
 		if !p.expectPeek(EQUAL) {
 			return nil, fmt.Errorf("expected =")
 		}
 		
-		if !p.expectPeek(STRING) && !p.expectPeek(NUMBER) {
-			return nil, fmt.Errorf("expected value")
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
 		}
-		value := p.curToken.Literal
 		
-		stmt.Set[column] = value
+		stmt.Set = append(stmt.Set, Assignment{Column: column, Value: value})
 		
 		if p.peekToken.Type != COMMA {
 			break
@@ -220,7 +496,7 @@ func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
 	}
 	
 	// Parse table name
-	if !p.expectPeek(IDENTIFIER) {
+	if !p.expectIdentifier() {
 		return nil, fmt.Errorf("expected table name")
 	}
 	stmt.TableName = p.curToken.Literal
@@ -238,18 +514,655 @@ func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
 	return stmt, nil
 }
 
+// parseCreateStatement parses a CREATE TABLE statement
+func (p *Parser) parseCreateStatement() (*CreateTableStatement, error) {
+	stmt := &CreateTableStatement{}
+	
+	// Expect TABLE
+	if !p.expectPeek(TABLE) {
+		return nil, fmt.Errorf("expected TABLE")
+	}
+	
+	// Optional IF NOT EXISTS
+	if p.peekToken.Type == IF {
+		p.nextToken()
+		if !p.expectPeek(NOT) || !p.expectPeek(EXISTS) {
+			return nil, fmt.Errorf("expected IF NOT EXISTS")
+		}
+		stmt.IfNotExists = true
+	}
+	
+	// Parse table name
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected table name")
+	}
+	stmt.TableName = p.curToken.Literal
+	
+	// Without a column list this is a plain key/value table
+	if p.peekToken.Type != LPAREN {
+		return stmt, nil
+	}
+	p.nextToken() // consume (
+	
+	for {
+		switch p.peekToken.Type {
+		case CONSTRAINT, UNIQUE, CHECK, FOREIGN:
+			constraint, err := p.parseTableConstraint()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Constraints = append(stmt.Constraints, constraint)
+		case PRIMARY:
+			// Table constraint: PRIMARY KEY (a, b)
+			p.nextToken()
+			if !p.expectPeek(KEY) || !p.expectPeek(LPAREN) {
+				return nil, fmt.Errorf("expected PRIMARY KEY (columns)")
+			}
+			if stmt.PrimaryKey != nil {
+				return nil, fmt.Errorf("table has more than one primary key")
+			}
+			columns, err := p.parseColumnList()
+			if err != nil {
+				return nil, err
+			}
+			if !p.expectPeek(RPAREN) {
+				return nil, fmt.Errorf("expected ) after primary key columns")
+			}
+			stmt.PrimaryKey = columns
+		default:
+			column, err := p.parseColumnDefinition()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, column)
+		}
+		
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken() // consume comma
+	}
+	
+	if !p.expectPeek(RPAREN) {
+		return nil, fmt.Errorf("expected )")
+	}
+	
+	return stmt, nil
+}
+
+// parseTableConstraint parses [CONSTRAINT name] followed by UNIQUE
+// (columns), CHECK (expr) or FOREIGN KEY (columns) REFERENCES ... in a
+// CREATE TABLE column list
+func (p *Parser) parseTableConstraint() (ConstraintDefinition, error) {
+	name, err := p.parseConstraintName()
+	if err != nil {
+		return ConstraintDefinition{}, err
+	}
+	
+	switch p.peekToken.Type {
+	case UNIQUE:
+		p.nextToken()
+		if !p.expectPeek(LPAREN) {
+			return ConstraintDefinition{}, fmt.Errorf("expected ( after UNIQUE")
+		}
+		columns, err := p.parseColumnList()
+		if err != nil {
+			return ConstraintDefinition{}, err
+		}
+		if !p.expectPeek(RPAREN) {
+			return ConstraintDefinition{}, fmt.Errorf("expected ) after unique columns")
+		}
+		return ConstraintDefinition{Name: name, Kind: ConstraintUnique, Columns: columns}, nil
+	case CHECK:
+		p.nextToken()
+		_, check, err := p.parseCheck()
+		if err != nil {
+			return ConstraintDefinition{}, err
+		}
+		return ConstraintDefinition{Name: name, Kind: ConstraintCheck, Check: check}, nil
+	case FOREIGN:
+		p.nextToken()
+		if !p.expectPeek(KEY) || !p.expectPeek(LPAREN) {
+			return ConstraintDefinition{}, fmt.Errorf("expected FOREIGN KEY (columns)")
+		}
+		columns, err := p.parseColumnList()
+		if err != nil {
+			return ConstraintDefinition{}, err
+		}
+		if !p.expectPeek(RPAREN) {
+			return ConstraintDefinition{}, fmt.Errorf("expected ) after foreign key columns")
+		}
+		if !p.expectPeek(REFERENCES) {
+			return ConstraintDefinition{}, fmt.Errorf("expected REFERENCES after FOREIGN KEY (%s)", strings.Join(columns, ", "))
+		}
+		constraint := ConstraintDefinition{Name: name, Columns: columns}
+		if err := p.parseReferences(&constraint); err != nil {
+			return ConstraintDefinition{}, err
+		}
+		return constraint, nil
+	default:
+		return ConstraintDefinition{}, fmt.Errorf("expected UNIQUE, CHECK or FOREIGN KEY")
+	}
+}
+
+// parseConstraintName parses an optional CONSTRAINT name prefix
+func (p *Parser) parseConstraintName() (string, error) {
+	if p.peekToken.Type != CONSTRAINT {
+		return "", nil
+	}
+	p.nextToken()
+	if !p.expectIdentifier() {
+		return "", fmt.Errorf("expected constraint name")
+	}
+	name := p.curToken.Literal
+	switch p.peekToken.Type {
+	case UNIQUE, CHECK, FOREIGN, REFERENCES:
+		return name, nil
+	}
+	return "", fmt.Errorf("expected UNIQUE, CHECK, FOREIGN KEY or REFERENCES after CONSTRAINT %s", name)
+}
+
+// parseReferences parses the rest of a REFERENCES clause, whose keyword is
+// the current token: the referenced table, its columns if given, and the
+// ON DELETE and ON UPDATE actions
+func (p *Parser) parseReferences(constraint *ConstraintDefinition) error {
+	if !p.expectIdentifier() {
+		return fmt.Errorf("expected table name after REFERENCES")
+	}
+	constraint.Kind = ConstraintForeignKey
+	constraint.RefTable = p.curToken.Literal
+	
+	if p.peekToken.Type == LPAREN {
+		p.nextToken()
+		columns, err := p.parseColumnList()
+		if err != nil {
+			return err
+		}
+		if !p.expectPeek(RPAREN) {
+			return fmt.Errorf("expected ) after referenced columns")
+		}
+		constraint.RefColumns = columns
+	}
+	
+	for p.peekToken.Type == ON {
+		p.nextToken()
+		p.nextToken()
+		event := p.curToken.Type
+		if event != DELETE && event != UPDATE {
+			return fmt.Errorf("expected DELETE or UPDATE after ON")
+		}
+		action, err := p.parseForeignKeyAction()
+		if err != nil {
+			return err
+		}
+		if event == DELETE {
+			constraint.OnDelete = action
+		} else {
+			constraint.OnUpdate = action
+		}
+	}
+	return nil
+}
+
+// parseForeignKeyAction parses the action of an ON DELETE or ON UPDATE
+// clause
+func (p *Parser) parseForeignKeyAction() (ForeignKeyAction, error) {
+	p.nextToken()
+	switch p.curToken.Type {
+	case RESTRICT:
+		return ActionRestrict, nil
+	case CASCADE:
+		return ActionCascade, nil
+	case NO:
+		if p.expectPeek(ACTION) {
+			return ActionNoAction, nil
+		}
+	case SET:
+		if p.expectPeek(NULL) {
+			return ActionSetNull, nil
+		}
+		if p.expectPeek(DEFAULT) {
+			return ActionSetDefault, nil
+		}
+	}
+	return "", fmt.Errorf("expected RESTRICT, CASCADE, SET NULL, SET DEFAULT or NO ACTION")
+}
+
+// parseCheck parses the parenthesized expression after CHECK and returns
+// it with its SQL text
+func (p *Parser) parseCheck() (Expression, string, error) {
+	if !p.expectPeek(LPAREN) {
+		return nil, "", fmt.Errorf("expected ( after CHECK")
+	}
+	start := p.curToken.Pos + 1
+	
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, "", fmt.Errorf("CHECK: %w", err)
+	}
+	if !isCondition(expr) {
+		return nil, "", fmt.Errorf("CHECK needs a condition, not %s", expr)
+	}
+	if !p.expectPeek(RPAREN) {
+		return nil, "", fmt.Errorf("expected ) after CHECK expression")
+	}
+	return expr, strings.TrimSpace(p.l.input[start:p.curToken.Pos]), nil
+}
+
+// isCondition reports whether an expression is written as a condition: a
+// comparison, a test such as LIKE or IS NULL, AND, OR or NOT
+func isCondition(expr Expression) bool {
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		switch e.Operator {
+		case "+", "-", "*", "/", "%", "||":
+			return false
+		}
+		return true
+	case *UnaryExpression:
+		return e.Operator == "NOT"
+	case *LikeExpression, *InExpression, *BetweenExpression, *IsNullExpression:
+		return true
+	case *Literal:
+		_, ok := e.Value.(bool)
+		return ok
+	default:
+		return false
+	}
+}
+
+// parseDropStatement parses a DROP TABLE statement
+func (p *Parser) parseDropStatement() (*DropTableStatement, error) {
+	stmt := &DropTableStatement{}
+	
+	// Expect TABLE
+	if !p.expectPeek(TABLE) {
+		return nil, fmt.Errorf("expected TABLE")
+	}
+	
+	// Optional IF EXISTS
+	if p.peekToken.Type == IF {
+		p.nextToken()
+		if !p.expectPeek(EXISTS) {
+			return nil, fmt.Errorf("expected IF EXISTS")
+		}
+		stmt.IfExists = true
+	}
+	
+	// Parse table name
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected table name")
+	}
+	stmt.TableName = p.curToken.Literal
+	
+	return stmt, nil
+}
+
+// parseCreateSequenceStatement parses a CREATE SEQUENCE statement with
+// optional START [WITH] n and INCREMENT [BY] n clauses
+func (p *Parser) parseCreateSequenceStatement() (*CreateSequenceStatement, error) {
+	stmt := &CreateSequenceStatement{Start: 1, Increment: 1}
+	p.nextToken() // consume SEQUENCE
+	
+	// Optional IF NOT EXISTS
+	if p.peekToken.Type == IF {
+		p.nextToken()
+		if !p.expectPeek(NOT) || !p.expectPeek(EXISTS) {
+			return nil, fmt.Errorf("expected IF NOT EXISTS")
+		}
+		stmt.IfNotExists = true
+	}
+	
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected sequence name")
+	}
+	stmt.SequenceName = p.curToken.Literal
+	
+	for {
+		switch p.peekToken.Type {
+		case START:
+			p.nextToken()
+			p.expectPeek(WITH) // WITH is optional
+			start, err := p.parseInteger()
+			if err != nil {
+				return nil, fmt.Errorf("START: %w", err)
+			}
+			stmt.Start = start
+		case INCREMENT:
+			p.nextToken()
+			p.expectPeek(BY) // BY is optional
+			increment, err := p.parseInteger()
+			if err != nil {
+				return nil, fmt.Errorf("INCREMENT: %w", err)
+			}
+			if increment == 0 {
+				return nil, fmt.Errorf("INCREMENT cannot be zero")
+			}
+			stmt.Increment = increment
+		default:
+			return stmt, nil
+		}
+	}
+}
+
+// parseDropSequenceStatement parses a DROP SEQUENCE statement
+func (p *Parser) parseDropSequenceStatement() (*DropSequenceStatement, error) {
+	stmt := &DropSequenceStatement{}
+	p.nextToken() // consume SEQUENCE
+	
+	// Optional IF EXISTS
+	if p.peekToken.Type == IF {
+		p.nextToken()
+		if !p.expectPeek(EXISTS) {
+			return nil, fmt.Errorf("expected IF EXISTS")
+		}
+		stmt.IfExists = true
+	}
+	
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected sequence name")
+	}
+	stmt.SequenceName = p.curToken.Literal
+	
+	return stmt, nil
+}
+
+// parseInteger parses an integer with an optional minus sign
+func (p *Parser) parseInteger() (int64, error) {
+	negative := p.expectPeek(MINUS)
+	if !p.expectPeek(NUMBER) {
+		return 0, fmt.Errorf("expected an integer")
+	}
+	text := p.curToken.Literal
+	if negative {
+		text = "-" + text
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %s", text)
+	}
+	return n, nil
+}
+
+// parseCreateIndexStatement parses a CREATE [UNIQUE] INDEX statement
+func (p *Parser) parseCreateIndexStatement() (*CreateIndexStatement, error) {
+	stmt := &CreateIndexStatement{}
+	
+	if p.peekToken.Type == UNIQUE {
+		p.nextToken()
+		stmt.Unique = true
+	}
+	if !p.expectPeek(INDEX) {
+		return nil, fmt.Errorf("expected INDEX")
+	}
+	
+	// Optional IF NOT EXISTS
+	if p.peekToken.Type == IF {
+		p.nextToken()
+		if !p.expectPeek(NOT) || !p.expectPeek(EXISTS) {
+			return nil, fmt.Errorf("expected IF NOT EXISTS")
+		}
+		stmt.IfNotExists = true
+	}
+	
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected index name")
+	}
+	stmt.IndexName = p.curToken.Literal
+	
+	if !p.expectPeek(ON) {
+		return nil, fmt.Errorf("expected ON")
+	}
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected table name")
+	}
+	stmt.TableName = p.curToken.Literal
+	
+	if !p.expectPeek(LPAREN) {
+		return nil, fmt.Errorf("expected ( before indexed columns")
+	}
+	columns, err := p.parseColumnList()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Columns = columns
+	if !p.expectPeek(RPAREN) {
+		return nil, fmt.Errorf("expected ) after indexed columns")
+	}
+	
+	return stmt, nil
+}
+
+// parseDropIndexStatement parses a DROP INDEX statement
+func (p *Parser) parseDropIndexStatement() (*DropIndexStatement, error) {
+	stmt := &DropIndexStatement{}
+	p.nextToken() // consume INDEX
+	
+	// Optional IF EXISTS
+	if p.peekToken.Type == IF {
+		p.nextToken()
+		if !p.expectPeek(EXISTS) {
+			return nil, fmt.Errorf("expected IF EXISTS")
+		}
+		stmt.IfExists = true
+	}
+	
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected index name")
+	}
+	stmt.IndexName = p.curToken.Literal
+	
+	return stmt, nil
+}
+
+// parseColumnDefinition parses a column name, its type and its constraints
+func (p *Parser) parseColumnDefinition() (ColumnDefinition, error) {
+	var column ColumnDefinition
+	
+	if !p.expectIdentifier() {
+		return column, fmt.Errorf("expected column name")
+	}
+	column.Name = p.curToken.Literal
+	
+	if !p.expectPeek(IDENTIFIER) {
+		return column, fmt.Errorf("expected type for column %s", column.Name)
+	}
+	columnType, err := ParseColumnType(p.curToken.Literal)
+	if err != nil {
+		return column, err
+	}
+	column.Type = columnType
+	
+	// Accept and ignore a length, as in VARCHAR(255) or DECIMAL(10, 2)
+	if p.peekToken.Type == LPAREN {
+		p.nextToken()
+		for p.peekToken.Type == NUMBER || p.peekToken.Type == COMMA {
+			p.nextToken()
+		}
+		if !p.expectPeek(RPAREN) {
+			return column, fmt.Errorf("expected ) after type length")
+		}
+	}
+	
+	// Column constraints
+	for {
+		switch p.peekToken.Type {
+		case PRIMARY:
+			p.nextToken()
+			if !p.expectPeek(KEY) {
+				return column, fmt.Errorf("expected KEY after PRIMARY")
+			}
+			column.PrimaryKey = true
+		case AUTOINCREMENT:
+			p.nextToken()
+			column.AutoIncrement = true
+		case NOT:
+			p.nextToken()
+			if !p.expectPeek(NULL) {
+				return column, fmt.Errorf("expected NULL after NOT")
+			}
+			column.NotNull = true
+		case NULL:
+			p.nextToken() // nullable is the default
+		case DEFAULT:
+			p.nextToken()
+			_, value, err := p.parseDefault()
+			if err != nil {
+				return column, fmt.Errorf("DEFAULT for column %s: %w", column.Name, err)
+			}
+			column.Default = value
+		case CONSTRAINT, UNIQUE, CHECK, REFERENCES:
+			name, err := p.parseConstraintName()
+			if err != nil {
+				return column, err
+			}
+			constraint := ConstraintDefinition{Name: name, Columns: []string{column.Name}}
+			p.nextToken()
+			switch p.curToken.Type {
+			case UNIQUE:
+				constraint.Kind = ConstraintUnique
+			case CHECK:
+				constraint.Kind = ConstraintCheck
+				if _, constraint.Check, err = p.parseCheck(); err != nil {
+					return column, err
+				}
+			case REFERENCES:
+				if err := p.parseReferences(&constraint); err != nil {
+					return column, err
+				}
+			default:
+				return column, fmt.Errorf("expected UNIQUE, CHECK or REFERENCES after CONSTRAINT %s", name)
+			}
+			column.Constraints = append(column.Constraints, constraint)
+		default:
+			return column, nil
+		}
+	}
+}
+
+// parseDefault parses the value of a DEFAULT clause and returns it along
+// with its SQL text. The value is an expression evaluated for each new row,
+// such as 0, 'a' || 'b' or nextval('sequence') to number rows from a
+// sequence, so it cannot refer to columns. A comparison or test ends it
+// unless it is parenthesized, so DEFAULT 0 NOT NULL reads as two clauses.
+func (p *Parser) parseDefault() (Expression, string, error) {
+	start := p.peekToken.Pos
+	
+	expr, err := p.parseExpressionAbove(precComparison)
+	if err != nil {
+		return nil, "", err
+	}
+	err = walkExpression(expr, func(e Expression) error {
+		switch e := e.(type) {
+		case *ColumnRef:
+			return fmt.Errorf("cannot refer to column %s", e.Name)
+		case *Parameter:
+			return fmt.Errorf("cannot use parameter %s", e)
+		case *SubqueryExpression, *ExistsExpression:
+			return fmt.Errorf("cannot use a subquery")
+		case *InExpression:
+			if e.Subquery != nil {
+				return fmt.Errorf("cannot use a subquery")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	end := min(p.peekToken.Pos, len(p.l.input))
+	return expr, strings.TrimSpace(p.l.input[start:end]), nil
+}
+
+// nextvalDefault is the DEFAULT text of a column numbered from a sequence
+func nextvalDefault(sequence string) string {
+	return "nextval(" + quoteString(sequence) + ")"
+}
+
+// quoteString renders a string as a SQL literal
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// parseAlterStatement parses an ALTER TABLE statement
+func (p *Parser) parseAlterStatement() (*AlterTableStatement, error) {
+	stmt := &AlterTableStatement{}
+	
+	// Expect TABLE
+	if !p.expectPeek(TABLE) {
+		return nil, fmt.Errorf("expected TABLE")
+	}
+	
+	// Parse table name
+	if !p.expectIdentifier() {
+		return nil, fmt.Errorf("expected table name")
+	}
+	stmt.TableName = p.curToken.Literal
+	
+	switch p.peekToken.Type {
+	case ADD:
+		p.nextToken()
+		p.expectPeek(COLUMN) // COLUMN is optional
+		column, err := p.parseColumnDefinition()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Action = AlterAddColumn
+		stmt.Column = column
+	
+	case DROP:
+		p.nextToken()
+		p.expectPeek(COLUMN) // COLUMN is optional
+		if !p.expectIdentifier() {
+			return nil, fmt.Errorf("expected column name")
+		}
+		stmt.Action = AlterDropColumn
+		stmt.ColumnName = p.curToken.Literal
+	
+	case RENAME:
+		p.nextToken()
+		if p.expectPeek(TO) {
+			if !p.expectIdentifier() {
+				return nil, fmt.Errorf("expected new table name")
+			}
+			stmt.Action = AlterRenameTable
+			stmt.NewName = p.curToken.Literal
+			break
+		}
+		
+		p.expectPeek(COLUMN) // COLUMN is optional
+		if !p.expectIdentifier() {
+			return nil, fmt.Errorf("expected column name")
+		}
+		stmt.ColumnName = p.curToken.Literal
+		if !p.expectPeek(TO) {
+			return nil, fmt.Errorf("expected TO")
+		}
+		if !p.expectIdentifier() {
+			return nil, fmt.Errorf("expected new column name")
+		}
+		stmt.Action = AlterRenameColumn
+		stmt.NewName = p.curToken.Literal
+	
+	default:
+		return nil, fmt.Errorf("expected ADD, DROP or RENAME")
+	}
+	
+	return stmt, nil
+}
+
 // parseColumnList parses a comma-separated list of column names
 func (p *Parser) parseColumnList() ([]string, error) {
 	var columns []string
 	
-	if !p.expectPeek(IDENTIFIER) {
+	if !p.expectIdentifier() {
 		return nil, fmt.Errorf("expected column name")
 	}
 	columns = append(columns, p.curToken.Literal)
 	
 	for p.peekToken.Type == COMMA {
 		p.nextToken() // consume comma
-		if !p.expectPeek(IDENTIFIER) {
+		if !p.expectIdentifier() {
 			return nil, fmt.Errorf("expected column name")
 		}
 		columns = append(columns, p.curToken.Literal)
@@ -258,26 +1171,19 @@ func (p *Parser) parseColumnList() ([]string, error) {
 	return columns, nil
 }
 
-// parseValueList parses a comma-separated list of values
-func (p *Parser) parseValueList() ([]string, error) {
-	var values []string
-	
-	if p.peekToken.Type != STRING && p.peekToken.Type != NUMBER {
-		return nil, fmt.Errorf("expected value")
-	}
-	p.nextToken()
-	values = append(values, p.curToken.Literal)
-	
-	for p.peekToken.Type == COMMA {
-		p.nextToken() // consume comma
-		if p.peekToken.Type != STRING && p.peekToken.Type != NUMBER {
-			return nil, fmt.Errorf("expected value")
+// parseExpressionList parses a comma-separated list of expressions
+func (p *Parser) parseExpressionList() ([]Expression, error) {
+	var exprs []Expression
+	for {
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.expectPeek(COMMA) {
+			return exprs, nil
 		}
-		p.nextToken()
-		values = append(values, p.curToken.Literal)
 	}
-	
-	return values, nil
 }
 
 // parseWhereClause parses a WHERE clause
@@ -285,54 +1191,340 @@ func (p *Parser) parseWhereClause() (Expression, error) {
 	return p.parseExpression()
 }
 
+// Operator precedences, from the loosest binding to the tightest
+const (
+	precLowest = iota
+	precOr
+	precAnd
+	precNot
+	precComparison // =, <, LIKE, IN, BETWEEN, IS and the like
+	precConcat     // ||
+	precSum        // + and -
+	precProduct    // *, / and %
+	precUnary      // prefix - and +
+)
+
+// infixPrecedences maps the tokens that can follow an operand to the
+// precedence of the operator they start
+var infixPrecedences = map[TokenType]int{
+	OR:         precOr,
+	AND:        precAnd,
+	EQUAL:      precComparison,
+	NOT_EQUAL:  precComparison,
+	LESS:       precComparison,
+	LESS_EQ:    precComparison,
+	GREATER:    precComparison,
+	GREATER_EQ: precComparison,
+	NOT:        precComparison, // NOT LIKE, NOT IN and NOT BETWEEN
+	LIKE:       precComparison,
+	IN:         precComparison,
+	BETWEEN:    precComparison,
+	IS:         precComparison,
+	CONCAT:     precConcat,
+	PLUS:       precSum,
+	MINUS:      precSum,
+	ASTERISK:   precProduct,
+	SLASH:      precProduct,
+	PERCENT:    precProduct,
+}
+
 // parseExpression parses a SQL expression
 func (p *Parser) parseExpression() (Expression, error) {
-	left, err := p.parseComparisonExpression()
+	return p.parseExpressionAbove(precLowest)
+}
+
+// parseExpressionAbove parses an expression whose infix operators all bind
+// tighter than prec, so that an operator of precedence prec or looser ends
+// it. Operators of equal precedence associate to the left.
+func (p *Parser) parseExpressionAbove(prec int) (Expression, error) {
+	left, err := p.parsePrefix()
 	if err != nil {
 		return nil, err
 	}
 	
-	for p.peekToken.Type == AND || p.peekToken.Type == OR {
-		operator := p.peekToken.Literal
-		p.nextToken() // consume operator
+	for prec < infixPrecedences[p.peekToken.Type] {
+		p.nextToken()
+		if left, err = p.parseInfix(left); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
 		
-		right, err := p.parseComparisonExpression()
+// parsePrefix parses an operand: a literal, a column, a function call, a
+// parenthesized expression or subquery, an EXISTS test or a prefix operator
+// applied to an operand
+func (p *Parser) parsePrefix() (Expression, error) {
+	p.nextToken()
+	switch tok := p.curToken; tok.Type {
+	case NUMBER:
+		v, err := parseNumber(tok.Literal)
 		if err != nil {
 			return nil, err
 		}
-		
-		left = &BinaryExpression{
-			Left:     left,
-			Operator: strings.ToUpper(operator),
-			Right:    right,
+		return &Literal{Value: v}, nil
+	case STRING:
+		return &Literal{Value: tok.Literal}, nil
+	case NULL:
+		return &Literal{}, nil
+	case PARAMETER:
+		return p.parseParameter()
+	case MINUS, PLUS:
+		// Parse a minus sign with the number it precedes, so the smallest
+		// INTEGER does not overflow as a positive number first
+		if tok.Type == MINUS && p.peekToken.Type == NUMBER {
+			p.nextToken()
+			v, err := parseNumber("-" + p.curToken.Literal)
+			if err != nil {
+				return nil, err
+			}
+			return &Literal{Value: v}, nil
+		}
+		operand, err := p.parseExpressionAbove(precUnary)
+		if err != nil {
+			return nil, err
+		}
+		// Fold the sign into a number, so -5 is a literal
+		if lit, ok := operand.(*Literal); ok && tok.Type == MINUS {
+			switch v := lit.Value.(type) {
+			case int64:
+				return &Literal{Value: -v}, nil
+			case float64:
+				return &Literal{Value: -v}, nil
+			}
+		}
+		return &UnaryExpression{Operator: tok.Literal, Operand: operand}, nil
+	case NOT:
+		operand, err := p.parseExpressionAbove(precNot)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpression{Operator: "NOT", Operand: operand}, nil
+	case LPAREN:
+		if p.peekToken.Type == SELECT {
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &SubqueryExpression{Select: subquery}, nil
+		}
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
 		}
+		if !p.expectPeek(RPAREN) {
+			return nil, fmt.Errorf("expected )")
+		}
+		return expr, nil
+	case EXISTS:
+		if !p.expectPeek(LPAREN) {
+			return nil, fmt.Errorf("expected ( after EXISTS")
+		}
+		subquery, err := p.parseSubquery()
+		if err != nil {
+			return nil, err
+		}
+		return &ExistsExpression{Select: subquery}, nil
+	case IDENTIFIER:
+		if upper := strings.ToUpper(tok.Literal); upper == "TRUE" || upper == "FALSE" {
+			return &Literal{Value: upper == "TRUE"}, nil
+		}
+		if p.expectPeek(LPAREN) {
+			return p.parseFunctionCall(tok.Literal)
+		}
+		if p.expectPeek(DOT) {
+			if !p.expectIdentifier() {
+				return nil, fmt.Errorf("expected column name after %s.", tok.Literal)
+			}
+			return &ColumnRef{Table: tok.Literal, Name: p.curToken.Literal}, nil
+		}
+		return &ColumnRef{Name: tok.Literal}, nil
 	}
+		
+	if nonReserved[p.curToken.Type] {
+		return &ColumnRef{Name: p.curToken.Literal}, nil
+	}
+	if p.curToken.Type == EOF {
+		return nil, fmt.Errorf("expected an expression")
+	}
+	return nil, fmt.Errorf("unexpected %q in expression", p.curToken.Literal)
+}
+
+// parseParameter parses a ? or $n parameter. Each ? stands for the
+// parameter after the one before it, so a statement numbers its parameters
+// one way or the other, not both.
+func (p *Parser) parseParameter() (Expression, error) {
+	literal := p.curToken.Literal
+	style := literal[0]
+	if p.paramStyle != 0 && p.paramStyle != style {
+		return nil, fmt.Errorf("cannot mix ? and $n parameters")
+	}
+	p.paramStyle = style
 	
-	return left, nil
+	index := p.params + 1
+	if style == '$' {
+		n, err := strconv.Atoi(literal[1:])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid parameter %s", literal)
+		}
+		index = n
+		if p.paramsSeen == nil {
+			p.paramsSeen = make(map[int]bool)
+		}
+		p.paramsSeen[n] = true
+	}
+	p.params = max(p.params, index)
+	return &Parameter{Index: index}, nil
 }
 
-// parseComparisonExpression parses a comparison expression (col = 'value')
-func (p *Parser) parseComparisonExpression() (Expression, error) {
-	if !p.expectPeek(IDENTIFIER) {
-		return nil, fmt.Errorf("expected column name")
+// parseNumber converts a NUMBER token to an INTEGER, or to a REAL if it has
+// a fractional part or is too large for an INTEGER
+func parseNumber(literal string) (Value, error) {
+	if !strings.Contains(literal, ".") {
+		if v, err := strconv.ParseInt(literal, 10, 64); err == nil {
+			return v, nil
+		}
+	}
+	v, err := strconv.ParseFloat(literal, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number: %s", literal)
 	}
-	left := p.curToken.Literal
+	return v, nil
+}
+
+// parseFunctionCall parses the arguments of a call to the named function:
+// nothing, *, or a list of expressions that may start with DISTINCT. The
+// current token is the opening parenthesis.
+func (p *Parser) parseFunctionCall(name string) (Expression, error) {
+	call := &FunctionCall{Name: strings.ToLower(name)}
+	if p.expectPeek(RPAREN) {
+		return call, nil
+	}
+	if p.expectPeek(ASTERISK) {
+		if !p.expectPeek(RPAREN) {
+			return nil, fmt.Errorf("expected ) after %s(*", name)
+		}
+		call.Star = true
+		return call, nil
+	}
+	call.Distinct = p.expectPeek(DISTINCT)
 	
-	if !p.expectPeek(EQUAL) {
-		return nil, fmt.Errorf("expected =")
+	args, err := p.parseExpressionList()
+	if err != nil {
+		return nil, err
 	}
-	operator := p.curToken.Literal
+	if !p.expectPeek(RPAREN) {
+		return nil, fmt.Errorf("expected ) after the arguments of %s", name)
+	}
+	call.Args = args
+	return call, nil
+}
+
+// parseInfix parses the operator that is the current token and its right
+// operand, applied to left
+func (p *Parser) parseInfix(left Expression) (Expression, error) {
+	tok := p.curToken
+	prec := infixPrecedences[tok.Type]
 	
-	if !p.expectPeek(STRING) && !p.expectPeek(NUMBER) {
-		return nil, fmt.Errorf("expected value")
+	switch tok.Type {
+	case IS:
+		not := p.expectPeek(NOT)
+		if !p.expectPeek(NULL) {
+			return nil, fmt.Errorf("expected NULL after IS")
+		}
+		return &IsNullExpression{Left: left, Not: not}, nil
+	case NOT:
+		if p.peekToken.Type != LIKE && p.peekToken.Type != IN && p.peekToken.Type != BETWEEN {
+			return nil, fmt.Errorf("expected LIKE, IN or BETWEEN after NOT")
+		}
+		p.nextToken()
+		return p.parseNegatable(left, true)
+	case LIKE, IN, BETWEEN:
+		return p.parseNegatable(left, false)
 	}
-	right := p.curToken.Literal
 	
-	return &ComparisonExpression{
-		Left:     left,
-		Operator: operator,
-		Right:    right,
-	}, nil
+	right, err := p.parseExpressionAbove(prec)
+	if err != nil {
+		return nil, err
+	}
+	operator := tok.Literal
+	if tok.Type == AND || tok.Type == OR {
+		operator = strings.ToUpper(operator)
+	}
+	return &BinaryExpression{Left: left, Operator: operator, Right: right}, nil
+}
+
+// parseNegatable parses the rest of a LIKE, IN or BETWEEN condition on left,
+// whose keyword is the current token
+func (p *Parser) parseNegatable(left Expression, not bool) (Expression, error) {
+	switch p.curToken.Type {
+	case LIKE:
+		pattern, err := p.parseExpressionAbove(precComparison)
+		if err != nil {
+			return nil, err
+		}
+		return &LikeExpression{Left: left, Pattern: pattern, Not: not}, nil
+	case IN:
+		if !p.expectPeek(LPAREN) {
+			return nil, fmt.Errorf("expected ( after IN")
+		}
+		if p.peekToken.Type == SELECT {
+			subquery, err := p.parseSubquery()
+			if err != nil {
+				return nil, err
+			}
+			return &InExpression{Left: left, Subquery: subquery, Not: not}, nil
+		}
+		values, err := p.parseExpressionList()
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(RPAREN) {
+			return nil, fmt.Errorf("expected ) after IN list")
+		}
+		return &InExpression{Left: left, Values: values, Not: not}, nil
+	default:
+		// The bounds bind tighter than AND, which separates them
+		low, err := p.parseExpressionAbove(precComparison)
+		if err != nil {
+			return nil, err
+		}
+		if !p.expectPeek(AND) {
+			return nil, fmt.Errorf("expected AND after BETWEEN %s", low)
+		}
+		high, err := p.parseExpressionAbove(precComparison)
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpression{Left: left, Low: low, High: high, Not: not}, nil
+	}
+}
+
+// expectIdentifier advances if the peek token can be used as a name: an
+// identifier or a non-reserved keyword
+func (p *Parser) expectIdentifier() bool {
+	if p.peekToken.Type == IDENTIFIER || nonReserved[p.peekToken.Type] {
+		p.nextToken()
+		return true
+	}
+	return false
+}
+
+// expectTableStar advances past table.* if those are the next tokens, and
+// returns the table name
+func (p *Parser) expectTableStar() (string, bool) {
+	if p.peekToken.Type != IDENTIFIER {
+		return "", false
+	}
+	ahead := *p.l
+	if ahead.NextToken().Type != DOT || ahead.NextToken().Type != ASTERISK {
+		return "", false
+	}
+	table := p.peekToken.Literal
+	p.nextToken()
+	p.nextToken()
+	p.nextToken()
+	return table, true
 }
 
 // expectPeek checks the peek token type and advances if it matches
@@ -349,4 +1541,4 @@ func ParseSQL(sql string) (Statement, error) {
 	lexer := NewLexer(sql)
 	parser := NewParser(lexer)
 	return parser.Parse()
-}
\ No newline at end of file
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseSelectStatement(t *testing.T) {
@@ -16,7 +17,7 @@ func TestParseSelectStatement(t *testing.T) {
 			name:  "select all from table",
 			input: "SELECT * FROM users",
 			expected: &SelectStatement{
-				Columns:   []string{"*"},
+				Columns:   []SelectColumn{{}},
 				TableName: "users",
 			},
 		},
@@ -24,7 +25,7 @@ func TestParseSelectStatement(t *testing.T) {
 			name:  "select specific columns",
 			input: "SELECT name, email FROM users",
 			expected: &SelectStatement{
-				Columns:   []string{"name", "email"},
+				Columns:   []SelectColumn{{Expr: &ColumnRef{Name: "name"}}, {Expr: &ColumnRef{Name: "email"}}},
 				TableName: "users",
 			},
 		},
@@ -32,12 +33,12 @@ func TestParseSelectStatement(t *testing.T) {
 			name:  "select with where clause",
 			input: "SELECT * FROM users WHERE id = '123'",
 			expected: &SelectStatement{
-				Columns:   []string{"*"},
+				Columns:   []SelectColumn{{}},
 				TableName: "users",
-				Where: &ComparisonExpression{
-					Left:     "id",
+				Where: &BinaryExpression{
+					Left:     &ColumnRef{Name: "id"},
 					Operator: "=",
-					Right:    "123",
+					Right:    &Literal{Value: "123"},
 				},
 			},
 		},
@@ -71,7 +72,7 @@ func TestParseInsertStatement(t *testing.T) {
 			input: "INSERT INTO users VALUES ('john', 'john@example.com')",
 			expected: &InsertStatement{
 				TableName: "users",
-				Values:    [][]string{{"john", "john@example.com"}},
+				Values:    [][]Expression{{&Literal{Value: "john"}, &Literal{Value: "john@example.com"}}},
 			},
 		},
 		{
@@ -80,7 +81,29 @@ func TestParseInsertStatement(t *testing.T) {
 			expected: &InsertStatement{
 				TableName: "users",
 				Columns:   []string{"name", "email"},
-				Values:    [][]string{{"john", "john@example.com"}},
+				Values:    [][]Expression{{&Literal{Value: "john"}, &Literal{Value: "john@example.com"}}},
+			},
+		},
+		{
+			name:  "insert several rows",
+			input: "INSERT INTO users (name) VALUES ('john'), ('jane')",
+			expected: &InsertStatement{
+				TableName: "users",
+				Columns:   []string{"name"},
+				Values:    [][]Expression{{&Literal{Value: "john"}}, {&Literal{Value: "jane"}}},
+			},
+		},
+		{
+			name:  "insert select",
+			input: "INSERT INTO archive (name) SELECT name FROM users WHERE id > 1",
+			expected: &InsertStatement{
+				TableName: "archive",
+				Columns:   []string{"name"},
+				Select: &SelectStatement{
+					Columns:   []SelectColumn{{Expr: &ColumnRef{Name: "name"}}},
+					TableName: "users",
+					Where:     &BinaryExpression{Left: &ColumnRef{Name: "id"}, Operator: ">", Right: &Literal{Value: int64(1)}},
+				},
 			},
 		},
 	}
@@ -95,8 +118,19 @@ func TestParseInsertStatement(t *testing.T) {
 			assert.Equal(t, tt.expected.TableName, insertStmt.TableName)
 			assert.Equal(t, tt.expected.Columns, insertStmt.Columns)
 			assert.Equal(t, tt.expected.Values, insertStmt.Values)
+			assert.Equal(t, tt.expected.Select, insertStmt.Select)
 		})
 	}
+	
+	for _, input := range []string{
+		"INSERT INTO users VALUES ('john'),",
+		"INSERT INTO users VALUES ('john') ('jane')",
+		"INSERT INTO users SELECT",
+		"INSERT INTO users (name) FROM users",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
 }
 
 func TestParseUpdateStatement(t *testing.T) {
@@ -110,7 +144,7 @@ func TestParseUpdateStatement(t *testing.T) {
 			input: "UPDATE users SET name = 'john'",
 			expected: &UpdateStatement{
 				TableName: "users",
-				Set:       map[string]string{"name": "john"},
+				Set:       []Assignment{{Column: "name", Value: &Literal{Value: "john"}}},
 			},
 		},
 		{
@@ -118,11 +152,11 @@ func TestParseUpdateStatement(t *testing.T) {
 			input: "UPDATE users SET name = 'john' WHERE id = '123'",
 			expected: &UpdateStatement{
 				TableName: "users",
-				Set:       map[string]string{"name": "john"},
-				Where: &ComparisonExpression{
-					Left:     "id",
+				Set:       []Assignment{{Column: "name", Value: &Literal{Value: "john"}}},
+				Where: &BinaryExpression{
+					Left:     &ColumnRef{Name: "id"},
 					Operator: "=",
-					Right:    "123",
+					Right:    &Literal{Value: "123"},
 				},
 			},
 		},
@@ -163,10 +197,10 @@ func TestParseDeleteStatement(t *testing.T) {
 			input: "DELETE FROM users WHERE id = '123'",
 			expected: &DeleteStatement{
 				TableName: "users",
-				Where: &ComparisonExpression{
-					Left:     "id",
+				Where: &BinaryExpression{
+					Left:     &ColumnRef{Name: "id"},
 					Operator: "=",
-					Right:    "123",
+					Right:    &Literal{Value: "123"},
 				},
 			},
 		},
@@ -188,6 +222,633 @@ func TestParseDeleteStatement(t *testing.T) {
 	}
 }
 
+func TestParseCreateTableStatement(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected *CreateTableStatement
+	}{
+		{
+			name:  "key/value table",
+			input: "CREATE TABLE users",
+			expected: &CreateTableStatement{
+				TableName: "users",
+			},
+		},
+		{
+			name:  "typed columns",
+			input: "CREATE TABLE users (id INTEGER PRIMARY KEY, name VARCHAR(64) NOT NULL, score REAL NULL, data BLOB, active BOOL)",
+			expected: &CreateTableStatement{
+				TableName: "users",
+				Columns: []ColumnDefinition{
+					{Name: "id", Type: TypeInteger, PrimaryKey: true},
+					{Name: "name", Type: TypeText, NotNull: true},
+					{Name: "score", Type: TypeReal},
+					{Name: "data", Type: TypeBlob},
+					{Name: "active", Type: TypeBoolean},
+				},
+			},
+		},
+		{
+			name:  "composite primary key",
+			input: "CREATE TABLE orders (region TEXT, id INTEGER, total REAL, PRIMARY KEY (region, id))",
+			expected: &CreateTableStatement{
+				TableName: "orders",
+				Columns: []ColumnDefinition{
+					{Name: "region", Type: TypeText},
+					{Name: "id", Type: TypeInteger},
+					{Name: "total", Type: TypeReal},
+				},
+				PrimaryKey: []string{"region", "id"},
+			},
+		},
+		{
+			name:  "if not exists",
+			input: "create table if not exists kv (key TEXT primary key, value TEXT)",
+			expected: &CreateTableStatement{
+				TableName:   "kv",
+				IfNotExists: true,
+				Columns: []ColumnDefinition{
+					{Name: "key", Type: TypeText, PrimaryKey: true},
+					{Name: "value", Type: TypeText},
+				},
+			},
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, err := ParseSQL(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, stmt)
+		})
+	}
+	
+	// Malformed statements
+	for _, input := range []string{
+		"CREATE users (id INTEGER)",
+		"CREATE TABLE users (id)",
+		"CREATE TABLE users (id DECIMALS)",
+		"CREATE TABLE users (id INTEGER PRIMARY)",
+		"CREATE TABLE users (id INTEGER",
+		"CREATE TABLE IF EXISTS users",
+		"CREATE TABLE users (id INTEGER, PRIMARY (id))",
+		"CREATE TABLE users (id INTEGER, PRIMARY KEY id)",
+		"CREATE TABLE users (id INTEGER, PRIMARY KEY (id), PRIMARY KEY (id))",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseIndexStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Statement
+	}{
+		{
+			input: "CREATE INDEX by_name ON users (name)",
+			expected: &CreateIndexStatement{
+				IndexName: "by_name",
+				TableName: "users",
+				Columns:   []string{"name"},
+			},
+		},
+		{
+			input: "create unique index if not exists by_email on users (email, id)",
+			expected: &CreateIndexStatement{
+				IndexName:   "by_email",
+				TableName:   "users",
+				Columns:     []string{"email", "id"},
+				Unique:      true,
+				IfNotExists: true,
+			},
+		},
+		{
+			input:    "DROP INDEX by_name",
+			expected: &DropIndexStatement{IndexName: "by_name"},
+		},
+		{
+			input:    "DROP INDEX IF EXISTS by_name",
+			expected: &DropIndexStatement{IndexName: "by_name", IfExists: true},
+		},
+	}
+	
+	for _, tt := range tests {
+		stmt, err := ParseSQL(tt.input)
+		assert.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, stmt, tt.input)
+	}
+	
+	for _, input := range []string{
+		"CREATE UNIQUE TABLE users (id INTEGER)",
+		"CREATE INDEX ON users (name)",
+		"CREATE INDEX by_name users (name)",
+		"CREATE INDEX by_name ON users",
+		"CREATE INDEX by_name ON users ()",
+		"DROP INDEX",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+	stmt, err := ParseSQL("CREATE TABLE products (id INTEGER PRIMARY KEY, sku TEXT NOT NULL UNIQUE, " +
+		"price REAL CHECK (price >= 0), qty INTEGER CONSTRAINT qty_positive CHECK (qty > 0), " +
+		"UNIQUE (sku, qty), CONSTRAINT sane CHECK (price < 1000 OR qty <> 'x'))")
+	require.NoError(t, err)
+	
+	create := stmt.(*CreateTableStatement)
+	assert.Equal(t, []ConstraintDefinition{
+		{Kind: ConstraintUnique, Columns: []string{"sku"}},
+	}, create.Columns[1].Constraints)
+	assert.True(t, create.Columns[1].NotNull)
+	assert.Equal(t, []ConstraintDefinition{
+		{Kind: ConstraintCheck, Columns: []string{"price"}, Check: "price >= 0"},
+	}, create.Columns[2].Constraints)
+	assert.Equal(t, []ConstraintDefinition{
+		{Name: "qty_positive", Kind: ConstraintCheck, Columns: []string{"qty"}, Check: "qty > 0"},
+	}, create.Columns[3].Constraints)
+	assert.Equal(t, []ConstraintDefinition{
+		{Kind: ConstraintUnique, Columns: []string{"sku", "qty"}},
+		{Name: "sane", Kind: ConstraintCheck, Check: "price < 1000 OR qty <> 'x'"},
+	}, create.Constraints)
+	
+	for _, input := range []string{
+		"CREATE TABLE t (a INTEGER CHECK a > 0)",
+		"CREATE TABLE t (a INTEGER CHECK (a > 0)",
+		"CREATE TABLE t (a INTEGER CHECK (a))",
+		"CREATE TABLE t (a INTEGER CONSTRAINT c NOT NULL)",
+		"CREATE TABLE t (a INTEGER, UNIQUE a)",
+		"CREATE TABLE t (a INTEGER, CONSTRAINT UNIQUE (a))",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+func TestParseForeignKeys(t *testing.T) {
+	stmt, err := ParseSQL("CREATE TABLE orders (id INTEGER PRIMARY KEY, " +
+		"user_id INTEGER REFERENCES users ON DELETE CASCADE, " +
+		"sku TEXT CONSTRAINT order_product REFERENCES products (sku) ON UPDATE SET NULL ON DELETE SET DEFAULT, " +
+		"region TEXT, warehouse INTEGER, " +
+		"CONSTRAINT stock FOREIGN KEY (region, warehouse) REFERENCES warehouses (region, id) ON DELETE NO ACTION ON UPDATE RESTRICT)")
+	require.NoError(t, err)
+	
+	create := stmt.(*CreateTableStatement)
+	assert.Equal(t, []ConstraintDefinition{
+		{Kind: ConstraintForeignKey, Columns: []string{"user_id"}, RefTable: "users", OnDelete: ActionCascade},
+	}, create.Columns[1].Constraints)
+	assert.Equal(t, []ConstraintDefinition{
+		{Name: "order_product", Kind: ConstraintForeignKey, Columns: []string{"sku"}, RefTable: "products",
+			RefColumns: []string{"sku"}, OnDelete: ActionSetDefault, OnUpdate: ActionSetNull},
+	}, create.Columns[2].Constraints)
+	assert.Equal(t, []ConstraintDefinition{
+		{Name: "stock", Kind: ConstraintForeignKey, Columns: []string{"region", "warehouse"}, RefTable: "warehouses",
+			RefColumns: []string{"region", "id"}, OnDelete: ActionNoAction, OnUpdate: ActionRestrict},
+	}, create.Constraints)
+	
+	for _, input := range []string{
+		"CREATE TABLE t (a INTEGER REFERENCES)",
+		"CREATE TABLE t (a INTEGER REFERENCES p (id)",
+		"CREATE TABLE t (a INTEGER REFERENCES p ON DELETE)",
+		"CREATE TABLE t (a INTEGER REFERENCES p ON DELETE SET)",
+		"CREATE TABLE t (a INTEGER REFERENCES p ON INSERT CASCADE)",
+		"CREATE TABLE t (a INTEGER, FOREIGN KEY a REFERENCES p)",
+		"CREATE TABLE t (a INTEGER, FOREIGN KEY (a))",
+		"CREATE TABLE t (a INTEGER, CONSTRAINT REFERENCES p)",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseSequenceStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Statement
+	}{
+		{
+			input:    "CREATE SEQUENCE ids",
+			expected: &CreateSequenceStatement{SequenceName: "ids", Start: 1, Increment: 1},
+		},
+		{
+			input:    "create sequence if not exists ids start with -5 increment by -1",
+			expected: &CreateSequenceStatement{SequenceName: "ids", IfNotExists: true, Start: -5, Increment: -1},
+		},
+		{
+			input:    "DROP SEQUENCE IF EXISTS ids",
+			expected: &DropSequenceStatement{SequenceName: "ids", IfExists: true},
+		},
+	}
+	
+	for _, tt := range tests {
+		stmt, err := ParseSQL(tt.input)
+		assert.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, stmt, tt.input)
+	}
+	
+	stmt, err := ParseSQL("CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT, n INTEGER DEFAULT NEXTVAL('ids'), m REAL DEFAULT -1.5)")
+	require.NoError(t, err)
+	create := stmt.(*CreateTableStatement)
+	assert.True(t, create.Columns[0].AutoIncrement)
+	assert.Equal(t, "NEXTVAL('ids')", create.Columns[1].Default)
+	assert.Equal(t, "-1.5", create.Columns[2].Default)
+	
+	// A DEFAULT is an expression; a comparison or test ends it
+	stmt, err = ParseSQL("CREATE TABLE t (a INTEGER DEFAULT (1 + 2) * 3 NOT NULL, b TEXT DEFAULT 'x' || 'y', " +
+		"c INTEGER DEFAULT nextval('ids') * 10 CHECK (c > 0), d BOOLEAN DEFAULT (1 < 2))")
+	require.NoError(t, err)
+	create = stmt.(*CreateTableStatement)
+	assert.Equal(t, "(1 + 2) * 3", create.Columns[0].Default)
+	assert.True(t, create.Columns[0].NotNull)
+	assert.Equal(t, "'x' || 'y'", create.Columns[1].Default)
+	assert.Equal(t, "nextval('ids') * 10", create.Columns[2].Default)
+	assert.Len(t, create.Columns[2].Constraints, 1)
+	assert.Equal(t, "(1 < 2)", create.Columns[3].Default)
+	
+	for _, input := range []string{
+		"CREATE SEQUENCE",
+		"CREATE SEQUENCE ids INCREMENT BY 0",
+		"CREATE SEQUENCE ids START WITH 'a'",
+		"CREATE TABLE t (n INTEGER DEFAULT nextval(ids))",
+		"CREATE TABLE t (n INTEGER, m INTEGER DEFAULT n + 1)",
+		"CREATE TABLE t (n INTEGER DEFAULT (SELECT 1))",
+		"CREATE TABLE t (n INTEGER DEFAULT 1 +)",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseAlterTableStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *AlterTableStatement
+	}{
+		{
+			input: "ALTER TABLE users ADD COLUMN age INTEGER NOT NULL DEFAULT 0",
+			expected: &AlterTableStatement{
+				TableName: "users",
+				Action:    AlterAddColumn,
+				Column:    ColumnDefinition{Name: "age", Type: TypeInteger, NotNull: true, Default: "0"},
+			},
+		},
+		{
+			input: "ALTER TABLE users ADD nick TEXT DEFAULT 'it''s'",
+			expected: &AlterTableStatement{
+				TableName: "users",
+				Action:    AlterAddColumn,
+				Column:    ColumnDefinition{Name: "nick", Type: TypeText, Default: "'it''s'"},
+			},
+		},
+		{
+			input:    "ALTER TABLE users DROP COLUMN age",
+			expected: &AlterTableStatement{TableName: "users", Action: AlterDropColumn, ColumnName: "age"},
+		},
+		{
+			input:    "ALTER TABLE users RENAME COLUMN name TO nick",
+			expected: &AlterTableStatement{TableName: "users", Action: AlterRenameColumn, ColumnName: "name", NewName: "nick"},
+		},
+		{
+			input:    "alter table users rename to people",
+			expected: &AlterTableStatement{TableName: "users", Action: AlterRenameTable, NewName: "people"},
+		},
+	}
+	
+	for _, tt := range tests {
+		stmt, err := ParseSQL(tt.input)
+		assert.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, stmt, tt.input)
+	}
+	
+	for _, input := range []string{
+		"ALTER users ADD age INTEGER",
+		"ALTER TABLE users ADD COLUMN age",
+		"ALTER TABLE users DROP",
+		"ALTER TABLE users RENAME name nick",
+		"ALTER TABLE users ADD age INTEGER DEFAULT",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseWhereOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Expression
+	}{
+		{"a >= -5", &BinaryExpression{Left: &ColumnRef{Name: "a"}, Operator: ">=", Right: &Literal{Value: int64(-5)}}},
+		{"a = -9223372036854775808", &BinaryExpression{Left: &ColumnRef{Name: "a"}, Operator: "=", Right: &Literal{Value: int64(-9223372036854775808)}}},
+		{"a <> 'x'", &BinaryExpression{Left: &ColumnRef{Name: "a"}, Operator: "<>", Right: &Literal{Value: "x"}}},
+		{"name LIKE 'a%'", &LikeExpression{Left: &ColumnRef{Name: "name"}, Pattern: &Literal{Value: "a%"}}},
+		{"name NOT LIKE '_b'", &LikeExpression{Left: &ColumnRef{Name: "name"}, Pattern: &Literal{Value: "_b"}, Not: true}},
+		{"id IN (1, 2, 3)", &InExpression{
+			Left:   &ColumnRef{Name: "id"},
+			Values: []Expression{&Literal{Value: int64(1)}, &Literal{Value: int64(2)}, &Literal{Value: int64(3)}},
+		}},
+		{"id NOT IN ('x')", &InExpression{Left: &ColumnRef{Name: "id"}, Values: []Expression{&Literal{Value: "x"}}, Not: true}},
+		{"age BETWEEN 18 AND 65", &BetweenExpression{Left: &ColumnRef{Name: "age"}, Low: &Literal{Value: int64(18)}, High: &Literal{Value: int64(65)}}},
+		{"age NOT BETWEEN 1 AND 2", &BetweenExpression{Left: &ColumnRef{Name: "age"}, Low: &Literal{Value: int64(1)}, High: &Literal{Value: int64(2)}, Not: true}},
+		{"email IS NULL", &IsNullExpression{Left: &ColumnRef{Name: "email"}}},
+		{"email IS NOT NULL", &IsNullExpression{Left: &ColumnRef{Name: "email"}, Not: true}},
+		{"age BETWEEN 1 AND 2 AND id = 3", &BinaryExpression{
+			Left:     &BetweenExpression{Left: &ColumnRef{Name: "age"}, Low: &Literal{Value: int64(1)}, High: &Literal{Value: int64(2)}},
+			Operator: "AND",
+			Right:    &BinaryExpression{Left: &ColumnRef{Name: "id"}, Operator: "=", Right: &Literal{Value: int64(3)}},
+		}},
+	}
+	for _, tt := range tests {
+		stmt, err := ParseSQL("SELECT * FROM t WHERE " + tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, stmt.(*SelectStatement).Where, tt.input)
+	}
+	
+	for _, input := range []string{
+		"a NOT = 1",
+		"a IS 1",
+		"a IN ()",
+		"a IN (1, 2",
+		"a BETWEEN 1 OR 2",
+	} {
+		_, err := ParseSQL("SELECT * FROM t WHERE " + input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a = 1 OR b = 2 AND c = 3", "((a = 1) OR ((b = 2) AND (c = 3)))"},
+		{"(a = 1 OR b = 2) AND c = 3", "(((a = 1) OR (b = 2)) AND (c = 3))"},
+		{"NOT a = 1 AND b = 2", "(NOT (a = 1) AND (b = 2))"},
+		{"a + b * c - d / 2 % 3", "((a + (b * c)) - ((d / 2) % 3))"},
+		{"(a + b) * -c", "((a + b) * -c)"},
+		{"a - -1", "(a - -1)"},
+		{"a + 1 > b * 2", "((a + 1) > (b * 2))"},
+		{"first || ' ' || last = 'a b'", "(((first || ' ') || last) = 'a b')"},
+		{"a + 1 BETWEEN 2 AND 3 + 4", "(a + 1) BETWEEN 2 AND (3 + 4)"},
+		{"a * 2 NOT IN (1, b)", "(a * 2) NOT IN (1, b)"},
+		{"flag = TRUE AND x IS NOT NULL", "((flag = TRUE) AND x IS NOT NULL)"},
+		{"1.5 < 2.25", "(1.5 < 2.25)"},
+	}
+	for _, tt := range tests {
+		stmt, err := ParseSQL("SELECT * FROM t WHERE " + tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, stmt.(*SelectStatement).Where.String(), tt.input)
+	}
+	
+	stmt, err := ParseSQL("SELECT id, price * qty AS total, name n FROM t")
+	require.NoError(t, err)
+	assert.Equal(t, []SelectColumn{
+		{Expr: &ColumnRef{Name: "id"}},
+		{Expr: &BinaryExpression{Left: &ColumnRef{Name: "price"}, Operator: "*", Right: &ColumnRef{Name: "qty"}}, Alias: "total"},
+		{Expr: &ColumnRef{Name: "name"}, Alias: "n"},
+	}, stmt.(*SelectStatement).Columns)
+	
+	stmt, err = ParseSQL("INSERT INTO t VALUES (nextval('seq'), NULL, -2, 1 + 1)")
+	require.NoError(t, err)
+	assert.Equal(t, [][]Expression{{
+		&FunctionCall{Name: "nextval", Args: []Expression{&Literal{Value: "seq"}}},
+		&Literal{Value: nil},
+		&Literal{Value: int64(-2)},
+		&BinaryExpression{Left: &Literal{Value: int64(1)}, Operator: "+", Right: &Literal{Value: int64(1)}},
+	}}, stmt.(*InsertStatement).Values)
+	
+	stmt, err = ParseSQL("UPDATE t SET n = n + 1 WHERE id = 1")
+	require.NoError(t, err)
+	assert.Equal(t, []Assignment{
+		{Column: "n", Value: &BinaryExpression{Left: &ColumnRef{Name: "n"}, Operator: "+", Right: &Literal{Value: int64(1)}}},
+	}, stmt.(*UpdateStatement).Set)
+	
+	stmt, err = ParseSQL("UPDATE t SET b = a, a = b")
+	require.NoError(t, err)
+	assert.Equal(t, []Assignment{
+		{Column: "b", Value: &ColumnRef{Name: "a"}},
+		{Column: "a", Value: &ColumnRef{Name: "b"}},
+	}, stmt.(*UpdateStatement).Set)
+	
+	_, err = ParseSQL("UPDATE t SET a = 1, A = 2")
+	assert.EqualError(t, err, "column A is assigned more than once")
+	_, err = ParseSQL("UPDATE t SET a = 1, b = 2, a = 3")
+	assert.Error(t, err)
+	
+	for _, input := range []string{
+		"a +",
+		"(a = 1",
+		"a = 1)",
+		"a | b",
+		"* 2",
+		"NOT",
+	} {
+		_, err := ParseSQL("SELECT * FROM t WHERE " + input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseOrderByLimit(t *testing.T) {
+	stmt, err := ParseSQL("SELECT * FROM t WHERE a > 1 ORDER BY a DESC NULLS LAST, b + 1, c ASC NULLS FIRST LIMIT 10 OFFSET 2 * 5")
+	require.NoError(t, err)
+	sel := stmt.(*SelectStatement)
+	assert.Equal(t, []OrderByItem{
+		{Expr: &ColumnRef{Name: "a"}, Desc: true, Nulls: NullsLast},
+		{Expr: &BinaryExpression{Left: &ColumnRef{Name: "b"}, Operator: "+", Right: &Literal{Value: int64(1)}}},
+		{Expr: &ColumnRef{Name: "c"}, Nulls: NullsFirst},
+	}, sel.OrderBy)
+	assert.Equal(t, &Literal{Value: int64(10)}, sel.Limit)
+	assert.Equal(t, "(2 * 5)", sel.Offset.String())
+	
+	stmt, err = ParseSQL("SELECT first, last FROM t ORDER BY last OFFSET 3")
+	require.NoError(t, err)
+	sel = stmt.(*SelectStatement)
+	assert.Equal(t, []OrderByItem{{Expr: &ColumnRef{Name: "last"}}}, sel.OrderBy)
+	assert.Nil(t, sel.Limit)
+	assert.Equal(t, &Literal{Value: int64(3)}, sel.Offset)
+	
+	for _, input := range []string{
+		"SELECT * FROM t ORDER a",
+		"SELECT * FROM t ORDER BY",
+		"SELECT * FROM t ORDER BY a NULLS",
+		"SELECT * FROM t ORDER BY a DESC ASC",
+		"SELECT * FROM t LIMIT",
+		"SELECT * FROM t OFFSET 1 LIMIT 2",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseAggregates(t *testing.T) {
+	stmt, err := ParseSQL("SELECT dept, count(*), COUNT(DISTINCT title), sum(salary) AS total FROM staff WHERE age > 30 GROUP BY dept, 2 HAVING count(*) > 1 ORDER BY total DESC")
+	require.NoError(t, err)
+	sel := stmt.(*SelectStatement)
+	assert.Equal(t, &FunctionCall{Name: "count", Star: true}, sel.Columns[1].Expr)
+	assert.Equal(t, &FunctionCall{Name: "count", Args: []Expression{&ColumnRef{Name: "title"}}, Distinct: true}, sel.Columns[2].Expr)
+	assert.Equal(t, "total", sel.Columns[3].Alias)
+	assert.Equal(t, []Expression{&ColumnRef{Name: "dept"}, &Literal{Value: int64(2)}}, sel.GroupBy)
+	assert.Equal(t, "(count(*) > 1)", sel.Having.String())
+	assert.Equal(t, "count(DISTINCT title)", sel.Columns[2].Expr.String())
+	assert.Len(t, sel.OrderBy, 1)
+	
+	stmt, err = ParseSQL("SELECT max(a) FROM t HAVING max(a) > 3")
+	require.NoError(t, err)
+	sel = stmt.(*SelectStatement)
+	assert.Nil(t, sel.GroupBy)
+	assert.NotNil(t, sel.Having)
+	
+	for _, input := range []string{
+		"SELECT count(* FROM t",
+		"SELECT count(*, a) FROM t",
+		"SELECT count(DISTINCT) FROM t",
+		"SELECT a FROM t GROUP a",
+		"SELECT a FROM t GROUP BY",
+		"SELECT a FROM t HAVING",
+		"SELECT a FROM t ORDER BY a GROUP BY a",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseJoins(t *testing.T) {
+	stmt, err := ParseSQL("SELECT u.name, o.* FROM users AS u JOIN orders o ON o.user_id = u.id LEFT OUTER JOIN items ON items.order_id = o.id, tags CROSS JOIN colors c WHERE u.id > 1")
+	require.NoError(t, err)
+	sel := stmt.(*SelectStatement)
+	assert.Equal(t, "users", sel.TableName)
+	assert.Equal(t, "u", sel.Alias)
+	assert.Equal(t, &ColumnRef{Table: "u", Name: "name"}, sel.Columns[0].Expr)
+	assert.Equal(t, SelectColumn{Table: "o"}, sel.Columns[1])
+	require.Len(t, sel.Joins, 4)
+	assert.Equal(t, Join{Kind: InnerJoin, TableName: "orders", Alias: "o", On: &BinaryExpression{
+		Left:     &ColumnRef{Table: "o", Name: "user_id"},
+		Operator: "=",
+		Right:    &ColumnRef{Table: "u", Name: "id"},
+	}}, sel.Joins[0])
+	assert.Equal(t, LeftJoin, sel.Joins[1].Kind)
+	assert.Equal(t, "(items.order_id = o.id)", sel.Joins[1].On.String())
+	assert.Equal(t, Join{Kind: CrossJoin, TableName: "tags"}, sel.Joins[2])
+	assert.Equal(t, Join{Kind: CrossJoin, TableName: "colors", Alias: "c"}, sel.Joins[3])
+	assert.Equal(t, "(u.id > 1)", sel.Where.String())
+	
+	stmt, err = ParseSQL("SELECT * FROM a INNER JOIN b ON a.x = b.x ORDER BY b.y")
+	require.NoError(t, err)
+	sel = stmt.(*SelectStatement)
+	assert.Equal(t, InnerJoin, sel.Joins[0].Kind)
+	assert.Equal(t, &ColumnRef{Table: "b", Name: "y"}, sel.OrderBy[0].Expr)
+	
+	for _, input := range []string{
+		"SELECT * FROM a JOIN b",
+		"SELECT * FROM a JOIN ON a.x = 1",
+		"SELECT * FROM a LEFT b ON a.x = b.x",
+		"SELECT * FROM a CROSS b",
+		"SELECT * FROM a CROSS JOIN b ON a.x = b.x",
+		"SELECT * FROM a INNER b ON a.x = b.x",
+		"SELECT * FROM a AS",
+		"SELECT a. FROM a",
+		"SELECT * FROM a,",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseSubqueries(t *testing.T) {
+	stmt, err := ParseSQL("SELECT name, (SELECT max(total) FROM orders o WHERE o.user_id = u.id) AS top FROM users u WHERE EXISTS (SELECT 1 FROM orders WHERE orders.user_id = u.id) AND id NOT IN (SELECT user_id FROM banned)")
+	require.NoError(t, err)
+	sel := stmt.(*SelectStatement)
+	sub, ok := sel.Columns[1].Expr.(*SubqueryExpression)
+	require.True(t, ok)
+	assert.Equal(t, "orders", sub.Select.TableName)
+	assert.Equal(t, "o", sub.Select.Alias)
+	assert.Equal(t, "(SELECT max(total) FROM orders AS o WHERE (o.user_id = u.id))", sub.String())
+	where := sel.Where.(*BinaryExpression)
+	assert.IsType(t, &ExistsExpression{}, where.Left)
+	in := where.Right.(*InExpression)
+	assert.True(t, in.Not)
+	assert.Equal(t, "banned", in.Subquery.TableName)
+	assert.Equal(t, "id NOT IN (SELECT user_id FROM banned)", in.String())
+	
+	stmt, err = ParseSQL("SELECT * FROM a WHERE NOT EXISTS (SELECT * FROM b WHERE b.x = a.x)")
+	require.NoError(t, err)
+	not := stmt.(*SelectStatement).Where.(*UnaryExpression)
+	assert.Equal(t, "NOT EXISTS (SELECT * FROM b WHERE (b.x = a.x))", not.String())
+	
+	stmt, err = ParseSQL("SELECT t.n FROM (SELECT count(*) AS n FROM a) AS t JOIN (SELECT id FROM b ORDER BY id DESC LIMIT 2) b ON b.id = t.n")
+	require.NoError(t, err)
+	sel = stmt.(*SelectStatement)
+	assert.Equal(t, "", sel.TableName)
+	assert.Equal(t, "t", sel.Alias)
+	assert.Equal(t, "a", sel.Subquery.TableName)
+	require.Len(t, sel.Joins, 1)
+	assert.Equal(t, "b", sel.Joins[0].Alias)
+	assert.Equal(t, "SELECT id FROM b ORDER BY id DESC LIMIT 2", sel.Joins[0].Subquery.sql())
+	
+	for _, input := range []string{
+		"SELECT * FROM (SELECT * FROM a)",
+		"SELECT * FROM (a) AS t",
+		"SELECT * FROM a WHERE EXISTS SELECT 1 FROM b",
+		"SELECT * FROM a WHERE x IN (SELECT y FROM b",
+		"SELECT (SELECT FROM a) FROM b",
+		"SELECT * FROM a WHERE EXISTS (1)",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	parse := func(sql string) (Statement, int) {
+		parser := NewParser(NewLexer(sql))
+		stmt, err := parser.Parse()
+		require.NoError(t, err, sql)
+		return stmt, parser.params
+	}
+	
+	// Each ? takes the next number
+	stmt, n := parse("SELECT * FROM users WHERE age > ? AND name = ? LIMIT ?")
+	assert.Equal(t, 3, n)
+	sel := stmt.(*SelectStatement)
+	assert.Equal(t, "((age > $1) AND (name = $2))", sel.Where.String())
+	assert.Equal(t, &Parameter{Index: 3}, sel.Limit)
+	
+	// $n may repeat and come in any order
+	stmt, n = parse("UPDATE users SET name = $2 WHERE id = $1 OR parent = $1")
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []Assignment{{Column: "name", Value: &Parameter{Index: 2}}}, stmt.(*UpdateStatement).Set)
+	
+	stmt, n = parse("INSERT INTO users VALUES (?, ?), (?, 'x')")
+	assert.Equal(t, 3, n)
+	assert.Equal(t, &Parameter{Index: 3}, stmt.(*InsertStatement).Values[1][0])
+	
+	_, n = parse("DELETE FROM users WHERE id IN (SELECT user_id FROM banned WHERE until > $1)")
+	assert.Equal(t, 1, n)
+	
+	for _, input := range []string{
+		"SELECT * FROM users WHERE id = ? OR id = $2",
+		"SELECT * FROM users WHERE id = $0",
+		"SELECT * FROM users WHERE id = $",
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, n INTEGER DEFAULT ?)",
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, n INTEGER CHECK (n > $1))",
+	} {
+		_, err := ParseSQL(input)
+		assert.Error(t, err, input)
+	}
+	
+	lexer := NewLexer("id = $12 AND ?")
+	for _, expected := range []Token{
+		{Type: IDENTIFIER, Literal: "id", Pos: 0},
+		{Type: EQUAL, Literal: "=", Pos: 3},
+		{Type: PARAMETER, Literal: "$12", Pos: 5},
+		{Type: AND, Literal: "AND", Pos: 9},
+		{Type: PARAMETER, Literal: "?", Pos: 13},
+	} {
+		assert.Equal(t, expected, lexer.NextToken())
+	}
+}
+
 func TestLexer(t *testing.T) {
 	input := "SELECT * FROM users WHERE id = '123'"
 	
@@ -201,4 +862,4 @@ func TestLexer(t *testing.T) {
 		tok := lexer.NextToken()
 		assert.Equal(t, expectedType, tok.Type, "token %d - expected %v, got %v", i, expectedType, tok.Type)
 	}
-}
\ No newline at end of file
+}
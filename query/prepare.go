@@ -0,0 +1,397 @@
+package query
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// statementCacheSize is how many parsed statements the statement cache
+// keeps
+const statementCacheSize = 256
+
+// statements caches the statements that Prepare and ExecuteSQL parse
+var statements = newStatementCache(statementCacheSize)
+
+// parsedStatement is a statement parsed from SQL text along with the number
+// of parameters it takes. Running a statement never changes it, so one
+// parsed statement can be run by any number of callers at once.
+type parsedStatement struct {
+	stmt   Statement
+	params int
+}
+
+// statementCache is a bounded cache of parsed statements keyed by their SQL
+// text. When full, it drops the statement used least recently.
+type statementCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *statementCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+// statementCacheEntry is a statement in a statementCache
+type statementCacheEntry struct {
+	sql    string
+	parsed *parsedStatement
+}
+
+// newStatementCache creates a cache holding up to size statements
+func newStatementCache(size int) *statementCache {
+	return &statementCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the statement parsed from sql, if the cache has it
+func (c *statementCache) get(sql string) (*parsedStatement, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[sql]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*statementCacheEntry).parsed, true
+}
+
+// put adds the statement parsed from sql, dropping the least recently used
+// statement if the cache is full
+func (c *statementCache) put(sql string, parsed *parsedStatement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[sql]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[sql] = c.order.PushFront(&statementCacheEntry{sql: sql, parsed: parsed})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*statementCacheEntry).sql)
+	}
+}
+
+// len returns the number of statements in the cache
+func (c *statementCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// parseCached parses SQL text, reusing the statement parsed from the same
+// text before if the cache still has it
+func parseCached(sql string) (*parsedStatement, error) {
+	if parsed, ok := statements.get(sql); ok {
+		return parsed, nil
+	}
+	parser := NewParser(NewLexer(sql))
+	stmt, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	parsed := &parsedStatement{stmt: stmt, params: parser.params}
+	statements.put(sql, parsed)
+	return parsed, nil
+}
+
+// Stmt is a prepared statement: SQL text parsed once, then run any number
+// of times with values bound to its ? or $n parameters. Binding values
+// keeps them out of the SQL text, so they are never parsed as SQL. A Stmt
+// can be run from several goroutines at once.
+type Stmt struct {
+	exec   *Executor // nil until the statement is given an executor
+	parsed *parsedStatement
+}
+
+// Prepare parses SQL text into a statement that is not tied to any
+// database. Run it with Executor.ExecuteStmt.
+func Prepare(sql string) (*Stmt, error) {
+	parsed, err := parseCached(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	return &Stmt{parsed: parsed}, nil
+}
+
+// Prepare parses SQL text into a statement to run on the executor with
+// Stmt.Exec or Stmt.Query
+func (e *Executor) Prepare(sql string) (*Stmt, error) {
+	stmt, err := Prepare(sql)
+	if err != nil {
+		return nil, err
+	}
+	stmt.exec = e
+	return stmt, nil
+}
+
+// ExecuteStmt runs a prepared statement on the executor with args bound to
+// its parameters, as Stmt.Exec does
+func (e *Executor) ExecuteStmt(stmt *Stmt, args ...any) *QueryResult {
+	return e.ExecuteStmtContext(context.Background(), stmt, args...)
+}
+
+// ExecuteStmtContext runs a prepared statement as ExecuteStmt does,
+// stopping early if the context is canceled or times out
+func (e *Executor) ExecuteStmtContext(ctx context.Context, stmt *Stmt, args ...any) *QueryResult {
+	bound, err := stmt.bind(args)
+	if err != nil {
+		return &QueryResult{Success: false, Error: err}
+	}
+	return e.ExecuteContext(ctx, bound)
+}
+
+// NumParams returns the number of values the statement takes
+func (s *Stmt) NumParams() int {
+	return s.parsed.params
+}
+
+// Exec runs the statement with args bound to its parameters in order: the
+// first to $1 or the first ?, and so on. An argument may be nil for NULL,
+// or a Go integer, float, bool, string or []byte, or a pointer to one.
+func (s *Stmt) Exec(args ...any) *QueryResult {
+	return s.ExecContext(context.Background(), args...)
+}
+
+// ExecContext runs the statement as Exec does, stopping early if the
+// context is canceled or times out
+func (s *Stmt) ExecContext(ctx context.Context, args ...any) *QueryResult {
+	if s.exec == nil {
+		return &QueryResult{Success: false, Error: fmt.Errorf("statement has no executor; run it with Executor.ExecuteStmt")}
+	}
+	return s.exec.ExecuteStmtContext(ctx, s, args...)
+}
+
+// Query runs a statement that returns rows, a SELECT, with args bound as
+// Exec binds them
+func (s *Stmt) Query(args ...any) *QueryResult {
+	return s.QueryContext(context.Background(), args...)
+}
+
+// QueryContext runs the statement as Query does, stopping early if the
+// context is canceled or times out
+func (s *Stmt) QueryContext(ctx context.Context, args ...any) *QueryResult {
+	if _, ok := s.parsed.stmt.(*SelectStatement); !ok {
+		return &QueryResult{Success: false, Error: fmt.Errorf("%s returns no rows; run it with Exec", s.parsed.stmt)}
+	}
+	return s.ExecContext(ctx, args...)
+}
+
+// bind returns the statement with the values of args in place of its
+// parameters
+func (s *Stmt) bind(args []any) (Statement, error) {
+	if len(args) != s.parsed.params {
+		return nil, fmt.Errorf("statement takes %d parameters, not %d", s.parsed.params, len(args))
+	}
+	if len(args) == 0 {
+		return s.parsed.stmt, nil
+	}
+
+	values := make(parameterValues, len(args))
+	for i, arg := range args {
+		v, err := argValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parameter $%d: %w", i+1, err)
+		}
+		values[i] = v
+	}
+	return values.statement(s.parsed.stmt)
+}
+
+// argValue converts a Go value bound to a parameter to the value it stands
+// for
+func argValue(arg any) (Value, error) {
+	if b, ok := arg.([]byte); ok {
+		return append([]byte{}, b...), nil
+	}
+
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d is out of range for an INTEGER", v.Uint())
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte{}, v.Bytes()...), nil
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return argValue(v.Elem().Interface())
+	}
+	return nil, fmt.Errorf("cannot bind a value of type %T", arg)
+}
+
+// parameterValues are the values bound to a statement's parameters, the
+// value of $n at n-1
+type parameterValues []Value
+
+// statement returns a copy of a statement with the values in place of its
+// parameters, including those of its subqueries
+func (p parameterValues) statement(stmt Statement) (Statement, error) {
+	var err error
+	switch s := stmt.(type) {
+	case *SelectStatement:
+		return p.selectStatement(s)
+	case *InsertStatement:
+		c := *s
+		c.Values = make([][]Expression, len(s.Values))
+		for i, row := range s.Values {
+			if c.Values[i], err = p.list(row); err != nil {
+				return nil, err
+			}
+		}
+		if s.Select != nil {
+			if c.Select, err = p.selectStatement(s.Select); err != nil {
+				return nil, err
+			}
+		}
+		return &c, nil
+	case *UpdateStatement:
+		c := *s
+		c.Set = make([]Assignment, len(s.Set))
+		for i, a := range s.Set {
+			if a.Value, err = p.expression(a.Value); err != nil {
+				return nil, err
+			}
+			c.Set[i] = a
+		}
+		if c.Where, err = p.expression(s.Where); err != nil {
+			return nil, err
+		}
+		return &c, nil
+	case *DeleteStatement:
+		c := *s
+		if c.Where, err = p.expression(s.Where); err != nil {
+			return nil, err
+		}
+		return &c, nil
+	}
+	return stmt, nil
+}
+
+// selectStatement returns a copy of a SELECT with the values in place of
+// its parameters
+func (p parameterValues) selectStatement(stmt *SelectStatement) (*SelectStatement, error) {
+	var err error
+	c := *stmt
+	if stmt.Subquery != nil {
+		if c.Subquery, err = p.selectStatement(stmt.Subquery); err != nil {
+			return nil, err
+		}
+	}
+	c.Columns = make([]SelectColumn, len(stmt.Columns))
+	for i, col := range stmt.Columns {
+		if col.Expr, err = p.expression(col.Expr); err != nil {
+			return nil, err
+		}
+		c.Columns[i] = col
+	}
+	c.Joins = make([]Join, len(stmt.Joins))
+	for i, join := range stmt.Joins {
+		if join.Subquery != nil {
+			if join.Subquery, err = p.selectStatement(join.Subquery); err != nil {
+				return nil, err
+			}
+		}
+		if join.On, err = p.expression(join.On); err != nil {
+			return nil, err
+		}
+		c.Joins[i] = join
+	}
+	if c.Where, err = p.expression(stmt.Where); err != nil {
+		return nil, err
+	}
+	if c.GroupBy, err = p.list(stmt.GroupBy); err != nil {
+		return nil, err
+	}
+	if c.Having, err = p.expression(stmt.Having); err != nil {
+		return nil, err
+	}
+	c.OrderBy = make([]OrderByItem, len(stmt.OrderBy))
+	for i, item := range stmt.OrderBy {
+		if item.Expr, err = p.expression(item.Expr); err != nil {
+			return nil, err
+		}
+		c.OrderBy[i] = item
+	}
+	if c.Limit, err = p.expression(stmt.Limit); err != nil {
+		return nil, err
+	}
+	if c.Offset, err = p.expression(stmt.Offset); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// list returns a copy of a list of expressions with the values in place of
+// their parameters
+func (p parameterValues) list(exprs []Expression) ([]Expression, error) {
+	if exprs == nil {
+		return nil, nil
+	}
+	out := make([]Expression, len(exprs))
+	for i, expr := range exprs {
+		var err error
+		if out[i], err = p.expression(expr); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// expression returns a copy of an expression with the values in place of
+// its parameters
+func (p parameterValues) expression(expr Expression) (Expression, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	return rewriteExpression(expr, func(x Expression) (Expression, error) {
+		switch x := x.(type) {
+		case *Parameter:
+			return &Literal{Value: p[x.Index-1]}, nil
+		case *SubqueryExpression:
+			stmt, err := p.selectStatement(x.Select)
+			if err != nil {
+				return nil, err
+			}
+			return &SubqueryExpression{Select: stmt}, nil
+		case *ExistsExpression:
+			stmt, err := p.selectStatement(x.Select)
+			if err != nil {
+				return nil, err
+			}
+			return &ExistsExpression{Select: stmt}, nil
+		case *InExpression:
+			if x.Subquery == nil {
+				return nil, nil
+			}
+			left, err := p.expression(x.Left)
+			if err != nil {
+				return nil, err
+			}
+			stmt, err := p.selectStatement(x.Subquery)
+			if err != nil {
+				return nil, err
+			}
+			return &InExpression{Left: left, Subquery: stmt, Not: x.Not}, nil
+		}
+		return nil, nil
+	})
+}
@@ -0,0 +1,130 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreparedStatements(t *testing.T) {
+	db := NewMockDatabase()
+	executor := NewExecutor(db)
+
+	prepare := func(sql string) *Stmt {
+		stmt, err := executor.Prepare(sql)
+		require.NoError(t, err, sql)
+		return stmt
+	}
+	exec := func(stmt *Stmt, args ...any) *QueryResult {
+		result := stmt.Exec(args...)
+		require.True(t, result.Success, "%v", result.Error)
+		return result
+	}
+
+	exec(prepare("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, score REAL, active BOOLEAN, avatar BLOB)"))
+
+	// Arguments bind as the values of their Go types
+	type userID int32
+	score := 7.5
+	insert := prepare("INSERT INTO users VALUES (?, ?, ?, ?, ?)")
+	assert.Equal(t, 5, insert.NumParams())
+	exec(insert, 1, "ann", 9.5, true, []byte{1, 2})
+	exec(insert, userID(2), "ben", &score, false, nil)
+	exec(insert, uint8(3), "o'brien'); DROP TABLE users; --", float32(0.5), nil, []byte{})
+
+	query := prepare("SELECT name, score FROM users WHERE id = $1 OR (active = $2 AND score > $3) ORDER BY id")
+	result := query.Query(2, true, 9.0)
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"name": "ann", "score": "9.5"}, {"name": "ben", "score": "7.5"}}, result.Rows)
+
+	// A value is never parsed as SQL
+	result = prepare("SELECT id FROM users WHERE name = ?").Query("o'brien'); DROP TABLE users; --")
+	require.True(t, result.Success, "%v", result.Error)
+	assert.Equal(t, []map[string]string{{"id": "3"}}, result.Rows)
+
+	// A statement can be run again with other values
+	page := prepare("SELECT id FROM users WHERE id IN (SELECT id FROM users WHERE score >= ?) ORDER BY id LIMIT ? OFFSET ?")
+	result = page.Query(1, 1, 1)
+	assert.Equal(t, []map[string]string{{"id": "2"}}, result.Rows)
+	result = page.Query(0, 5, 0)
+	assert.Len(t, result.Rows, 3)
+
+	update := prepare("UPDATE users SET score = score + ? WHERE active = ?")
+	assert.Equal(t, int64(1), exec(update, 1, true).RowsAffected)
+	assert.Equal(t, int64(1), exec(prepare("DELETE FROM users WHERE avatar IS NULL AND name = ?"), "ben").RowsAffected)
+	assert.Equal(t, int64(1), exec(prepare("INSERT INTO users (id, name) SELECT id + ?, name FROM users WHERE id = ?"), 10, 1).RowsAffected)
+	result = ExecuteSQL(db, "SELECT id, score FROM users ORDER BY id")
+	assert.Equal(t, []map[string]string{{"id": "1", "score": "10.5"}, {"id": "3", "score": "0.5"}, {"id": "11", "score": "NULL"}}, result.Rows)
+
+	for _, args := range [][]any{
+		{1},
+		{1, 2, 3},
+		{struct{}{}, 1},
+		{uint64(1) << 63, 1},
+	} {
+		result := update.Exec(args...)
+		assert.False(t, result.Success, "%v", args)
+	}
+	assert.False(t, insert.Query(4, "x", 1.0, true, nil).Success)
+	assert.False(t, ExecuteSQL(db, "SELECT * FROM users WHERE id = ?").Success)
+	_, err := executor.Prepare("SELECT * FROM users WHERE")
+	assert.Error(t, err)
+
+	// Every $n up to the highest has to be used
+	_, err = executor.Prepare("SELECT * FROM users WHERE id = $2")
+	assert.EqualError(t, err, "parse error: parameter $1 is missing; parameters are numbered from $1 without gaps")
+	_, err = executor.Prepare("SELECT * FROM users WHERE id = $1 OR id = $3")
+	assert.Error(t, err)
+	assert.Equal(t, 2, prepare("SELECT * FROM users WHERE id = $2 OR score > $1 OR id = $2").NumParams())
+}
+
+func TestPrepareWithoutExecutor(t *testing.T) {
+	stmt, err := Prepare("SELECT name FROM users WHERE id = ?")
+	require.NoError(t, err)
+	assert.False(t, stmt.Query(1).Success)
+
+	// The same statement runs on any executor
+	for _, name := range []string{"ann", "ben"} {
+		db := NewMockDatabase()
+		executor := NewExecutor(db)
+		for _, sql := range []string{
+			"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+			"INSERT INTO users VALUES (1, '" + name + "')",
+		} {
+			result := ExecuteSQL(db, sql)
+			require.True(t, result.Success, "%s: %v", sql, result.Error)
+		}
+		result := executor.ExecuteStmt(stmt, 1)
+		require.True(t, result.Success, "%v", result.Error)
+		assert.Equal(t, []map[string]string{{"name": name}}, result.Rows)
+		assert.False(t, executor.ExecuteStmt(stmt).Success)
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	sql := "SELECT * FROM cached WHERE id = ?"
+	first, err := parseCached(sql)
+	require.NoError(t, err)
+	second, err := parseCached(sql)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.LessOrEqual(t, statements.len(), statementCacheSize)
+
+	// The cache drops the statement used least recently
+	cache := newStatementCache(2)
+	a, b, c := &parsedStatement{}, &parsedStatement{}, &parsedStatement{}
+	cache.put("a", a)
+	cache.put("b", b)
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.put("c", c)
+	assert.Equal(t, 2, cache.len())
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	got, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Same(t, a, got)
+	got, _ = cache.get("c")
+	assert.Same(t, c, got)
+}
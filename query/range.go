@@ -0,0 +1,543 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+)
+
+// rangeBound is one end of a range of column values
+type rangeBound struct {
+	value     Value
+	inclusive bool
+}
+
+// valueRange is the range of values the conditions of a WHERE clause allow
+// in one column. A nil bound leaves that end open.
+type valueRange struct {
+	low, high *rangeBound
+}
+
+// narrow tightens the range to the values on one side of a bound: above it
+// if lower is true, below it otherwise
+func (r *valueRange) narrow(b *rangeBound, lower bool) error {
+	current := &r.high
+	if lower {
+		current = &r.low
+	}
+	if *current == nil {
+		*current = b
+		return nil
+	}
+
+	c, err := compareValues(b.value, (*current).value)
+	if err != nil {
+		return err
+	}
+	if !lower {
+		c = -c
+	}
+	if c > 0 || c == 0 && !b.inclusive {
+		*current = b
+	}
+	return nil
+}
+
+// aboveLow reports whether a non-NULL value is within the lower bound
+func (r *valueRange) aboveLow(v Value) (bool, error) {
+	if r.low == nil {
+		return true, nil
+	}
+	c, err := compareValues(v, r.low.value)
+	return c > 0 || c == 0 && r.low.inclusive, err
+}
+
+// belowHigh reports whether a non-NULL value is within the upper bound
+func (r *valueRange) belowHigh(v Value) (bool, error) {
+	if r.high == nil {
+		return true, nil
+	}
+	c, err := compareValues(v, r.high.value)
+	return c < 0 || c == 0 && r.high.inclusive, err
+}
+
+// whereConditions collects the conditions of a WHERE clause that can choose
+// the rows to read: the column = constant comparisons and the range
+// conditions (<, <=, >, >= and BETWEEN) joined to the rest of the clause by
+// AND, keyed by column position. Rows found through them must still be
+// tested against the whole clause.
+func whereConditions(schema *Schema, where Expression) (map[int]Value, map[int]*valueRange, error) {
+	equal := make(map[int]Value)
+	ranges := make(map[int]*valueRange)
+	bound := func(i int, b *rangeBound, lower bool) error {
+		if ranges[i] == nil {
+			ranges[i] = &valueRange{}
+		}
+		return ranges[i].narrow(b, lower)
+	}
+
+	var collect func(Expression) error
+	collect = func(expr Expression) error {
+		switch e := expr.(type) {
+		case *BinaryExpression:
+			if e.Operator == "AND" {
+				if err := collect(e.Left); err != nil {
+					return err
+				}
+				return collect(e.Right)
+			}
+			i, operator, v, ok, err := columnComparison(schema, e)
+			if err != nil || !ok {
+				return err
+			}
+			switch operator {
+			case "=":
+				if _, dup := equal[i]; !dup {
+					equal[i] = v
+				}
+			case "<", "<=":
+				return bound(i, &rangeBound{v, operator == "<="}, false)
+			case ">", ">=":
+				return bound(i, &rangeBound{v, operator == ">="}, true)
+			}
+		case *BetweenExpression:
+			if e.Not {
+				return nil
+			}
+			i, low, ok, err := columnConstant(schema, e.Left, e.Low)
+			if err != nil || !ok {
+				return err
+			}
+			_, high, ok, err := columnConstant(schema, e.Left, e.High)
+			if err != nil || !ok {
+				return err
+			}
+			if err := bound(i, &rangeBound{low, true}, true); err != nil {
+				return err
+			}
+			return bound(i, &rangeBound{high, true}, false)
+		}
+		return nil
+	}
+
+	if where == nil {
+		return equal, ranges, nil
+	}
+	return equal, ranges, collect(where)
+}
+
+// flippedComparisons gives the operator that keeps a comparison's meaning
+// when its operands swap sides
+var flippedComparisons = map[string]string{
+	"=": "=", "!=": "!=", "<>": "<>", "<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// columnComparison matches a comparison of a column with a constant, written
+// either way round. It returns the column's position, the operator as if the
+// column were on the left, and the constant converted to the column's type.
+func columnComparison(schema *Schema, e *BinaryExpression) (int, string, Value, bool, error) {
+	flipped, ok := flippedComparisons[e.Operator]
+	if !ok {
+		return 0, "", nil, false, nil
+	}
+	i, v, ok, err := columnConstant(schema, e.Left, e.Right)
+	if err != nil || ok {
+		return i, e.Operator, v, ok, err
+	}
+	i, v, ok, err = columnConstant(schema, e.Right, e.Left)
+	return i, flipped, v, ok, err
+}
+
+// columnConstant matches a column and a constant, and returns the column's
+// position and the constant converted to the column's type. A NULL constant
+// does not match, since no row can satisfy a comparison with it, and
+// neither does a REAL with a fraction compared with an INTEGER column,
+// which comparing each row decides instead.
+func columnConstant(schema *Schema, column, constant Expression) (int, Value, bool, error) {
+	ref, ok := column.(*ColumnRef)
+	if !ok || !isConstant(constant) {
+		return 0, nil, false, nil
+	}
+	i, err := schema.resolveColumn(ref)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	v, err := (&evalContext{}).eval(constant)
+	if err != nil || v == nil {
+		return 0, nil, false, err
+	}
+	c, err := coerceValue(v, schema.Columns[i].Type)
+	if err != nil {
+		if _, real := v.(float64); real && schema.Columns[i].Type == TypeInteger {
+			return 0, nil, false, nil
+		}
+		return 0, nil, false, fmt.Errorf("column %s: %w", ref.Name, err)
+	}
+	return i, c, true, nil
+}
+
+// keyRange is a run of consecutive keys of a table: those that start with
+// prefix, the encoded values of the leading key columns fixed by
+// equalities, and whose value in the key column after them lies in values
+type keyRange struct {
+	prefix []byte
+	column int // the bounded key column, or -1
+	values *valueRange
+}
+
+// keyRangeFor returns the key range the conditions of a WHERE clause allow,
+// if they fix or bound a leading key column
+func keyRangeFor(schema *Schema, equal map[int]Value, ranges map[int]*valueRange) (keyRange, bool, error) {
+	r := keyRange{column: -1}
+	for _, i := range schema.keyColumns() {
+		if v, ok := equal[i]; ok {
+			var err error
+			if r.prefix, err = appendKeyValue(r.prefix, schema.Columns[i], v); err != nil {
+				return keyRange{}, false, err
+			}
+			continue
+		}
+		if values, ok := ranges[i]; ok {
+			r.column, r.values = i, values
+		}
+		break
+	}
+	return r, len(r.prefix) > 0 || r.column >= 0, nil
+}
+
+// scanKeyRange calls fn with every row in a key range, in key order, from
+// the largest key down if reverse is set. The scan starts at one bound and
+// stops at the first key past the other.
+func scanKeyRange(ctx context.Context, table Table, schema *Schema, r keyRange, reverse bool, fn func(key []byte, row Row) error) error {
+	// inRange reports whether a row is within the range, and whether the
+	// scan has gone past its far end
+	inRange := func(row Row) (in, more bool, err error) {
+		if r.column < 0 {
+			return true, true, nil
+		}
+		below, err := r.values.belowHigh(row[r.column])
+		if err != nil {
+			return false, false, err
+		}
+		above, err := r.values.aboveLow(row[r.column])
+		if err != nil {
+			return false, false, err
+		}
+		if reverse {
+			return above && below, above, nil
+		}
+		return above && below, below, nil
+	}
+	visit := func(key, data []byte) (bool, error) {
+		if !bytes.HasPrefix(key, r.prefix) {
+			return false, nil
+		}
+		row, err := DecodeRow(schema, data)
+		if err != nil {
+			return false, fmt.Errorf("row %q: %w", key, err)
+		}
+		in, more, err := inRange(row)
+		if err != nil || !more || !in {
+			return more, err
+		}
+		return true, fn(key, row)
+	}
+
+	if reverse {
+		end, err := r.end(schema)
+		if err != nil {
+			return err
+		}
+		return scanBefore(ctx, table, end, visit)
+	}
+	start, err := r.start(schema)
+	if err != nil {
+		return err
+	}
+	return scanFrom(ctx, table, start, visit)
+}
+
+// start returns the smallest key a forward scan of the range must read
+func (r keyRange) start(schema *Schema) ([]byte, error) {
+	if r.column < 0 || r.values.low == nil {
+		return r.prefix, nil
+	}
+	start, err := appendKeyValue(append([]byte(nil), r.prefix...), schema.Columns[r.column], r.values.low.value)
+	if err != nil {
+		return nil, err
+	}
+	if !r.values.low.inclusive {
+		// Skip the keys that have the bound itself in the column
+		start = prefixEnd(start)
+	}
+	return start, nil
+}
+
+// end returns the key a reverse scan of the range must start below, or nil
+// to start from the last key of the table
+func (r keyRange) end(schema *Schema) ([]byte, error) {
+	end := r.prefix
+	inclusive := true
+	if r.column >= 0 && r.values.high != nil {
+		var err error
+		if end, err = appendKeyValue(append([]byte(nil), r.prefix...), schema.Columns[r.column], r.values.high.value); err != nil {
+			return nil, err
+		}
+		inclusive = r.values.high.inclusive
+	}
+	if !inclusive || len(end) == 0 {
+		return end, nil
+	}
+
+	// Take in the keys that have the bound itself in the column
+	if after := prefixEnd(end); !bytes.Equal(after, end) {
+		return after, nil
+	}
+	return nil, nil
+}
+
+// scanFrom calls visit with every key of a table from start on, in key
+// order, until it reports false
+func scanFrom(ctx context.Context, table Table, start []byte, visit func(key, data []byte) (bool, error)) error {
+	// Scan only returns keys after the start, which may itself be a key
+	if data, found := table.Select(start); found {
+		if more, err := visit(start, data); err != nil || !more {
+			return err
+		}
+	}
+	return visitKeys(ctx, scanTable(ctx, table, start), visit)
+}
+
+// scanBefore calls visit with every key of a table smaller than end, or
+// every key if end is nil, from the largest down, until it reports false.
+// The table must be a ReverseScanner.
+func scanBefore(ctx context.Context, table Table, end []byte, visit func(key, data []byte) (bool, error)) error {
+	if cs, ok := table.(ContextScanner); ok {
+		return visitKeys(ctx, cs.ScanReverseContext(ctx, end), visit)
+	}
+	return visitKeys(ctx, table.(ReverseScanner).ScanReverse(end), visit)
+}
+
+// scanTable returns an iterator over the keys of a table after start that
+// stops reading once ctx is done, if the table can
+func scanTable(ctx context.Context, table Table, start []byte) Iterator {
+	if cs, ok := table.(ContextScanner); ok {
+		return cs.ScanContext(ctx, start)
+	}
+	return table.Scan(start)
+}
+
+// visitKeys calls visit with the keys an iterator returns until it reports
+// false. An iterator from scanTable may end early when ctx is done, so that
+// is checked once more at the end.
+func visitKeys(ctx context.Context, iter Iterator, visit func(key, data []byte) (bool, error)) error {
+	for iter.ContainsNext() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key, data := iter.Next()
+		if key == nil {
+			continue
+		}
+		if more, err := visit(key, data); err != nil || !more {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// prefixEnd returns the smallest key larger than every key that starts with
+// prefix, or prefix itself if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return prefix
+}
+
+// scanOrder is the order a scan returns rows in
+type scanOrder int
+
+const (
+	anyOrder scanOrder = iota
+	ascendingKeys
+	descendingKeys
+)
+
+// keyOrder returns the key order that sorts rows as an ORDER BY asks, given
+// the columns equalities of the WHERE clause fix, and anyOrder if those fix
+// every sort key. It reports false if key order does not sort the rows.
+func keyOrder(schema *Schema, equal map[int]Value, orderBy []OrderByItem) (scanOrder, bool) {
+	order := anyOrder
+	keys := schema.keyColumns()
+	k := 0
+	for _, item := range orderBy {
+		// Past the last key column every row differs, so later sort keys
+		// never come into play
+		for k < len(keys) {
+			if _, fixed := equal[keys[k]]; !fixed {
+				break
+			}
+			k++
+		}
+		if k == len(keys) {
+			break
+		}
+
+		ref, ok := item.Expr.(*ColumnRef)
+		if !ok {
+			return anyOrder, false
+		}
+		i, err := schema.resolveColumn(ref)
+		if err != nil {
+			return anyOrder, false
+		}
+		if _, fixed := equal[i]; fixed {
+			continue
+		}
+		want := ascendingKeys
+		if item.Desc {
+			want = descendingKeys
+		}
+		if i != keys[k] || order != anyOrder && order != want {
+			return anyOrder, false
+		}
+		order = want
+		k++
+	}
+	return order, true
+}
+
+// whereRows calls fn with every row of a table that satisfies a WHERE
+// clause, in no particular order
+func (e *Executor) whereRows(ctx context.Context, table Table, schema *Schema, where Expression, fn func(key []byte, row Row) error) error {
+	_, err := e.scanWhere(ctx, table, schema, where, nil, fn)
+	return err
+}
+
+// scanWhere calls fn with every row of a table that satisfies a WHERE
+// clause. It reads the rows through a key lookup or an index when equalities
+// allow, then through a bounded scan of the key range the clause allows,
+// then through the range of an index whose leading column the clause
+// bounds, and scans the whole table otherwise. When the scan cannot return the rows
+// in the order of orderBy, it reports false without reading any.
+func (e *Executor) scanWhere(ctx context.Context, table Table, schema *Schema, where Expression, orderBy []OrderByItem, fn func(key []byte, row Row) error) (bool, error) {
+	if where != nil {
+		if err := checkColumnRefs(schema, where); err != nil {
+			return false, err
+		}
+	}
+	equal, ranges, err := whereConditions(schema, where)
+	if err != nil {
+		return false, err
+	}
+	order, ok := keyOrder(schema, equal, orderBy)
+	if !ok {
+		return false, nil
+	}
+	if _, canReverse := table.(ReverseScanner); order == descendingKeys && !canReverse {
+		return false, nil
+	}
+	reverse := order == descendingKeys
+
+	matching := func(key []byte, row Row) error {
+		if where != nil {
+			v, err := (&evalContext{ctx: ctx, exec: e, schema: schema, row: row}).condition(where)
+			if err != nil {
+				return err
+			}
+			if v != true {
+				return nil
+			}
+		}
+		return fn(key, row)
+	}
+
+	if schema.keyValue {
+		return true, keyValueRows(ctx, table, equal[0], ranges[0], reverse, matching)
+	}
+
+	_, isKey, err := keyFromEqualities(schema, equal)
+	if err != nil {
+		return false, err
+	}
+	if isKey {
+		return true, e.findRows(ctx, table, schema, equal, matching)
+	}
+	r, narrowed, err := keyRangeFor(schema, equal, ranges)
+	if err != nil {
+		return false, err
+	}
+	idx, ir, err := chooseIndex(schema, equal, ranges)
+	if err != nil {
+		return false, err
+	}
+
+	// A key range reads the rows it covers directly, and an index range
+	// looks each of them up, so an index that only bounds a column is used
+	// when nothing narrows the key
+	if idx != nil && (len(ir.prefix) > 0 || !narrowed) {
+		// An index returns rows in the order of its own columns
+		if order != anyOrder {
+			return false, nil
+		}
+		return true, scanIndex(ctx, e.db, schema, idx, ir, fetchRow(table, schema, equal, matching))
+	}
+	return true, scanKeyRange(ctx, table, schema, r, reverse, matching)
+}
+
+// keyValueRows calls fn with the rows of a key/value table whose key can
+// equal key, or else lie in keys, or with every row when both are nil. The
+// rows come in key order, from the largest key down if reverse is set.
+func keyValueRows(ctx context.Context, table Table, key Value, keys *valueRange, reverse bool, fn func(key []byte, row Row) error) error {
+	if key != nil {
+		k := []byte(key.(string))
+		if value, found := table.Select(k); found {
+			return fn(k, Row{key, string(value)})
+		}
+		return nil
+	}
+
+	visit := func(k, value []byte) (bool, error) {
+		row := Row{string(k), string(value)}
+		if keys == nil {
+			return true, fn(k, row)
+		}
+		below, err := keys.belowHigh(row[0])
+		if err != nil {
+			return false, err
+		}
+		above, err := keys.aboveLow(row[0])
+		if err != nil {
+			return false, err
+		}
+		if above && below {
+			err = fn(k, row)
+		}
+		if reverse {
+			return above, err
+		}
+		return below, err
+	}
+
+	if reverse {
+		var end []byte
+		if keys != nil && keys.high != nil {
+			end = []byte(keys.high.value.(string))
+			if keys.high.inclusive {
+				end = append(end, 0) // the key just after the bound
+			}
+		}
+		return scanBefore(ctx, table, end, visit)
+	}
+	var start []byte
+	if keys != nil && keys.low != nil {
+		start = []byte(keys.low.value.(string))
+	}
+	return scanFrom(ctx, table, start, visit)
+}
@@ -0,0 +1,355 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingTable counts the rows its scans return
+type countingTable struct {
+	*MockTable
+	read int
+}
+
+func (c *countingTable) Scan(startKey []byte) Iterator {
+	return &countingIterator{Iterator: c.MockTable.Scan(startKey), table: c}
+}
+
+func (c *countingTable) ScanReverse(endKey []byte) Iterator {
+	return &countingIterator{Iterator: c.MockTable.ScanReverse(endKey), table: c}
+}
+
+type countingIterator struct {
+	Iterator
+	table *countingTable
+}
+
+func (c *countingIterator) Next() (key, val []byte) {
+	key, val = c.Iterator.Next()
+	if key != nil {
+		c.table.read++
+	}
+	return key, val
+}
+
+func TestWhereRowsScansKeyRange(t *testing.T) {
+	db := NewMockDatabase()
+	result := ExecuteSQL(db, "CREATE TABLE events (day INTEGER, seq INTEGER, name TEXT, PRIMARY KEY (day, seq))")
+	require.True(t, result.Success, "%v", result.Error)
+	for day := 1; day <= 20; day++ {
+		for seq := 1; seq <= 5; seq++ {
+			sql := fmt.Sprintf("INSERT INTO events VALUES (%d, %d, 'e%d.%d')", day, seq, day, seq)
+			require.True(t, ExecuteSQL(db, sql).Success, sql)
+		}
+	}
+	table := &countingTable{MockTable: db.tables["events"]}
+	schema, err := tableSchema(table)
+	require.NoError(t, err)
+
+	tests := []struct {
+		where string
+		rows  int
+		read  int // at most
+	}{
+		{"day > 18", 10, 11},
+		{"day >= 3 AND day < 5", 10, 11},
+		{"day BETWEEN 7 AND 8 AND seq <> 2", 8, 11},
+		{"day = 4 AND seq > 3", 2, 3},
+		{"day = 4 AND seq BETWEEN 2 AND 3", 2, 3},
+		{"day > 5 AND day < 3", 0, 6},
+		{"seq = 1", 20, 100}, // not a leading key column
+	}
+	for _, tt := range tests {
+		stmt, err := ParseSQL("SELECT * FROM events WHERE " + tt.where)
+		require.NoError(t, err, tt.where)
+
+		table.read = 0
+		var days []int64
+		err = NewExecutor(db).whereRows(context.Background(), table, schema, stmt.(*SelectStatement).Where, func(key []byte, row Row) error {
+			days = append(days, row[0].(int64))
+			return nil
+		})
+		require.NoError(t, err, tt.where)
+		assert.Len(t, days, tt.rows, tt.where)
+		assert.LessOrEqual(t, table.read, tt.read, tt.where)
+		assert.IsNonDecreasing(t, days, tt.where)
+	}
+}
+
+func TestWhereRowsKeyValueTable(t *testing.T) {
+	db := NewMockDatabase()
+	kv, err := db.CreateTable("kv")
+	require.NoError(t, err)
+	for i := 0; i < 26; i++ {
+		require.NoError(t, kv.Insert([]byte{'a' + byte(i)}, []byte(fmt.Sprint(i))))
+	}
+	table := &countingTable{MockTable: kv.(*MockTable)}
+
+	tests := []struct {
+		where string
+		keys  string
+		read  int // at most
+	}{
+		{"key = 'c'", "c", 0},
+		{"key = 'c' OR key = 'x'", "cx", 26},
+		{"key > 'w'", "xyz", 3},
+		{"key BETWEEN 'b' AND 'd'", "bcd", 3},
+		{"'e' > key AND value <> '1'", "acd", 5},
+		{"value LIKE '2_'", "uvwxyz", 26},
+		{"key >= 'y' AND value = '25'", "z", 2},
+	}
+	for _, tt := range tests {
+		stmt, err := ParseSQL("SELECT * FROM kv WHERE " + tt.where)
+		require.NoError(t, err, tt.where)
+
+		table.read = 0
+		var keys string
+		err = NewExecutor(db).whereRows(context.Background(), table, keyValueSchema(), stmt.(*SelectStatement).Where, func(key []byte, row Row) error {
+			keys += row[0].(string)
+			return nil
+		})
+		require.NoError(t, err, tt.where)
+		assert.Equal(t, tt.keys, keys, tt.where)
+		assert.LessOrEqual(t, table.read, tt.read, tt.where)
+	}
+}
+
+func TestOrderedRowsStopsAtLimit(t *testing.T) {
+	db := NewMockDatabase()
+	result := ExecuteSQL(db, "CREATE TABLE events (day INTEGER, seq INTEGER, name TEXT, PRIMARY KEY (day, seq))")
+	require.True(t, result.Success, "%v", result.Error)
+	for day := 1; day <= 20; day++ {
+		for seq := 1; seq <= 5; seq++ {
+			sql := fmt.Sprintf("INSERT INTO events VALUES (%d, %d, 'e%d.%d')", day, seq, day, seq)
+			require.True(t, ExecuteSQL(db, sql).Success, sql)
+		}
+	}
+	table := &countingTable{MockTable: db.tables["events"]}
+	schema, err := tableSchema(table)
+	require.NoError(t, err)
+
+	tests := []struct {
+		query string
+		names []string
+		read  int // at most
+	}{
+		{"ORDER BY day, seq LIMIT 2", []string{"e1.1", "e1.2"}, 2},
+		{"ORDER BY day DESC, seq DESC LIMIT 3", []string{"e20.5", "e20.4", "e20.3"}, 3},
+		{"WHERE day <= 10 ORDER BY day DESC LIMIT 2 OFFSET 1", []string{"e10.4", "e10.3"}, 3},
+		{"WHERE day = 7 ORDER BY seq DESC LIMIT 2", []string{"e7.5", "e7.4"}, 2},
+		{"WHERE day > 3 AND day < 6 ORDER BY day DESC, seq", []string{"e5.1", "e5.2", "e5.3", "e5.4", "e5.5", "e4.1", "e4.2", "e4.3", "e4.4", "e4.5"}, 100},
+		{"WHERE seq = 5 ORDER BY name DESC LIMIT 2", []string{"e9.5", "e8.5"}, 100},
+		{"LIMIT 1", []string{"e1.1"}, 1},
+	}
+	r := &relation{schema: &Schema{}}
+	r.add("events", table, schema, Join{})
+	for _, tt := range tests {
+		stmt, err := ParseSQL("SELECT * FROM events " + tt.query)
+		require.NoError(t, err, tt.query)
+		sel := stmt.(*SelectStatement)
+		e := NewExecutor(db)
+		limit, offset := int64(-1), int64(0)
+		if sel.Limit != nil {
+			limit, err = e.rowCount("LIMIT", sel.Limit)
+			require.NoError(t, err)
+		}
+		if sel.Offset != nil {
+			offset, err = e.rowCount("OFFSET", sel.Offset)
+			require.NoError(t, err)
+		}
+
+		table.read = 0
+		names := []string{}
+		err = e.orderedRows(context.Background(), r, sel.Where, sel.OrderBy, limit, offset, func(row Row) error {
+			names = append(names, row[2].(string))
+			return nil
+		})
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.names, names, tt.query)
+		assert.LessOrEqual(t, table.read, tt.read, tt.query)
+	}
+}
+
+// statsDatabase keeps a row count of one table
+type statsDatabase struct {
+	*MockDatabase
+	table *countingTable
+	rows  int64
+}
+
+func (s *statsDatabase) GetTable(name string) (Table, error) {
+	if name == s.table.Name() {
+		return s.table, nil
+	}
+	return s.MockDatabase.GetTable(name)
+}
+
+func (s *statsDatabase) TableStats(name string) (TreeStats, error) {
+	return TreeStats{Rows: s.rows}, nil
+}
+
+func TestCountUsesRowCount(t *testing.T) {
+	mock := NewMockDatabase()
+	result := ExecuteSQL(mock, "CREATE TABLE t (id INTEGER PRIMARY KEY, n INTEGER)")
+	require.True(t, result.Success, "%v", result.Error)
+	for i := 1; i <= 10; i++ {
+		require.True(t, ExecuteSQL(mock, fmt.Sprintf("INSERT INTO t VALUES (%d, %d)", i, i%3)).Success)
+	}
+	db := &statsDatabase{MockDatabase: mock, table: &countingTable{MockTable: mock.tables["t"]}, rows: 10}
+
+	count := func(sql string) string {
+		db.table.read = 0
+		result := ExecuteSQL(db, sql)
+		require.True(t, result.Success, "%s: %v", sql, result.Error)
+		require.Len(t, result.Rows, 1, sql)
+		return result.Rows[0][result.Columns[0]]
+	}
+	assert.Equal(t, "10", count("SELECT count(*) FROM t"))
+	assert.Zero(t, db.table.read)
+	assert.Equal(t, "10", count("SELECT count(*) AS n FROM t HAVING count(*) > 5"))
+	assert.Zero(t, db.table.read)
+
+	// Anything else reads the rows
+	assert.Equal(t, "4", count("SELECT count(*) FROM t WHERE n = 1"))
+	assert.Equal(t, 10, db.table.read)
+	assert.Equal(t, "10", count("SELECT count(n) FROM t"))
+	assert.Equal(t, 10, db.table.read)
+}
+
+func TestJoinStrategies(t *testing.T) {
+	db := NewMockDatabase()
+	for _, sql := range []string{
+		"CREATE TABLE a (id INTEGER PRIMARY KEY, b_id INTEGER, c_ref INTEGER)",
+		"CREATE TABLE b (id INTEGER PRIMARY KEY, tag TEXT)",
+		"CREATE TABLE c (id INTEGER PRIMARY KEY, ref INTEGER)",
+		"CREATE INDEX c_ref ON c (ref)",
+	} {
+		require.True(t, ExecuteSQL(db, sql).Success, sql)
+	}
+	for i := 1; i <= 50; i++ {
+		for _, sql := range []string{
+			fmt.Sprintf("INSERT INTO a VALUES (%d, %d, %d)", i, i, i),
+			fmt.Sprintf("INSERT INTO b VALUES (%d, 't%d')", i, i%5),
+			fmt.Sprintf("INSERT INTO c VALUES (%d, %d)", i, i),
+		} {
+			require.True(t, ExecuteSQL(db, sql).Success, sql)
+		}
+	}
+	tables := map[string]*countingTable{}
+	for _, name := range []string{"a", "b", "c"} {
+		tables[name] = &countingTable{MockTable: db.tables[name]}
+		db.tables[name] = tables[name].MockTable
+	}
+	counting := &countingDatabase{MockDatabase: db, tables: tables}
+
+	tests := []struct {
+		sql  string
+		rows int
+		read map[string]int // at most
+	}{
+		// Key lookups into b for each row of a
+		{"SELECT * FROM a JOIN b ON b.id = a.b_id WHERE a.id <= 3", 3, map[string]int{"a": 4, "b": 3}},
+		// Index lookups into c
+		{"SELECT * FROM a JOIN c ON c.ref = a.c_ref WHERE a.id <= 3", 3, map[string]int{"a": 4, "c": 6}},
+		// A hash join reads b once, only the rows its own conditions allow
+		{"SELECT * FROM a JOIN b ON b.tag = 't1' AND a.b_id = b.id + 0", 10, map[string]int{"a": 50, "b": 50}},
+		{"SELECT * FROM b JOIN a ON a.c_ref = b.id WHERE b.tag = 't2' AND b.id < 20", 4, map[string]int{"a": 50, "b": 20}},
+	}
+	for _, tt := range tests {
+		for _, table := range tables {
+			table.read = 0
+		}
+		result := ExecuteSQL(counting, tt.sql)
+		require.True(t, result.Success, "%s: %v", tt.sql, result.Error)
+		assert.Len(t, result.Rows, tt.rows, tt.sql)
+		for name, read := range tt.read {
+			assert.LessOrEqual(t, tables[name].read, read, "%s: %s", tt.sql, name)
+		}
+	}
+}
+
+func TestSubqueryReads(t *testing.T) {
+	db := NewMockDatabase()
+	for _, sql := range []string{
+		"CREATE TABLE a (id INTEGER PRIMARY KEY, b_id INTEGER)",
+		"CREATE TABLE b (id INTEGER PRIMARY KEY, tag TEXT)",
+	} {
+		require.True(t, ExecuteSQL(db, sql).Success, sql)
+	}
+	for i := 1; i <= 50; i++ {
+		for _, sql := range []string{
+			fmt.Sprintf("INSERT INTO a VALUES (%d, %d)", i, i),
+			fmt.Sprintf("INSERT INTO b VALUES (%d, 't%d')", i, i%5),
+		} {
+			require.True(t, ExecuteSQL(db, sql).Success, sql)
+		}
+	}
+	tables := map[string]*countingTable{}
+	for _, name := range []string{"a", "b"} {
+		tables[name] = &countingTable{MockTable: db.tables[name]}
+	}
+	counting := &countingDatabase{MockDatabase: db, tables: tables}
+
+	tests := []struct {
+		sql  string
+		rows int
+		read map[string]int // at most
+	}{
+		// A subquery that refers to no outer column runs once, and its value
+		// chooses the rows to read
+		{"SELECT * FROM a WHERE id = (SELECT max(id) FROM b)", 1, map[string]int{"a": 1, "b": 50}},
+		{"SELECT * FROM a WHERE b_id IN (SELECT id FROM b WHERE tag = 't1')", 10, map[string]int{"a": 50, "b": 50}},
+		{"SELECT (SELECT count(*) FROM b WHERE tag = 't2') AS n FROM a", 50, map[string]int{"a": 50, "b": 50}},
+		// A correlated subquery runs for each row, looking up b's key
+		{"SELECT * FROM a WHERE EXISTS (SELECT 1 FROM b WHERE b.id = a.b_id AND b.tag = 't1')", 10, map[string]int{"a": 50, "b": 50}},
+	}
+	for _, tt := range tests {
+		for _, table := range tables {
+			table.read = 0
+		}
+		result := ExecuteSQL(counting, tt.sql)
+		require.True(t, result.Success, "%s: %v", tt.sql, result.Error)
+		assert.Len(t, result.Rows, tt.rows, tt.sql)
+		for name, read := range tt.read {
+			assert.LessOrEqual(t, tables[name].read, read, "%s: %s", tt.sql, name)
+		}
+	}
+}
+
+// countingDatabase returns counting tables
+type countingDatabase struct {
+	*MockDatabase
+	tables map[string]*countingTable
+}
+
+func (c *countingDatabase) GetTable(name string) (Table, error) {
+	if table, ok := c.tables[name]; ok {
+		return table, nil
+	}
+	return c.MockDatabase.GetTable(name)
+}
+
+func TestMatchLike(t *testing.T) {
+	for _, tt := range []struct {
+		s, pattern string
+		match      bool
+	}{
+		{"alice", "a%", true},
+		{"alice", "%ice", true},
+		{"alice", "%l%c%", true},
+		{"alice", "_lice", true},
+		{"alice", "a_c%", false},
+		{"alice", "Alice", false},
+		{"", "%", true},
+		{"", "_", false},
+		{"50%", "50%", true},
+		{"aaab", "%ab", true},
+		{"héllo", "h_llo", true},
+	} {
+		assert.Equal(t, tt.match, matchLike(tt.s, tt.pattern), "%q LIKE %q", tt.s, tt.pattern)
+	}
+}
@@ -0,0 +1,183 @@
+package query
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// rowFormatVersion is the first byte of every encoded row
+const rowFormatVersion = 1
+
+// Type tags of encoded values. Non-NULL values use their column type.
+const tagNull = 0
+
+// EncodeRow serializes a row of a typed table. The layout is the format
+// version (1 byte), the schema version (uvarint) and the column count
+// (uvarint), followed by each column as its ID (uvarint), a type tag
+// (1 byte) and its value: a varint for INTEGER, 8 bytes for REAL, a
+// length-prefixed string for TEXT and BLOB, and 1 byte for BOOLEAN. NULL is
+// a tag with no value. Columns are identified by ID rather than position,
+// so rows stay readable after the schema changes.
+func EncodeRow(schema *Schema, row Row) ([]byte, error) {
+	if len(row) != len(schema.Columns) {
+		return nil, fmt.Errorf("row has %d values but the table has %d columns", len(row), len(schema.Columns))
+	}
+
+	buf := []byte{rowFormatVersion}
+	buf = binary.AppendUvarint(buf, uint64(schema.Version))
+	buf = binary.AppendUvarint(buf, uint64(len(row)))
+
+	for i, col := range schema.Columns {
+		if err := checkValueType(row[i], col.Type); err != nil {
+			return nil, fmt.Errorf("column %s: %w", col.Name, err)
+		}
+
+		buf = binary.AppendUvarint(buf, uint64(col.ID))
+		switch v := row[i].(type) {
+		case nil:
+			buf = append(buf, tagNull)
+		case int64:
+			buf = append(buf, byte(TypeInteger))
+			buf = binary.AppendVarint(buf, v)
+		case float64:
+			buf = append(buf, byte(TypeReal))
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+		case string:
+			buf = append(buf, byte(TypeText))
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case []byte:
+			buf = append(buf, byte(TypeBlob))
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case bool:
+			buf = append(buf, byte(TypeBoolean))
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		}
+	}
+
+	return buf, nil
+}
+
+// DecodeRow parses a row written by EncodeRow against the current schema.
+// Values of columns that no longer exist are skipped, and columns added
+// after the row was written read as their DEFAULT value.
+func DecodeRow(schema *Schema, data []byte) (Row, error) {
+	d := rowDecoder{data: data}
+
+	if version := d.byte(); version != rowFormatVersion {
+		return nil, fmt.Errorf("unsupported row format version %d", version)
+	}
+	d.uvarint() // schema version the row was written with
+	count := d.uvarint()
+
+	values := make(map[int]Value, count)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		id := int(d.uvarint())
+		values[id] = d.value()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	row := make(Row, len(schema.Columns))
+	for i, col := range schema.Columns {
+		if v, ok := values[col.ID]; ok {
+			row[i] = v
+			continue
+		}
+
+		v, err := schema.defaultValue(i)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// rowDecoder reads the fields of an encoded row, remembering the first error
+type rowDecoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *rowDecoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("corrupt row at offset %d", d.pos)
+	}
+	d.pos = len(d.data)
+}
+
+func (d *rowDecoder) byte() byte {
+	if d.pos >= len(d.data) {
+		d.fail()
+		return 0
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *rowDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *rowDecoder) varint() int64 {
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *rowDecoder) bytes(n uint64) []byte {
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail()
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data[d.pos:])
+	d.pos += int(n)
+	return b
+}
+
+func (d *rowDecoder) value() Value {
+	switch tag := d.byte(); ColumnType(tag) {
+	case tagNull:
+		return nil
+	case TypeInteger:
+		return d.varint()
+	case TypeReal:
+		b := d.bytes(8)
+		if b == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case TypeText:
+		return string(d.bytes(d.uvarint()))
+	case TypeBlob:
+		return d.bytes(d.uvarint())
+	case TypeBoolean:
+		return d.byte() != 0
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("corrupt row: unknown type tag %d", tag)
+		}
+		return nil
+	}
+}
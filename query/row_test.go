@@ -0,0 +1,85 @@
+package query
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRowEncoding(t *testing.T) {
+	schema, err := NewSchema([]ColumnDefinition{
+		{Name: "id", Type: TypeInteger, PrimaryKey: true},
+		{Name: "name", Type: TypeText},
+		{Name: "score", Type: TypeReal},
+		{Name: "data", Type: TypeBlob},
+		{Name: "active", Type: TypeBoolean},
+	}, nil)
+	require.NoError(t, err)
+
+	rows := []Row{
+		{int64(1), "plain", 1.5, []byte{0, 1, 2}, true},
+		{int64(-42), "with|pipes:and\x00nul", math.Inf(-1), []byte{}, false},
+		{int64(math.MaxInt64), "", nil, nil, nil},
+	}
+
+	for _, row := range rows {
+		data, err := EncodeRow(schema, row)
+		require.NoError(t, err)
+
+		decoded, err := DecodeRow(schema, data)
+		require.NoError(t, err)
+		assert.Equal(t, row, decoded)
+	}
+
+	// Values must match their column types
+	_, err = EncodeRow(schema, Row{"1", "name", nil, nil, nil})
+	assert.EqualError(t, err, "column id: cannot store a TEXT value in an INTEGER column")
+	_, err = EncodeRow(schema, Row{int64(1)})
+	assert.Error(t, err)
+
+	// Truncated and unknown data is reported instead of misread
+	data, err := EncodeRow(schema, rows[0])
+	require.NoError(t, err)
+	for n := 1; n < len(data); n++ {
+		_, err := DecodeRow(schema, data[:n])
+		assert.Error(t, err, "truncated to %d bytes", n)
+	}
+	_, err = DecodeRow(schema, append([]byte{99}, data[1:]...))
+	assert.Error(t, err)
+}
+
+func TestRowDecodingFollowsColumnIDs(t *testing.T) {
+	schema, err := NewSchema([]ColumnDefinition{
+		{Name: "id", Type: TypeInteger},
+		{Name: "name", Type: TypeText},
+	}, []string{"id"})
+	require.NoError(t, err)
+
+	data, err := EncodeRow(schema, Row{int64(7), "seven"})
+	require.NoError(t, err)
+
+	// A schema that dropped "id" and gained a new column still reads the row
+	changed := &Schema{
+		Version: 2,
+		Columns: []Column{
+			{ID: 2, Name: "label", Type: TypeText},
+			{ID: 3, Name: "extra", Type: TypeInteger},
+		},
+		NextColumnID: 4,
+	}
+	row, err := DecodeRow(changed, data)
+	require.NoError(t, err)
+	assert.Equal(t, Row{"seven", nil}, row)
+}
+
+func TestDecodeSchemaWithoutPrimaryKey(t *testing.T) {
+	// Tables created before a primary key was required are keyed by their
+	// first column, and say so
+	schema, err := DecodeSchema([]byte(`{"version":1,"columns":[{"id":1,"name":"code","type":"TEXT"},{"id":2,"name":"n","type":"INTEGER"}],"next_column_id":3}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"code"}, schema.PrimaryKey)
+	assert.True(t, schema.Columns[0].NotNull)
+	assert.Equal(t, []int{0}, schema.keyColumns())
+}
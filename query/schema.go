@@ -0,0 +1,494 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ColumnType is the declared type of a column
+type ColumnType int
+
+const (
+	TypeInteger ColumnType = iota + 1
+	TypeReal
+	TypeText
+	TypeBlob
+	TypeBoolean
+)
+
+// columnTypeNames maps SQL type names, including common aliases, to types
+var columnTypeNames = map[string]ColumnType{
+	"INTEGER": TypeInteger,
+	"INT":     TypeInteger,
+	"BIGINT":  TypeInteger,
+	"REAL":    TypeReal,
+	"FLOAT":   TypeReal,
+	"DOUBLE":  TypeReal,
+	"TEXT":    TypeText,
+	"VARCHAR": TypeText,
+	"CHAR":    TypeText,
+	"BLOB":    TypeBlob,
+	"BOOLEAN": TypeBoolean,
+	"BOOL":    TypeBoolean,
+}
+
+// ParseColumnType looks up a SQL type name
+func ParseColumnType(name string) (ColumnType, error) {
+	if t, ok := columnTypeNames[strings.ToUpper(name)]; ok {
+		return t, nil
+	}
+	return 0, fmt.Errorf("unknown column type: %s", name)
+}
+
+// String returns the canonical SQL name of the type
+func (t ColumnType) String() string {
+	switch t {
+	case TypeInteger:
+		return "INTEGER"
+	case TypeReal:
+		return "REAL"
+	case TypeText:
+		return "TEXT"
+	case TypeBlob:
+		return "BLOB"
+	case TypeBoolean:
+		return "BOOLEAN"
+	default:
+		return fmt.Sprintf("ColumnType(%d)", int(t))
+	}
+}
+
+// MarshalText stores types by name so persisted schemas stay readable
+func (t ColumnType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText parses a type name written by MarshalText
+func (t *ColumnType) UnmarshalText(text []byte) error {
+	parsed, err := ParseColumnType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Column describes one column of a table
+type Column struct {
+	ID      int        `json:"id"` // stable across renames; never reused
+	Name    string     `json:"name"`
+	Type    ColumnType `json:"type"`
+	NotNull bool       `json:"not_null,omitempty"`
+	Default string     `json:"default,omitempty"` // SQL text of the DEFAULT value
+
+	// Sequence names the sequence owned by an AUTOINCREMENT column. It is
+	// created and dropped with the table.
+	Sequence string `json:"sequence,omitempty"`
+
+	table string // name or alias of the table in the rows of a SELECT; see qualified
+}
+
+// Schema describes the columns of a typed table. It is persisted with the
+// table in the database catalog.
+type Schema struct {
+	Version      int          `json:"version"`
+	Columns      []Column     `json:"columns"`
+	PrimaryKey   []string     `json:"primary_key,omitempty"`
+	NextColumnID int          `json:"next_column_id"`
+	Indexes      []Index      `json:"indexes,omitempty"`
+	Checks       []Check      `json:"checks,omitempty"`
+	ForeignKeys  []ForeignKey `json:"foreign_keys,omitempty"`
+
+	// IndexTree marks the schema of a table that holds the entries of an
+	// index rather than rows
+	IndexTree bool `json:"index_tree,omitempty"`
+
+	defaults []columnDefault // parsed DEFAULT values, filled in on first use
+	checks   []Expression    // parsed CHECK expressions, filled in on first use
+	keyValue bool            // describes a key/value table; see keyValueSchema
+}
+
+// NewSchema builds the schema for a CREATE TABLE statement. The primary key
+// is either declared on a single column or given as a list of columns, as
+// in PRIMARY KEY (a, b).
+func NewSchema(defs []ColumnDefinition, primaryKey []string) (*Schema, error) {
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("a table needs at least one column")
+	}
+
+	schema := &Schema{Version: 1, NextColumnID: 1, PrimaryKey: primaryKey}
+	for _, def := range defs {
+		if def.PrimaryKey {
+			if len(schema.PrimaryKey) > 0 {
+				return nil, fmt.Errorf("table has more than one primary key")
+			}
+			schema.PrimaryKey = []string{def.Name}
+		}
+		if def.AutoIncrement && (def.Type != TypeInteger || def.Default != "") {
+			return nil, fmt.Errorf("column %s: AUTOINCREMENT needs an INTEGER column without a DEFAULT", def.Name)
+		}
+
+		if err := schema.appendColumn(def); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rows are keyed by the primary key, so every table declares one. Key
+	// columns must exist, appear once and are implicitly NOT NULL.
+	if len(schema.PrimaryKey) == 0 {
+		return nil, fmt.Errorf("a table needs a PRIMARY KEY")
+	}
+	seen := make(map[int]bool)
+	for _, name := range schema.PrimaryKey {
+		i := schema.ColumnIndex(name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown primary key column: %s", name)
+		}
+		if seen[i] {
+			return nil, fmt.Errorf("column %s appears twice in the primary key", name)
+		}
+		seen[i] = true
+		schema.Columns[i].NotNull = true
+	}
+	for i, def := range defs {
+		if def.AutoIncrement && (len(schema.PrimaryKey) != 1 || schema.ColumnIndex(schema.PrimaryKey[0]) != i) {
+			return nil, fmt.Errorf("column %s: AUTOINCREMENT is only allowed on a single-column primary key", def.Name)
+		}
+	}
+
+	return schema, nil
+}
+
+// appendColumn adds a column with a fresh ID
+func (s *Schema) appendColumn(def ColumnDefinition) error {
+	if s.Column(def.Name) != nil {
+		return fmt.Errorf("duplicate column name: %s", def.Name)
+	}
+	if _, err := evalDefault(def.Default, def.Type); err != nil {
+		return fmt.Errorf("DEFAULT for column %s: %w", def.Name, err)
+	}
+
+	s.Columns = append(s.Columns, Column{
+		ID:      s.NextColumnID,
+		Name:    def.Name,
+		Type:    def.Type,
+		NotNull: def.NotNull || def.PrimaryKey,
+		Default: def.Default,
+	})
+	s.NextColumnID++
+	s.defaults = nil
+	return nil
+}
+
+// AddColumn adds a column to an existing table. Rows written before the
+// change read the column's default.
+func (s *Schema) AddColumn(def ColumnDefinition) error {
+	if def.PrimaryKey {
+		return fmt.Errorf("cannot add a primary key column")
+	}
+	if def.NotNull && def.Default == "" {
+		return fmt.Errorf("column %s: NOT NULL needs a DEFAULT when added to an existing table", def.Name)
+	}
+	if len(def.Constraints) > 0 {
+		return fmt.Errorf("column %s: cannot add a column with UNIQUE or CHECK constraints", def.Name)
+	}
+	if def.AutoIncrement {
+		return fmt.Errorf("column %s: cannot add an AUTOINCREMENT column", def.Name)
+	}
+	if d, err := evalDefault(def.Default, def.Type); err == nil && d.expr != nil {
+		return fmt.Errorf("column %s: a DEFAULT from a sequence cannot be added to an existing table", def.Name)
+	}
+
+	if err := s.appendColumn(def); err != nil {
+		return err
+	}
+	s.Version++
+	return nil
+}
+
+// DropColumn removes a column. Values stored for it are ignored from now on
+// and its ID is never reused.
+func (s *Schema) DropColumn(name string) error {
+	i := s.ColumnIndex(name)
+	if i < 0 {
+		return fmt.Errorf("unknown column: %s", name)
+	}
+	for _, k := range s.keyColumns() {
+		if i == k {
+			return fmt.Errorf("cannot drop key column %s", name)
+		}
+	}
+	for _, idx := range s.Indexes {
+		if idx.hasColumn(name) {
+			return fmt.Errorf("cannot drop column %s: it is used by index %s", name, idx.Name)
+		}
+	}
+	for _, c := range s.Checks {
+		for _, col := range c.Columns {
+			if strings.EqualFold(col, name) {
+				return fmt.Errorf("cannot drop column %s: it is used by constraint %s", name, c.Name)
+			}
+		}
+	}
+	for _, fk := range s.ForeignKeys {
+		for _, col := range fk.Columns {
+			if strings.EqualFold(col, name) {
+				return fmt.Errorf("cannot drop column %s: it is used by foreign key %s", name, fk.Name)
+			}
+		}
+	}
+
+	s.Columns = append(s.Columns[:i], s.Columns[i+1:]...)
+	s.defaults = nil
+	s.Version++
+	return nil
+}
+
+// RenameColumn renames a column
+func (s *Schema) RenameColumn(oldName, newName string) error {
+	col := s.Column(oldName)
+	if col == nil {
+		return fmt.Errorf("unknown column: %s", oldName)
+	}
+	if other := s.Column(newName); other != nil && other != col {
+		return fmt.Errorf("duplicate column name: %s", newName)
+	}
+
+	for i, pk := range s.PrimaryKey {
+		if strings.EqualFold(pk, col.Name) {
+			s.PrimaryKey[i] = newName
+		}
+	}
+	for _, idx := range s.Indexes {
+		for i, name := range idx.Columns {
+			if strings.EqualFold(name, col.Name) {
+				idx.Columns[i] = newName
+			}
+		}
+	}
+	for _, fk := range s.ForeignKeys {
+		for i, name := range fk.Columns {
+			if strings.EqualFold(name, col.Name) {
+				fk.Columns[i] = newName
+			}
+		}
+	}
+	s.renameCheckColumn(col.Name, newName)
+	col.Name = newName
+	s.Version++
+	return nil
+}
+
+// columnDefault is a parsed DEFAULT clause: a constant, or an expression
+// that calls a function such as nextval and so is evaluated for each new row
+type columnDefault struct {
+	value Value
+	expr  Expression
+}
+
+// columnDefault returns the parsed DEFAULT of the column at index i
+func (s *Schema) columnDefault(i int) (columnDefault, error) {
+	if s.defaults == nil {
+		s.defaults = make([]columnDefault, len(s.Columns))
+		for j, col := range s.Columns {
+			d, err := evalDefault(col.Default, col.Type)
+			if err != nil {
+				s.defaults = nil
+				return columnDefault{}, fmt.Errorf("DEFAULT for column %s: %w", col.Name, err)
+			}
+			s.defaults[j] = d
+		}
+	}
+	return s.defaults[i], nil
+}
+
+// defaultValue returns the constant DEFAULT value of the column at index i.
+// Rows written before the column was added read it. Columns whose default
+// calls a function, such as nextval, have no constant default.
+func (s *Schema) defaultValue(i int) (Value, error) {
+	d, err := s.columnDefault(i)
+	return d.value, err
+}
+
+// evalDefault parses a DEFAULT clause from its SQL text, as written by
+// Parser.parseDefault. A constant default is evaluated once, here, and must
+// suit the column's type.
+func evalDefault(text string, t ColumnType) (columnDefault, error) {
+	if text == "" {
+		return columnDefault{}, nil
+	}
+
+	p := NewParser(NewLexer("DEFAULT " + text))
+	expr, _, err := p.parseDefault()
+	if err == nil && p.peekToken.Type != EOF {
+		err = fmt.Errorf("unexpected %q", p.peekToken.Literal)
+	}
+	if err != nil {
+		return columnDefault{}, err
+	}
+
+	calls := false
+	err = walkExpression(expr, func(e Expression) error {
+		f, ok := e.(*FunctionCall)
+		if !ok {
+			return nil
+		}
+		if f.Name != "nextval" {
+			return fmt.Errorf("function %s cannot be used in DEFAULT", f.Name)
+		}
+		if t != TypeInteger {
+			return fmt.Errorf("nextval() needs an INTEGER column")
+		}
+		calls = true
+		return nil
+	})
+	if err != nil || calls {
+		return columnDefault{expr: expr}, err
+	}
+
+	v, err := (&evalContext{}).eval(expr)
+	if err == nil {
+		v, err = coerceValue(v, t)
+	}
+	return columnDefault{value: v}, err
+}
+
+// setAutoIncrement numbers an AUTOINCREMENT column from a sequence owned by
+// the table
+func (s *Schema) setAutoIncrement(name, sequence string) {
+	col := s.Column(name)
+	col.Sequence = sequence
+	col.Default = nextvalDefault(sequence)
+	s.defaults = nil
+}
+
+// sequences returns the sequences owned by the table
+func (s *Schema) sequences() []string {
+	var names []string
+	for _, col := range s.Columns {
+		if col.Sequence != "" {
+			names = append(names, col.Sequence)
+		}
+	}
+	return names
+}
+
+// Column returns the column with the given name, or nil
+func (s *Schema) Column(name string) *Column {
+	for i := range s.Columns {
+		if strings.EqualFold(s.Columns[i].Name, name) {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
+
+// ColumnIndex returns the position of the named column, or -1
+func (s *Schema) ColumnIndex(name string) int {
+	for i := range s.Columns {
+		if strings.EqualFold(s.Columns[i].Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveColumn returns the position of the column a reference names. A
+// qualified reference only matches a column of the table it names, and an
+// unqualified one must not match columns of two tables.
+func (s *Schema) resolveColumn(ref *ColumnRef) (int, error) {
+	found := -1
+	for i := range s.Columns {
+		if !s.Columns[i].matches(ref) {
+			continue
+		}
+		if found >= 0 {
+			return -1, fmt.Errorf("column reference %s is ambiguous", ref)
+		}
+		found = i
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("unknown column: %s", ref)
+	}
+	return found, nil
+}
+
+// hasColumn reports whether a reference names any column of the schema
+func (s *Schema) hasColumn(ref *ColumnRef) bool {
+	for i := range s.Columns {
+		if s.Columns[i].matches(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a reference names the column
+func (c *Column) matches(ref *ColumnRef) bool {
+	return strings.EqualFold(c.Name, ref.Name) && (ref.Table == "" || strings.EqualFold(c.table, ref.Table))
+}
+
+// qualified returns a copy of the schema whose columns belong to the named
+// table, so that references such as t.col can find them
+func (s *Schema) qualified(table string) *Schema {
+	q := *s
+	q.Columns = make([]Column, len(s.Columns))
+	for i, col := range s.Columns {
+		col.table = table
+		q.Columns[i] = col
+	}
+	return &q
+}
+
+// keyColumns returns the positions of the columns rows are keyed by: the
+// primary key columns in key order
+func (s *Schema) keyColumns() []int {
+	columns := make([]int, len(s.PrimaryKey))
+	for k, name := range s.PrimaryKey {
+		columns[k] = s.ColumnIndex(name)
+	}
+	return columns
+}
+
+// keyColumnNames returns the names of the columns rows are keyed by
+func (s *Schema) keyColumnNames() []string {
+	var names []string
+	for _, i := range s.keyColumns() {
+		names = append(names, s.Columns[i].Name)
+	}
+	return names
+}
+
+// ColumnNames returns the names of all columns in order
+func (s *Schema) ColumnNames() []string {
+	names := make([]string, len(s.Columns))
+	for i, col := range s.Columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// Encode serializes the schema for the catalog
+func (s *Schema) Encode() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// DecodeSchema parses a schema written by Encode
+func DecodeSchema(data []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid table schema: %w", err)
+	}
+	if len(schema.Columns) == 0 && !schema.IndexTree {
+		return nil, fmt.Errorf("invalid table schema: no columns")
+	}
+
+	// Tables created before a primary key was required keyed their rows by
+	// the first column, so that column is their primary key
+	if len(schema.PrimaryKey) == 0 && !schema.IndexTree {
+		schema.PrimaryKey = []string{schema.Columns[0].Name}
+		schema.Columns[0].NotNull = true
+	}
+	return &schema, nil
+}
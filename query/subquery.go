@@ -0,0 +1,325 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// subqueryResult is what a subquery returned
+type subqueryResult struct {
+	columns []string
+	rows    []Row
+}
+
+// value returns the value of a subquery used as one: its column of its one
+// row, or NULL when it returned none
+func (r *subqueryResult) value() (Value, error) {
+	if err := r.oneColumn(); err != nil {
+		return nil, err
+	}
+	switch len(r.rows) {
+	case 0:
+		return nil, nil
+	case 1:
+		return r.rows[0][0], nil
+	}
+	return nil, fmt.Errorf("subquery used as a value returned more than one row")
+}
+
+// list returns an IN condition that tests the left side of in against the
+// values the subquery returned
+func (r *subqueryResult) list(in *InExpression) (*InExpression, error) {
+	if err := r.oneColumn(); err != nil {
+		return nil, err
+	}
+	values := make([]Expression, len(r.rows))
+	for i, row := range r.rows {
+		values[i] = &Literal{Value: row[0]}
+	}
+	return &InExpression{Left: in.Left, Values: values, Not: in.Not}, nil
+}
+
+// oneColumn checks that a subquery used as a value or an IN list returned
+// a single column
+func (r *subqueryResult) oneColumn() error {
+	if len(r.columns) != 1 {
+		return fmt.Errorf("subquery must return one column, not %d", len(r.columns))
+	}
+	return nil
+}
+
+// subqueryCacheKey is the context key of a statement's subqueryCache
+type subqueryCacheKey struct{}
+
+// subqueryCache holds the results of a statement's subqueries that refer to
+// no column of the queries around them, so that each runs once
+type subqueryCache map[*SelectStatement]*subqueryResult
+
+// withSubqueryCache returns a context for running a statement, which
+// caches the results of its subqueries
+func withSubqueryCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, subqueryCacheKey{}, subqueryCache{})
+}
+
+// scope is a query around a subquery, whose columns the subquery may refer
+// to: a correlated subquery
+type scope struct {
+	schema *Schema
+	row    Row    // the row the subquery runs for; nil while there is none
+	outer  *scope // the query around this one, if it is a subquery too
+	used   bool   // the subquery refers to a column of the scope
+}
+
+// lookup finds the innermost scope with a column a reference names, and
+// returns what the reference stands for: the column's value in the
+// scope's row, or the reference itself when the scope has no row. A
+// reference to no column is kept, to be reported when the subquery runs.
+func (sc *scope) lookup(ref *ColumnRef) (Expression, error) {
+	for s := sc; s != nil; s = s.outer {
+		if !s.schema.hasColumn(ref) {
+			continue
+		}
+		i, err := s.schema.resolveColumn(ref)
+		if err != nil {
+			return nil, err
+		}
+		s.used = true
+		if s.row == nil {
+			return ref, nil
+		}
+		return &Literal{Value: s.row[i]}, nil
+	}
+	return ref, nil
+}
+
+// correlated reports whether a subquery referred to a column of the scope
+// or of those around it
+func (sc *scope) correlated() bool {
+	for s := sc; s != nil; s = s.outer {
+		if s.used {
+			return true
+		}
+	}
+	return false
+}
+
+// subquery runs a subquery for the row an expression is evaluated on, as
+// runSubquery does
+func (c *evalContext) subquery(stmt *SelectStatement, limit int64) (*subqueryResult, error) {
+	if c.exec == nil || c.ctx == nil {
+		return nil, fmt.Errorf("subqueries cannot be used here")
+	}
+	var sc *scope
+	if c.schema != nil {
+		sc = &scope{schema: c.schema, row: c.row}
+	}
+	return c.exec.runSubquery(c.ctx, stmt, sc, limit)
+}
+
+// runSubquery runs a subquery for a row of the query around it, given by
+// sc, and returns at most limit rows when limit is not negative. A
+// subquery that refers to no column of that query only runs once per
+// statement. While sc has no row, runSubquery returns nil for a subquery
+// that refers to its columns.
+func (e *Executor) runSubquery(ctx context.Context, stmt *SelectStatement, sc *scope, limit int64) (*subqueryResult, error) {
+	cache, _ := ctx.Value(subqueryCacheKey{}).(subqueryCache)
+	if result, ok := cache[stmt]; ok {
+		return result, nil
+	}
+
+	r, bound, err := e.bindSubquery(ctx, stmt, sc)
+	if err != nil {
+		return nil, err
+	}
+	correlated := sc.correlated()
+	if correlated && sc.row == nil {
+		return nil, nil
+	}
+	if limit >= 0 && bound.Limit == nil {
+		bound.Limit = &Literal{Value: limit}
+	}
+	columns, rows, err := e.selectFrom(ctx, r, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &subqueryResult{columns: columns, rows: rows}
+	if !correlated && cache != nil {
+		cache[stmt] = result
+	}
+	return result, nil
+}
+
+// bindSubquery opens the tables a subquery reads and returns a copy of it
+// in which the references to columns of the queries around it, given by
+// sc, stand for what scope.lookup finds
+func (e *Executor) bindSubquery(ctx context.Context, stmt *SelectStatement, sc *scope) (*relation, *SelectStatement, error) {
+	r, err := e.openRelation(ctx, stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	bound := *stmt
+	if sc == nil {
+		return r, &bound, nil
+	}
+
+	// The subquery's own columns hide those of the queries around it
+	own := &scope{schema: r.schema, outer: sc}
+	bind := func(expr Expression) (Expression, error) {
+		return e.bindExpression(ctx, expr, own)
+	}
+	// ORDER BY and GROUP BY may name an alias of the select list instead
+	bindKey := func(expr Expression) (Expression, error) {
+		if ref, ok := expr.(*ColumnRef); ok && ref.Table == "" {
+			for _, col := range stmt.Columns {
+				if strings.EqualFold(col.Alias, ref.Name) {
+					return expr, nil
+				}
+			}
+		}
+		return bind(expr)
+	}
+
+	bound.Columns = make([]SelectColumn, len(stmt.Columns))
+	for i, col := range stmt.Columns {
+		if col.Expr != nil {
+			if col.Expr, err = bind(col.Expr); err != nil {
+				return nil, nil, err
+			}
+		}
+		bound.Columns[i] = col
+	}
+	bound.Joins = make([]Join, len(stmt.Joins))
+	for i, join := range stmt.Joins {
+		if join.On, err = bind(join.On); err != nil {
+			return nil, nil, err
+		}
+		bound.Joins[i] = join
+	}
+	if bound.Where, err = bind(stmt.Where); err != nil {
+		return nil, nil, err
+	}
+	bound.GroupBy = make([]Expression, len(stmt.GroupBy))
+	for i, expr := range stmt.GroupBy {
+		if bound.GroupBy[i], err = bindKey(expr); err != nil {
+			return nil, nil, err
+		}
+	}
+	if bound.Having, err = bind(stmt.Having); err != nil {
+		return nil, nil, err
+	}
+	bound.OrderBy = make([]OrderByItem, len(stmt.OrderBy))
+	for i, item := range stmt.OrderBy {
+		if item.Expr, err = bindKey(item.Expr); err != nil {
+			return nil, nil, err
+		}
+		bound.OrderBy[i] = item
+	}
+	return r, &bound, nil
+}
+
+// bindExpression replaces the references an expression of a subquery makes
+// to the queries around it as scope.lookup says, and binds the subqueries
+// nested in it to the subquery's own scope
+func (e *Executor) bindExpression(ctx context.Context, expr Expression, sc *scope) (Expression, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	return rewriteExpression(expr, func(x Expression) (Expression, error) {
+		switch x := x.(type) {
+		case *ColumnRef:
+			return sc.lookup(x)
+		case *SubqueryExpression:
+			_, stmt, err := e.bindSubquery(ctx, x.Select, sc)
+			if err != nil {
+				return nil, err
+			}
+			return &SubqueryExpression{Select: stmt}, nil
+		case *ExistsExpression:
+			_, stmt, err := e.bindSubquery(ctx, x.Select, sc)
+			if err != nil {
+				return nil, err
+			}
+			return &ExistsExpression{Select: stmt}, nil
+		case *InExpression:
+			if x.Subquery == nil {
+				return nil, nil
+			}
+			left, err := e.bindExpression(ctx, x.Left, sc)
+			if err != nil {
+				return nil, err
+			}
+			_, stmt, err := e.bindSubquery(ctx, x.Subquery, sc)
+			if err != nil {
+				return nil, err
+			}
+			return &InExpression{Left: left, Subquery: stmt, Not: x.Not}, nil
+		}
+		return nil, nil
+	})
+}
+
+// bindSubqueries runs the subqueries of a condition on the rows of a schema
+// that refer to none of its columns, and replaces each by what it returned,
+// so that a scan can use the values to choose the rows it reads. The other
+// subqueries are left to run for each row.
+func (e *Executor) bindSubqueries(ctx context.Context, schema *Schema, expr Expression) (Expression, error) {
+	if expr == nil {
+		return nil, nil
+	}
+	return rewriteExpression(expr, func(x Expression) (Expression, error) {
+		sc := &scope{schema: schema}
+		switch x := x.(type) {
+		case *SubqueryExpression:
+			result, err := e.runSubquery(ctx, x.Select, sc, 2)
+			if err != nil || result == nil {
+				return nil, err
+			}
+			v, err := result.value()
+			if err != nil {
+				return nil, err
+			}
+			return &Literal{Value: v}, nil
+		case *ExistsExpression:
+			result, err := e.runSubquery(ctx, x.Select, sc, 1)
+			if err != nil || result == nil {
+				return nil, err
+			}
+			return &Literal{Value: len(result.rows) > 0}, nil
+		case *InExpression:
+			if x.Subquery == nil {
+				return nil, nil
+			}
+			result, err := e.runSubquery(ctx, x.Subquery, sc, -1)
+			if err != nil || result == nil {
+				return nil, err
+			}
+			list, err := result.list(x)
+			if err != nil {
+				return nil, err
+			}
+			if list.Left, err = e.bindSubqueries(ctx, schema, x.Left); err != nil {
+				return nil, err
+			}
+			return list, nil
+		}
+		return nil, nil
+	})
+}
+
+// derivedSchema returns the schema of the rows a subquery in FROM returned.
+// Each column has the type of its first value that is not NULL.
+func derivedSchema(result *subqueryResult) *Schema {
+	schema := &Schema{Columns: make([]Column, len(result.columns))}
+	for i, name := range result.columns {
+		schema.Columns[i] = Column{ID: i + 1, Name: name, Type: TypeText}
+		for _, row := range result.rows {
+			if row[i] != nil {
+				schema.Columns[i].Type = valueType(row[i])
+				break
+			}
+		}
+	}
+	return schema
+}
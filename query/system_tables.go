@@ -0,0 +1,409 @@
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TreeStats describes the B+Tree a table or index is stored in
+type TreeStats struct {
+	RootPage int64
+	Rows     int64 // number of keys
+	Height   int64 // levels from the root to the leaves, 1 for a single leaf
+}
+
+// StatsDatabase is implemented by databases that can describe the B+Trees
+// their tables are stored in
+type StatsDatabase interface {
+	TableStats(tableName string) (TreeStats, error)
+}
+
+// systemTable is a read-only virtual table describing the database. Its
+// rows are built from the catalog each time it is read.
+type systemTable struct {
+	columns    []ColumnDefinition
+	primaryKey []string
+	rows       func(e *Executor) ([]Row, error)
+}
+
+// systemTables are the virtual tables SELECT can read. Their names are
+// reserved.
+var systemTables = map[string]systemTable{
+	"db_tables": {
+		columns: []ColumnDefinition{
+			{Name: "name", Type: TypeText},
+			{Name: "type", Type: TypeText},
+			{Name: "column_count", Type: TypeInteger},
+			{Name: "primary_key", Type: TypeText},
+			{Name: "root_page", Type: TypeInteger},
+		},
+		primaryKey: []string{"name"},
+		rows:       (*Executor).tableRows,
+	},
+	"db_columns": {
+		columns: []ColumnDefinition{
+			{Name: "table_name", Type: TypeText},
+			{Name: "position", Type: TypeInteger},
+			{Name: "name", Type: TypeText},
+			{Name: "type", Type: TypeText},
+			{Name: "not_null", Type: TypeBoolean},
+			{Name: "default_value", Type: TypeText},
+			{Name: "primary_key", Type: TypeBoolean},
+		},
+		primaryKey: []string{"table_name", "position"},
+		rows:       (*Executor).columnRows,
+	},
+	"db_indexes": {
+		columns: []ColumnDefinition{
+			{Name: "name", Type: TypeText},
+			{Name: "table_name", Type: TypeText},
+			{Name: "columns", Type: TypeText},
+			{Name: "is_unique", Type: TypeBoolean},
+			{Name: "root_page", Type: TypeInteger},
+		},
+		primaryKey: []string{"name"},
+		rows:       (*Executor).indexRows,
+	},
+	"db_stats": {
+		columns: []ColumnDefinition{
+			{Name: "name", Type: TypeText},
+			{Name: "type", Type: TypeText},
+			{Name: "table_name", Type: TypeText},
+			{Name: "root_page", Type: TypeInteger},
+			{Name: "row_count", Type: TypeInteger},
+			{Name: "height", Type: TypeInteger},
+		},
+		primaryKey: []string{"name"},
+		rows:       (*Executor).statsRows,
+	},
+}
+
+// isSystemTable reports whether a name belongs to a system table
+func isSystemTable(name string) bool {
+	_, ok := systemTables[strings.ToLower(name)]
+	return ok
+}
+
+// getTable returns a table to read from: a system table, built now, or a
+// table of the database
+func (e *Executor) getTable(name string) (Table, error) {
+	st, ok := systemTables[strings.ToLower(name)]
+	if !ok {
+		return e.db.GetTable(name)
+	}
+
+	schema, err := NewSchema(st.columns, st.primaryKey)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := st.rows(e)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return newMemTable(strings.ToLower(name), schema, rows)
+}
+
+// checkNotSystemTable fails statements that would change a system table or
+// create a table under a reserved name
+func checkNotSystemTable(stmt Statement) error {
+	var names []string
+	switch s := stmt.(type) {
+	case *InsertStatement:
+		names = []string{s.TableName}
+	case *UpdateStatement:
+		names = []string{s.TableName}
+	case *DeleteStatement:
+		names = []string{s.TableName}
+	case *CreateTableStatement:
+		names = []string{s.TableName}
+	case *DropTableStatement:
+		names = []string{s.TableName}
+	case *AlterTableStatement:
+		names = []string{s.TableName, s.NewName}
+	case *CreateIndexStatement:
+		names = []string{s.TableName, s.IndexName}
+	}
+	for _, name := range names {
+		if isSystemTable(name) {
+			return fmt.Errorf("%s is a read-only system table", strings.ToLower(name))
+		}
+	}
+	return nil
+}
+
+// catalogEntry is a table of the database with its decoded schema, nil for
+// a key/value table
+type catalogEntry struct {
+	name   string
+	schema *Schema
+}
+
+// catalogEntries returns the tables of the database in name order, leaving
+// out the tables that hold index entries
+func (e *Executor) catalogEntries() ([]catalogEntry, error) {
+	lister, ok := e.db.(TableLister)
+	if !ok {
+		return nil, fmt.Errorf("database cannot list its tables")
+	}
+	names := lister.ListTables()
+	sort.Strings(names)
+
+	var entries []catalogEntry
+	for _, name := range names {
+		table, err := e.db.GetTable(name)
+		if err != nil {
+			continue // dropped since it was listed
+		}
+		var schema *Schema
+		if st, ok := table.(SchemaTable); ok && st.Schema() != nil {
+			if schema, err = DecodeSchema(st.Schema()); err != nil {
+				return nil, fmt.Errorf("table %s: %w", name, err)
+			}
+			if schema.IndexTree {
+				continue
+			}
+		}
+		entries = append(entries, catalogEntry{name: name, schema: schema})
+	}
+	return entries, nil
+}
+
+// treeStats returns the statistics of a table's B+Tree. A database that
+// cannot provide them has its rows counted and no root page or height.
+func (e *Executor) treeStats(name string) (rootPage, rows, height Value, err error) {
+	if sd, ok := e.db.(StatsDatabase); ok {
+		stats, err := sd.TableStats(name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return stats.RootPage, stats.Rows, stats.Height, nil
+	}
+
+	table, err := e.db.GetTable(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var count int64
+	for iter := table.Scan([]byte("")); iter.ContainsNext(); {
+		if key, _ := iter.Next(); key != nil {
+			count++
+		}
+	}
+	return nil, count, nil, nil
+}
+
+// tableRows builds the rows of db_tables
+func (e *Executor) tableRows() ([]Row, error) {
+	entries, err := e.catalogEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	for _, entry := range entries {
+		rootPage, _, _, err := e.treeStats(entry.name)
+		if err != nil {
+			return nil, err
+		}
+		if entry.schema == nil {
+			rows = append(rows, Row{entry.name, "key/value", int64(2), "key", rootPage})
+			continue
+		}
+		rows = append(rows, Row{
+			entry.name,
+			"table",
+			int64(len(entry.schema.Columns)),
+			nullIfEmpty(strings.Join(entry.schema.PrimaryKey, ", ")),
+			rootPage,
+		})
+	}
+	return rows, nil
+}
+
+// columnRows builds the rows of db_columns
+func (e *Executor) columnRows() ([]Row, error) {
+	entries, err := e.catalogEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	for _, entry := range entries {
+		if entry.schema == nil {
+			rows = append(rows,
+				Row{entry.name, int64(1), "key", TypeBlob.String(), true, nil, true},
+				Row{entry.name, int64(2), "value", TypeBlob.String(), false, nil, false})
+			continue
+		}
+		for i, col := range entry.schema.Columns {
+			primaryKey := false
+			for _, name := range entry.schema.PrimaryKey {
+				primaryKey = primaryKey || strings.EqualFold(name, col.Name)
+			}
+			rows = append(rows, Row{
+				entry.name,
+				int64(i + 1),
+				col.Name,
+				col.Type.String(),
+				col.NotNull,
+				nullIfEmpty(col.Default),
+				primaryKey,
+			})
+		}
+	}
+	return rows, nil
+}
+
+// indexRows builds the rows of db_indexes
+func (e *Executor) indexRows() ([]Row, error) {
+	entries, err := e.catalogEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	for _, entry := range entries {
+		if entry.schema == nil {
+			continue
+		}
+		for _, idx := range entry.schema.Indexes {
+			rootPage, _, _, err := e.treeStats(idx.Name)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, Row{idx.Name, entry.name, strings.Join(idx.Columns, ", "), idx.Unique, rootPage})
+		}
+	}
+	return rows, nil
+}
+
+// statsRows builds the rows of db_stats: one for the B+Tree of every table
+// and index
+func (e *Executor) statsRows() ([]Row, error) {
+	entries, err := e.catalogEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	add := func(name, kind, tableName string) error {
+		rootPage, count, height, err := e.treeStats(name)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, Row{name, kind, tableName, rootPage, count, height})
+		return nil
+	}
+	for _, entry := range entries {
+		if err := add(entry.name, "table", entry.name); err != nil {
+			return nil, err
+		}
+		if entry.schema == nil {
+			continue
+		}
+		for _, idx := range entry.schema.Indexes {
+			if err := add(idx.Name, "index", entry.name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rows, nil
+}
+
+// nullIfEmpty returns NULL for an empty string
+func nullIfEmpty(s string) Value {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// memTable is a read-only table held in memory, used for system tables
+type memTable struct {
+	name   string
+	schema []byte
+	keys   [][]byte // sorted
+	values map[string][]byte
+}
+
+// newMemTable encodes rows into a memTable with the given schema
+func newMemTable(name string, schema *Schema, rows []Row) (*memTable, error) {
+	data, err := schema.Encode()
+	if err != nil {
+		return nil, err
+	}
+	t := &memTable{name: name, schema: data, values: make(map[string][]byte, len(rows))}
+	for _, row := range rows {
+		key, err := EncodeKey(schema, row)
+		if err != nil {
+			return nil, err
+		}
+		value, err := EncodeRow(schema, row)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := t.values[string(key)]; !dup {
+			t.keys = append(t.keys, key)
+		}
+		t.values[string(key)] = value
+	}
+	sort.Slice(t.keys, func(i, j int) bool { return bytes.Compare(t.keys[i], t.keys[j]) < 0 })
+	return t, nil
+}
+
+func (t *memTable) Insert(key, value []byte) error { return t.readOnly() }
+func (t *memTable) Update(key, value []byte) error { return t.readOnly() }
+func (t *memTable) Delete(key []byte) error        { return t.readOnly() }
+func (t *memTable) Name() string                   { return t.name }
+func (t *memTable) Schema() []byte                 { return t.schema }
+
+func (t *memTable) readOnly() error {
+	return fmt.Errorf("%s is a read-only system table", t.name)
+}
+
+func (t *memTable) Select(key []byte) ([]byte, bool) {
+	value, ok := t.values[string(key)]
+	return value, ok
+}
+
+// Scan returns the keys larger than startKey in order, like a B+Tree scan
+func (t *memTable) Scan(startKey []byte) Iterator {
+	i := sort.Search(len(t.keys), func(i int) bool { return bytes.Compare(t.keys[i], startKey) > 0 })
+	return &memIterator{table: t, pos: i}
+}
+
+// ScanReverse returns the keys smaller than endKey from the largest down, or
+// every key if endKey is nil
+func (t *memTable) ScanReverse(endKey []byte) Iterator {
+	i := len(t.keys)
+	if endKey != nil {
+		i = sort.Search(len(t.keys), func(i int) bool { return bytes.Compare(t.keys[i], endKey) >= 0 })
+	}
+	return &memIterator{table: t, pos: i - 1, reverse: true}
+}
+
+// memIterator walks the keys of a memTable
+type memIterator struct {
+	table   *memTable
+	pos     int
+	reverse bool
+}
+
+func (it *memIterator) ContainsNext() bool {
+	return it.pos >= 0 && it.pos < len(it.table.keys)
+}
+
+func (it *memIterator) Next() (key, val []byte) {
+	if !it.ContainsNext() {
+		return nil, nil
+	}
+	key = it.table.keys[it.pos]
+	if it.reverse {
+		it.pos--
+	} else {
+		it.pos++
+	}
+	return key, it.table.values[string(key)]
+}
@@ -14,6 +14,7 @@ const (
 	IDENTIFIER
 	STRING
 	NUMBER
+	PARAMETER // ? or $1
 	
 	// Keywords
 	SELECT
@@ -27,14 +28,78 @@ const (
 	WHERE
 	AND
 	OR
+	CREATE
+	TABLE
+	IF
+	NOT
+	EXISTS
+	PRIMARY
+	KEY
+	NULL
+	DROP
+	ALTER
+	ADD
+	COLUMN
+	RENAME
+	TO
+	DEFAULT
+	INDEX
+	UNIQUE
+	ON
+	CHECK
+	CONSTRAINT
+	AUTOINCREMENT
+	SEQUENCE
+	START
+	INCREMENT
+	WITH
+	BY
+	FOREIGN
+	REFERENCES
+	CASCADE
+	RESTRICT
+	NO
+	ACTION
+	LIKE
+	IN
+	BETWEEN
+	IS
+	AS
+	ORDER
+	ASC
+	DESC
+	NULLS
+	FIRST
+	LAST
+	LIMIT
+	OFFSET
+	DISTINCT
+	GROUP
+	HAVING
+	JOIN
+	INNER
+	LEFT
+	OUTER
+	CROSS
 	
 	// Operators and delimiters
 	EQUAL      // =
+	NOT_EQUAL  // != or <>
+	LESS       // <
+	LESS_EQ    // <=
+	GREATER    // >
+	GREATER_EQ // >=
 	COMMA      // ,
 	SEMICOLON  // ;
 	LPAREN     // (
 	RPAREN     // )
 	ASTERISK   // *
+	MINUS      // -
+	PLUS       // +
+	SLASH      // /
+	PERCENT    // %
+	CONCAT     // ||
+	DOT        // .
 )
 
 // Token represents a SQL token
@@ -46,17 +111,89 @@ type Token struct {
 
 // keywords maps string literals to their token types
 var keywords = map[string]TokenType{
-	"SELECT": SELECT,
-	"INSERT": INSERT,
-	"UPDATE": UPDATE,
-	"DELETE": DELETE,
-	"FROM":   FROM,
-	"INTO":   INTO,
-	"VALUES": VALUES,
-	"SET":    SET,
-	"WHERE":  WHERE,
-	"AND":    AND,
-	"OR":     OR,
+	"SELECT":        SELECT,
+	"INSERT":        INSERT,
+	"UPDATE":        UPDATE,
+	"DELETE":        DELETE,
+	"FROM":          FROM,
+	"INTO":          INTO,
+	"VALUES":        VALUES,
+	"SET":           SET,
+	"WHERE":         WHERE,
+	"AND":           AND,
+	"OR":            OR,
+	"CREATE":        CREATE,
+	"TABLE":         TABLE,
+	"IF":            IF,
+	"NOT":           NOT,
+	"EXISTS":        EXISTS,
+	"PRIMARY":       PRIMARY,
+	"KEY":           KEY,
+	"NULL":          NULL,
+	"DROP":          DROP,
+	"ALTER":         ALTER,
+	"ADD":           ADD,
+	"COLUMN":        COLUMN,
+	"RENAME":        RENAME,
+	"TO":            TO,
+	"DEFAULT":       DEFAULT,
+	"INDEX":         INDEX,
+	"UNIQUE":        UNIQUE,
+	"ON":            ON,
+	"CHECK":         CHECK,
+	"CONSTRAINT":    CONSTRAINT,
+	"AUTOINCREMENT": AUTOINCREMENT,
+	"SEQUENCE":      SEQUENCE,
+	"START":         START,
+	"INCREMENT":     INCREMENT,
+	"WITH":          WITH,
+	"BY":            BY,
+	"FOREIGN":       FOREIGN,
+	"REFERENCES":    REFERENCES,
+	"CASCADE":       CASCADE,
+	"RESTRICT":      RESTRICT,
+	"NO":            NO,
+	"ACTION":        ACTION,
+	"LIKE":          LIKE,
+	"IN":            IN,
+	"BETWEEN":       BETWEEN,
+	"IS":            IS,
+	"AS":            AS,
+	"ORDER":         ORDER,
+	"ASC":           ASC,
+	"DESC":          DESC,
+	"NULLS":         NULLS,
+	"FIRST":         FIRST,
+	"LAST":          LAST,
+	"LIMIT":         LIMIT,
+	"OFFSET":        OFFSET,
+	"DISTINCT":      DISTINCT,
+	"GROUP":         GROUP,
+	"HAVING":        HAVING,
+	"JOIN":          JOIN,
+	"INNER":         INNER,
+	"LEFT":          LEFT,
+	"OUTER":         OUTER,
+	"CROSS":         CROSS,
+}
+
+// nonReserved lists keywords that may still be used as table or column
+// names, since they only have a meaning in a few specific places
+var nonReserved = map[TokenType]bool{
+	KEY:       true,
+	INDEX:     true,
+	SEQUENCE:  true,
+	START:     true,
+	INCREMENT: true,
+	CASCADE:   true,
+	RESTRICT:  true,
+	NO:        true,
+	ACTION:    true,
+	ASC:       true,
+	DESC:      true,
+	NULLS:     true,
+	FIRST:     true,
+	LAST:      true,
 }
 
 // LookupIdent checks whether an identifier is a keyword
@@ -65,4 +202,4 @@ func LookupIdent(ident string) TokenType {
 		return tok
 	}
 	return IDENTIFIER
-}
\ No newline at end of file
+}
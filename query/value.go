@@ -0,0 +1,143 @@
+package query
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Value is a single SQL value. Its dynamic type is nil for NULL, or int64,
+// float64, string, []byte or bool for INTEGER, REAL, TEXT, BLOB and BOOLEAN
+// columns respectively.
+type Value any
+
+// Row holds one value per schema column, in schema order
+type Row []Value
+
+// ConvertLiteral converts a literal from a statement to a column's type
+func ConvertLiteral(literal string, t ColumnType) (Value, error) {
+	switch t {
+	case TypeInteger:
+		v, err := strconv.ParseInt(strings.TrimSpace(literal), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INTEGER value: %q", literal)
+		}
+		return v, nil
+	case TypeReal:
+		v, err := strconv.ParseFloat(strings.TrimSpace(literal), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REAL value: %q", literal)
+		}
+		return v, nil
+	case TypeText:
+		return literal, nil
+	case TypeBlob:
+		return []byte(literal), nil
+	case TypeBoolean:
+		switch strings.ToUpper(strings.TrimSpace(literal)) {
+		case "TRUE", "T", "1":
+			return true, nil
+		case "FALSE", "F", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid BOOLEAN value: %q", literal)
+	default:
+		return nil, fmt.Errorf("unknown column type: %v", t)
+	}
+}
+
+// FormatValue renders a value as text for display
+func FormatValue(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// checkValueType reports whether a value may be stored in a column of the
+// given type
+func checkValueType(v Value, t ColumnType) error {
+	var ok bool
+	switch v.(type) {
+	case nil:
+		ok = true
+	case int64:
+		ok = t == TypeInteger
+	case float64:
+		ok = t == TypeReal
+	case string:
+		ok = t == TypeText
+	case []byte:
+		ok = t == TypeBlob
+	case bool:
+		ok = t == TypeBoolean
+	}
+	if !ok {
+		return fmt.Errorf("cannot store %s value in %s column", withArticle(valueType(v)), withArticle(t))
+	}
+	return nil
+}
+
+// withArticle names a column type after "a" or "an", as in "an INTEGER"
+func withArticle(t ColumnType) string {
+	name := t.String()
+	if strings.ContainsAny(name[:1], "AEIOU") {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+// compareValues orders two non-NULL values of the same type, returning -1,
+// 0 or 1. INTEGER and REAL values compare with each other numerically.
+func compareValues(a, b Value) (int, error) {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, b), nil
+		case float64:
+			return cmp.Compare(float64(a), b), nil
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, float64(b)), nil
+		case float64:
+			return cmp.Compare(a, b), nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, nil
+			case b:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
@@ -0,0 +1,419 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// maxCascadeDepth limits how deeply foreign key actions may trigger each
+// other, as when deleting the root of a long chain of self-referencing rows
+const maxCascadeDepth = 1000
+
+// writeSet stages the row writes of one statement in a single batch, along
+// with the writes its foreign key actions cascade to. It remembers the rows
+// it has written, so lookups made later in the statement see them, and
+// stages the foreign key checks once every write is known.
+type writeSet struct {
+	e      *Executor
+	ctx    context.Context
+	batch  Batch
+	tables map[string]*writeTable
+
+	refs    map[string][]writeRef // foreign keys by the table they refer to; nil until loaded
+	touched []touchedRow          // rows written, in the order first written
+	removed []removedValues       // referenced values the statement deleted or changed
+	depth   int
+}
+
+// writeTable is a table written by a statement
+type writeTable struct {
+	name   string
+	table  Table
+	schema *Schema
+
+	rows     map[string]Row // rows written, by key; nil for a deleted row
+	original map[string]Row // rows as committed, by the keys written
+}
+
+// writeRef is a foreign key of child that refers to another table
+type writeRef struct {
+	child *writeTable
+	fk    *ForeignKey
+}
+
+// touchedRow is a key a statement wrote to
+type touchedRow struct {
+	table *writeTable
+	key   string
+}
+
+// removedValues records that a row of parent with values in the referenced
+// columns of a foreign key was deleted or changed, and the action taken
+type removedValues struct {
+	parent *writeTable
+	ref    writeRef
+	values []Value
+	action ForeignKeyAction
+}
+
+// newWriteSet starts the writes of one statement
+func (e *Executor) newWriteSet(ctx context.Context) *writeSet {
+	return &writeSet{e: e, ctx: ctx, batch: e.newBatch(), tables: make(map[string]*writeTable)}
+}
+
+// addTable makes a table whose schema the caller has already decoded
+// available to the write set
+func (w *writeSet) addTable(name string, table Table, schema *Schema) *writeTable {
+	if t, ok := w.tables[name]; ok {
+		return t
+	}
+	t := &writeTable{
+		name:     name,
+		table:    table,
+		schema:   schema,
+		rows:     make(map[string]Row),
+		original: make(map[string]Row),
+	}
+	w.tables[name] = t
+	return t
+}
+
+// table returns a typed table by name
+func (w *writeSet) table(name string) (*writeTable, error) {
+	if t, ok := w.tables[name]; ok {
+		return t, nil
+	}
+	table, err := w.e.db.GetTable(name)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := tableSchema(table)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("table %s has no columns", name)
+	}
+	return w.addTable(name, table, schema), nil
+}
+
+// references returns the foreign keys that refer to a table, including its
+// own
+func (w *writeSet) references(t *writeTable) ([]writeRef, error) {
+	if w.refs == nil {
+		w.refs = make(map[string][]writeRef)
+		err := w.e.typedTables(func(name string, table Table, schema *Schema) error {
+			child := w.addTable(name, table, schema)
+			for i := range child.schema.ForeignKeys {
+				fk := &child.schema.ForeignKeys[i]
+				w.refs[fk.RefTable] = append(w.refs[fk.RefTable], writeRef{child: child, fk: fk})
+			}
+			return nil
+		})
+		if err != nil {
+			w.refs = nil
+			return nil, err
+		}
+	}
+	return w.refs[t.name], nil
+}
+
+// get returns the current row with a key, as the statement's writes so far
+// leave it, or nil
+func (w *writeSet) get(t *writeTable, key []byte) (Row, error) {
+	if row, ok := t.rows[string(key)]; ok {
+		return row, nil
+	}
+	data, found := t.table.Select(key)
+	if !found {
+		return nil, nil
+	}
+	row, err := DecodeRow(t.schema, data)
+	if err != nil {
+		return nil, fmt.Errorf("row %q: %w", key, err)
+	}
+	return row, nil
+}
+
+// record notes the row now stored under a key, nil if none
+func (w *writeSet) record(t *writeTable, key []byte, row Row) error {
+	if _, ok := t.rows[string(key)]; !ok {
+		original, err := w.get(t, key)
+		if err != nil {
+			return err
+		}
+		t.original[string(key)] = original
+		w.touched = append(w.touched, touchedRow{table: t, key: string(key)})
+	}
+	t.rows[string(key)] = row
+	return nil
+}
+
+// lookup calls fn with every current row of a table that has the given
+// values, as the statement's writes so far leave the table
+func (w *writeSet) lookup(t *writeTable, equal map[int]Value, fn func(key []byte, row Row) error) error {
+	var keys [][]byte
+	for key, row := range t.rows {
+		if row != nil && rowMatches(row, equal) {
+			keys = append(keys, []byte(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	err := w.e.findRows(w.ctx, t.table, t.schema, equal, func(key []byte, row Row) error {
+		if _, ok := t.rows[string(key)]; !ok {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Earlier calls of fn may have changed the rows found
+	for _, key := range keys {
+		row, err := w.get(t, key)
+		if err != nil {
+			return err
+		}
+		if row == nil || !rowMatches(row, equal) {
+			continue
+		}
+		if err := fn(key, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insert stages a new row
+func (w *writeSet) insert(t *writeTable, row Row) error {
+	if err := checkRow(t.name, t.schema, row); err != nil {
+		return err
+	}
+	key, err := EncodeKey(t.schema, row)
+	if err != nil {
+		return err
+	}
+	if err := stageInsert(w.batch, t.name, t.schema, row); err != nil {
+		return err
+	}
+	return w.record(t, key, row)
+}
+
+// rowChange is a row an update replaces: old, stored under key, and the row
+// that takes its place
+type rowChange struct {
+	key      []byte
+	old, row Row
+}
+
+// update stages replacing the row old, stored under key, with row. If the
+// key changed, the row moves.
+func (w *writeSet) update(t *writeTable, key []byte, old, row Row) error {
+	return w.updateAll(t, []rowChange{{key: key, old: old, row: row}})
+}
+
+// updateAll stages replacing rows of a table as one set: every old row and
+// its index entries are removed before any new row is added, so a row may
+// take a key or unique value that another row of the set gives up, as
+// SET id = id + 1 has each row do
+func (w *writeSet) updateAll(t *writeTable, changes []rowChange) error {
+	newKeys := make([][]byte, len(changes))
+	for i, c := range changes {
+		if err := checkRow(t.name, t.schema, c.row); err != nil {
+			return err
+		}
+		key, err := EncodeKey(t.schema, c.row)
+		if err != nil {
+			return err
+		}
+		newKeys[i] = key
+	}
+
+	for _, c := range changes {
+		if err := stageDelete(w.batch, t.name, t.schema, c.key, c.old); err != nil {
+			return err
+		}
+		if err := w.record(t, c.key, nil); err != nil {
+			return err
+		}
+	}
+	for i, c := range changes {
+		if err := stageInsert(w.batch, t.name, t.schema, c.row); err != nil {
+			return err
+		}
+		if err := w.record(t, newKeys[i], c.row); err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		if err := w.cascade(t, c.old, c.row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete stages removing the row old, stored under key
+func (w *writeSet) delete(t *writeTable, key []byte, old Row) error {
+	if err := stageDelete(w.batch, t.name, t.schema, key, old); err != nil {
+		return err
+	}
+	if err := w.record(t, key, nil); err != nil {
+		return err
+	}
+	return w.cascade(t, old, nil)
+}
+
+// cascade carries out the foreign key actions for the rows referring to a
+// row that was deleted, when row is nil, or updated to row
+func (w *writeSet) cascade(t *writeTable, old, row Row) error {
+	refs, err := w.references(t)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		values := columnValues(t.schema, ref.fk.RefColumns, old)
+		if containsNull(values) {
+			continue // no row can refer to it
+		}
+
+		action := ref.fk.OnDelete
+		var newValues []Value
+		if row != nil {
+			newValues = columnValues(t.schema, ref.fk.RefColumns, row)
+			if sameValues(values, newValues) {
+				continue
+			}
+			action = ref.fk.OnUpdate
+		}
+		w.removed = append(w.removed, removedValues{parent: t, ref: ref, values: values, action: action})
+		if action == ActionNoAction {
+			continue // checked by finish
+		}
+
+		if w.depth >= maxCascadeDepth {
+			return fmt.Errorf("foreign key actions nested more than %d levels deep", maxCascadeDepth)
+		}
+		w.depth++
+		child := ref.child
+		err := w.lookup(child, columnEqualities(child.schema, ref.fk.Columns, values), func(key []byte, childRow Row) error {
+			if action == ActionRestrict {
+				return stillReferenced(child.name, ref.fk, values)
+			}
+			if action == ActionCascade && row == nil {
+				return w.delete(child, key, childRow)
+			}
+
+			updated := append(Row(nil), childRow...)
+			for k, name := range ref.fk.Columns {
+				i := child.schema.ColumnIndex(name)
+				switch action {
+				case ActionCascade:
+					updated[i] = newValues[k]
+				case ActionSetNull:
+					updated[i] = nil
+				case ActionSetDefault:
+					v, err := w.e.defaultFor(child.schema, i)
+					if err != nil {
+						return err
+					}
+					updated[i] = v
+				default:
+					return fmt.Errorf("foreign key %s: unsupported action %s", ref.fk.Name, action)
+				}
+			}
+			return w.update(child, key, childRow, updated)
+		})
+		w.depth--
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish stages the foreign key checks of the statement, which see all of
+// its writes: every row written must refer to existing rows, and no row may
+// refer to values the statement removed. The checks are made again when the
+// batch commits, so rows written concurrently cannot break them.
+func (w *writeSet) finish() error {
+	for _, r := range w.removed {
+		// The values may have been given to another row
+		parentEqual := columnEqualities(r.parent.schema, r.ref.fk.RefColumns, r.values)
+		exists := false
+		err := w.lookup(r.parent, parentEqual, func(key []byte, row Row) error {
+			exists = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		child := r.ref.child
+		violation := stillReferenced(child.name, r.ref.fk, r.values)
+		// Only these actions can leave rows referring to the old values
+		if r.action == ActionNoAction || r.action == ActionSetDefault {
+			err := w.lookup(child, columnEqualities(child.schema, r.ref.fk.Columns, r.values), func(key []byte, row Row) error {
+				return violation
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		tree, prefix, ok, err := referringPrefix(child.name, child.schema, r.ref.fk, r.values)
+		if err != nil {
+			return err
+		}
+		if ok {
+			w.batch.RequireAbsent(tree, prefix, violation)
+		}
+	}
+
+	for _, touched := range w.touched {
+		t := touched.table
+		row := t.rows[touched.key]
+		if row == nil {
+			continue
+		}
+		original := t.original[touched.key]
+
+		for i := range t.schema.ForeignKeys {
+			fk := &t.schema.ForeignKeys[i]
+			values := columnValues(t.schema, fk.Columns, row)
+			if containsNull(values) {
+				continue
+			}
+			if original != nil && sameValues(values, columnValues(t.schema, fk.Columns, original)) {
+				continue // unchanged, so still valid
+			}
+
+			parent, err := w.table(fk.RefTable)
+			if err != nil {
+				return fmt.Errorf("foreign key %s: %w", fk.Name, err)
+			}
+			tree, prefix, err := referencedPrefix(parent.name, parent.schema, fk.RefColumns, values)
+			if err != nil {
+				return fmt.Errorf("foreign key %s: %w", fk.Name, err)
+			}
+			w.batch.RequirePresent(tree, prefix, missingReference(t.name, fk, values))
+		}
+	}
+	return nil
+}
+
+// commit stages the foreign key checks and commits the statement's writes
+func (w *writeSet) commit() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	return w.batch.Commit()
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrSequenceExists is returned when creating a sequence whose name is
+	// already taken
+	ErrSequenceExists = errors.New("sequence already exists")
+
+	// ErrSequenceNotFound is returned when a sequence does not exist
+	ErrSequenceNotFound = errors.New("sequence does not exist")
+)
+
+// CreateSequence creates a sequence whose first value is start and whose
+// values then step by increment
+func (db *Database) CreateSequence(name string, start, increment int64) error {
+	wb := db.NewWriteBatch()
+	wb.CreateSequence(name, start, increment)
+	return wb.Commit()
+}
+
+// DropSequence removes a sequence
+func (db *Database) DropSequence(name string) error {
+	wb := db.NewWriteBatch()
+	wb.DropSequence(name)
+	return wb.Commit()
+}
+
+// AdvanceSequence makes sure a sequence never returns value, or a value it
+// would have returned before value. It is used when a row is written with
+// an explicit value for a column that is normally filled from a sequence.
+func (db *Database) AdvanceSequence(name string, value int64) error {
+	wb := db.NewWriteBatch()
+	wb.AdvanceSequence(name, value)
+	return wb.Commit()
+}
+
+// NextVal returns the next value of a sequence. The advanced sequence is
+// committed before the value is returned, so a value is never handed out
+// twice, even by concurrent callers or after a crash. Values taken by
+// statements that fail later are skipped, not reused.
+func (db *Database) NextVal(name string) (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.pm.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+
+	db.seqMu.Lock()
+	defer db.seqMu.Unlock()
+
+	entry, found, err := db.catalog.sequence(name)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: %s", ErrSequenceNotFound, name)
+	}
+
+	value := entry.next
+	if entry.next, err = stepSequence(name, value, entry.increment); err != nil {
+		return 0, err
+	}
+
+	batch := db.pm.NewBatch()
+	if err := db.catalog.putSequence(batch, name, entry); err != nil {
+		batch.Abort()
+		db.catalog.rollback(batch)
+		return 0, err
+	}
+	if err := batch.Commit(); err != nil {
+		db.catalog.rollback(batch)
+		return 0, fmt.Errorf("failed to advance sequence %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// stepSequence returns the value after value in a sequence, or an error if
+// it does not fit in an int64
+func stepSequence(name string, value, increment int64) (int64, error) {
+	if increment > 0 && value > math.MaxInt64-increment ||
+		increment < 0 && value < math.MinInt64-increment {
+		return 0, fmt.Errorf("sequence %s has reached its limit", name)
+	}
+	return value + increment, nil
+}
+
+// sequencePassed reports whether value is at or beyond the next value of a
+// sequence, in the direction the sequence moves
+func sequencePassed(entry sequenceEntry, value int64) bool {
+	if entry.increment < 0 {
+		return value <= entry.next
+	}
+	return value >= entry.next
+}
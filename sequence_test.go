@@ -0,0 +1,129 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequence(t *testing.T) {
+	tempFile := "test_sequence.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, db.CreateSequence("ids", 10, 5))
+	assert.ErrorIs(t, db.CreateSequence("ids", 1, 1), ErrSequenceExists)
+	assert.Error(t, db.CreateSequence("zero", 1, 0))
+	_, err = db.NextVal("missing")
+	assert.ErrorIs(t, err, ErrSequenceNotFound)
+
+	for _, want := range []int64{10, 15, 20} {
+		v, err := db.NextVal("ids")
+		require.NoError(t, err)
+		assert.Equal(t, want, v)
+	}
+
+	// Advancing to a value already handed out changes nothing; advancing
+	// past the next value skips ahead
+	require.NoError(t, db.AdvanceSequence("ids", 12))
+	v, err := db.NextVal("ids")
+	require.NoError(t, err)
+	assert.Equal(t, int64(25), v)
+	require.NoError(t, db.AdvanceSequence("ids", 100))
+	require.NoError(t, db.Close())
+
+	// The counter survives a reopen
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	v, err = db.NextVal("ids")
+	require.NoError(t, err)
+	assert.Equal(t, int64(105), v)
+
+	require.NoError(t, db.DropSequence("ids"))
+	_, err = db.NextVal("ids")
+	assert.ErrorIs(t, err, ErrSequenceNotFound)
+	assert.ErrorIs(t, db.DropSequence("ids"), ErrSequenceNotFound)
+	assert.ErrorIs(t, db.AdvanceSequence("ids", 1), ErrSequenceNotFound)
+}
+
+func TestSequenceConcurrentNextVal(t *testing.T) {
+	tempFile := "test_sequence_concurrent.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateSequence("ids", 1, 1))
+
+	const workers, perWorker = 8, 25
+	values := make(chan int64, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				v, err := db.NextVal("ids")
+				assert.NoError(t, err)
+				values <- v
+			}
+		}()
+	}
+	wg.Wait()
+	close(values)
+
+	seen := make(map[int64]bool)
+	for v := range values {
+		assert.False(t, seen[v], "value %d handed out twice", v)
+		seen[v] = true
+	}
+	assert.Len(t, seen, workers*perWorker)
+}
+
+func TestWriteBatchSequences(t *testing.T) {
+	tempFile := "test_write_batch_sequences.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// A failed batch creates no sequence
+	errTaken := errors.New("taken")
+	wb := db.NewWriteBatch()
+	wb.CreateTable("users", nil)
+	wb.CreateSequence("users_id_seq", 1, 1)
+	wb.Put("users", []byte("1"), []byte("alice"))
+	wb.RequireAbsent("users", []byte("1"), errTaken)
+	assert.ErrorIs(t, wb.Commit(), errTaken)
+	_, err = db.NextVal("users_id_seq")
+	assert.Error(t, err)
+
+	// A table and its sequence are created and dropped together
+	wb = db.NewWriteBatch()
+	wb.CreateTable("users", nil)
+	wb.CreateSequence("users_id_seq", 1, 1)
+	wb.Put("users", []byte("7"), []byte("alice"))
+	wb.AdvanceSequence("users_id_seq", 7)
+	require.NoError(t, wb.Commit())
+	v, err := db.NextVal("users_id_seq")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), v)
+
+	wb = db.NewWriteBatch()
+	wb.DropTable("users")
+	wb.DropSequence("users_id_seq")
+	require.NoError(t, wb.Commit())
+	_, err = db.GetTable("users")
+	assert.Error(t, err)
+	_, err = db.NextVal("users_id_seq")
+	assert.Error(t, err)
+}
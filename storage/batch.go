@@ -0,0 +1,161 @@
+package storage
+
+import "fmt"
+
+// Batch stages page writes, allocations and frees so they reach disk as a
+// single atomic commit. Nothing staged in a batch is visible through the
+// page manager until Commit or Publish; Abort throws the staged pages away
+// and returns any pages the batch allocated to the free list.
+//
+// Commit is Publish followed by Wait. Callers holding a lock over the data
+// the batch changes publish under the lock and wait after releasing it, so
+// other writers can add their commits to the same log fsync.
+type Batch struct {
+	pm        *PageManager
+	pages     map[PageID]*Page
+	order     []PageID // first-write order of staged pages
+	allocated []PageID
+	freed     []PageID
+	done      bool
+	published bool
+	lsn       uint64 // commit group of the published pages; 0 if none
+}
+
+// NewBatch starts an empty batch
+func (pm *PageManager) NewBatch() *Batch {
+	return &Batch{
+		pm:    pm,
+		pages: make(map[PageID]*Page),
+	}
+}
+
+// AllocatePage reserves a page for the batch and stages it as an empty
+// page of the given type
+func (b *Batch) AllocatePage(pageType PageType) (PageID, error) {
+	if b.done {
+		return InvalidPageID, fmt.Errorf("batch already finished")
+	}
+
+	pageID, err := b.pm.reservePage()
+	if err != nil {
+		return InvalidPageID, err
+	}
+	b.allocated = append(b.allocated, pageID)
+
+	b.WritePage(NewPage(pageID, pageType))
+	return pageID, nil
+}
+
+// DeallocatePage stages freeing a page. The page becomes reusable once the
+// batch commits.
+func (b *Batch) DeallocatePage(pageID PageID) {
+	b.WritePage(NewPage(pageID, FreePageType))
+	b.freed = append(b.freed, pageID)
+}
+
+// WritePage stages a page write, replacing any earlier write of the same
+// page in this batch
+func (b *Batch) WritePage(page *Page) {
+	if _, exists := b.pages[page.ID]; !exists {
+		b.order = append(b.order, page.ID)
+	}
+	b.pages[page.ID] = page
+}
+
+// ReadPage reads a page as the batch sees it: staged writes first, then the
+// committed state
+func (b *Batch) ReadPage(pageID PageID) (*Page, error) {
+	if page, ok := b.pages[pageID]; ok {
+		copied := *page
+		return &copied, nil
+	}
+	return b.pm.ReadPage(pageID)
+}
+
+// WriteMeta stages new contents for the metadata page
+func (b *Batch) WriteMeta(data []byte) error {
+	page := NewPage(0, MetaPageType)
+	if err := page.SetData(data); err != nil {
+		return err
+	}
+	b.WritePage(page)
+	return nil
+}
+
+// Staged reports whether the batch has a pending write for the page
+func (b *Batch) Staged(pageID PageID) bool {
+	_, ok := b.pages[pageID]
+	return ok
+}
+
+// StagedPages returns the IDs of every page the batch writes
+func (b *Batch) StagedPages() []PageID {
+	return append([]PageID(nil), b.order...)
+}
+
+// Len returns the number of staged pages
+func (b *Batch) Len() int {
+	return len(b.order)
+}
+
+// Commit durably writes every staged page as one atomic unit
+func (b *Batch) Commit() error {
+	if err := b.Publish(); err != nil {
+		return err
+	}
+	return b.Wait()
+}
+
+// Publish makes every staged page visible as one atomic unit and queues it
+// for the write-ahead log. The pages are not durable until Wait returns.
+func (b *Batch) Publish() error {
+	if b.done {
+		return fmt.Errorf("batch already finished")
+	}
+	b.done = true
+
+	if len(b.order) == 0 {
+		return nil
+	}
+
+	if b.pm.readOnly {
+		b.pm.releasePages(b.allocated)
+		return ErrReadOnly
+	}
+
+	pages := make([]*Page, 0, len(b.order))
+	for _, id := range b.order {
+		pages = append(pages, b.pages[id])
+	}
+
+	lsn, err := b.pm.publishPages(pages...)
+	if err != nil {
+		b.pm.releasePages(b.allocated)
+		return err
+	}
+	b.published = true
+	b.lsn = lsn
+
+	b.pm.releasePages(b.freed)
+	return nil
+}
+
+// Wait blocks until the pages published by the batch are durable
+func (b *Batch) Wait() error {
+	if !b.done {
+		return fmt.Errorf("batch not published")
+	}
+	if !b.published {
+		return nil
+	}
+	return b.pm.commits.wait(b.lsn)
+}
+
+// Abort discards the batch
+func (b *Batch) Abort() {
+	if b.done {
+		return
+	}
+	b.done = true
+	b.pm.releasePages(b.allocated)
+}
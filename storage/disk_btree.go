@@ -1,293 +1,659 @@
 package storage
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	
 	"github.com/JoshuaLim25/db/btree"
 )
 
-// DiskBTree implements a persistent B+Tree using page-based storage
+// ErrEntryTooLarge is returned when a key and value do not fit in a page
+var ErrEntryTooLarge = errors.New("entry too large for a B+Tree page")
+
+// DiskBTree implements a persistent B+Tree using page-based storage.
+// The root never moves: when it splits, its contents move into two new
+// children, so a tree can be found again from the root page ID alone.
 type DiskBTree struct {
 	pm     *PageManager
 	rootID PageID
-	cache  map[PageID]*btree.Node // Simple node cache
+	
+	mu         sync.Mutex           // guards the cache and the row count
+	cache      map[PageID]*diskNode // Simple node cache
+	count      int
+	countKnown bool
 }
 
 // NewDiskBTree creates a new disk-based B+Tree
 func NewDiskBTree(pm *PageManager) (*DiskBTree, error) {
-	dbt := &DiskBTree{
-		pm:    pm,
-		cache: make(map[PageID]*btree.Node),
+	batch := pm.NewBatch()
+	dbt, err := CreateDiskBTree(batch)
+	if err != nil {
+		batch.Abort()
+		return nil, err
 	}
 	
-	// Create initial root page
-	rootID, err := pm.AllocatePage(BTreeLeafType)
+	if err := batch.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to save root node: %w", err)
+	}
+	return dbt, nil
+}
+
+// CreateDiskBTree creates a new empty B+Tree whose root page is written as
+// part of the batch
+func CreateDiskBTree(batch *Batch) (*DiskBTree, error) {
+	rootID, err := batch.AllocatePage(BTreeLeafType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate root page: %w", err)
 	}
 	
+	dbt := &DiskBTree{
+		pm:         batch.pm,
+		rootID:     rootID,
+		cache:      make(map[PageID]*diskNode),
+		countKnown: true,
+	}
+	
 	// Create root node and save it
-	root := btree.NewLeafNode()
-	if err := dbt.saveNode(rootID, root); err != nil {
+	if err := dbt.stage(batch, newLeafDiskNode(rootID)); err != nil {
 		return nil, fmt.Errorf("failed to save root node: %w", err)
 	}
+	return dbt, nil
+}
+	
+// OpenDiskBTree opens an existing B+Tree by its root page
+func OpenDiskBTree(pm *PageManager, rootID PageID) (*DiskBTree, error) {
+	dbt := &DiskBTree{
+		pm:     pm,
+		rootID: rootID,
+		cache:  make(map[PageID]*diskNode),
+	}
 	
-	dbt.rootID = rootID
+	if _, err := dbt.loadNode(nil, rootID); err != nil {
+		return nil, fmt.Errorf("failed to load root page %d: %w", rootID, err)
+	}
 	return dbt, nil
 }
 
+// RootID returns the page ID of the tree's root, which never changes
+func (dbt *DiskBTree) RootID() PageID {
+	return dbt.rootID
+}
+
 // Get retrieves a value by key
 func (dbt *DiskBTree) Get(key []byte) (val []byte, ok bool) {
-	root, err := dbt.loadNode(dbt.rootID)
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	_, _, leaf, err := dbt.descend(nil, key)
 	if err != nil {
 		return nil, false
 	}
 	
-	leaf := dbt.findLeaf(root, key)
-	index := dbt.findKeyIndex(leaf, key)
-	
-	if index >= 0 && index < leaf.NumKeys && bytes.Equal(leaf.KeyAt(index), key) {
-		return leaf.ValueAt(index), true
+	if index, found := leaf.search(key); found {
+		return leaf.vals[index], true
 	}
-	
 	return nil, false
 }
 
 // Set inserts or updates a key-value pair
 func (dbt *DiskBTree) Set(key, val []byte) {
-	root, err := dbt.loadNode(dbt.rootID)
-	if err != nil {
-		return // In a production system, we'd return the error
+	dbt.Put(nil, key, val) // Errors are reported by Put
+}
+
+// Delete removes a key-value pair
+func (dbt *DiskBTree) Delete(key []byte) {
+	dbt.Remove(nil, key) // Errors are reported by Remove
+}
+
+// Put inserts or updates a key-value pair. With a batch the change is
+// staged in it; with a nil batch it is committed on its own.
+func (dbt *DiskBTree) Put(batch *Batch, key, val []byte) error {
+	if 8+len(key)+len(val) > MaxEntrySize {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrEntryTooLarge, 8+len(key)+len(val), MaxEntrySize)
 	}
 	
-	leaf := dbt.findLeaf(root, key)
-	index := dbt.findKeyIndex(leaf, key)
+	return dbt.apply(batch, func(b *Batch) error {
+		return dbt.put(b, key, val)
+	})
+}
 	
-	// If key exists, update the value
-	if index >= 0 && index < leaf.NumKeys && bytes.Equal(leaf.KeyAt(index), key) {
-		leaf.Values[index] = val
-		// Save the modified leaf back to disk
-		dbt.saveNodeFromCache(leaf)
-		return
-	}
+// Remove deletes a key and reports whether it existed. With a batch the
+// change is staged in it; with a nil batch it is committed on its own.
+func (dbt *DiskBTree) Remove(batch *Batch, key []byte) (bool, error) {
+	var removed bool
+	err := dbt.apply(batch, func(b *Batch) error {
+		var err error
+		removed, err = dbt.remove(b, key)
+		return err
+	})
+	return removed, err
+}
+
+// Rollback forgets every change staged in an aborted batch. Call it for
+// each tree the batch touched before or after aborting the batch.
+func (dbt *DiskBTree) Rollback(batch *Batch) {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
 	
-	// Insert new key-value pair
-	dbt.insertIntoLeaf(leaf, key, val, index)
+	dbt.rollbackLocked()
 }
 
-// Delete removes a key-value pair
-func (dbt *DiskBTree) Delete(key []byte) {
-	root, err := dbt.loadNode(dbt.rootID)
-	if err != nil {
-		return // In a production system, we'd return the error
+// apply runs a mutation under the tree lock, either inside the caller's
+// batch or in a batch of its own that it commits. In the latter case it
+// waits for the commit to be durable with the lock released, so readers and
+// other writers of the tree do not queue behind the log fsync.
+func (dbt *DiskBTree) apply(batch *Batch, op func(b *Batch) error) error {
+	if batch != nil {
+		dbt.mu.Lock()
+		defer dbt.mu.Unlock()
+	
+		return op(batch)
 	}
 	
-	leaf := dbt.findLeaf(root, key)
-	index := dbt.findKeyIndex(leaf, key)
-	
-	if index >= 0 && index < leaf.NumKeys && bytes.Equal(leaf.KeyAt(index), key) {
-		dbt.deleteFromLeaf(leaf, index)
+	batch = dbt.pm.NewBatch()
+	if err := dbt.publish(batch, op); err != nil {
+		return err
 	}
+	return batch.Wait()
+}
+
+// publish runs op in batch under the tree lock and publishes the batch
+func (dbt *DiskBTree) publish(batch *Batch, op func(b *Batch) error) error {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	if err := op(batch); err != nil {
+		batch.Abort()
+		dbt.rollbackLocked()
+		return err
+	}
+	if err := batch.Publish(); err != nil {
+		dbt.rollbackLocked()
+		return err
+	}
+	return nil
+}
+
+// rollbackLocked drops the node cache, which may hold nodes modified by an
+// aborted batch, so that the next access rereads the committed pages
+func (dbt *DiskBTree) rollbackLocked() {
+	dbt.cache = make(map[PageID]*diskNode)
+	dbt.countKnown = false
+}
+
+// Free stages returning every page of the tree, including its root, to the
+// free list. The tree must not be used once the batch commits.
+func (dbt *DiskBTree) Free(batch *Batch) error {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	stack := []PageID{dbt.rootID}
+	for len(stack) > 0 {
+		pageID := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		
+		node, err := dbt.loadNode(batch, pageID)
+		if err != nil {
+			return fmt.Errorf("failed to free page %d: %w", pageID, err)
+		}
+		if !node.leaf {
+			stack = append(stack, node.children...)
+		}
+		
+		delete(dbt.cache, pageID)
+		batch.DeallocatePage(pageID)
+	}
+	
+	dbt.countKnown = false
+	return nil
 }
 
 // FindLarger returns an iterator for keys larger than the given key
 func (dbt *DiskBTree) FindLarger(key []byte) btree.Iterator {
-	root, err := dbt.loadNode(dbt.rootID)
+	return dbt.FindLargerContext(context.Background(), key)
+}
+
+// FindLargerContext returns an iterator for keys larger than the given key
+// that reads no more pages once ctx is done. It then reports no more keys,
+// so the caller checks ctx to tell a canceled scan from the end of the
+// tree.
+func (dbt *DiskBTree) FindLargerContext(ctx context.Context, key []byte) btree.Iterator {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	_, _, leaf, err := dbt.descend(nil, key)
 	if err != nil {
-		return &DiskBTreeIterator{dbt: dbt, current: InvalidPageID, index: 0}
+		return &DiskBTreeIterator{ctx: ctx, dbt: dbt, current: InvalidPageID, index: 0}
 	}
 	
-	leaf := dbt.findLeaf(root, key)
-	index := dbt.findKeyIndex(leaf, key)
-	
-	// Find the first key larger than the given key
-	for index < leaf.NumKeys && bytes.Compare(leaf.KeyAt(index), key) <= 0 {
+	// Find the first key larger than the given key; the iterator moves on
+	// to the next leaf if that is past the end of this one
+	index, found := leaf.search(key)
+	if found {
 		index++
 	}
 	
-	// If we've gone past the end of this leaf, move to next leaf
-	if index >= leaf.NumKeys {
-		// For now, we'll just return an empty iterator
-		// In a full implementation, we'd follow next pointers
-		return &DiskBTreeIterator{dbt: dbt, current: InvalidPageID, index: 0}
-	}
-	
 	return &DiskBTreeIterator{
+		ctx:     ctx,
 		dbt:     dbt,
-		current: dbt.getPageIDFromNode(leaf),
+		current: leaf.id,
 		index:   index,
 	}
 }
 
-// loadNode loads a node from disk or cache
-func (dbt *DiskBTree) loadNode(pageID PageID) (*btree.Node, error) {
+// FindSmaller returns an iterator for keys smaller than the given key, from
+// the largest down. A nil or empty key starts from the largest key in the
+// tree.
+func (dbt *DiskBTree) FindSmaller(key []byte) btree.Iterator {
+	return dbt.FindSmallerContext(context.Background(), key)
+}
+
+// FindSmallerContext returns an iterator as FindSmaller does that reads no
+// more pages once ctx is done, as FindLargerContext does
+func (dbt *DiskBTree) FindSmallerContext(ctx context.Context, key []byte) btree.Iterator {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	var leaf *diskNode
+	var err error
+	if len(key) == 0 {
+		leaf, err = dbt.lastLeaf()
+	} else {
+		_, _, leaf, err = dbt.descend(nil, key)
+	}
+	if err != nil {
+		return &DiskBTreeReverseIterator{ctx: ctx, dbt: dbt, current: InvalidPageID}
+	}
+	
+	// The largest key smaller than the given one is just before where it
+	// would go; the iterator moves back to the previous leaf if that is
+	// before the start of this one
+	index := len(leaf.keys)
+	if len(key) > 0 {
+		index, _ = leaf.search(key)
+	}
+	
+	return &DiskBTreeReverseIterator{
+		ctx:     ctx,
+		dbt:     dbt,
+		current: leaf.id,
+		index:   index - 1,
+	}
+}
+
+// Count returns the number of keys in the tree. The first call on an opened
+// tree walks its leaves; after that the count is maintained as keys come
+// and go.
+func (dbt *DiskBTree) Count() (int, error) {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	if dbt.countKnown {
+		return dbt.count, nil
+	}
+	
+	leaf, err := dbt.firstLeaf(nil)
+	if err != nil {
+		return 0, err
+	}
+	
+	count := 0
+	for {
+		count += len(leaf.keys)
+		if leaf.next == InvalidPageID {
+			break
+		}
+		if leaf, err = dbt.loadNode(nil, leaf.next); err != nil {
+			return 0, err
+		}
+	}
+	
+	dbt.count = count
+	dbt.countKnown = true
+	return count, nil
+}
+
+// Height returns the number of levels in the tree: 1 while the root is a
+// leaf, one more for every level of internal nodes above the leaves
+func (dbt *DiskBTree) Height() (int, error) {
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	node, err := dbt.loadNode(nil, dbt.rootID)
+	if err != nil {
+		return 0, err
+	}
+	
+	height := 1
+	for !node.leaf {
+		if node, err = dbt.loadNode(nil, node.children[0]); err != nil {
+			return 0, err
+		}
+		height++
+	}
+	return height, nil
+}
+
+// loadNode loads a node from the cache, the batch or disk
+func (dbt *DiskBTree) loadNode(batch *Batch, pageID PageID) (*diskNode, error) {
 	// Check cache first
 	if node, exists := dbt.cache[pageID]; exists {
 		return node, nil
 	}
 	
-	// Load from disk
-	page, err := dbt.pm.ReadPage(pageID)
+	var page *Page
+	var err error
+	if batch != nil {
+		page, err = batch.ReadPage(pageID)
+	} else {
+		page, err = dbt.pm.ReadPage(pageID)
+	}
 	if err != nil {
 		return nil, err
 	}
 	
-	node, err := DeserializeNode(page.GetData())
+	if page.Header.PageType != BTreeLeafType && page.Header.PageType != BTreeInternalType {
+		return nil, fmt.Errorf("page %d is not a B+Tree page", pageID)
+	}
+	
+	node, err := diskNodeFromPage(page)
 	if err != nil {
 		return nil, err
 	}
 	
-	// Store node ID for later reference
-	dbt.setNodePageID(node, pageID)
-	
 	// Cache the node
 	dbt.cache[pageID] = node
-	
 	return node, nil
 }
 
-// saveNode saves a node to disk
-func (dbt *DiskBTree) saveNode(pageID PageID, node *btree.Node) error {
-	data, err := SerializeNode(node)
+// stage writes a node into the batch and the cache
+func (dbt *DiskBTree) stage(batch *Batch, node *diskNode) error {
+	page, err := node.toPage()
 	if err != nil {
 		return err
 	}
 	
-	// Determine page type
-	var pageType PageType
-	if node.IsLeaf() {
-		pageType = BTreeLeafType
-	} else {
-		pageType = BTreeInternalType
-	}
+	batch.WritePage(page)
+	dbt.cache[node.id] = node
+	return nil
+}
+
+// descend walks from the root to the leaf that should contain the key. It
+// returns the internal nodes on the way and the child index taken in each.
+func (dbt *DiskBTree) descend(batch *Batch, key []byte) ([]*diskNode, []int, *diskNode, error) {
+	var path []*diskNode
+	var indexes []int
 	
-	page := NewPage(pageID, pageType)
-	if err := page.SetData(data); err != nil {
-		return err
+	node, err := dbt.loadNode(batch, dbt.rootID)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	
-	if err := dbt.pm.WritePage(page); err != nil {
-		return err
+	for !node.leaf {
+		index := node.childIndex(key)
+		path = append(path, node)
+		indexes = append(indexes, index)
+		
+		if node, err = dbt.loadNode(batch, node.children[index]); err != nil {
+			return nil, nil, nil, err
+		}
 	}
 	
-	// Update cache
-	dbt.setNodePageID(node, pageID)
-	dbt.cache[pageID] = node
-	
-	return nil
+	return path, indexes, node, nil
 }
 
-// saveNodeFromCache saves a node that's already in cache
-func (dbt *DiskBTree) saveNodeFromCache(node *btree.Node) {
-	pageID := dbt.getPageIDFromNode(node)
-	if pageID != InvalidPageID {
-		dbt.saveNode(pageID, node)
+// firstLeaf returns the leftmost leaf of the tree
+func (dbt *DiskBTree) firstLeaf(batch *Batch) (*diskNode, error) {
+	node, err := dbt.loadNode(batch, dbt.rootID)
+	if err != nil {
+		return nil, err
 	}
+	
+	for !node.leaf {
+		if node, err = dbt.loadNode(batch, node.children[0]); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
 }
 
-// Helper methods for node-to-pageID mapping
-// In a full implementation, we'd store this as part of the node structure
-var nodeToPageID = make(map[*btree.Node]PageID)
-
-func (dbt *DiskBTree) setNodePageID(node *btree.Node, pageID PageID) {
-	nodeToPageID[node] = pageID
-}
-
-func (dbt *DiskBTree) getPageIDFromNode(node *btree.Node) PageID {
-	if pageID, exists := nodeToPageID[node]; exists {
-		return pageID
+// lastLeaf returns the rightmost leaf of the tree
+func (dbt *DiskBTree) lastLeaf() (*diskNode, error) {
+	node, err := dbt.loadNode(nil, dbt.rootID)
+	if err != nil {
+		return nil, err
+	}
+	
+	for !node.leaf {
+		if node, err = dbt.loadNode(nil, node.children[len(node.children)-1]); err != nil {
+			return nil, err
+		}
 	}
-	return InvalidPageID
+	return node, nil
 }
 
-// findLeaf navigates to the leaf node that should contain the given key
-func (dbt *DiskBTree) findLeaf(node *btree.Node, key []byte) *btree.Node {
-	current := node
+// put inserts or replaces a key in its leaf and splits nodes as needed
+func (dbt *DiskBTree) put(batch *Batch, key, val []byte) error {
+	path, indexes, leaf, err := dbt.descend(batch, key)
+	if err != nil {
+		return err
+	}
 	
-	for current != nil && !current.IsLeaf() {
-		// For now, we don't follow child pointers in internal nodes
-		// This is a simplified implementation
-		break
+	val = append([]byte{}, val...)
+	index, found := leaf.search(key)
+	if found {
+		// If key exists, update the value
+		leaf.vals[index] = val
+		return dbt.stage(batch, leaf)
 	}
 	
-	return current
-}
-
-// findKeyIndex finds the position where key should be in the node
-func (dbt *DiskBTree) findKeyIndex(node *btree.Node, key []byte) int {
-	if node == nil {
-		return 0
+	leaf.keys = insertAt(leaf.keys, index, append([]byte{}, key...))
+	leaf.vals = insertAt(leaf.vals, index, val)
+	if dbt.countKnown {
+		dbt.count++
 	}
 	
-	for i := 0; i < node.NumKeys; i++ {
-		nodeKey := node.KeyAt(i)
-		if nodeKey == nil {
-			continue
+	// Split overfull nodes from the leaf upwards
+	node := leaf
+	for node.size() > nodeCapacity {
+		if node.id == dbt.rootID {
+			return dbt.splitRoot(batch, node)
 		}
-		cmp := bytes.Compare(key, nodeKey)
-		if cmp <= 0 {
-			return i
+		
+		right, separator, err := dbt.split(batch, node)
+		if err != nil {
+			return err
 		}
+		if err := dbt.stage(batch, right); err != nil {
+			return err
+		}
+		if err := dbt.stage(batch, node); err != nil {
+			return err
+		}
+		
+		parent := path[len(path)-1]
+		childIndex := indexes[len(indexes)-1]
+		path, indexes = path[:len(path)-1], indexes[:len(indexes)-1]
+		
+		parent.keys = insertAt(parent.keys, childIndex, separator)
+		parent.children = insertAt(parent.children, childIndex+1, right.id)
+		node = parent
 	}
-	return node.NumKeys
+	
+	return dbt.stage(batch, node)
 }
 
-// findChildIndex finds which child to follow for the given key
-func (dbt *DiskBTree) findChildIndex(node *btree.Node, key []byte) int {
-	for i := 0; i < node.NumKeys; i++ {
-		if bytes.Compare(key, node.KeyAt(i)) < 0 {
-			return i
-		}
+// remove deletes a key from its leaf. Leaves are allowed to become empty
+// rather than being merged; scans simply skip them.
+func (dbt *DiskBTree) remove(batch *Batch, key []byte) (bool, error) {
+	_, _, leaf, err := dbt.descend(batch, key)
+	if err != nil {
+		return false, err
+	}
+	
+	index, found := leaf.search(key)
+	if !found {
+		return false, nil
 	}
-	return node.NumKeys
+	
+	leaf.keys = removeAt(leaf.keys, index)
+	leaf.vals = removeAt(leaf.vals, index)
+	if dbt.countKnown {
+		dbt.count--
+	}
+	
+	return true, dbt.stage(batch, leaf)
 }
 
-// insertIntoLeaf inserts a key-value pair into a leaf node
-func (dbt *DiskBTree) insertIntoLeaf(leaf *btree.Node, key, val []byte, index int) {
-	if leaf == nil {
-		return
+// splitPoint picks where to split an overfull node so that both halves
+// are as close to the same size as possible
+func splitPoint(node *diskNode) int {
+	total := 0
+	for i := range node.keys {
+		total += node.entrySize(i)
 	}
 	
-	// Simple implementation - just insert without splitting for now
-	// In a full implementation, we'd handle splitting
-	if leaf.NumKeys < btree.MaxKeys {
-		// Shift elements to make room
-		for i := leaf.NumKeys; i > index; i-- {
-			leaf.Keys[i] = leaf.Keys[i-1]
-			leaf.Values[i] = leaf.Values[i-1]
+	best, bestSize := 1, -1
+	prefix := 0
+	for m := 1; m < len(node.keys); m++ {
+		prefix += node.entrySize(m - 1)
+		
+		right := total - prefix
+		if !node.leaf {
+			// The separator at m moves up instead of going right
+			if m == len(node.keys)-1 {
+				break
+			}
+			right -= node.entrySize(m)
 		}
 		
-		leaf.Keys[index] = key
-		leaf.Values[index] = val
-		leaf.NumKeys++
+		larger := max(prefix, right)
+		if bestSize < 0 || larger < bestSize {
+			best, bestSize = m, larger
+		}
+	}
+	return best
+}
+
+// split moves the upper half of an overfull node into a new right sibling
+// and returns it with the separator key for the parent
+func (dbt *DiskBTree) split(batch *Batch, node *diskNode) (*diskNode, []byte, error) {
+	m := splitPoint(node)
+	
+	pageType := BTreeInternalType
+	if node.leaf {
+		pageType = BTreeLeafType
+	}
+	rightID, err := batch.AllocatePage(pageType)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	right := &diskNode{id: rightID, leaf: node.leaf, next: InvalidPageID, prev: InvalidPageID}
+	var separator []byte
+	
+	if node.leaf {
+		right.keys = append([][]byte{}, node.keys[m:]...)
+		right.vals = append([][]byte{}, node.vals[m:]...)
+		node.keys = append([][]byte{}, node.keys[:m]...)
+		node.vals = append([][]byte{}, node.vals[:m]...)
+		separator = right.keys[0]
 		
-		// Save back to disk
-		dbt.saveNodeFromCache(leaf)
+		// Link the new leaf into the sibling chain
+		right.next = node.next
+		right.prev = node.id
+		if node.next != InvalidPageID {
+			next, err := dbt.loadNode(batch, node.next)
+			if err != nil {
+				return nil, nil, err
+			}
+			next.prev = rightID
+			if err := dbt.stage(batch, next); err != nil {
+				return nil, nil, err
+			}
+		}
+		node.next = rightID
+	} else {
+		separator = node.keys[m]
+		right.keys = append([][]byte{}, node.keys[m+1:]...)
+		right.children = append([]PageID{}, node.children[m+1:]...)
+		node.keys = append([][]byte{}, node.keys[:m]...)
+		node.children = append([]PageID{}, node.children[:m+1]...)
 	}
+	
+	return right, separator, nil
 }
 
-// deleteFromLeaf removes a key-value pair from a leaf node
-func (dbt *DiskBTree) deleteFromLeaf(leaf *btree.Node, index int) {
-	if leaf == nil || index < 0 || index >= leaf.NumKeys {
-		return
+// splitRoot moves the contents of an overfull root into two new children
+// so that the root keeps its page ID
+func (dbt *DiskBTree) splitRoot(batch *Batch, root *diskNode) error {
+	leftID, err := dbt.allocateLike(batch, root)
+	if err != nil {
+		return err
 	}
 	
-	// Shift elements to fill the gap
-	for i := index; i < leaf.NumKeys-1; i++ {
-		leaf.Keys[i] = leaf.Keys[i+1]
-		leaf.Values[i] = leaf.Values[i+1]
+	left := &diskNode{
+		id:       leftID,
+		leaf:     root.leaf,
+		keys:     root.keys,
+		vals:     root.vals,
+		children: root.children,
+		next:     InvalidPageID,
+		prev:     InvalidPageID,
 	}
+	dbt.cache[leftID] = left
 	
-	leaf.NumKeys--
+	right, separator, err := dbt.split(batch, left)
+	if err != nil {
+		return err
+	}
+	if err := dbt.stage(batch, left); err != nil {
+		return err
+	}
+	if err := dbt.stage(batch, right); err != nil {
+		return err
+	}
 	
-	// Save back to disk
-	dbt.saveNodeFromCache(leaf)
+	root.leaf = false
+	root.keys = [][]byte{separator}
+	root.vals = nil
+	root.children = []PageID{leftID, right.id}
+	root.next = InvalidPageID
+	root.prev = InvalidPageID
+	return dbt.stage(batch, root)
+}
+
+// allocateLike allocates a page of the same type as the node
+func (dbt *DiskBTree) allocateLike(batch *Batch, node *diskNode) (PageID, error) {
+	if node.leaf {
+		return batch.AllocatePage(BTreeLeafType)
+	}
+	return batch.AllocatePage(BTreeInternalType)
+}
+
+// insertAt inserts an element into a slice at the given index
+func insertAt[T any](s []T, index int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[index+1:], s[index:])
+	s[index] = v
+	return s
+}
+
+// removeAt removes the element at the given index from a slice
+func removeAt[T any](s []T, index int) []T {
+	return append(s[:index], s[index+1:]...)
 }
 
 // Close closes the disk B+Tree and flushes any pending changes
 func (dbt *DiskBTree) Close() error {
-	// In a full implementation, we'd flush the cache
-	dbt.cache = make(map[PageID]*btree.Node)
+	dbt.mu.Lock()
+	defer dbt.mu.Unlock()
+	
+	// Every change is written through its batch, so there is nothing to
+	// flush; just drop the cache
+	dbt.cache = make(map[PageID]*diskNode)
 	return nil
-}
\ No newline at end of file
+}
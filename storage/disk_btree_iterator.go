@@ -1,9 +1,17 @@
 package storage
 
-import "github.com/JoshuaLim25/db/btree"
+import (
+	"context"
+	"math"
+	
+	"github.com/JoshuaLim25/db/btree"
+)
 
-// DiskBTreeIterator implements the Iterator interface for disk-based B+Tree
+// DiskBTreeIterator implements the Iterator interface for disk-based B+Tree.
+// It walks the leaves through their next pointers, skipping empty leaves,
+// and stops once its context is done.
 type DiskBTreeIterator struct {
+	ctx     context.Context
 	dbt     *DiskBTree
 	current PageID
 	index   int
@@ -11,44 +19,125 @@ type DiskBTreeIterator struct {
 
 // Next returns the next key-value pair
 func (it *DiskBTreeIterator) Next() (key, val []byte) {
-	if it.current == InvalidPageID {
-		return nil, nil
-	}
+	it.dbt.mu.Lock()
+	defer it.dbt.mu.Unlock()
 	
-	node, err := it.dbt.loadNode(it.current)
-	if err != nil || it.index >= node.NumKeys {
+	node := it.position()
+	if node == nil {
 		return nil, nil
 	}
 	
-	key = node.KeyAt(it.index)
-	val = node.ValueAt(it.index)
+	key = node.keys[it.index]
+	val = node.vals[it.index]
 	
 	// Advance to next position
 	it.index++
-	
-	// If we've reached the end of this leaf, we'd move to next leaf
-	// For now, we'll just stop (simplified implementation)
-	if it.index >= node.NumKeys {
-		it.current = InvalidPageID
-	}
-	
 	return key, val
 }
 
 // ContainsNext returns true if there are more key-value pairs
 func (it *DiskBTreeIterator) ContainsNext() bool {
-	if it.current == InvalidPageID {
-		return false
+	it.dbt.mu.Lock()
+	defer it.dbt.mu.Unlock()
+	
+	return it.position() != nil
+}
+
+// position moves the iterator onto the next leaf that still has keys
+// and returns that leaf, or nil at the end of the tree or once the context
+// is done
+func (it *DiskBTreeIterator) position() *diskNode {
+	for it.current != InvalidPageID {
+		if it.ctx.Err() != nil {
+			it.current = InvalidPageID
+			return nil
+		}
+		node, err := it.dbt.loadNode(nil, it.current)
+		if err != nil || !node.leaf {
+			it.current = InvalidPageID
+			return nil
+		}
+		
+		if it.index < len(node.keys) {
+			return node
+		}
+		
+		// We've reached the end of this leaf, move to the next one
+		it.current = node.next
+		it.index = 0
 	}
+	return nil
+}
+
+// DiskBTreeReverseIterator walks the keys of a disk-based B+Tree from the
+// largest down, following the leaves' prev pointers and skipping empty
+// leaves. It stops once its context is done.
+type DiskBTreeReverseIterator struct {
+	ctx     context.Context
+	dbt     *DiskBTree
+	current PageID
+	index   int // the next key to return, -1 past the start of the leaf
+}
+
+// Next returns the previous key-value pair
+func (it *DiskBTreeReverseIterator) Next() (key, val []byte) {
+	it.dbt.mu.Lock()
+	defer it.dbt.mu.Unlock()
 	
-	node, err := it.dbt.loadNode(it.current)
-	if err != nil {
-		return false
+	node := it.position()
+	if node == nil {
+		return nil, nil
 	}
 	
-	// Check if we have more keys in current leaf
-	return it.index < node.NumKeys
+	key = node.keys[it.index]
+	val = node.vals[it.index]
+	
+	// Move back to the previous position
+	it.index--
+	return key, val
+}
+
+// ContainsNext returns true if there are more key-value pairs
+func (it *DiskBTreeReverseIterator) ContainsNext() bool {
+	it.dbt.mu.Lock()
+	defer it.dbt.mu.Unlock()
+	
+	return it.position() != nil
+}
+
+// position moves the iterator onto the previous leaf that still has keys
+// and returns that leaf, or nil at the start of the tree or once the
+// context is done
+func (it *DiskBTreeReverseIterator) position() *diskNode {
+	for it.current != InvalidPageID {
+		if it.ctx.Err() != nil {
+			it.current = InvalidPageID
+			return nil
+		}
+		node, err := it.dbt.loadNode(nil, it.current)
+		if err != nil || !node.leaf {
+			it.current = InvalidPageID
+			return nil
+		}
+		
+		// Start from the last key of the leaf, which may also have lost
+		// keys since the iterator was placed on it
+		if it.index >= len(node.keys) {
+			it.index = len(node.keys) - 1
+		}
+		if it.index >= 0 {
+			return node
+		}
+		
+		// We've reached the start of this leaf, move to the previous one
+		it.current = node.prev
+		it.index = math.MaxInt
+	}
+	return nil
 }
 
-// Ensure DiskBTreeIterator implements the Iterator interface
-var _ btree.Iterator = (*DiskBTreeIterator)(nil)
\ No newline at end of file
+// Ensure the iterators implement the Iterator interface
+var (
+	_ btree.Iterator = (*DiskBTreeIterator)(nil)
+	_ btree.Iterator = (*DiskBTreeReverseIterator)(nil)
+)
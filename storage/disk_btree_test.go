@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"testing"
 	
@@ -193,4 +195,178 @@ func TestDiskBTreeIterator(t *testing.T) {
 	// The exact behavior depends on our simplified implementation
 	hasNext := iter.ContainsNext()
 	assert.IsType(t, bool(false), hasNext, "ContainsNext should return a boolean")
-}
\ No newline at end of file
+}
+
+func TestDiskBTreeSplitsAndReopens(t *testing.T) {
+	tempFile := "test_disk_btree_splits.dat"
+	defer os.Remove(tempFile)
+
+	pm, err := NewPageManager(tempFile)
+	require.NoError(t, err)
+
+	dbt, err := NewDiskBTree(pm)
+	require.NoError(t, err)
+	rootID := dbt.RootID()
+	height, err := dbt.Height()
+	require.NoError(t, err)
+	assert.Equal(t, 1, height)
+
+	// Enough keys to split leaves and internal nodes several times
+	const n = 5000
+	batch := pm.NewBatch()
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%05d", (i*7919)%n))
+		require.NoError(t, dbt.Put(batch, key, []byte(fmt.Sprintf("value%d", i))))
+	}
+	require.NoError(t, batch.Commit())
+	assert.Equal(t, rootID, dbt.RootID(), "the root page should never move")
+
+	count, err := dbt.Count()
+	require.NoError(t, err)
+	assert.Equal(t, n, count)
+	height, err = dbt.Height()
+	require.NoError(t, err)
+	assert.Greater(t, height, 1, "the root should have split")
+
+	// Remove every other key
+	for i := 0; i < n; i += 2 {
+		removed, err := dbt.Remove(nil, []byte(fmt.Sprintf("key%05d", i)))
+		require.NoError(t, err)
+		assert.True(t, removed)
+	}
+	require.NoError(t, dbt.Close())
+	require.NoError(t, pm.Close())
+
+	// Reopen from the root page and walk every leaf in order
+	pm, err = NewPageManager(tempFile)
+	require.NoError(t, err)
+	defer pm.Close()
+
+	dbt, err = OpenDiskBTree(pm, rootID)
+	require.NoError(t, err)
+
+	count, err = dbt.Count()
+	require.NoError(t, err)
+	assert.Equal(t, n/2, count)
+
+	iter := dbt.FindLarger([]byte(""))
+	expected := 1
+	for iter.ContainsNext() {
+		key, _ := iter.Next()
+		assert.Equal(t, fmt.Sprintf("key%05d", expected), string(key))
+		expected += 2
+	}
+	assert.Equal(t, n+1, expected, "iterator should visit every remaining key")
+
+	iter = dbt.FindLarger([]byte("key04990"))
+	var keys []string
+	for iter.ContainsNext() {
+		key, _ := iter.Next()
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"key04991", "key04993", "key04995", "key04997", "key04999"}, keys)
+
+	// Walk the leaves backwards through their prev pointers
+	iter = dbt.FindSmaller(nil)
+	expected = n - 1
+	for iter.ContainsNext() {
+		key, _ := iter.Next()
+		assert.Equal(t, fmt.Sprintf("key%05d", expected), string(key))
+		expected -= 2
+	}
+	assert.Equal(t, -1, expected, "reverse iterator should visit every remaining key")
+
+	iter = dbt.FindSmaller([]byte("key00008"))
+	keys = nil
+	for iter.ContainsNext() {
+		key, _ := iter.Next()
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"key00007", "key00005", "key00003", "key00001"}, keys)
+
+	iter = dbt.FindSmaller([]byte("key00001"))
+	assert.False(t, iter.ContainsNext())
+}
+
+func TestDiskBTreeRollback(t *testing.T) {
+	tempFile := "test_disk_btree_rollback.dat"
+	defer os.Remove(tempFile)
+
+	pm, err := NewPageManager(tempFile)
+	require.NoError(t, err)
+	defer pm.Close()
+
+	dbt, err := NewDiskBTree(pm)
+	require.NoError(t, err)
+	dbt.Set([]byte("kept"), []byte("1"))
+
+	batch := pm.NewBatch()
+	for i := 0; i < 500; i++ {
+		require.NoError(t, dbt.Put(batch, []byte(fmt.Sprintf("key%03d", i)), make([]byte, 100)))
+	}
+	batch.Abort()
+	dbt.Rollback(batch)
+
+	_, ok := dbt.Get([]byte("key000"))
+	assert.False(t, ok, "aborted writes should not be visible")
+	val, ok := dbt.Get([]byte("kept"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), val)
+
+	count, err := dbt.Count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Greater(t, pm.FreePageCount(), 0, "pages allocated by the aborted batch should be reusable")
+
+	// Entries that cannot fit in a page are rejected
+	err = dbt.Put(nil, []byte("big"), make([]byte, PageSize))
+	assert.ErrorIs(t, err, ErrEntryTooLarge)
+}
+
+func TestDiskBTreeIteratorStopsWhenCanceled(t *testing.T) {
+	tempFile := "test_disk_btree_cancel.dat"
+	defer os.Remove(tempFile)
+
+	pm, err := NewPageManager(tempFile)
+	require.NoError(t, err)
+	defer pm.Close()
+
+	dbt, err := NewDiskBTree(pm)
+	require.NoError(t, err)
+
+	// Only the first and last keys remain, with empty leaves between them
+	const n = 2000
+	batch := pm.NewBatch()
+	for i := 0; i < n; i++ {
+		require.NoError(t, dbt.Put(batch, []byte(fmt.Sprintf("key%05d", i)), []byte("value")))
+	}
+	require.NoError(t, batch.Commit())
+	for i := 1; i < n-1; i++ {
+		_, err := dbt.Remove(nil, []byte(fmt.Sprintf("key%05d", i)))
+		require.NoError(t, err)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		iter := dbt.FindLargerContext(ctx, nil)
+		if reverse {
+			iter = dbt.FindSmallerContext(ctx, nil)
+		}
+		require.True(t, iter.ContainsNext())
+		key, _ := iter.Next()
+		assert.NotNil(t, key)
+
+		// Once canceled, the iterator reads no further pages
+		cancel()
+		assert.False(t, iter.ContainsNext(), "reverse: %v", reverse)
+		key, _ = iter.Next()
+		assert.Nil(t, key)
+	}
+
+	var keys []string
+	for iter := dbt.FindLargerContext(context.Background(), nil); iter.ContainsNext(); {
+		key, _ := iter.Next()
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"key00000", fmt.Sprintf("key%05d", n-1)}, keys)
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// nodeCapacity is the space a serialized node may use in a page
+	nodeCapacity = PageSize - PageHeaderSize
+
+	// nodeOverhead is the node type (1) + key count (4) + trailing page
+	// pointer (4) that every serialized node carries
+	nodeOverhead = 1 + 4 + 4
+
+	// MaxEntrySize is the largest key plus value, including their length
+	// prefixes, that fits in a tree. Keeping entries under a third of a
+	// page guarantees that an overfull node can always be split in two.
+	MaxEntrySize = (nodeCapacity - nodeOverhead) / 3
+)
+
+// diskNode is a B+Tree node as stored in one page. It uses the layout of
+// SerializeNode, with real page IDs for the child and next-leaf pointers;
+// the previous-leaf pointer lives in the page header.
+type diskNode struct {
+	id       PageID
+	leaf     bool
+	keys     [][]byte
+	vals     [][]byte // leaf nodes only
+	children []PageID // internal nodes only, len(keys)+1 entries
+	next     PageID   // leaf nodes only
+	prev     PageID   // leaf nodes only
+}
+
+// newLeafDiskNode creates an empty, unlinked leaf
+func newLeafDiskNode(id PageID) *diskNode {
+	return &diskNode{id: id, leaf: true, next: InvalidPageID, prev: InvalidPageID}
+}
+
+// entrySize returns the serialized size of the entry at index i
+func (n *diskNode) entrySize(i int) int {
+	if n.leaf {
+		return 8 + len(n.keys[i]) + len(n.vals[i])
+	}
+	return 8 + len(n.keys[i]) // key length + key + child pointer
+}
+
+// size returns the serialized size of the node
+func (n *diskNode) size() int {
+	size := nodeOverhead
+	for i := range n.keys {
+		size += n.entrySize(i)
+	}
+	return size
+}
+
+// search returns the index of the first key >= key and whether it is equal
+func (n *diskNode) search(key []byte) (int, bool) {
+	lo, hi := 0, len(n.keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if bytes.Compare(n.keys[mid], key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(n.keys) && bytes.Equal(n.keys[lo], key)
+}
+
+// childIndex returns which child of an internal node covers the key
+func (n *diskNode) childIndex(key []byte) int {
+	lo, hi := 0, len(n.keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if bytes.Compare(key, n.keys[mid]) < 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
+
+// toPage serializes the node into a page
+func (n *diskNode) toPage() (*Page, error) {
+	buf := make([]byte, 0, n.size())
+
+	var nodeType byte
+	pageType := BTreeInternalType
+	if n.leaf {
+		nodeType = 1
+		pageType = BTreeLeafType
+	}
+	buf = append(buf, nodeType)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(n.keys)))
+
+	for i, key := range n.keys {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+		buf = append(buf, key...)
+		if n.leaf {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(n.vals[i])))
+			buf = append(buf, n.vals[i]...)
+		}
+	}
+
+	if n.leaf {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(n.next))
+	} else {
+		for _, child := range n.children {
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(child))
+		}
+	}
+
+	page := NewPage(n.id, pageType)
+	if err := page.SetData(buf); err != nil {
+		return nil, fmt.Errorf("node %d: %w", n.id, err)
+	}
+	if n.leaf {
+		page.Header.NextPage = n.next
+		page.Header.PrevPage = n.prev
+	}
+	return page, nil
+}
+
+// diskNodeFromPage deserializes a node stored in a page
+func diskNodeFromPage(page *Page) (*diskNode, error) {
+	data := page.GetData()
+	if len(data) < 5 {
+		return nil, fmt.Errorf("page %d does not hold a B+Tree node", page.ID)
+	}
+
+	n := &diskNode{id: page.ID, leaf: data[0] == 1, next: InvalidPageID, prev: InvalidPageID}
+	numKeys := int(binary.LittleEndian.Uint32(data[1:5]))
+	offset := 5
+
+	readBytes := func() ([]byte, error) {
+		if offset+4 > len(data) {
+			return nil, fmt.Errorf("page %d: truncated node", page.ID)
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("page %d: truncated node", page.ID)
+		}
+		b := make([]byte, length)
+		copy(b, data[offset:offset+length])
+		offset += length
+		return b, nil
+	}
+
+	n.keys = make([][]byte, numKeys)
+	if n.leaf {
+		n.vals = make([][]byte, numKeys)
+	}
+	for i := 0; i < numKeys; i++ {
+		key, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		n.keys[i] = key
+
+		if n.leaf {
+			val, err := readBytes()
+			if err != nil {
+				return nil, err
+			}
+			n.vals[i] = val
+		}
+	}
+
+	pointers := 1
+	if !n.leaf {
+		pointers = numKeys + 1
+	}
+	if offset+4*pointers > len(data) {
+		return nil, fmt.Errorf("page %d: truncated node", page.ID)
+	}
+
+	if n.leaf {
+		n.next = PageID(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		n.prev = page.Header.PrevPage
+	} else {
+		n.children = make([]PageID, pointers)
+		for i := range n.children {
+			n.children[i] = PageID(binary.LittleEndian.Uint32(data[offset : offset+4]))
+			offset += 4
+		}
+	}
+
+	return n, nil
+}
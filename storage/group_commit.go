@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// pageWrite is a serialized page waiting to be applied to the data file
+type pageWrite struct {
+	id   PageID
+	data []byte
+	lsn  uint64
+}
+
+// groupCommitter batches commits from concurrent writers into a single
+// log write and fsync. Committers append their commit group to a shared
+// buffer and wait; whichever of them finds no flush in progress becomes
+// the leader, optionally lingers for up to maxWait so more committers can
+// join, and then flushes the whole buffer on behalf of the group.
+type groupCommitter struct {
+	pm      *PageManager
+	wal     *wal
+	maxWait time.Duration
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	buf        []byte      // encoded commit groups not yet in the log
+	writes     []pageWrite // page images to apply once buf is durable
+	nextLSN    uint64      // LSN of the last appended commit group
+	flushedLSN uint64      // LSN of the last durable commit group
+	flushing   bool
+	err        error // sticky: a failed log write poisons the log
+	syncs      int   // number of log fsyncs, for tests and benchmarks
+}
+
+// newGroupCommitter creates a group committer writing to the given log
+func newGroupCommitter(pm *PageManager, w *wal, maxWait time.Duration) *groupCommitter {
+	gc := &groupCommitter{pm: pm, wal: w, maxWait: maxWait}
+	gc.cond = sync.NewCond(&gc.mu)
+	return gc
+}
+
+// append adds a commit group to the shared buffer and returns its LSN.
+// The commit is durable once wait(lsn) returns without error.
+func (gc *groupCommitter) append(pages []walPage) uint64 {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	gc.nextLSN++
+	gc.buf = append(gc.buf, encodeWALGroup(pages)...)
+	for _, p := range pages {
+		gc.writes = append(gc.writes, pageWrite{id: p.id, data: p.data, lsn: gc.nextLSN})
+	}
+	return gc.nextLSN
+}
+
+// wait blocks until the commit group with the given LSN is durable,
+// leading a flush if nobody else is
+func (gc *groupCommitter) wait(lsn uint64) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	for gc.flushedLSN < lsn {
+		if gc.err != nil {
+			return gc.err
+		}
+		if gc.flushing {
+			gc.cond.Wait()
+			continue
+		}
+
+		gc.flushing = true
+		if gc.maxWait > 0 {
+			gc.mu.Unlock()
+			time.Sleep(gc.maxWait)
+			gc.mu.Lock()
+		}
+
+		buf, writes, upTo := gc.buf, gc.writes, gc.nextLSN
+		gc.buf, gc.writes = nil, nil
+		gc.mu.Unlock()
+
+		err := gc.flush(buf, writes)
+
+		gc.mu.Lock()
+		gc.flushing = false
+		if err != nil {
+			gc.err = err
+		} else {
+			gc.flushedLSN = upTo
+			gc.syncs++
+		}
+		gc.cond.Broadcast()
+	}
+
+	return nil
+}
+
+// flush makes the buffered commit groups durable in the log and then
+// applies their pages to the data file in commit order
+func (gc *groupCommitter) flush(buf []byte, writes []pageWrite) error {
+	if err := gc.wal.append(buf); err != nil {
+		return err
+	}
+
+	for _, w := range writes {
+		if err := gc.pm.applyPage(w); err != nil {
+			return err
+		}
+	}
+
+	if gc.wal.size >= walCheckpointSize {
+		return gc.pm.checkpoint()
+	}
+	return nil
+}
+
+// waitAll blocks until every appended commit group is durable
+func (gc *groupCommitter) waitAll() error {
+	gc.mu.Lock()
+	lsn := gc.nextLSN
+	gc.mu.Unlock()
+
+	return gc.wait(lsn)
+}
+
+// syncCount returns how many log fsyncs have been performed
+func (gc *groupCommitter) syncCount() int {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	return gc.syncs
+}
+
+// checkpoint runs a log checkpoint while no flush is in progress
+func (gc *groupCommitter) checkpoint() error {
+	gc.mu.Lock()
+	for gc.flushing {
+		gc.cond.Wait()
+	}
+	gc.flushing = true
+	gc.mu.Unlock()
+
+	err := gc.pm.checkpoint()
+
+	gc.mu.Lock()
+	gc.flushing = false
+	gc.cond.Broadcast()
+	gc.mu.Unlock()
+
+	return err
+}
@@ -1,30 +1,80 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"sync"
+	"time"
 )
 
-// PageManager manages disk pages for the database
+var (
+	// ErrDatabaseLocked is returned when another process holds a conflicting
+	// lock on the database file
+	ErrDatabaseLocked = errors.New("database is locked")
+	
+	// ErrReadOnly is returned by mutating calls on a read-only page manager
+	ErrReadOnly = errors.New("database is read-only")
+)
+
+// Options configures how a database file is opened
+type Options struct {
+	// ReadOnly opens the file without write access and takes a shared lock,
+	// so several readers can open the same file while no writer holds it
+	ReadOnly bool
+	
+	// CommitMaxWait is how long the leader of a group commit waits for more
+	// concurrent committers to join before it fsyncs. Zero flushes as soon
+	// as the previous fsync finishes, which already batches every commit
+	// that arrived in the meantime.
+	CommitMaxWait time.Duration
+}
+
+// PageManager manages disk pages for the database. Page writes go through
+// a write-ahead log with group commit: a write is durable once its log
+// record is fsynced, and concurrent writers share fsyncs.
 type PageManager struct {
 	file     *os.File
 	mu       sync.RWMutex
 	nextPage PageID
 	freeList []PageID // Simple free list for deallocated pages
+	readOnly bool
+	
+	wal     *wal
+	commits *groupCommitter
+	pending map[PageID]pageWrite // committed pages not yet in the data file
 }
 
 // NewPageManager creates a new page manager for the given database file
 func NewPageManager(filename string) (*PageManager, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	return NewPageManagerWithOptions(filename, Options{})
+}
+
+// NewPageManagerWithOptions opens the database file with the given options.
+// Writers take an exclusive advisory lock on the file and readers a shared
+// one; a conflicting open fails with ErrDatabaseLocked.
+func NewPageManagerWithOptions(filename string, opts Options) (*PageManager, error) {
+	flags := os.O_CREATE | os.O_RDWR
+	if opts.ReadOnly {
+		flags = os.O_RDONLY
+	}
+	
+	file, err := os.OpenFile(filename, flags, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database file: %w", err)
 	}
 	
+	if err := lockFile(file, !opts.ReadOnly); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock database file %s: %w", filename, err)
+	}
+	
 	pm := &PageManager{
 		file:     file,
 		nextPage: 1, // Page 0 is reserved for metadata
 		freeList: make([]PageID, 0),
+		readOnly: opts.ReadOnly,
+		pending:  make(map[PageID]pageWrite),
 	}
 	
 	// Initialize database if it's new (empty file)
@@ -33,60 +83,171 @@ func NewPageManager(filename string) (*PageManager, error) {
 		return nil, err
 	}
 	
+	pm.wal, err = openWAL(filename, opts.ReadOnly)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	
+	// Replay anything a crash left in the log
+	if err := pm.recover(); err != nil {
+		if pm.wal != nil {
+			pm.wal.file.Close()
+		}
+		file.Close()
+		return nil, err
+	}
+	
+	if !opts.ReadOnly {
+		if err := pm.loadFreeList(); err != nil {
+			pm.wal.file.Close()
+			file.Close()
+			return nil, err
+		}
+		pm.commits = newGroupCommitter(pm, pm.wal, opts.CommitMaxWait)
+	}
+	
 	return pm, nil
 }
 
-// Close closes the page manager and underlying file
+// ReadOnly reports whether the page manager was opened in read-only mode
+func (pm *PageManager) ReadOnly() bool {
+	return pm.readOnly
+}
+
+// Close checkpoints the log and closes the page manager and underlying file
 func (pm *PageManager) Close() error {
+	var err error
+	if pm.commits != nil && pm.file != nil {
+		err = pm.Sync()
+	}
+	
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	
+	if pm.wal != nil {
+		if walErr := pm.wal.close(); err == nil {
+			err = walErr
+		}
+		pm.wal = nil
+	}
+	
 	if pm.file != nil {
-		err := pm.file.Close()
+		unlockFile(pm.file)
+		if closeErr := pm.file.Close(); err == nil {
+			err = closeErr
+		}
 		pm.file = nil
-		return err
 	}
-	return nil
+	return err
 }
 
 // AllocatePage allocates a new page and returns its ID
 func (pm *PageManager) AllocatePage(pageType PageType) (PageID, error) {
+	// Create and write an empty page
+	batch := pm.NewBatch()
+	pageID, err := batch.AllocatePage(pageType)
+	if err != nil {
+		return InvalidPageID, err
+	}
+	
+	if err := batch.Commit(); err != nil {
+		return InvalidPageID, err
+	}
+	return pageID, nil
+}
+
+// DeallocatePage marks a page as free for reuse
+func (pm *PageManager) DeallocatePage(pageID PageID) error {
+	if pm.readOnly {
+		return ErrReadOnly
+	}
+	
+	// Mark page as free
+	batch := pm.NewBatch()
+	batch.DeallocatePage(pageID)
+	return batch.Commit()
+}
+
+// reservePage picks the page ID for a new page
+func (pm *PageManager) reservePage() (PageID, error) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	
-	var pageID PageID
+	if pm.readOnly {
+		return InvalidPageID, ErrReadOnly
+	}
 	
 	// Try to reuse a page from the free list first
 	if len(pm.freeList) > 0 {
-		pageID = pm.freeList[len(pm.freeList)-1]
+		pageID := pm.freeList[len(pm.freeList)-1]
 		pm.freeList = pm.freeList[:len(pm.freeList)-1]
-	} else {
-		// Allocate a new page at the end of file
-		pageID = pm.nextPage
-		pm.nextPage++
+		return pageID, nil
 	}
 	
-	// Create and write an empty page
-	page := NewPage(pageID, pageType)
-	return pageID, pm.writePageLocked(page)
+	// Allocate a new page at the end of file
+	if pm.nextPage > MaxPageID {
+		return InvalidPageID, fmt.Errorf("database file is full")
+	}
+	pageID := pm.nextPage
+	pm.nextPage++
+	return pageID, nil
 }
 
-// DeallocatePage marks a page as free for reuse
-func (pm *PageManager) DeallocatePage(pageID PageID) error {
+// releasePages puts pages back on the free list
+func (pm *PageManager) releasePages(pageIDs []PageID) {
+	if len(pageIDs) == 0 {
+		return
+	}
+	
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	
-	// Mark page as free
-	page := NewPage(pageID, FreePageType)
-	if err := pm.writePageLocked(page); err != nil {
+	pm.freeList = append(pm.freeList, pageIDs...)
+}
+
+// loadFreeList rebuilds the allocation state of an existing file: new pages
+// go after the last page on disk, and freed pages are found by their type
+func (pm *PageManager) loadFreeList() error {
+	stat, err := pm.file.Stat()
+	if err != nil {
 		return err
 	}
 	
-	// Add to free list
-	pm.freeList = append(pm.freeList, pageID)
+	numPages := PageID(stat.Size() / PageSize)
+	if numPages > 1 {
+		pm.nextPage = numPages
+	}
+	
+	header := make([]byte, 1)
+	for id := PageID(1); id < numPages; id++ {
+		if _, err := pm.file.ReadAt(header, int64(id)*PageSize); err != nil {
+			return fmt.Errorf("failed to read page %d: %w", id, err)
+		}
+		if PageType(header[0]) == FreePageType {
+			pm.freeList = append(pm.freeList, id)
+		}
+	}
 	return nil
 }
 
+// FreePageCount returns the number of pages waiting to be reused
+func (pm *PageManager) FreePageCount() int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	
+	return len(pm.freeList)
+}
+
+// PageCount returns the number of pages in the file, including the
+// metadata page and free pages
+func (pm *PageManager) PageCount() int {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	
+	return int(pm.nextPage)
+}
+
 // ReadPage reads a page from disk
 func (pm *PageManager) ReadPage(pageID PageID) (*Page, error) {
 	pm.mu.RLock()
@@ -95,12 +256,76 @@ func (pm *PageManager) ReadPage(pageID PageID) (*Page, error) {
 	return pm.readPageLocked(pageID)
 }
 
-// WritePage writes a page to disk
+// WritePage durably writes a page. It returns once the page is in the
+// write-ahead log on disk; concurrent writers share a single fsync.
 func (pm *PageManager) WritePage(page *Page) error {
+	if pm.readOnly {
+		return ErrReadOnly
+	}
+	
+	lsn, err := pm.publishPages(page)
+	if err != nil {
+		return err
+	}
+	return pm.commits.wait(lsn)
+}
+
+// ReadMeta returns the contents of the metadata page
+func (pm *PageManager) ReadMeta() ([]byte, error) {
+	page, err := pm.ReadPage(0)
+	if err != nil {
+		return nil, err
+	}
+	return page.GetData(), nil
+}
+
+// publishPages makes pages visible to readers and queues them for the
+// write-ahead log as one atomic unit: after a crash either all of them are
+// visible or none are. They are durable once commits.wait returns for the
+// LSN it gives back.
+func (pm *PageManager) publishPages(pages ...*Page) (uint64, error) {
+	images := make([]walPage, 0, len(pages))
+	for _, page := range pages {
+		if page.ID == InvalidPageID {
+			return 0, fmt.Errorf("invalid page ID for write: %d", page.ID)
+		}
+		
+		// Update checksum before writing
+		page.updateChecksum()
+		images = append(images, walPage{id: page.ID, data: page.Serialize()})
+	}
+	
+	pm.mu.Lock()
+	if pm.file == nil {
+		pm.mu.Unlock()
+		return 0, fmt.Errorf("page manager is closed")
+	}
+	
+	// Make the pages visible to readers right away; they reach the data
+	// file once the group they belong to is durable
+	lsn := pm.commits.append(images)
+	for _, img := range images {
+		pm.pending[img.id] = pageWrite{id: img.id, data: img.data, lsn: lsn}
+	}
+	pm.mu.Unlock()
+	
+	return lsn, nil
+}
+
+// applyPage copies a durable page image into the data file
+func (pm *PageManager) applyPage(w pageWrite) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	
-	return pm.writePageLocked(page)
+	if err := pm.writeAt(w.id, w.data); err != nil {
+		return err
+	}
+	
+	// A later commit may have replaced the page in the meantime
+	if pending, ok := pm.pending[w.id]; ok && pending.lsn == w.lsn {
+		delete(pm.pending, w.id)
+	}
+	return nil
 }
 
 // readPageLocked reads a page while holding the lock
@@ -109,6 +334,14 @@ func (pm *PageManager) readPageLocked(pageID PageID) (*Page, error) {
 		return nil, fmt.Errorf("invalid page ID for read: %d", pageID)
 	}
 	
+	if w, ok := pm.pending[pageID]; ok {
+		page := &Page{ID: pageID}
+		if err := page.Deserialize(w.data); err != nil {
+			return nil, fmt.Errorf("failed to deserialize page %d: %w", pageID, err)
+		}
+		return page, nil
+	}
+	
 	offset := int64(pageID) * PageSize
 	
 	buf := make([]byte, PageSize)
@@ -137,19 +370,69 @@ func (pm *PageManager) writePageLocked(page *Page) error {
 	// Update checksum before writing
 	page.updateChecksum()
 	
-	offset := int64(page.ID) * PageSize
-	buf := page.Serialize()
+	if err := pm.writeAt(page.ID, page.Serialize()); err != nil {
+		return err
+	}
+	
+	// Ensure data is written to disk
+	return pm.file.Sync()
+}
+
+// writeAt writes a serialized page to its slot in the data file
+func (pm *PageManager) writeAt(pageID PageID, buf []byte) error {
+	offset := int64(pageID) * PageSize
 	
 	n, err := pm.file.WriteAt(buf, offset)
 	if err != nil {
-		return fmt.Errorf("failed to write page %d: %w", page.ID, err)
+		return fmt.Errorf("failed to write page %d: %w", pageID, err)
 	}
 	if n != PageSize {
 		return fmt.Errorf("incomplete page write: wrote %d bytes, expected %d", n, PageSize)
 	}
+	return nil
+}
+	
+// recover replays the commit groups a crash left in the log. Writers copy
+// them into the data file; readers cannot, so they keep them in memory and
+// still see every committed page.
+func (pm *PageManager) recover() error {
+	if pm.wal == nil {
+		return nil
+	}
 	
-	// Ensure data is written to disk
-	return pm.file.Sync()
+	groups, err := pm.wal.readGroups()
+	if err != nil {
+		return err
+	}
+	
+	for _, group := range groups {
+		for _, p := range group {
+			if pm.readOnly {
+				pm.pending[p.id] = pageWrite{id: p.id, data: p.data}
+				continue
+			}
+			if err := pm.writeAt(p.id, p.data); err != nil {
+				return err
+			}
+		}
+	}
+	
+	if pm.readOnly {
+		return nil
+	}
+	return pm.checkpoint()
+}
+
+// checkpoint forces every applied page into the data file and empties the
+// log. Callers must make sure no flush is running concurrently.
+func (pm *PageManager) checkpoint() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	
+	if err := pm.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync database file: %w", err)
+	}
+	return pm.wal.reset()
 }
 
 // initializeIfEmpty initializes an empty database file with metadata
@@ -161,6 +444,10 @@ func (pm *PageManager) initializeIfEmpty() error {
 	
 	// If file is empty, initialize with metadata page
 	if stat.Size() == 0 {
+		if pm.readOnly {
+			return fmt.Errorf("cannot open empty database file read-only")
+		}
+		
 		metaPage := NewPage(0, MetaPageType)
 		metaData := []byte("SIMPLEDB_V1") // Simple magic header
 		if err := metaPage.SetData(metaData); err != nil {
@@ -173,10 +460,14 @@ func (pm *PageManager) initializeIfEmpty() error {
 	return nil
 }
 
-// Sync forces any pending writes to disk
+// Sync forces any pending writes into the data file and checkpoints the log
 func (pm *PageManager) Sync() error {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	if pm.readOnly {
+		return nil
+	}
 	
-	return pm.file.Sync()
+	if err := pm.commits.waitAll(); err != nil {
+		return err
+	}
+	return pm.commits.checkpoint()
 }
\ No newline at end of file
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageManagerExclusiveLock(t *testing.T) {
+	tempFile := "test_pm_lock.dat"
+	defer os.Remove(tempFile)
+
+	pm, err := NewPageManager(tempFile)
+	require.NoError(t, err)
+
+	// A second writer must not be able to open the same file
+	_, err = NewPageManager(tempFile)
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+
+	// Readers are excluded while a writer holds the file too
+	_, err = NewPageManagerWithOptions(tempFile, Options{ReadOnly: true})
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+
+	// Closing releases the lock
+	require.NoError(t, pm.Close())
+	pm, err = NewPageManager(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, pm.Close())
+}
+
+func TestPageManagerReadOnly(t *testing.T) {
+	tempFile := "test_pm_readonly.dat"
+	defer os.Remove(tempFile)
+
+	// Create the file with one page of data
+	pm, err := NewPageManager(tempFile)
+	require.NoError(t, err)
+	pageID, err := pm.AllocatePage(BTreeLeafType)
+	require.NoError(t, err)
+	page := NewPage(pageID, BTreeLeafType)
+	require.NoError(t, page.SetData([]byte("hello")))
+	require.NoError(t, pm.WritePage(page))
+	require.NoError(t, pm.Close())
+
+	// Several readers can share the file
+	r1, err := NewPageManagerWithOptions(tempFile, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer r1.Close()
+	r2, err := NewPageManagerWithOptions(tempFile, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer r2.Close()
+	assert.True(t, r1.ReadOnly())
+
+	read, err := r1.ReadPage(pageID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), read.GetData())
+
+	// Every mutating call is rejected
+	_, err = r1.AllocatePage(BTreeLeafType)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, r1.WritePage(page), ErrReadOnly)
+	assert.ErrorIs(t, r1.DeallocatePage(pageID), ErrReadOnly)
+
+	// A writer cannot open the file while readers hold it
+	_, err = NewPageManager(tempFile)
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+}
+
+func TestPageManagerReadOnlyMissingFile(t *testing.T) {
+	_, err := NewPageManagerWithOptions("test_pm_missing.dat", Options{ReadOnly: true})
+	assert.Error(t, err)
+	_, statErr := os.Stat("test_pm_missing.dat")
+	assert.True(t, os.IsNotExist(statErr), "read-only open must not create the file")
+}
+
+func TestPageManagerGroupCommit(t *testing.T) {
+	tempFile := "test_pm_group_commit.dat"
+	defer os.Remove(tempFile)
+
+	pm, err := NewPageManagerWithOptions(tempFile, Options{CommitMaxWait: time.Millisecond})
+	require.NoError(t, err)
+	defer pm.Close()
+
+	const writers = 32
+	const writesPerWriter = 10
+
+	pageIDs := make([]PageID, writers)
+	for i := range pageIDs {
+		pageIDs[i], err = pm.AllocatePage(BTreeLeafType)
+		require.NoError(t, err)
+	}
+	syncsBefore := pm.commits.syncCount()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*writesPerWriter)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(id PageID) {
+			defer wg.Done()
+			for j := 0; j < writesPerWriter; j++ {
+				page := NewPage(id, BTreeLeafType)
+				page.SetData([]byte(fmt.Sprintf("page %d write %d", id, j)))
+				errs <- pm.WritePage(page)
+			}
+		}(pageIDs[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// Concurrent writers share fsyncs
+	syncs := pm.commits.syncCount() - syncsBefore
+	assert.Less(t, syncs, writers*writesPerWriter, "commits should be grouped")
+
+	// Every writer's last write is visible
+	for _, id := range pageIDs {
+		page, err := pm.ReadPage(id)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("page %d write %d", id, writesPerWriter-1), string(page.GetData()))
+	}
+}
+
+func TestPageManagerRecoversCommittedGroups(t *testing.T) {
+	tempFile := "test_pm_recovery.dat"
+	defer os.Remove(tempFile)
+	defer os.Remove(walPath(tempFile))
+
+	pm, err := NewPageManager(tempFile)
+	require.NoError(t, err)
+	first, err := pm.AllocatePage(BTreeLeafType)
+	require.NoError(t, err)
+	second, err := pm.AllocatePage(BTreeLeafType)
+	require.NoError(t, err)
+	require.NoError(t, pm.Close())
+
+	// Simulate a crash: one complete commit group in the log, followed by
+	// a group that was torn halfway through its append
+	image := func(id PageID, data string) walPage {
+		page := NewPage(id, BTreeLeafType)
+		page.SetData([]byte(data))
+		page.updateChecksum()
+		return walPage{id: id, data: page.Serialize()}
+	}
+	committed := encodeWALGroup([]walPage{image(first, "committed")})
+	torn := encodeWALGroup([]walPage{image(first, "torn"), image(second, "torn")})
+	log := append(committed, torn[:len(torn)/2]...)
+	require.NoError(t, os.WriteFile(walPath(tempFile), log, 0644))
+
+	// A reader sees the committed group without touching the files
+	ro, err := NewPageManagerWithOptions(tempFile, Options{ReadOnly: true})
+	require.NoError(t, err)
+	page, err := ro.ReadPage(first)
+	require.NoError(t, err)
+	assert.Equal(t, "committed", string(page.GetData()))
+	require.NoError(t, ro.Close())
+
+	// A writer replays it into the data file and empties the log
+	pm, err = NewPageManager(tempFile)
+	require.NoError(t, err)
+	page, err = pm.ReadPage(first)
+	require.NoError(t, err)
+	assert.Equal(t, "committed", string(page.GetData()))
+	page, err = pm.ReadPage(second)
+	require.NoError(t, err)
+	assert.Empty(t, page.GetData(), "torn group must not be applied")
+	require.NoError(t, pm.Close())
+
+	_, err = os.Stat(walPath(tempFile))
+	assert.True(t, os.IsNotExist(err), "clean close should remove the empty log")
+}
+
+func BenchmarkPageManagerConcurrentWrites(b *testing.B) {
+	tempFile := "bench_pm_writes.dat"
+	defer os.Remove(tempFile)
+
+	pm, err := NewPageManager(tempFile)
+	require.NoError(b, err)
+	defer pm.Close()
+
+	var next atomic.Int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		id, err := pm.AllocatePage(BTreeLeafType)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		page := NewPage(id, BTreeLeafType)
+		for pb.Next() {
+			page.SetData([]byte(fmt.Sprintf("write %d", next.Add(1))))
+			if err := pm.WritePage(page); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "writes/s")
+}
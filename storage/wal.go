@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	// walGroupMagic marks the start of a commit group in the log
+	walGroupMagic uint32 = 0x57414c47 // "WALG"
+
+	// walGroupHeaderSize is magic (4) + page count (4)
+	walGroupHeaderSize = 8
+
+	// walPageRecordSize is page ID (4) + page image
+	walPageRecordSize = 4 + PageSize
+
+	// walCheckpointSize is how large the log may grow before its pages are
+	// forced into the data file and the log is truncated
+	walCheckpointSize = 4 << 20
+)
+
+// walPage is a page image recorded in the log
+type walPage struct {
+	id   PageID
+	data []byte
+}
+
+// wal is the write-ahead log that sits next to the database file. Page
+// images are appended to it in commit groups; a group is applied to the
+// data file only after it is durable in the log, and only complete groups
+// are replayed after a crash, so every group is all-or-nothing.
+type wal struct {
+	file     *os.File
+	path     string
+	size     int64
+	readOnly bool
+}
+
+// walPath returns the path of the log for a database file
+func walPath(filename string) string {
+	return filename + "-wal"
+}
+
+// openWAL opens (or, for writers, creates) the log for a database file.
+// A read-only open of a database without a log returns a nil wal.
+func openWAL(filename string, readOnly bool) (*wal, error) {
+	path := walPath(filename)
+
+	flags := os.O_CREATE | os.O_RDWR
+	if readOnly {
+		flags = os.O_RDONLY
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if readOnly && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &wal{file: file, path: path, size: stat.Size(), readOnly: readOnly}, nil
+}
+
+// encodeWALGroup serializes page images as one commit group
+func encodeWALGroup(pages []walPage) []byte {
+	buf := make([]byte, walGroupHeaderSize, walGroupHeaderSize+len(pages)*walPageRecordSize+4)
+	binary.LittleEndian.PutUint32(buf[0:4], walGroupMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(pages)))
+
+	for _, p := range pages {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(p.id))
+		buf = append(buf, p.data...)
+	}
+
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// readGroups returns the page images of every complete commit group in
+// the log, in order. Reading stops at the first torn or corrupt group,
+// which is what a crash in the middle of an append leaves behind.
+func (w *wal) readGroups() ([][]walPage, error) {
+	var groups [][]walPage
+	var offset int64
+
+	header := make([]byte, walGroupHeaderSize)
+	for {
+		if _, err := w.file.ReadAt(header, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return groups, nil
+			}
+			return nil, fmt.Errorf("failed to read write-ahead log: %w", err)
+		}
+		if binary.LittleEndian.Uint32(header[0:4]) != walGroupMagic {
+			return groups, nil
+		}
+
+		count := int(binary.LittleEndian.Uint32(header[4:8]))
+		groupSize := int64(walGroupHeaderSize) + int64(count)*walPageRecordSize + 4
+		if groupSize > w.size-offset {
+			return groups, nil
+		}
+
+		buf := make([]byte, groupSize)
+		if _, err := w.file.ReadAt(buf, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return groups, nil
+			}
+			return nil, fmt.Errorf("failed to read write-ahead log: %w", err)
+		}
+
+		body := buf[:len(buf)-4]
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+			return groups, nil
+		}
+
+		group := make([]walPage, count)
+		pos := walGroupHeaderSize
+		for i := range group {
+			group[i].id = PageID(binary.LittleEndian.Uint32(body[pos : pos+4]))
+			group[i].data = body[pos+4 : pos+walPageRecordSize]
+			pos += walPageRecordSize
+		}
+		groups = append(groups, group)
+		offset += int64(len(buf))
+	}
+}
+
+// append writes encoded commit groups to the end of the log and makes
+// them durable with a single fsync
+func (w *wal) append(buf []byte) error {
+	n, err := w.file.WriteAt(buf, w.size)
+	if err != nil {
+		return fmt.Errorf("failed to append to write-ahead log: %w", err)
+	}
+	w.size += int64(n)
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// reset empties the log once its contents are safely in the data file
+func (w *wal) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log: %w", err)
+	}
+	w.size = 0
+	return w.file.Sync()
+}
+
+// close closes the log file. A writer removes the log when it holds nothing.
+func (w *wal) close() error {
+	err := w.file.Close()
+	if err == nil && w.size == 0 && !w.readOnly {
+		os.Remove(w.path)
+	}
+	return err
+}
@@ -1,17 +1,32 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 	
 	"github.com/JoshuaLim25/db/storage"
 )
 
+var (
+	// ErrDatabaseLocked is returned when another process already has the
+	// database file open for writing
+	ErrDatabaseLocked = storage.ErrDatabaseLocked
+	
+	// ErrReadOnly is returned by mutating calls on a read-only database
+	ErrReadOnly = storage.ErrReadOnly
+)
+
 // Table represents a database table backed by a B+Tree
 type Table struct {
-	name  string
-	btree *storage.DiskBTree
-	mu    sync.RWMutex
+	name     string
+	btree    *storage.DiskBTree
+	pm       *storage.PageManager
+	schema   []byte
+	mu       sync.RWMutex
+	readOnly bool
+	dropped  bool
 }
 
 // NewTable creates a new table with the given name
@@ -22,23 +37,53 @@ func NewTable(name string, pm *storage.PageManager) (*Table, error) {
 	}
 	
 	return &Table{
-		name:  name,
-		btree: btree,
+		name:     name,
+		btree:    btree,
+		pm:       pm,
+		readOnly: pm.ReadOnly(),
 	}, nil
 }
 
 // Name returns the table name
 func (t *Table) Name() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	
 	return t.name
 }
 
+// Schema returns the schema the table was created with, or nil for a plain
+// key/value table. The database stores it but does not interpret it.
+func (t *Table) Schema() []byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	
+	return t.schema
+}
+
 // Insert inserts a key-value pair into the table
 func (t *Table) Insert(key, value []byte) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	
-	t.btree.Set(key, value)
-	return nil
+	return t.write(func(batch *storage.Batch) error {
+		return t.btree.Put(batch, key, value)
+	})
+}
+
+// InsertNew inserts a key-value pair unless the key is already present.
+// The check and the insert happen under the table lock, so two concurrent
+// calls with the same key cannot both succeed.
+func (t *Table) InsertNew(key, value []byte) (bool, error) {
+	inserted := false
+	err := t.write(func(batch *storage.Batch) error {
+		if _, exists := t.btree.Get(key); exists {
+			return nil
+		}
+		inserted = true
+		return t.btree.Put(batch, key, value)
+	})
+	if err != nil {
+		return false, err
+	}
+	return inserted, nil
 }
 
 // Select retrieves a value by key from the table
@@ -46,45 +91,123 @@ func (t *Table) Select(key []byte) ([]byte, bool) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	
+	if t.dropped {
+		return nil, false
+	}
 	return t.btree.Get(key)
 }
 
 // Update updates a key with a new value
 func (t *Table) Update(key, value []byte) error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	
-	// Check if key exists first
-	if _, exists := t.btree.Get(key); !exists {
-		return fmt.Errorf("key not found: %s", key)
-	}
-	
-	t.btree.Set(key, value)
-	return nil
+	return t.write(func(batch *storage.Batch) error {
+		// Check if key exists first
+		if _, exists := t.btree.Get(key); !exists {
+			return fmt.Errorf("key not found: %s", key)
+		}
+		return t.btree.Put(batch, key, value)
+	})
 }
 
 // Delete removes a key-value pair from the table
 func (t *Table) Delete(key []byte) error {
+	return t.write(func(batch *storage.Batch) error {
+		// Check if key exists first
+		if _, exists := t.btree.Get(key); !exists {
+			return fmt.Errorf("key not found: %s", key)
+		}
+		_, err := t.btree.Remove(batch, key)
+		return err
+	})
+}
+
+// write runs op in a batch of its own and commits it. The batch is
+// published under the table lock, so the next writer sees it, and waited
+// on after the lock is released: concurrent writers to the table then
+// share a log fsync instead of queueing behind each other's.
+func (t *Table) write(op func(batch *storage.Batch) error) error {
+	batch, err := t.publish(op)
+	if err != nil {
+		return err
+	}
+	return batch.Wait()
+}
+
+// publish runs op in a new batch under the table lock and publishes it
+func (t *Table) publish(op func(batch *storage.Batch) error) (*storage.Batch, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	
-	// Check if key exists first
-	if _, exists := t.btree.Get(key); !exists {
-		return fmt.Errorf("key not found: %s", key)
+	if err := t.checkWritable(); err != nil {
+		return nil, err
 	}
 	
-	t.btree.Delete(key)
-	return nil
+	batch := t.pm.NewBatch()
+	if err := op(batch); err != nil {
+		batch.Abort()
+		t.btree.Rollback(batch)
+		return nil, err
+	}
+	if err := batch.Publish(); err != nil {
+		t.btree.Rollback(batch)
+		return nil, err
+	}
+	return batch, nil
 }
 
 // Scan returns an iterator for keys larger than the given key
 func (t *Table) Scan(startKey []byte) storage.Iterator {
+	return t.ScanContext(context.Background(), startKey)
+}
+
+// ScanContext returns an iterator as Scan does that reads no more pages
+// once ctx is done. It then reports no more keys, so the caller checks ctx
+// to tell a canceled scan from the end of the table.
+func (t *Table) ScanContext(ctx context.Context, startKey []byte) storage.Iterator {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	
-	return t.btree.FindLarger(startKey)
+	if t.dropped {
+		return emptyIterator{}
+	}
+	return t.btree.FindLargerContext(ctx, startKey)
 }
 
+// ScanReverse returns an iterator for keys smaller than the given key, from
+// the largest down. A nil key starts from the largest key in the table.
+func (t *Table) ScanReverse(endKey []byte) storage.Iterator {
+	return t.ScanReverseContext(context.Background(), endKey)
+}
+
+// ScanReverseContext returns an iterator as ScanReverse does that reads no
+// more pages once ctx is done, as ScanContext does
+func (t *Table) ScanReverseContext(ctx context.Context, endKey []byte) storage.Iterator {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	
+	if t.dropped {
+		return emptyIterator{}
+	}
+	return t.btree.FindSmallerContext(ctx, endKey)
+}
+
+// checkWritable returns the error a mutation of the table should fail with,
+// if any. The caller holds t.mu.
+func (t *Table) checkWritable() error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if t.dropped {
+		return fmt.Errorf("table %s has been dropped", t.name)
+	}
+	return nil
+}
+
+// emptyIterator is returned by scans of a dropped table
+type emptyIterator struct{}
+
+func (emptyIterator) Next() (key, val []byte) { return nil, nil }
+func (emptyIterator) ContainsNext() bool      { return false }
+
 // Close closes the table and flushes any pending changes
 func (t *Table) Close() error {
 	t.mu.Lock()
@@ -95,24 +218,82 @@ func (t *Table) Close() error {
 
 // Database represents a collection of tables
 type Database struct {
-	name   string
-	pm     *storage.PageManager
-	tables map[string]*Table
-	mu     sync.RWMutex
+	name    string
+	pm      *storage.PageManager
+	catalog *catalog
+	tables  map[string]*Table
+	mu      sync.RWMutex
+	seqMu   sync.Mutex // serializes changes to sequences
+}
+
+// Options configures how a database is opened
+type Options struct {
+	// ReadOnly opens the database with a shared lock and rejects every
+	// mutating call with ErrReadOnly
+	ReadOnly bool
+	
+	// CommitMaxWait is how long a group commit leader waits for concurrent
+	// writers to join its fsync; see storage.Options
+	CommitMaxWait time.Duration
 }
 
 // NewDatabase creates a new database with the given name and file
 func NewDatabase(name, filename string) (*Database, error) {
-	pm, err := storage.NewPageManager(filename)
+	return NewDatabaseWithOptions(name, filename, Options{})
+}
+
+// NewDatabaseWithOptions opens a database with the given options. Tables
+// created earlier in the same file are loaded from its catalog. Only one
+// writer may have a file open at a time; a second one gets ErrDatabaseLocked.
+func NewDatabaseWithOptions(name, filename string, opts Options) (*Database, error) {
+	pm, err := storage.NewPageManagerWithOptions(filename, storage.Options{
+		ReadOnly:      opts.ReadOnly,
+		CommitMaxWait: opts.CommitMaxWait,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create page manager: %w", err)
 	}
 	
-	return &Database{
+	db := &Database{
 		name:   name,
 		pm:     pm,
 		tables: make(map[string]*Table),
-	}, nil
+	}
+	
+	if err := db.loadTables(); err != nil {
+		pm.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// loadTables opens the catalog and every table it lists
+func (db *Database) loadTables() error {
+	catalog, err := openCatalog(db.pm)
+	if err != nil {
+		return err
+	}
+	db.catalog = catalog
+	
+	entries, err := catalog.tables()
+	if err != nil {
+		return err
+	}
+	
+	for name, entry := range entries {
+		btree, err := storage.OpenDiskBTree(db.pm, entry.root)
+		if err != nil {
+			return fmt.Errorf("failed to open table %s: %w", name, err)
+		}
+		db.tables[name] = &Table{
+			name:     name,
+			btree:    btree,
+			pm:       db.pm,
+			schema:   entry.schema,
+			readOnly: db.pm.ReadOnly(),
+		}
+	}
+	return nil
 }
 
 // Name returns the database name
@@ -120,20 +301,58 @@ func (db *Database) Name() string {
 	return db.name
 }
 
+// ReadOnly reports whether the database was opened in read-only mode
+func (db *Database) ReadOnly() bool {
+	return db.pm.ReadOnly()
+}
+
 // CreateTable creates a new table with the given name
 func (db *Database) CreateTable(tableName string) (*Table, error) {
+	return db.CreateTableWithSchema(tableName, nil)
+}
+
+// CreateTableWithSchema creates a new table and records an opaque schema
+// with it in the catalog, in the same commit as the table itself
+func (db *Database) CreateTableWithSchema(tableName string, schema []byte) (*Table, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
+	if db.pm.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	
 	if _, exists := db.tables[tableName]; exists {
 		return nil, fmt.Errorf("table %s already exists", tableName)
 	}
 	
-	table, err := NewTable(tableName, db.pm)
+	// Create the tree and its catalog entry in one commit
+	batch := db.pm.NewBatch()
+	btree, err := storage.CreateDiskBTree(batch)
 	if err != nil {
+		batch.Abort()
+		return nil, fmt.Errorf("failed to create B+Tree for table %s: %w", tableName, err)
+	}
+	
+	schema = append([]byte(nil), schema...)
+	entry := tableEntry{root: btree.RootID(), schema: schema}
+	if err := db.catalog.putTable(batch, tableName, entry); err != nil {
+		batch.Abort()
+		db.catalog.rollback(batch)
 		return nil, err
 	}
 	
+	if err := batch.Commit(); err != nil {
+		db.catalog.rollback(batch)
+		return nil, fmt.Errorf("failed to create table %s: %w", tableName, err)
+	}
+	
+	table := &Table{
+		name:     tableName,
+		btree:    btree,
+		pm:       db.pm,
+		schema:   schema,
+		readOnly: db.pm.ReadOnly(),
+	}
 	db.tables[tableName] = table
 	return table, nil
 }
@@ -156,13 +375,45 @@ func (db *Database) DropTable(tableName string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	
+	if db.pm.ReadOnly() {
+		return ErrReadOnly
+	}
+	
 	table, exists := db.tables[tableName]
 	if !exists {
 		return fmt.Errorf("table %s does not exist", tableName)
 	}
 	
-	// Close the table first
-	if err := table.Close(); err != nil {
+	// Wait for operations in progress on the table
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	
+	// Free every page of the table's tree and remove its catalog entry in
+	// one commit
+	batch := db.pm.NewBatch()
+	rollback := func() {
+		batch.Abort()
+		table.btree.Rollback(batch)
+		db.catalog.rollback(batch)
+	}
+	if err := table.btree.Free(batch); err != nil {
+		rollback()
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+	if err := db.catalog.removeTable(batch, tableName); err != nil {
+		rollback()
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+	if err := batch.Commit(); err != nil {
+		table.btree.Rollback(batch)
+		db.catalog.rollback(batch)
+		return fmt.Errorf("failed to drop table %s: %w", tableName, err)
+	}
+	
+	// Anyone still holding the table now gets errors instead of touching
+	// pages that are about to be reused
+	table.dropped = true
+	if err := table.btree.Close(); err != nil {
 		return fmt.Errorf("failed to close table %s: %w", tableName, err)
 	}
 	
@@ -171,6 +422,116 @@ func (db *Database) DropTable(tableName string) error {
 	return nil
 }
 
+// SetTableSchema replaces the schema recorded for a table in the catalog.
+// Existing rows are not rewritten.
+func (db *Database) SetTableSchema(tableName string, schema []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	
+	if db.pm.ReadOnly() {
+		return ErrReadOnly
+	}
+	
+	table, exists := db.tables[tableName]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", tableName)
+	}
+	
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	
+	schema = append([]byte(nil), schema...)
+	batch := db.pm.NewBatch()
+	entry := tableEntry{root: table.btree.RootID(), schema: schema}
+	if err := db.catalog.putTable(batch, tableName, entry); err != nil {
+		batch.Abort()
+		db.catalog.rollback(batch)
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		db.catalog.rollback(batch)
+		return fmt.Errorf("failed to alter table %s: %w", tableName, err)
+	}
+	
+	table.schema = schema
+	return nil
+}
+
+// RenameTable gives a table a new name. The rows stay where they are; only
+// the catalog entry moves.
+func (db *Database) RenameTable(oldName, newName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	
+	if db.pm.ReadOnly() {
+		return ErrReadOnly
+	}
+	
+	table, exists := db.tables[oldName]
+	if !exists {
+		return fmt.Errorf("table %s does not exist", oldName)
+	}
+	if _, exists := db.tables[newName]; exists {
+		return fmt.Errorf("table %s already exists", newName)
+	}
+	
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	
+	batch := db.pm.NewBatch()
+	entry := tableEntry{root: table.btree.RootID(), schema: table.schema}
+	if err := db.catalog.removeTable(batch, oldName); err != nil {
+		batch.Abort()
+		db.catalog.rollback(batch)
+		return fmt.Errorf("failed to rename table %s: %w", oldName, err)
+	}
+	if err := db.catalog.putTable(batch, newName, entry); err != nil {
+		batch.Abort()
+		db.catalog.rollback(batch)
+		return fmt.Errorf("failed to rename table %s: %w", oldName, err)
+	}
+	if err := batch.Commit(); err != nil {
+		db.catalog.rollback(batch)
+		return fmt.Errorf("failed to rename table %s: %w", oldName, err)
+	}
+	
+	table.name = newName
+	delete(db.tables, oldName)
+	db.tables[newName] = table
+	return nil
+}
+
+// TableStats describes the B+Tree a table is stored in
+type TableStats struct {
+	RootPage storage.PageID
+	Rows     int // number of keys
+	Height   int // levels from the root to the leaves, 1 for a single leaf
+}
+
+// TableStats returns the root page, key count and height of a table's
+// B+Tree
+func (db *Database) TableStats(tableName string) (TableStats, error) {
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		return TableStats{}, err
+	}
+	
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	
+	if table.dropped {
+		return TableStats{}, fmt.Errorf("table %s has been dropped", table.name)
+	}
+	stats := TableStats{RootPage: table.btree.RootID()}
+	if stats.Rows, err = table.btree.Count(); err != nil {
+		return TableStats{}, fmt.Errorf("failed to count rows of table %s: %w", tableName, err)
+	}
+	if stats.Height, err = table.btree.Height(); err != nil {
+		return TableStats{}, fmt.Errorf("failed to read table %s: %w", tableName, err)
+	}
+	return stats, nil
+}
+
 // ListTables returns a list of all table names
 func (db *Database) ListTables() []string {
 	db.mu.RLock()
@@ -195,10 +556,14 @@ func (db *Database) Close() error {
 		}
 	}
 	
+	if err := db.catalog.close(); err != nil {
+		return fmt.Errorf("failed to close catalog: %w", err)
+	}
+	
 	// Close the page manager
 	if err := db.pm.Close(); err != nil {
 		return fmt.Errorf("failed to close page manager: %w", err)
 	}
 	
 	return nil
-}
\ No newline at end of file
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	
 	"github.com/stretchr/testify/assert"
@@ -42,6 +45,16 @@ func TestTableBasicOperations(t *testing.T) {
 	// Select non-existent
 	_, exists = table.Select([]byte("user3"))
 	assert.False(t, exists, "user3 should not exist")
+	
+	// InsertNew leaves existing keys alone
+	inserted, err := table.InsertNew([]byte("user1"), []byte("Someone Else"))
+	require.NoError(t, err)
+	assert.False(t, inserted)
+	inserted, err = table.InsertNew([]byte("user3"), []byte("Jim Beam"))
+	require.NoError(t, err)
+	assert.True(t, inserted)
+	value, _ = table.Select([]byte("user1"))
+	assert.Equal(t, []byte("John Doe"), value)
 }
 
 func TestTableUpdate(t *testing.T) {
@@ -218,4 +231,293 @@ func TestTableScan(t *testing.T) {
 	
 	// Test that iterator implements the interface
 	var _ storage.Iterator = iter
-}
\ No newline at end of file
+	
+	// A reverse scan returns the smaller keys, largest first
+	var keys []string
+	for iter = table.ScanReverse([]byte("cherry")); iter.ContainsNext(); {
+		key, _ := iter.Next()
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"banana", "apple"}, keys)
+}
+
+func TestDatabaseLocking(t *testing.T) {
+	tempFile := "test_database_lock.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	
+	// A second writer gets a clear locking error
+	_, err = NewDatabase("testdb", tempFile)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+	assert.Contains(t, err.Error(), "database is locked")
+	
+	require.NoError(t, db.Close())
+}
+
+func TestDatabaseReadOnly(t *testing.T) {
+	tempFile := "test_database_readonly.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	
+	ro, err := NewDatabaseWithOptions("testdb", tempFile, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+	assert.True(t, ro.ReadOnly())
+	
+	_, err = ro.CreateTable("users")
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, ro.DropTable("users"), ErrReadOnly)
+}
+
+func TestDatabaseReopen(t *testing.T) {
+	tempFile := "test_database_reopen.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	
+	users, err := db.CreateTable("users")
+	require.NoError(t, err)
+	_, err = db.CreateTable("scratch")
+	require.NoError(t, err)
+	
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, users.Insert([]byte(fmt.Sprintf("user%04d", i)), []byte("data")))
+	}
+	require.NoError(t, db.DropTable("scratch"))
+	require.NoError(t, db.Close())
+	
+	// Tables and their rows are found again through the catalog
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	
+	assert.Equal(t, []string{"users"}, db.ListTables())
+	
+	users, err = db.GetTable("users")
+	require.NoError(t, err)
+	val, ok := users.Select([]byte("user0999"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("data"), val)
+	
+	// Mutations in read-only mode fail instead of being dropped silently
+	require.NoError(t, db.Close())
+	ro, err := NewDatabaseWithOptions("testdb", tempFile, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+	
+	users, err = ro.GetTable("users")
+	require.NoError(t, err)
+	assert.ErrorIs(t, users.Insert([]byte("new"), []byte("data")), ErrReadOnly)
+	_, ok = users.Select([]byte("user0000"))
+	assert.True(t, ok)
+}
+
+func TestDatabaseTableSchema(t *testing.T) {
+	tempFile := "test_database_schema.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	
+	schema := []byte(`{"columns":["id","name"]}`)
+	_, err = db.CreateTableWithSchema("users", schema)
+	require.NoError(t, err)
+	_, err = db.CreateTable("kv")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	
+	// The schema is stored in the catalog with the table
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+	
+	users, err := db.GetTable("users")
+	require.NoError(t, err)
+	assert.Equal(t, schema, users.Schema())
+	
+	kv, err := db.GetTable("kv")
+	require.NoError(t, err)
+	assert.Nil(t, kv.Schema())
+}
+
+func TestDatabaseTableStats(t *testing.T) {
+	tempFile := "test_database_stats.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+	
+	table, err := db.CreateTable("users")
+	require.NoError(t, err)
+	stats, err := db.TableStats("users")
+	require.NoError(t, err)
+	assert.Equal(t, TableStats{RootPage: table.btree.RootID(), Rows: 0, Height: 1}, stats)
+	
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, table.Insert([]byte(fmt.Sprintf("user%04d", i)), []byte("some value")))
+	}
+	stats, err = db.TableStats("users")
+	require.NoError(t, err)
+	assert.Equal(t, 2000, stats.Rows)
+	assert.Greater(t, stats.Height, 1)
+	
+	_, err = db.TableStats("missing")
+	assert.Error(t, err)
+}
+
+func TestDatabaseDropTableReclaimsPages(t *testing.T) {
+	tempFile := "test_database_drop_reclaim.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	
+	big, err := db.CreateTable("big")
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, big.Insert([]byte(fmt.Sprintf("key%05d", i)), make([]byte, 100)))
+	}
+	pagesBefore := db.pm.PageCount()
+	
+	require.NoError(t, db.DropTable("big"))
+	freed := db.pm.FreePageCount()
+	assert.Greater(t, freed, 50, "every page of the dropped tree should be free")
+	
+	// A handle to the dropped table no longer works
+	assert.Error(t, big.Insert([]byte("key"), []byte("value")))
+	_, ok := big.Select([]byte("key00000"))
+	assert.False(t, ok)
+	
+	// New tables reuse the freed pages instead of growing the file
+	other, err := db.CreateTable("other")
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, other.Insert([]byte(fmt.Sprintf("key%05d", i)), make([]byte, 100)))
+	}
+	assert.LessOrEqual(t, db.pm.PageCount(), pagesBefore+1)
+	require.NoError(t, db.DropTable("other"))
+	require.NoError(t, db.Close())
+	
+	// The drop and the free list survive a reopen
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+	
+	assert.Empty(t, db.ListTables())
+	assert.GreaterOrEqual(t, db.pm.FreePageCount(), freed)
+}
+
+func TestDatabaseAlterTable(t *testing.T) {
+	tempFile := "test_database_alter.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	
+	users, err := db.CreateTableWithSchema("users", []byte("v1"))
+	require.NoError(t, err)
+	require.NoError(t, users.Insert([]byte("1"), []byte("alice")))
+	_, err = db.CreateTable("other")
+	require.NoError(t, err)
+	
+	require.NoError(t, db.SetTableSchema("users", []byte("v2")))
+	assert.Equal(t, []byte("v2"), users.Schema())
+	
+	assert.Error(t, db.RenameTable("users", "other"))
+	assert.Error(t, db.RenameTable("missing", "people"))
+	require.NoError(t, db.RenameTable("users", "people"))
+	assert.Equal(t, "people", users.Name())
+	_, err = db.GetTable("users")
+	assert.Error(t, err)
+	require.NoError(t, db.Close())
+	
+	// Both changes are in the catalog
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+	
+	people, err := db.GetTable("people")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), people.Schema())
+	value, found := people.Select([]byte("1"))
+	assert.True(t, found)
+	assert.Equal(t, []byte("alice"), value)
+	assert.ElementsMatch(t, []string{"people", "other"}, db.ListTables())
+}
+
+func TestTableConcurrentWriters(t *testing.T) {
+	tempFile := "test_table_concurrent.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+	
+	table, err := db.CreateTable("users")
+	require.NoError(t, err)
+	
+	// Writers share a table, and readers run while their commits wait on
+	// the log
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := []byte(fmt.Sprintf("user%d-%03d", w, i))
+				assert.NoError(t, table.Insert(key, []byte("v1")))
+				assert.NoError(t, table.Update(key, []byte("v2")))
+				if i%2 == 0 {
+					assert.NoError(t, table.Delete(key))
+				}
+				_, exists := table.Select(key)
+				assert.Equal(t, i%2 == 1, exists)
+			}
+		}(w)
+	}
+	wg.Wait()
+	
+	count := 0
+	iter := table.Scan([]byte(""))
+	for iter.ContainsNext() {
+		key, value := iter.Next()
+		if key != nil {
+			assert.Equal(t, []byte("v2"), value)
+			count++
+		}
+	}
+	assert.Equal(t, 8*25, count)
+}
+
+func BenchmarkTableConcurrentInserts(b *testing.B) {
+	tempFile := "bench_table_inserts.dat"
+	defer os.Remove(tempFile)
+	
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(b, err)
+	defer db.Close()
+	
+	table, err := db.CreateTable("users")
+	require.NoError(b, err)
+	
+	var next atomic.Int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := []byte(fmt.Sprintf("user%010d", next.Add(1)))
+			if err := table.Insert(key, []byte("value")); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "inserts/s")
+}
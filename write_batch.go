@@ -0,0 +1,436 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/JoshuaLim25/db/storage"
+)
+
+// WriteBatch collects puts and deletes across any number of tables and
+// applies them as one atomic, durable commit: after Commit returns, or after
+// a crash and reopen, either every operation is visible or none is. A batch
+// can also create and drop tables and sequences and change table schemas,
+// so a statement that touches several tables and the catalog commits as one
+// unit.
+type WriteBatch struct {
+	db  *Database
+	ops []batchOp
+}
+
+// batchOpKind is the kind of a batchOp
+type batchOpKind int
+
+const (
+	opPut batchOpKind = iota
+	opDelete
+	opRequireAbsent
+	opRequirePresent
+	opCreateTable
+	opDropTable
+	opTruncate
+	opSetSchema
+	opCreateSequence
+	opDropSequence
+	opAdvanceSequence
+)
+
+// isSequenceOp reports whether an operation works on a sequence rather than
+// a table
+func (k batchOpKind) isSequenceOp() bool {
+	return k == opCreateSequence || k == opDropSequence || k == opAdvanceSequence
+}
+
+// batchOp is a single operation recorded in a WriteBatch
+type batchOp struct {
+	kind  batchOpKind
+	table string // the table, or the sequence of a sequence operation
+	key   []byte
+	value []byte        // the value of a put, or the schema of a table
+	err   error         // returned by a failed opRequireAbsent or opRequirePresent
+	seq   sequenceEntry // the new sequence, or the value to advance past
+}
+
+// NewWriteBatch starts an empty write batch
+func (db *Database) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Put records inserting or replacing a key in a table
+func (wb *WriteBatch) Put(table string, key, value []byte) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opPut,
+		table: table,
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+// Delete records removing a key from a table. Deleting a key that does not
+// exist is not an error.
+func (wb *WriteBatch) Delete(table string, key []byte) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opDelete,
+		table: table,
+		key:   append([]byte{}, key...),
+	})
+}
+
+// RequireAbsent records a check that no key in a table starts with prefix.
+// The check sees the operations recorded before it; if it fails, Commit
+// applies nothing and returns err.
+func (wb *WriteBatch) RequireAbsent(table string, prefix []byte, err error) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opRequireAbsent,
+		table: table,
+		key:   append([]byte{}, prefix...),
+		err:   err,
+	})
+}
+
+// RequirePresent records a check that some key in a table starts with
+// prefix. The check sees the operations recorded before it; if it fails,
+// Commit applies nothing and returns err.
+func (wb *WriteBatch) RequirePresent(table string, prefix []byte, err error) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opRequirePresent,
+		table: table,
+		key:   append([]byte{}, prefix...),
+		err:   err,
+	})
+}
+
+// CreateTable records creating a table with an opaque schema. Later
+// operations in the batch can write to it.
+func (wb *WriteBatch) CreateTable(table string, schema []byte) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opCreateTable,
+		table: table,
+		value: append([]byte(nil), schema...),
+	})
+}
+
+// DropTable records dropping a table and freeing its pages
+func (wb *WriteBatch) DropTable(table string) {
+	wb.ops = append(wb.ops, batchOp{kind: opDropTable, table: table})
+}
+
+// Truncate records removing every key of a table at once, by swapping its
+// tree for an empty one and freeing the old tree's pages
+func (wb *WriteBatch) Truncate(table string) {
+	wb.ops = append(wb.ops, batchOp{kind: opTruncate, table: table})
+}
+
+// SetTableSchema records replacing the schema of a table
+func (wb *WriteBatch) SetTableSchema(table string, schema []byte) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opSetSchema,
+		table: table,
+		value: append([]byte(nil), schema...),
+	})
+}
+
+// CreateSequence records creating a sequence whose first value is start
+func (wb *WriteBatch) CreateSequence(name string, start, increment int64) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opCreateSequence,
+		table: name,
+		seq:   sequenceEntry{next: start, increment: increment},
+	})
+}
+
+// DropSequence records dropping a sequence
+func (wb *WriteBatch) DropSequence(name string) {
+	wb.ops = append(wb.ops, batchOp{kind: opDropSequence, table: name})
+}
+
+// AdvanceSequence records moving a sequence past value if it has not
+// passed it yet
+func (wb *WriteBatch) AdvanceSequence(name string, value int64) {
+	wb.ops = append(wb.ops, batchOp{
+		kind:  opAdvanceSequence,
+		table: name,
+		seq:   sequenceEntry{next: value},
+	})
+}
+
+// Len returns the number of recorded operations
+func (wb *WriteBatch) Len() int {
+	return len(wb.ops)
+}
+
+// Reset discards every recorded operation so the batch can be reused
+func (wb *WriteBatch) Reset() {
+	wb.ops = nil
+}
+
+// pendingTable is a table as a batch being applied sees it
+type pendingTable struct {
+	table  *Table // nil for a table the batch created
+	btree  *storage.DiskBTree
+	schema []byte
+}
+
+// Commit applies the recorded operations in order. If any of them fails,
+// none are applied.
+func (wb *WriteBatch) Commit() error {
+	batch, err := wb.publish()
+	if err != nil || batch == nil {
+		return err
+	}
+
+	// The table locks are released, so writers that come along while this
+	// waits can join the same log fsync
+	if err := batch.Wait(); err != nil {
+		return fmt.Errorf("failed to commit write batch: %w", err)
+	}
+	return nil
+}
+
+// publish applies the recorded operations under the database and table
+// locks and publishes the result, returning the batch to wait on for
+// durability or nil if there was nothing to write
+func (wb *WriteBatch) publish() (*storage.Batch, error) {
+	db := wb.db
+
+	// Creating and dropping tables changes the table map
+	ddl, sequences := false, false
+	for _, op := range wb.ops {
+		if op.kind == opCreateTable || op.kind == opDropTable || op.kind == opTruncate || op.kind == opSetSchema {
+			ddl = true
+		}
+		if op.kind.isSequenceOp() {
+			sequences = true
+		}
+	}
+	if ddl {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	} else {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	}
+
+	if db.pm.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if len(wb.ops) == 0 {
+		return nil, nil
+	}
+
+	// Resolve every table before touching any of them. A table exists for
+	// the operations between its creation and its drop.
+	tables := make(map[string]*Table)
+	present := make(map[string]bool)
+	for _, op := range wb.ops {
+		if op.kind.isSequenceOp() {
+			continue
+		}
+		exists, seen := present[op.table]
+		if !seen {
+			var table *Table
+			table, exists = db.tables[op.table]
+			if exists {
+				tables[op.table] = table
+			}
+		}
+
+		switch {
+		case op.kind == opCreateTable && exists:
+			return nil, fmt.Errorf("table %s already exists", op.table)
+		case op.kind != opCreateTable && !exists:
+			return nil, fmt.Errorf("table %s does not exist", op.table)
+		}
+		present[op.table] = op.kind != opDropTable
+	}
+
+	// Lock tables in name order so concurrent batches cannot deadlock
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tables[name].mu.Lock()
+		defer tables[name].mu.Unlock()
+	}
+	for _, name := range names {
+		if tables[name].dropped {
+			return nil, fmt.Errorf("table %s has been dropped", name)
+		}
+	}
+	if sequences {
+		db.seqMu.Lock()
+		defer db.seqMu.Unlock()
+	}
+
+	batch := db.pm.NewBatch()
+	view := make(map[string]*pendingTable)
+	var touched []*storage.DiskBTree
+	var dropped []*Table
+	var emptied []*storage.DiskBTree // trees replaced by a truncate
+	for name, table := range tables {
+		view[name] = &pendingTable{table: table, btree: table.btree, schema: table.schema}
+		touched = append(touched, table.btree)
+	}
+	rollback := func() {
+		for _, btree := range touched {
+			btree.Rollback(batch)
+		}
+		db.catalog.rollback(batch)
+	}
+
+	for _, op := range wb.ops {
+		if err := wb.apply(batch, op, view, &touched, &dropped, &emptied); err != nil {
+			batch.Abort()
+			rollback()
+			if (op.kind == opRequireAbsent || op.kind == opRequirePresent) && err == op.err || op.kind.isSequenceOp() {
+				return nil, err
+			}
+			return nil, fmt.Errorf("write batch failed on table %s: %w", op.table, err)
+		}
+	}
+
+	if err := batch.Publish(); err != nil {
+		rollback()
+		return nil, fmt.Errorf("failed to commit write batch: %w", err)
+	}
+
+	// Publish the new state of the catalog
+	for _, btree := range emptied {
+		btree.Close()
+	}
+	for _, table := range dropped {
+		table.dropped = true
+		table.btree.Close()
+		if db.tables[table.name] == table {
+			delete(db.tables, table.name)
+		}
+	}
+	for name, pending := range view {
+		if pending.table == nil {
+			db.tables[name] = &Table{
+				name:     name,
+				btree:    pending.btree,
+				pm:       db.pm,
+				schema:   pending.schema,
+				readOnly: db.pm.ReadOnly(),
+			}
+		} else {
+			pending.table.btree = pending.btree
+			pending.table.schema = pending.schema
+		}
+	}
+	return batch, nil
+}
+
+// apply stages one operation of the batch against the tables as the batch
+// sees them
+func (wb *WriteBatch) apply(batch *storage.Batch, op batchOp, view map[string]*pendingTable, touched *[]*storage.DiskBTree, dropped *[]*Table, emptied *[]*storage.DiskBTree) error {
+	db := wb.db
+	pending := view[op.table]
+
+	switch op.kind {
+	case opPut:
+		return pending.btree.Put(batch, op.key, op.value)
+	case opDelete:
+		_, err := pending.btree.Remove(batch, op.key)
+		return err
+	case opRequireAbsent:
+		if hasPrefix(pending.btree, op.key) {
+			return op.err
+		}
+		return nil
+	case opRequirePresent:
+		if !hasPrefix(pending.btree, op.key) {
+			return op.err
+		}
+		return nil
+	case opCreateTable:
+		btree, err := storage.CreateDiskBTree(batch)
+		if err != nil {
+			return err
+		}
+		*touched = append(*touched, btree)
+		view[op.table] = &pendingTable{btree: btree, schema: op.value}
+		return db.catalog.putTable(batch, op.table, tableEntry{root: btree.RootID(), schema: op.value})
+	case opDropTable:
+		if err := pending.btree.Free(batch); err != nil {
+			return err
+		}
+		if pending.table != nil {
+			*dropped = append(*dropped, pending.table)
+		}
+		delete(view, op.table)
+		return db.catalog.removeTable(batch, op.table)
+	case opTruncate:
+		btree, err := storage.CreateDiskBTree(batch)
+		if err != nil {
+			return err
+		}
+		if err := pending.btree.Free(batch); err != nil {
+			return err
+		}
+		*touched = append(*touched, btree)
+		*emptied = append(*emptied, pending.btree)
+		pending.btree = btree
+		return db.catalog.putTable(batch, op.table, tableEntry{root: btree.RootID(), schema: pending.schema})
+	case opSetSchema:
+		pending.schema = op.value
+		return db.catalog.putTable(batch, op.table, tableEntry{root: pending.btree.RootID(), schema: op.value})
+	case opCreateSequence, opDropSequence, opAdvanceSequence:
+		return wb.applySequence(batch, op)
+	default:
+		return fmt.Errorf("unknown batch operation %d", op.kind)
+	}
+}
+
+// hasPrefix reports whether a tree has a key starting with prefix
+func hasPrefix(btree *storage.DiskBTree, prefix []byte) bool {
+	if _, found := btree.Get(prefix); found {
+		return true
+	}
+	iter := btree.FindLarger(prefix)
+	if iter.ContainsNext() {
+		if key, _ := iter.Next(); bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applySequence stages one sequence operation. Sequences staged earlier in
+// the batch are visible through the catalog.
+func (wb *WriteBatch) applySequence(batch *storage.Batch, op batchOp) error {
+	catalog := wb.db.catalog
+	entry, exists, err := catalog.sequence(op.table)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case op.kind == opCreateSequence && exists:
+		return fmt.Errorf("%w: %s", ErrSequenceExists, op.table)
+	case op.kind != opCreateSequence && !exists:
+		return fmt.Errorf("%w: %s", ErrSequenceNotFound, op.table)
+	}
+
+	switch op.kind {
+	case opCreateSequence:
+		if op.seq.increment == 0 {
+			return fmt.Errorf("sequence %s: increment cannot be zero", op.table)
+		}
+		return catalog.putSequence(batch, op.table, op.seq)
+	case opDropSequence:
+		return catalog.removeSequence(batch, op.table)
+	default:
+		if !sequencePassed(entry, op.seq.next) {
+			return nil
+		}
+		if entry.next, err = stepSequence(op.table, op.seq.next, entry.increment); err != nil {
+			return err
+		}
+		return catalog.putSequence(batch, op.table, entry)
+	}
+}
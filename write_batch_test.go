@@ -0,0 +1,319 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBatchAcrossTables(t *testing.T) {
+	tempFile := "test_write_batch.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+
+	accounts, err := db.CreateTable("accounts")
+	require.NoError(t, err)
+	_, err = db.CreateTable("ledger")
+	require.NoError(t, err)
+	require.NoError(t, accounts.Insert([]byte("bob"), []byte("100")))
+
+	wb := db.NewWriteBatch()
+	wb.Put("accounts", []byte("alice"), []byte("50"))
+	wb.Put("accounts", []byte("bob"), []byte("50"))
+	wb.Put("ledger", []byte("0001"), []byte("bob->alice 50"))
+	wb.Delete("accounts", []byte("nobody"))
+	assert.Equal(t, 4, wb.Len())
+	require.NoError(t, wb.Commit())
+	require.NoError(t, db.Close())
+
+	// Everything in the batch survives a reopen
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	accounts, err = db.GetTable("accounts")
+	require.NoError(t, err)
+	ledger, err := db.GetTable("ledger")
+	require.NoError(t, err)
+
+	val, ok := accounts.Select([]byte("alice"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("50"), val)
+	val, ok = accounts.Select([]byte("bob"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("50"), val)
+	val, ok = ledger.Select([]byte("0001"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bob->alice 50"), val)
+
+	// A batch can be reset and reused
+	wb = db.NewWriteBatch()
+	wb.Delete("accounts", []byte("alice"))
+	wb.Reset()
+	assert.Equal(t, 0, wb.Len())
+	require.NoError(t, wb.Commit())
+	_, ok = accounts.Select([]byte("alice"))
+	assert.True(t, ok)
+}
+
+func TestWriteBatchIsAtomic(t *testing.T) {
+	tempFile := "test_write_batch_atomic.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+
+	items, err := db.CreateTable("items")
+	require.NoError(t, err)
+	require.NoError(t, items.Insert([]byte("existing"), []byte("old")))
+
+	// Enough writes to split pages, then one that cannot be stored
+	wb := db.NewWriteBatch()
+	for i := 0; i < 500; i++ {
+		wb.Put("items", []byte(fmt.Sprintf("item%03d", i)), make([]byte, 64))
+	}
+	wb.Put("items", []byte("existing"), []byte("new"))
+	wb.Put("items", []byte("huge"), make([]byte, 8192))
+	assert.Error(t, wb.Commit())
+
+	// A batch naming an unknown table fails before doing anything
+	wb = db.NewWriteBatch()
+	wb.Put("items", []byte("item000"), []byte("value"))
+	wb.Put("missing", []byte("key"), []byte("value"))
+	err = wb.Commit()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not exist")
+
+	check := func(table *Table) {
+		_, ok := table.Select([]byte("item000"))
+		assert.False(t, ok, "no write from a failed batch should be visible")
+		val, ok := table.Select([]byte("existing"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("old"), val)
+	}
+	check(items)
+
+	require.NoError(t, db.Close())
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	items, err = db.GetTable("items")
+	require.NoError(t, err)
+	check(items)
+}
+
+func TestWriteBatchReadOnly(t *testing.T) {
+	tempFile := "test_write_batch_readonly.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	_, err = db.CreateTable("items")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	ro, err := NewDatabaseWithOptions("testdb", tempFile, Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer ro.Close()
+
+	wb := ro.NewWriteBatch()
+	wb.Put("items", []byte("key"), []byte("value"))
+	assert.ErrorIs(t, wb.Commit(), ErrReadOnly)
+}
+
+func TestWriteBatchRequireAbsent(t *testing.T) {
+	tempFile := "test_write_batch_require.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	items, err := db.CreateTable("items")
+	require.NoError(t, err)
+	require.NoError(t, items.Insert([]byte("ab/1"), []byte("x")))
+
+	errTaken := errors.New("taken")
+	wb := db.NewWriteBatch()
+	wb.Put("items", []byte("cd/1"), []byte("y"))
+	wb.RequireAbsent("items", []byte("ab/"), errTaken)
+	wb.Put("items", []byte("ab/2"), []byte("y"))
+	assert.ErrorIs(t, wb.Commit(), errTaken)
+	_, ok := items.Select([]byte("cd/1"))
+	assert.False(t, ok, "a failed check applies nothing")
+
+	// Checks see earlier operations of the same batch
+	wb = db.NewWriteBatch()
+	wb.Delete("items", []byte("ab/1"))
+	wb.RequireAbsent("items", []byte("ab/"), errTaken)
+	wb.Put("items", []byte("ab/2"), []byte("y"))
+	wb.RequireAbsent("items", []byte("ab/2"), errTaken)
+	assert.ErrorIs(t, wb.Commit(), errTaken)
+
+	wb.Reset()
+	wb.Delete("items", []byte("ab/1"))
+	wb.RequireAbsent("items", []byte("ab/"), errTaken)
+	wb.Put("items", []byte("ab/2"), []byte("y"))
+	require.NoError(t, wb.Commit())
+	_, ok = items.Select([]byte("ab/2"))
+	assert.True(t, ok)
+}
+
+func TestWriteBatchRequirePresent(t *testing.T) {
+	tempFile := "test_write_batch_present.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	items, err := db.CreateTable("items")
+	require.NoError(t, err)
+	require.NoError(t, items.Insert([]byte("ab/1"), []byte("x")))
+
+	errMissing := errors.New("missing")
+	wb := db.NewWriteBatch()
+	wb.Put("items", []byte("cd/1"), []byte("y"))
+	wb.RequirePresent("items", []byte("ab/"), errMissing)
+	require.NoError(t, wb.Commit())
+
+	// Checks see earlier operations of the same batch
+	wb = db.NewWriteBatch()
+	wb.Delete("items", []byte("ab/1"))
+	wb.RequirePresent("items", []byte("ab/"), errMissing)
+	assert.ErrorIs(t, wb.Commit(), errMissing)
+	_, ok := items.Select([]byte("ab/1"))
+	assert.True(t, ok, "a failed check applies nothing")
+
+	wb.Reset()
+	wb.Put("items", []byte("ef/1"), []byte("z"))
+	wb.RequirePresent("items", []byte("ef/"), errMissing)
+	require.NoError(t, wb.Commit())
+}
+
+func TestWriteBatchTables(t *testing.T) {
+	tempFile := "test_write_batch_tables.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+
+	users, err := db.CreateTableWithSchema("users", []byte("v1"))
+	require.NoError(t, err)
+	_, err = db.CreateTable("old")
+	require.NoError(t, err)
+
+	// Create a table, fill it and change another table's schema in one commit
+	wb := db.NewWriteBatch()
+	wb.CreateTable("users_idx", []byte("index"))
+	wb.Put("users_idx", []byte("a"), nil)
+	wb.Put("users_idx", []byte("b"), nil)
+	wb.SetTableSchema("users", []byte("v2"))
+	wb.DropTable("old")
+	require.NoError(t, wb.Commit())
+	assert.Equal(t, []byte("v2"), users.Schema())
+	assert.ElementsMatch(t, []string{"users", "users_idx"}, db.ListTables())
+
+	// A failing batch creates nothing
+	wb = db.NewWriteBatch()
+	wb.CreateTable("never", nil)
+	wb.Put("never", []byte("k"), []byte("v"))
+	wb.DropTable("users_idx")
+	wb.Put("users_idx", []byte("c"), nil)
+	err = wb.Commit()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "table users_idx does not exist")
+
+	errFull := errors.New("full")
+	wb = db.NewWriteBatch()
+	wb.CreateTable("never", nil)
+	wb.Put("never", []byte("k"), []byte("v"))
+	wb.SetTableSchema("users", []byte("v3"))
+	wb.RequireAbsent("never", []byte("k"), errFull)
+	assert.ErrorIs(t, wb.Commit(), errFull)
+	assert.Equal(t, []byte("v2"), users.Schema())
+	_, err = db.GetTable("never")
+	assert.Error(t, err)
+
+	wb = db.NewWriteBatch()
+	wb.CreateTable("users", nil)
+	assert.ErrorContains(t, wb.Commit(), "already exists")
+	require.NoError(t, db.Close())
+
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.ElementsMatch(t, []string{"users", "users_idx"}, db.ListTables())
+	idx, err := db.GetTable("users_idx")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("index"), idx.Schema())
+	_, ok := idx.Select([]byte("b"))
+	assert.True(t, ok)
+	users, err = db.GetTable("users")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), users.Schema())
+}
+
+func TestWriteBatchTruncate(t *testing.T) {
+	tempFile := "test_write_batch_truncate.dat"
+	defer os.Remove(tempFile)
+
+	db, err := NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+
+	big, err := db.CreateTableWithSchema("big", []byte("v1"))
+	require.NoError(t, err)
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, big.Insert([]byte(fmt.Sprintf("key%05d", i)), make([]byte, 100)))
+	}
+
+	// A failing batch leaves the rows in place
+	errFull := errors.New("full")
+	wb := db.NewWriteBatch()
+	wb.Truncate("big")
+	wb.RequirePresent("big", []byte("key"), errFull)
+	assert.ErrorIs(t, wb.Commit(), errFull)
+	_, ok := big.Select([]byte("key01999"))
+	assert.True(t, ok)
+
+	// Writes after the truncate land in the empty tree
+	wb = db.NewWriteBatch()
+	wb.Truncate("big")
+	wb.RequireAbsent("big", []byte("key"), errFull)
+	wb.Put("big", []byte("fresh"), []byte("v"))
+	require.NoError(t, wb.Commit())
+	assert.Greater(t, db.pm.FreePageCount(), 50, "the old tree's pages should be free")
+
+	// Handles to the table still work, and the schema stays
+	_, ok = big.Select([]byte("key00000"))
+	assert.False(t, ok)
+	require.NoError(t, big.Insert([]byte("later"), []byte("v")))
+	assert.Equal(t, []byte("v1"), big.Schema())
+	stats, err := db.TableStats("big")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Rows)
+	require.NoError(t, db.Close())
+
+	db, err = NewDatabase("testdb", tempFile)
+	require.NoError(t, err)
+	defer db.Close()
+
+	big, err = db.GetTable("big")
+	require.NoError(t, err)
+	var keys []string
+	iter := big.Scan(nil)
+	for iter.ContainsNext() {
+		key, _ := iter.Next()
+		keys = append(keys, string(key))
+	}
+	assert.Equal(t, []string{"fresh", "later"}, keys)
+	assert.Equal(t, []byte("v1"), big.Schema())
+}